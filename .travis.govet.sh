@@ -1,7 +1,7 @@
 #!/bin/bash
 
 cd "$(dirname $0)"
-DIRS=". layers pcap pcapgo tcpassembly tcpassembly/tcpreader routing ip4defrag bytediff macs defrag/lcmdefrag"
+DIRS=". layers pcap pcapgo pcapfilter tcpassembly tcpassembly/tcpreader routing ip4defrag ip6defrag dot11decrypt bytediff macs defrag/lcmdefrag"
 set -e
 for subdir in $DIRS; do
   pushd $subdir
@@ -0,0 +1,348 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot11decrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rc4"
+	"crypto/subtle"
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/google/gopacket/layers"
+)
+
+// temporalKey is a key used to protect data frames, with its cipher.
+type temporalKey struct {
+	cipher cipherSuite
+	key    []byte
+}
+
+// aad builds the additional authentication data of CCMP and GCMP from the
+// MAC header (IEEE 802.11, section 12.5.3.3.3).
+func aad(f *layers.Dot11) []byte {
+	h := f.Contents
+	a := make([]byte, 0, 30)
+	// Mask the subtype bits 4-6, Retry, Power Management and More Data
+	fc0, fc1 := h[0]&^0x70, h[1]&^0x38|0x40
+	if f.Type.QOS() {
+		fc1 &^= 0x80
+	}
+	a = append(a, fc0, fc1)
+	a = append(a, h[4:22]...)
+	a = append(a, h[22]&0x0f, 0)
+	off := 24
+	if f.Flags.ToDS() && f.Flags.FromDS() {
+		a = append(a, h[24:30]...)
+		off = 30
+	}
+	if f.Type.QOS() {
+		a = append(a, h[off]&0x0f, 0)
+	}
+	return a
+}
+
+// priority returns the TID of a QoS data frame, 0 otherwise.
+func priority(f *layers.Dot11) uint8 {
+	if f.QOS != nil {
+		return f.QOS.TID
+	}
+	return 0
+}
+
+// decryptCCMP decrypts the body of a frame protected with CCMP or GCMP.
+func decryptCCMP(f *layers.Dot11, tk temporalKey) ([]byte, error) {
+	body := f.Payload
+	micLen := 8
+	if tk.cipher != cipherCCMP128 {
+		micLen = 16
+	}
+	if len(body) < 8+micLen {
+		return nil, ErrDecryption
+	}
+	block, err := aes.NewCipher(tk.key)
+	if err != nil {
+		return nil, err
+	}
+	// PN5 ... PN0
+	pn := []byte{body[7], body[6], body[5], body[4], body[1], body[0]}
+	data := body[8:]
+
+	if tk.cipher == cipherGCMP128 || tk.cipher == cipherGCMP256 {
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		nonce := append(append(make([]byte, 0, 12), f.Address2...), pn...)
+		plain, err := gcm.Open(nil, nonce, data, aad(f))
+		if err != nil {
+			return nil, ErrDecryption
+		}
+		return plain, nil
+	}
+	nonce := append(append([]byte{priority(f)}, f.Address2...), pn...)
+	plain, ok := ccmOpen(block, nonce, data, aad(f), micLen)
+	if !ok {
+		return nil, ErrDecryption
+	}
+	return plain, nil
+}
+
+// ccmOpen decrypts and authenticates a message encrypted with CCM (RFC
+// 3610), with a 13 bytes nonce as in CCMP.
+func ccmOpen(block cipher.Block, nonce, data, aad []byte, tagLen int) ([]byte, bool) {
+	n := len(data) - tagLen
+	plain, s0 := ccmCTR(block, nonce, data[:n])
+	mac := ccmMAC(block, nonce, plain, aad, tagLen)
+	for i := 0; i < tagLen; i++ {
+		mac[i] ^= s0[i]
+	}
+	return plain, subtle.ConstantTimeCompare(mac[:tagLen], data[n:]) == 1
+}
+
+// ccmSeal encrypts and authenticates a message with CCM.
+func ccmSeal(block cipher.Block, nonce, plain, aad []byte, tagLen int) []byte {
+	mac := ccmMAC(block, nonce, plain, aad, tagLen)
+	out, s0 := ccmCTR(block, nonce, plain)
+	for i := 0; i < tagLen; i++ {
+		out = append(out, mac[i]^s0[i])
+	}
+	return out
+}
+
+// ccmCTR encrypts data in counter mode, and returns the key stream block of
+// the counter 0, used to encrypt the MAC.
+func ccmCTR(block cipher.Block, nonce, data []byte) (out []byte, s0 [16]byte) {
+	var ctr, ks [16]byte
+	ctr[0] = 1
+	copy(ctr[1:14], nonce)
+	block.Encrypt(s0[:], ctr[:])
+	out = make([]byte, len(data), len(data)+16)
+	for i := 0; i < len(data); i += 16 {
+		binary.BigEndian.PutUint16(ctr[14:], uint16(i/16+1))
+		block.Encrypt(ks[:], ctr[:])
+		for j := i; j < len(data) && j < i+16; j++ {
+			out[j] = data[j] ^ ks[j-i]
+		}
+	}
+	return out, s0
+}
+
+// ccmMAC computes the CBC-MAC of a CCM message.
+func ccmMAC(block cipher.Block, nonce, plain, aad []byte, tagLen int) [16]byte {
+	var b, x [16]byte
+	b[0] = byte((tagLen-2)/2)<<3 | 1
+	if len(aad) > 0 {
+		b[0] |= 0x40
+	}
+	copy(b[1:14], nonce)
+	binary.BigEndian.PutUint16(b[14:], uint16(len(plain)))
+	block.Encrypt(x[:], b[:])
+	mac := func(data []byte) {
+		for i := 0; i < len(data); i += 16 {
+			for j := i; j < len(data) && j < i+16; j++ {
+				x[j-i] ^= data[j]
+			}
+			block.Encrypt(x[:], x[:])
+		}
+	}
+	if len(aad) > 0 {
+		a := make([]byte, 2+len(aad))
+		binary.BigEndian.PutUint16(a, uint16(len(aad)))
+		copy(a[2:], aad)
+		mac(a)
+	}
+	mac(plain)
+	return x
+}
+
+// decryptWEP decrypts the body of a frame protected with WEP, and checks its
+// ICV.
+func decryptWEP(body, key []byte) ([]byte, error) {
+	if len(body) < 8 {
+		return nil, ErrDecryption
+	}
+	seed := append(append(make([]byte, 0, 3+len(key)), body[:3]...), key...)
+	return rc4ICV(seed, body[4:])
+}
+
+// rc4ICV decrypts data with RC4 and checks the ICV found in its last 4
+// bytes, as for WEP and TKIP.
+func rc4ICV(seed, data []byte) ([]byte, error) {
+	c, err := rc4.NewCipher(seed)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(data))
+	c.XORKeyStream(plain, data)
+	n := len(plain) - 4
+	if binary.LittleEndian.Uint32(plain[n:]) != crc32.ChecksumIEEE(plain[:n]) {
+		return nil, ErrDecryption
+	}
+	return plain[:n], nil
+}
+
+// decryptTKIP decrypts the body of a frame protected with TKIP, and checks
+// its ICV and Michael MIC.  The Michael MIC covers the whole MSDU, so it is
+// only checked for unfragmented frames; fragments are returned as is.
+func decryptTKIP(f *layers.Dot11, tk temporalKey, micKey []byte) ([]byte, error) {
+	body := f.Payload
+	if len(body) < 8+4+8 {
+		return nil, ErrDecryption
+	}
+	iv16 := uint16(body[0])<<8 | uint16(body[2])
+	iv32 := binary.LittleEndian.Uint32(body[4:8])
+	seed := tkipMixing(tk.key[:16], f.Address2, iv32, iv16)
+	plain, err := rc4ICV(seed[:], body[8:])
+	if err != nil || f.Flags.MF() || f.FragmentNumber != 0 {
+		return plain, err
+	}
+
+	var da, sa []byte
+	switch {
+	case f.Flags.ToDS() && f.Flags.FromDS():
+		da, sa = f.Address3, f.Address4
+	case f.Flags.ToDS():
+		da, sa = f.Address3, f.Address2
+	case f.Flags.FromDS():
+		da, sa = f.Address1, f.Address3
+	default:
+		da, sa = f.Address1, f.Address2
+	}
+	n := len(plain) - 8
+	data := make([]byte, 0, 16+n)
+	data = append(append(data, da...), sa...)
+	data = append(data, priority(f), 0, 0, 0)
+	data = append(data, plain[:n]...)
+	mic := michael(micKey, data)
+	if subtle.ConstantTimeCompare(mic[:], plain[n:]) != 1 {
+		return nil, ErrDecryption
+	}
+	return plain[:n], nil
+}
+
+// michael computes the Michael MIC of TKIP (IEEE 802.11, section
+// 12.5.2.3).
+func michael(key, data []byte) (mic [8]byte) {
+	l := binary.LittleEndian.Uint32(key)
+	r := binary.LittleEndian.Uint32(key[4:])
+	block := func(w uint32) {
+		l ^= w
+		r ^= l<<17 | l>>15
+		l += r
+		r ^= (l&0xff00ff00)>>8 | (l&0x00ff00ff)<<8
+		l += r
+		r ^= l<<3 | l>>29
+		l += r
+		r ^= l>>2 | l<<30
+		l += r
+	}
+	for len(data) >= 4 {
+		block(binary.LittleEndian.Uint32(data))
+		data = data[4:]
+	}
+	// The message is padded with 0x5a and at least 4 zeros
+	var last [4]byte
+	copy(last[:], data)
+	last[len(data)] = 0x5a
+	block(binary.LittleEndian.Uint32(last[:]))
+	block(0)
+	binary.LittleEndian.PutUint32(mic[:], l)
+	binary.LittleEndian.PutUint32(mic[4:], r)
+	return mic
+}
+
+// tkipSbox is the S-box of the TKIP key mixing function, derived from the
+// S-box of AES.
+var tkipSbox [256]uint16
+
+func init() {
+	var sbox [256]byte
+	rotl := func(x byte, n uint) byte { return x<<n | x>>(8-n) }
+	p, q := byte(1), byte(1)
+	for {
+		var m byte
+		if p&0x80 != 0 {
+			m = 0x1b
+		}
+		p = p ^ p<<1 ^ m
+		q ^= q << 1
+		q ^= q << 2
+		q ^= q << 4
+		if q&0x80 != 0 {
+			q ^= 0x09
+		}
+		sbox[p] = q ^ rotl(q, 1) ^ rotl(q, 2) ^ rotl(q, 3) ^ rotl(q, 4) ^ 0x63
+		if p == 1 {
+			break
+		}
+	}
+	sbox[0] = 0x63
+	for i, s := range sbox {
+		s2 := s << 1
+		if s&0x80 != 0 {
+			s2 ^= 0x1b
+		}
+		tkipSbox[i] = uint16(s2)<<8 | uint16(s2^s)
+	}
+}
+
+func tkipS(v uint16) uint16 {
+	hi := tkipSbox[v>>8]
+	return tkipSbox[v&0xff] ^ (hi<<8 | hi>>8)
+}
+
+func mk16(hi, lo byte) uint16 {
+	return uint16(hi)<<8 | uint16(lo)
+}
+
+// tkipMixing computes the RC4 key of a TKIP frame from the temporal key, the
+// transmitter address and the TSC (IEEE 802.11, section 12.5.2.5).
+func tkipMixing(tk, ta []byte, iv32 uint32, iv16 uint16) (seed [16]byte) {
+	// Phase 1
+	var p [6]uint16
+	p[0] = uint16(iv32)
+	p[1] = uint16(iv32 >> 16)
+	p[2] = mk16(ta[1], ta[0])
+	p[3] = mk16(ta[3], ta[2])
+	p[4] = mk16(ta[5], ta[4])
+	for i := 0; i < 8; i++ {
+		j := 2 * (i & 1)
+		p[0] += tkipS(p[4] ^ mk16(tk[1+j], tk[j]))
+		p[1] += tkipS(p[0] ^ mk16(tk[5+j], tk[4+j]))
+		p[2] += tkipS(p[1] ^ mk16(tk[9+j], tk[8+j]))
+		p[3] += tkipS(p[2] ^ mk16(tk[13+j], tk[12+j]))
+		p[4] += tkipS(p[3]^mk16(tk[1+j], tk[j])) + uint16(i)
+	}
+
+	// Phase 2
+	rotr1 := func(v uint16) uint16 { return v>>1 | v<<15 }
+	p[5] = p[4] + iv16
+	p[0] += tkipS(p[5] ^ mk16(tk[1], tk[0]))
+	p[1] += tkipS(p[0] ^ mk16(tk[3], tk[2]))
+	p[2] += tkipS(p[1] ^ mk16(tk[5], tk[4]))
+	p[3] += tkipS(p[2] ^ mk16(tk[7], tk[6]))
+	p[4] += tkipS(p[3] ^ mk16(tk[9], tk[8]))
+	p[5] += tkipS(p[4] ^ mk16(tk[11], tk[10]))
+	p[0] += rotr1(p[5] ^ mk16(tk[13], tk[12]))
+	p[1] += rotr1(p[0] ^ mk16(tk[15], tk[14]))
+	p[2] += rotr1(p[1])
+	p[3] += rotr1(p[2])
+	p[4] += rotr1(p[3])
+	p[5] += rotr1(p[4])
+
+	seed[0] = byte(iv16 >> 8)
+	seed[1] = (byte(iv16>>8) | 0x20) & 0x7f
+	seed[2] = byte(iv16)
+	seed[3] = byte((p[5] ^ mk16(tk[1], tk[0])) >> 1)
+	for i := 0; i < 6; i++ {
+		seed[4+2*i] = byte(p[i])
+		seed[5+2*i] = byte(p[i] >> 8)
+	}
+	return seed
+}
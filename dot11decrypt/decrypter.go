@@ -0,0 +1,358 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package dot11decrypt decrypts the data frames of 802.11 networks protected
+// with WPA/WPA2/WPA3 (CCMP, GCMP and TKIP) or WEP.
+//
+// A Decrypter is given the passphrases, PMKs or WEP keys of the networks,
+// and fed all the data frames captured in monitor mode, in order.  It
+// follows the EAPOL-Key handshakes between access points and stations to
+// derive their pairwise keys (PTK) and group keys (GTK), so frames can only
+// be decrypted once the 4-way handshake of a station has been captured.
+//
+// Usage example:
+//
+//	d := dot11decrypt.NewDecrypter()
+//	if err := d.AddPassphrase("MyNetwork", "secret passphrase"); err != nil {
+//		...
+//	}
+//	for packet := range source.Packets() {
+//		dot11, _ := packet.Layer(layers.LayerTypeDot11).(*layers.Dot11)
+//		if dot11 == nil {
+//			continue
+//		}
+//		msdu, err := d.Decrypt(dot11)
+//		if err != nil || msdu == nil {
+//			continue
+//		}
+//		p := gopacket.NewPacket(msdu, layers.LayerTypeLLC, gopacket.Default)
+//		...
+//	}
+package dot11decrypt
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	// ErrNoKey is returned when decrypting a frame whose key is unknown,
+	// typically because the handshake of the station wasn't captured.
+	ErrNoKey = errors.New("dot11decrypt: no key for frame")
+	// ErrDecryption is returned when a frame fails its integrity check
+	// once decrypted.
+	ErrDecryption = errors.New("dot11decrypt: decryption failed")
+	// ErrUnsupported is returned for protected frames that can't be
+	// decrypted, like frames between stations of an IBSS or a mesh.
+	ErrUnsupported = errors.New("dot11decrypt: unsupported frame")
+)
+
+// session is the state of the key handshakes between an access point and a
+// station.
+type session struct {
+	anonce, snonce []byte
+	info           rsnInfo
+	hasInfo        bool
+	ptk            *ptk
+}
+
+// Decrypter decrypts the data frames of protected 802.11 networks.  It is
+// safe for concurrent use, but frames must be passed in the order they were
+// captured for the handshakes to be followed.
+type Decrypter struct {
+	mu       sync.Mutex
+	pmks     [][]byte
+	wepKeys  [][]byte
+	sessions map[[12]byte]*session
+	groups   map[[6]byte]*[4]*temporalKey
+}
+
+// NewDecrypter returns a Decrypter without any key.
+func NewDecrypter() *Decrypter {
+	return &Decrypter{
+		sessions: make(map[[12]byte]*session),
+		groups:   make(map[[6]byte]*[4]*temporalKey),
+	}
+}
+
+// AddPassphrase adds the passphrase of a WPA/WPA2-Personal network, whose
+// PMK depends on the SSID.
+func (d *Decrypter) AddPassphrase(ssid, passphrase string) error {
+	if len(ssid) > 32 {
+		return errors.New("dot11decrypt: SSID longer than 32 bytes")
+	}
+	if len(passphrase) < 8 || len(passphrase) > 63 {
+		return errors.New("dot11decrypt: passphrase must have 8 to 63 characters")
+	}
+	return d.AddPMK(passphraseToPMK(passphrase, ssid))
+}
+
+// AddPMK adds a 32 bytes pairwise master key.  This is the only way to
+// decrypt WPA3-Personal (SAE) and WPA-Enterprise networks, whose PMK is
+// established during the authentication and can't be derived from captured
+// frames; it must be retrieved from the access point, the station or the
+// authentication server.
+func (d *Decrypter) AddPMK(pmk []byte) error {
+	if len(pmk) != 32 {
+		return errors.New("dot11decrypt: PMK must have 32 bytes")
+	}
+	d.mu.Lock()
+	d.pmks = append(d.pmks, append([]byte(nil), pmk...))
+	d.mu.Unlock()
+	return nil
+}
+
+// AddWEPKey adds a WEP key of 5, 13 or 16 bytes.  All the WEP keys are tried
+// on each WEP frame, regardless of its key ID.
+func (d *Decrypter) AddWEPKey(key []byte) error {
+	switch len(key) {
+	case 5, 13, 16:
+	default:
+		return errors.New("dot11decrypt: WEP key must have 5, 13 or 16 bytes")
+	}
+	d.mu.Lock()
+	d.wepKeys = append(d.wepKeys, append([]byte(nil), key...))
+	d.mu.Unlock()
+	return nil
+}
+
+// Decrypt returns the MSDU of a data frame, starting with its LLC/SNAP
+// header, that can be decoded with layers.LayerTypeLLC.  Protected frames
+// are decrypted, and EAPOL-Key frames are used to follow the key handshakes.
+// Decrypt returns nil without error for frames without data, like
+// management, control or null data frames.
+//
+// Each fragment of a fragmented MSDU is decrypted separately, and its
+// plaintext returned: fragments must be put back together by the caller.
+func (d *Decrypter) Decrypt(frame *layers.Dot11) ([]byte, error) {
+	if frame.Type.MainType() != layers.Dot11TypeData || len(frame.Payload) == 0 {
+		return nil, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	bssid, sta := stations(frame)
+	msdu := frame.Payload
+	if frame.Flags.WEP() {
+		var err error
+		if msdu, err = d.decrypt(frame, bssid, sta); err != nil {
+			return nil, err
+		}
+	}
+	if sta != nil && frame.FragmentNumber == 0 && !frame.Flags.MF() {
+		d.handleEAPOL(msdu, bssid, sta)
+	}
+	return msdu, nil
+}
+
+// stations returns the BSSID of a frame sent to or from an access point,
+// and the address of the station, nil for group addressed frames.
+func stations(f *layers.Dot11) (bssid, sta net.HardwareAddr) {
+	switch {
+	case f.Flags.ToDS() && !f.Flags.FromDS():
+		return f.Address1, f.Address2
+	case f.Flags.FromDS() && !f.Flags.ToDS():
+		if f.Address1[0]&1 != 0 {
+			return f.Address2, nil
+		}
+		return f.Address2, f.Address1
+	}
+	return nil, nil
+}
+
+func pairKey(bssid, sta net.HardwareAddr) (k [12]byte) {
+	copy(k[:6], bssid)
+	copy(k[6:], sta)
+	return k
+}
+
+func (d *Decrypter) decrypt(f *layers.Dot11, bssid, sta net.HardwareAddr) ([]byte, error) {
+	body := f.Payload
+	if len(body) < 4 {
+		return nil, ErrDecryption
+	}
+	if body[3]&0x20 == 0 {
+		// No extended IV: WEP
+		if len(d.wepKeys) == 0 {
+			return nil, ErrNoKey
+		}
+		for _, key := range d.wepKeys {
+			if plain, err := decryptWEP(body, key); err == nil {
+				return plain, nil
+			}
+		}
+		return nil, ErrDecryption
+	}
+	if bssid == nil {
+		return nil, ErrUnsupported
+	}
+
+	var tk *temporalKey
+	var micKey []byte
+	if sta == nil {
+		var k [6]byte
+		copy(k[:], bssid)
+		if g := d.groups[k]; g != nil {
+			tk = g[body[3]>>6]
+		}
+		if tk != nil && tk.cipher == cipherTKIP {
+			micKey = tk.key[16:24]
+		}
+	} else if s := d.sessions[pairKey(bssid, sta)]; s != nil && s.ptk != nil {
+		tk = &s.ptk.tk
+		if tk.cipher == cipherTKIP {
+			// Authenticator to supplicant, or supplicant to authenticator
+			micKey = tk.key[16:24]
+			if f.Flags.ToDS() {
+				micKey = tk.key[24:32]
+			}
+		}
+	}
+	if tk == nil {
+		return nil, ErrNoKey
+	}
+	switch tk.cipher {
+	case cipherTKIP:
+		return decryptTKIP(f, *tk, micKey)
+	case cipherCCMP128, cipherCCMP256, cipherGCMP128, cipherGCMP256:
+		return decryptCCMP(f, *tk)
+	}
+	return nil, ErrUnsupported
+}
+
+var eapolSNAP = []byte{0xaa, 0xaa, 0x03, 0x00, 0x00, 0x00, 0x88, 0x8e}
+
+// handleEAPOL follows the key handshakes, from the EAPOL-Key frames found in
+// the MSDUs sent between an access point and a station.
+func (d *Decrypter) handleEAPOL(msdu []byte, bssid, sta net.HardwareAddr) {
+	if !bytes.HasPrefix(msdu, eapolSNAP) {
+		return
+	}
+	p := gopacket.NewPacket(msdu, layers.LayerTypeLLC, gopacket.NoCopy)
+	eapol, _ := p.Layer(layers.LayerTypeEAPOL).(*layers.EAPOL)
+	key, _ := p.Layer(layers.LayerTypeEAPOLKey).(*layers.EAPOLKey)
+	if eapol == nil || key == nil {
+		return
+	}
+	frame := msdu[len(eapolSNAP):]
+	if n := 4 + int(eapol.Length); n <= len(frame) {
+		frame = frame[:n]
+	}
+	if len(frame) < eapolKeyDataOffset+int(key.KeyDataLength) {
+		return
+	}
+	keyData := frame[eapolKeyDataOffset : eapolKeyDataOffset+int(key.KeyDataLength)]
+
+	k := pairKey(bssid, sta)
+	s := d.sessions[k]
+	if s == nil {
+		s = &session{}
+		d.sessions[k] = s
+	}
+
+	pairwise := key.KeyType == layers.EAPOLKeyTypePairwise
+	switch {
+	case pairwise && key.KeyACK && !key.KeyMIC:
+		// Message 1 of the 4-way handshake
+		s.anonce = append([]byte(nil), key.Nonce...)
+	case pairwise && !key.KeyACK && key.KeyMIC && !isZero(key.Nonce):
+		// Message 2 of the 4-way handshake, with the RSN element
+		s.snonce = append([]byte(nil), key.Nonce...)
+		s.info, s.hasInfo = parseRSNInfo(keyData)
+		if s.anonce != nil {
+			d.derivePTK(s, key, frame, bssid, sta)
+		}
+	case pairwise && key.KeyACK && key.KeyMIC:
+		// Message 3 of the 4-way handshake, with the GTK for RSN
+		if s.ptk == nil || !bytes.Equal(s.anonce, key.Nonce) {
+			s.anonce = append([]byte(nil), key.Nonce...)
+			if s.snonce != nil {
+				d.derivePTK(s, key, frame, bssid, sta)
+			}
+		}
+		if s.ptk != nil && key.HasEncryptedKeyData && validMIC(key.KeyDescriptorVersion, s.ptk.kck, frame) {
+			d.installGTK(s, key, keyData, bssid)
+		}
+	case !pairwise && key.KeyACK && key.KeyMIC:
+		// Message 1 of the group key handshake
+		if s.ptk != nil && validMIC(key.KeyDescriptorVersion, s.ptk.kck, frame) {
+			d.installGTK(s, key, keyData, bssid)
+		}
+	}
+}
+
+// derivePTK tries the PMKs to find the PTK whose KCK matches the MIC of a
+// frame of the 4-way handshake.
+func (d *Decrypter) derivePTK(s *session, key *layers.EAPOLKey, frame []byte, bssid, sta net.HardwareAddr) {
+	info := s.info
+	if !s.hasInfo {
+		info = defaultRSNInfo(key.KeyDescriptorVersion)
+	}
+	for _, pmk := range d.pmks {
+		ptk := derivePTK(pmk, info, bssid, sta, s.anonce, s.snonce)
+		if validMIC(key.KeyDescriptorVersion, ptk.kck, frame) {
+			s.ptk = ptk
+			return
+		}
+	}
+}
+
+// installGTK decrypts the key data of an EAPOL-Key frame to find the GTK of
+// the network.
+func (d *Decrypter) installGTK(s *session, key *layers.EAPOLKey, keyData []byte, bssid net.HardwareAddr) {
+	wpa := key.KeyDescriptorType == layers.EAPOLKeyDescriptorTypeWPA
+	if !key.HasEncryptedKeyData && !wpa {
+		return
+	}
+	data, err := decryptKeyData(key.KeyDescriptorVersion, s.ptk.kek, key.IV, keyData)
+	if err != nil {
+		return
+	}
+	var id uint8
+	var gtk []byte
+	if wpa {
+		// The key data of the WPA group key handshake is the GTK itself
+		if int(key.KeyLength) > len(data) {
+			return
+		}
+		id, gtk = key.KeyIndex, data[:key.KeyLength]
+	} else {
+		var ok bool
+		if id, gtk, ok = findGTK(data); !ok {
+			return
+		}
+	}
+
+	c := s.info.group
+	if !s.hasInfo {
+		c = defaultRSNInfo(key.KeyDescriptorVersion).group
+	}
+	if len(gtk) < c.keyLen() {
+		return
+	}
+	var k [6]byte
+	copy(k[:], bssid)
+	g := d.groups[k]
+	if g == nil {
+		g = new([4]*temporalKey)
+		d.groups[k] = g
+	}
+	g[id] = &temporalKey{cipher: c, key: append([]byte(nil), gtk[:c.keyLen()]...)}
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,460 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot11decrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rc4"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestPassphraseToPMK(t *testing.T) {
+	// IEEE 802.11, section J.4.2
+	want := unhex("f42c6fc52df0ebef9ebb4b90b38a5f902e83fe1b135a70e23aed762e9710a12e")
+	if got := passphraseToPMK("password", "IEEE"); !bytes.Equal(got, want) {
+		t.Errorf("got PMK %x, want %x", got, want)
+	}
+}
+
+func TestAESCMAC(t *testing.T) {
+	// RFC 4493, section 4
+	key := unhex("2b7e151628aed2a6abf7158809cf4f3c")
+	for _, test := range []struct{ msg, mac string }{
+		{"", "bb1d6929e95937287fa37d129b756746"},
+		{"6bc1bee22e409f96e93d7e117393172a", "070a16b46b4d4144f79bdd9dd04a287c"},
+	} {
+		if got := aesCMAC(key, unhex(test.msg)); !bytes.Equal(got, unhex(test.mac)) {
+			t.Errorf("message %q: got %x, want %s", test.msg, got, test.mac)
+		}
+	}
+}
+
+// aesKeyWrap wraps data with the AES key wrap algorithm (RFC 3394).
+func aesKeyWrap(kek, data []byte) []byte {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		panic(err)
+	}
+	n := len(data) / 8
+	r := append([]byte(nil), data...)
+	var b [16]byte
+	for i := range b[:8] {
+		b[i] = 0xa6
+	}
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b[8:], r[(i-1)*8:i*8])
+			block.Encrypt(b[:], b[:])
+			binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(b[:8])^uint64(n*j+i))
+			copy(r[(i-1)*8:], b[8:])
+		}
+	}
+	return append(b[:8:8], r...)
+}
+
+func TestAESKeyUnwrap(t *testing.T) {
+	// RFC 3394, section 4.1
+	kek := unhex("000102030405060708090a0b0c0d0e0f")
+	key := unhex("00112233445566778899aabbccddeeff")
+	wrapped := unhex("1fa68b0a8112b447aef34bd8fb5a7b829d3e862371d2cfe5")
+	if got := aesKeyWrap(kek, key); !bytes.Equal(got, wrapped) {
+		t.Errorf("wrap: got %x", got)
+	}
+	if got, err := aesKeyUnwrap(kek, wrapped); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("unwrap: got %x, %v", got, err)
+	}
+	wrapped[0] ^= 1
+	if _, err := aesKeyUnwrap(kek, wrapped); err != errKeyUnwrap {
+		t.Errorf("unwrap of corrupted data: got %v", err)
+	}
+}
+
+func TestCCM(t *testing.T) {
+	// RFC 3610, packet vector #1
+	block, _ := aes.NewCipher(unhex("c0c1c2c3c4c5c6c7c8c9cacbcccdcecf"))
+	nonce := unhex("00000003020100a0a1a2a3a4a5")
+	in := unhex("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e")
+	want := unhex("588c979a61c663d2f066d0c2c0f989806d5f6b61dac38417e8d12cfdf926e0")
+	out := ccmSeal(block, nonce, in[8:], in[:8], 8)
+	if !bytes.Equal(out, want) {
+		t.Errorf("seal: got %x, want %x", out, want)
+	}
+	plain, ok := ccmOpen(block, nonce, want, in[:8], 8)
+	if !ok || !bytes.Equal(plain, in[8:]) {
+		t.Errorf("open: got %x, %v", plain, ok)
+	}
+	want[0] ^= 1
+	if _, ok := ccmOpen(block, nonce, want, in[:8], 8); ok {
+		t.Error("open: corrupted message authenticated")
+	}
+}
+
+func TestMichael(t *testing.T) {
+	// IEEE 802.11, section M.6.2
+	key := make([]byte, 8)
+	for _, test := range []struct{ msg, mic string }{
+		{"", "82925c1ca1d130b8"},
+		{"M", "434721ca40639b3f"},
+		{"Mi", "e8f9becae97e5d29"},
+		{"Mic", "90038fc6cf13c1db"},
+		{"Mich", "d55e100510128986"},
+		{"Michael", "0a942b124ecaa546"},
+	} {
+		mic := michael(key, []byte(test.msg))
+		if hex.EncodeToString(mic[:]) != test.mic {
+			t.Errorf("message %q: got %x, want %s", test.msg, mic, test.mic)
+		}
+		key = mic[:]
+	}
+}
+
+func TestTKIPMixing(t *testing.T) {
+	// IEEE 802.11, section M.6.3, test vectors #1 and #2
+	tk := unhex("000102030405060708090a0b0c0d0e0f")
+	ta := []byte{0x10, 0x22, 0x33, 0x44, 0x55, 0x66}
+	for _, test := range []struct {
+		iv16 uint16
+		key  string
+	}{
+		{0, "00200033ea8d2f60ca6d1374234a660b"},
+		{1, "00200190ffdc314389a9d9d074fd20aa"},
+	} {
+		seed := tkipMixing(tk, ta, 0, test.iv16)
+		if hex.EncodeToString(seed[:]) != test.key {
+			t.Errorf("IV16 %d: got RC4 key %x, want %s", test.iv16, seed, test.key)
+		}
+	}
+}
+
+var (
+	testAP   = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	testSTA  = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+	testHost = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x03}
+	testBC   = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+)
+
+// testFrame builds a data frame, from the access point when toDS is false.
+// QoS frames use the TID 5.
+func testFrame(toDS, qos, protected bool, a1, a2, a3 net.HardwareAddr, body []byte) []byte {
+	fc := []byte{0x08, 0x02}
+	if toDS {
+		fc[1] = 0x01
+	}
+	if qos {
+		fc[0] = 0x88
+	}
+	if protected {
+		fc[1] |= 0x40
+	}
+	f := append(fc, 0, 0)
+	f = append(append(append(f, a1...), a2...), a3...)
+	f = append(f, 0x10, 0x00)
+	if qos {
+		f = append(f, 5, 0)
+	}
+	f = append(f, body...)
+	var fcs [4]byte
+	binary.LittleEndian.PutUint32(fcs[:], crc32.ChecksumIEEE(f))
+	return append(f, fcs[:]...)
+}
+
+func decodeDot11(t *testing.T, frame []byte) *layers.Dot11 {
+	p := gopacket.NewPacket(frame, layers.LayerTypeDot11, gopacket.Default)
+	dot11, _ := p.Layer(layers.LayerTypeDot11).(*layers.Dot11)
+	if dot11 == nil {
+		t.Fatalf("no Dot11 layer in %v", p)
+	}
+	return dot11
+}
+
+// testProtect encrypts the body of a frame built with testFrame.
+func testProtect(t *testing.T, tk temporalKey, keyID uint8, pn uint64, toDS, qos bool, a1, a2, a3 net.HardwareAddr, plain []byte) []byte {
+	f := decodeDot11(t, testFrame(toDS, qos, true, a1, a2, a3, make([]byte, 32)))
+	var hdr [8]byte
+	hdr[0], hdr[1] = byte(pn), byte(pn>>8)
+	hdr[3] = 0x20 | keyID<<6
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(pn>>16))
+	body := hdr[:]
+	pnBytes := []byte{byte(pn >> 40), byte(pn >> 32), byte(pn >> 24), byte(pn >> 16), byte(pn >> 8), byte(pn)}
+	block, _ := aes.NewCipher(tk.key)
+
+	switch tk.cipher {
+	case cipherCCMP128:
+		nonce := append(append([]byte{priority(f)}, a2...), pnBytes...)
+		body = append(body, ccmSeal(block, nonce, plain, aad(f), 8)...)
+	case cipherGCMP256:
+		gcm, _ := cipher.NewGCM(block)
+		body = gcm.Seal(body, append(append([]byte(nil), a2...), pnBytes...), plain, aad(f))
+	case cipherTKIP:
+		// TSC1, WEP seed, TSC0
+		hdr[0], hdr[1], hdr[2] = byte(pn>>8), (byte(pn>>8)|0x20)&0x7f, byte(pn)
+		body = hdr[:]
+		da, sa, micKey := a1, a3, tk.key[16:24]
+		if toDS {
+			da, sa, micKey = a3, a2, tk.key[24:32]
+		}
+		data := append(append(append(append([]byte(nil), da...), sa...), priority(f), 0, 0, 0), plain...)
+		mic := michael(micKey, data)
+		seed := tkipMixing(tk.key[:16], a2, uint32(pn>>16), uint16(pn))
+		body = append(body, rc4ICVSeal(seed[:], append(append([]byte(nil), plain...), mic[:]...))...)
+	default:
+		t.Fatalf("unexpected cipher %d", tk.cipher)
+	}
+	return testFrame(toDS, qos, true, a1, a2, a3, body)
+}
+
+func rc4ICVSeal(seed, plain []byte) []byte {
+	var icv [4]byte
+	binary.LittleEndian.PutUint32(icv[:], crc32.ChecksumIEEE(plain))
+	data := append(append([]byte(nil), plain...), icv[:]...)
+	c, _ := rc4.NewCipher(seed)
+	c.XORKeyStream(data, data)
+	return data
+}
+
+// testMSDU returns an IPv4/UDP packet with its LLC/SNAP header.
+func testMSDU(t *testing.T) []byte {
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{192, 0, 2, 2}, DstIP: net.IP{192, 0, 2, 3}}
+	udp := &layers.UDP{SrcPort: 1234, DstPort: 5678}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		&layers.LLC{DSAP: 0xaa, SSAP: 0xaa, Control: 3},
+		&layers.SNAP{OrganizationalCode: []byte{0, 0, 0}, Type: layers.EthernetTypeIPv4},
+		ip, udp, gopacket.Payload("hello, wireless world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testHandshake describes the 4-way handshake between testAP and testSTA.
+type testHandshake struct {
+	pmk            []byte
+	version        layers.EAPOLKeyDescriptorVersion
+	info           rsnInfo
+	anonce, snonce []byte
+	gtk            []byte
+	gtkID          uint8
+}
+
+// eapolKey builds the MSDU of an EAPOL-Key frame, with its MIC if kck is
+// set.
+func (h *testHandshake) eapolKey(info uint16, nonce, iv, keyData, kck []byte) []byte {
+	info |= uint16(h.version)
+	body := make([]byte, 95)
+	body[0] = 2
+	binary.BigEndian.PutUint16(body[1:], info)
+	binary.BigEndian.PutUint16(body[3:], 16)
+	body[12] = 1
+	copy(body[13:], nonce)
+	copy(body[45:], iv)
+	binary.BigEndian.PutUint16(body[93:], uint16(len(keyData)))
+	body = append(body, keyData...)
+	frame := append([]byte{2, 3, 0, 0}, body...)
+	binary.BigEndian.PutUint16(frame[2:], uint16(len(body)))
+	if kck != nil {
+		copy(frame[eapolMICOffset:], eapolMIC(h.version, kck, frame))
+	}
+	return append(append([]byte(nil), eapolSNAP...), frame...)
+}
+
+// frames returns the first three messages of the handshake, and the PTK.
+func (h *testHandshake) frames(t *testing.T) ([][]byte, *ptk) {
+	ptk := derivePTK(h.pmk, h.info, testAP, testSTA, h.anonce, h.snonce)
+	rsne := []byte{48, 20, 1, 0, 0, 0x0f, 0xac, byte(h.info.group), 1, 0, 0, 0x0f, 0xac, byte(h.info.pairwise), 1, 0, 0, 0x0f, 0xac, byte(h.info.akm), 0, 0}
+	kde := append([]byte{221, byte(6 + len(h.gtk)), 0, 0x0f, 0xac, 1, h.gtkID, 0}, h.gtk...)
+	if len(kde)%8 != 0 {
+		kde = append(kde, 0xdd)
+		for len(kde)%8 != 0 {
+			kde = append(kde, 0)
+		}
+	}
+	var iv, keyData []byte
+	if h.version == layers.EAPOLKeyDescriptorVersionRC4HMACMD5 {
+		iv = bytes.Repeat([]byte{7}, 16)
+		c, _ := rc4.NewCipher(append(append([]byte(nil), iv...), ptk.kek...))
+		var discard [256]byte
+		c.XORKeyStream(discard[:], discard[:])
+		keyData = make([]byte, len(kde))
+		c.XORKeyStream(keyData, kde)
+	} else {
+		keyData = aesKeyWrap(ptk.kek, kde)
+	}
+
+	return [][]byte{
+		testFrame(false, false, false, testSTA, testAP, testAP, h.eapolKey(0x0088, h.anonce, nil, nil, nil)),
+		testFrame(true, false, false, testAP, testSTA, testAP, h.eapolKey(0x0108, h.snonce, nil, rsne, ptk.kck)),
+		testFrame(false, false, false, testSTA, testAP, testAP, h.eapolKey(0x13c8, h.anonce, iv, keyData, ptk.kck)),
+	}, ptk
+}
+
+func testDecrypt(t *testing.T, d *Decrypter, frame []byte) ([]byte, error) {
+	return d.Decrypt(decodeDot11(t, frame))
+}
+
+func checkMSDU(t *testing.T, name string, got, want []byte) {
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s: got MSDU %x, want %x", name, got, want)
+		return
+	}
+	p := gopacket.NewPacket(got, layers.LayerTypeLLC, gopacket.Default)
+	if p.ErrorLayer() != nil || p.Layer(layers.LayerTypeUDP) == nil {
+		t.Errorf("%s: bad MSDU %v", name, p)
+	}
+}
+
+func testHandshakeDecrypt(t *testing.T, d *Decrypter, h *testHandshake) {
+	msdu := testMSDU(t)
+	frames, ptk := h.frames(t)
+	if h.info.pairwise != cipherTKIP {
+		// Check the derivation, since encryption uses the same PTK
+		if _, err := testDecrypt(t, d, testProtect(t, ptk.tk, 0, 1, false, true, testSTA, testAP, testAP, msdu)); err != ErrNoKey {
+			t.Errorf("before handshake: got error %v", err)
+		}
+	}
+	for i, f := range frames {
+		if plain, err := testDecrypt(t, d, f); err != nil || !bytes.HasPrefix(plain, eapolSNAP) {
+			t.Fatalf("message %d: got %x, %v", i+1, plain, err)
+		}
+	}
+
+	for _, test := range []struct {
+		name      string
+		toDS, qos bool
+		a1, a3    net.HardwareAddr
+	}{
+		{"from AP", false, false, testSTA, testHost},
+		{"to AP", true, false, testAP, testHost},
+		{"QoS from AP", false, true, testSTA, testHost},
+		{"QoS to AP", true, true, testAP, testHost},
+	} {
+		a2 := testAP
+		if test.toDS {
+			a2 = testSTA
+		}
+		frame := testProtect(t, ptk.tk, 0, 0x10203, test.toDS, test.qos, test.a1, a2, test.a3, msdu)
+		plain, err := testDecrypt(t, d, frame)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		checkMSDU(t, test.name, plain, msdu)
+
+		frame[len(frame)-10] ^= 1
+		if _, err := testDecrypt(t, d, frame); err != ErrDecryption {
+			t.Errorf("%s: got error %v for a corrupted frame", test.name, err)
+		}
+	}
+
+	gtk := temporalKey{cipher: h.info.group, key: h.gtk}
+	plain, err := testDecrypt(t, d, testProtect(t, gtk, h.gtkID, 7, false, false, testBC, testAP, testHost, msdu))
+	if err != nil {
+		t.Errorf("group frame: %v", err)
+	} else {
+		checkMSDU(t, "group frame", plain, msdu)
+	}
+}
+
+func TestWPA2CCMP(t *testing.T) {
+	d := NewDecrypter()
+	if err := d.AddPassphrase("IEEE", "short"); err == nil {
+		t.Error("short passphrase accepted")
+	}
+	if err := d.AddPassphrase("IEEE", "not the password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddPassphrase("IEEE", "password"); err != nil {
+		t.Fatal(err)
+	}
+	testHandshakeDecrypt(t, d, &testHandshake{
+		pmk:     passphraseToPMK("password", "IEEE"),
+		version: layers.EAPOLKeyDescriptorVersionAESHMACSHA1,
+		info:    rsnInfo{group: cipherCCMP128, pairwise: cipherCCMP128, akm: akmPSK},
+		anonce:  bytes.Repeat([]byte{1}, 32),
+		snonce:  bytes.Repeat([]byte{2}, 32),
+		gtk:     bytes.Repeat([]byte{3}, 16),
+		gtkID:   1,
+	})
+}
+
+func TestWPA3GCMP(t *testing.T) {
+	pmk := bytes.Repeat([]byte{0x42}, 32)
+	d := NewDecrypter()
+	if err := d.AddPMK(pmk); err != nil {
+		t.Fatal(err)
+	}
+	testHandshakeDecrypt(t, d, &testHandshake{
+		pmk:     pmk,
+		version: layers.EAPOLKeyDescriptorVersionOther,
+		info:    rsnInfo{group: cipherCCMP128, pairwise: cipherGCMP256, akm: akmSAE},
+		anonce:  bytes.Repeat([]byte{4}, 32),
+		snonce:  bytes.Repeat([]byte{5}, 32),
+		gtk:     bytes.Repeat([]byte{6}, 16),
+		gtkID:   2,
+	})
+}
+
+func TestTKIP(t *testing.T) {
+	d := NewDecrypter()
+	if err := d.AddPassphrase("tkip", "tkip passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	testHandshakeDecrypt(t, d, &testHandshake{
+		pmk:     passphraseToPMK("tkip passphrase", "tkip"),
+		version: layers.EAPOLKeyDescriptorVersionRC4HMACMD5,
+		info:    rsnInfo{group: cipherTKIP, pairwise: cipherTKIP, akm: akmPSK},
+		anonce:  bytes.Repeat([]byte{7}, 32),
+		snonce:  bytes.Repeat([]byte{8}, 32),
+		gtk:     bytes.Repeat([]byte{9}, 32),
+		gtkID:   1,
+	})
+}
+
+func TestWEP(t *testing.T) {
+	key := []byte("12345")
+	msdu := testMSDU(t)
+	iv := []byte{1, 2, 3}
+	body := append(append(append([]byte(nil), iv...), 0), rc4ICVSeal(append(append([]byte(nil), iv...), key...), msdu)...)
+	frame := testFrame(true, false, true, testAP, testSTA, testHost, body)
+
+	d := NewDecrypter()
+	if _, err := testDecrypt(t, d, frame); err != ErrNoKey {
+		t.Errorf("got error %v without key", err)
+	}
+	if err := d.AddWEPKey([]byte("54321")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testDecrypt(t, d, frame); err != ErrDecryption {
+		t.Errorf("got error %v with the wrong key", err)
+	}
+	if err := d.AddWEPKey(key); err != nil {
+		t.Fatal(err)
+	}
+	plain, err := testDecrypt(t, d, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMSDU(t, "WEP", plain, msdu)
+}
@@ -0,0 +1,358 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot11decrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rc4"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/google/gopacket/layers"
+)
+
+// cipherSuite is a cipher suite selector of the RSN element (OUI
+// 00-0F-AC), also used for the matching ciphers of the WPA element.
+type cipherSuite uint8
+
+const (
+	cipherWEP40   cipherSuite = 1
+	cipherTKIP    cipherSuite = 2
+	cipherCCMP128 cipherSuite = 4
+	cipherWEP104  cipherSuite = 5
+	cipherGCMP128 cipherSuite = 8
+	cipherGCMP256 cipherSuite = 9
+	cipherCCMP256 cipherSuite = 10
+)
+
+// keyLen returns the length of the temporal key of the cipher.
+func (c cipherSuite) keyLen() int {
+	switch c {
+	case cipherTKIP, cipherGCMP256, cipherCCMP256:
+		return 32
+	case cipherWEP40:
+		return 5
+	case cipherWEP104:
+		return 13
+	}
+	return 16
+}
+
+// akmSuite is an authentication and key management suite selector of the
+// RSN element.
+type akmSuite uint8
+
+const (
+	akm8021X       akmSuite = 1
+	akmPSK         akmSuite = 2
+	akm8021XSHA256 akmSuite = 5
+	akmPSKSHA256   akmSuite = 6
+	akmSAE         akmSuite = 8
+)
+
+// sha256 tells whether the AKM derives the PTK with KDF-SHA256 rather than
+// PRF-SHA1.
+func (a akmSuite) sha256() bool {
+	switch a {
+	case akm8021XSHA256, akmPSKSHA256, akmSAE:
+		return true
+	}
+	return false
+}
+
+var (
+	rsnOUI = []byte{0x00, 0x0f, 0xac}
+	wpaOUI = []byte{0x00, 0x50, 0xf2}
+)
+
+// rsnInfo holds the suites selected in the RSN or WPA element sent by a
+// station in message 2 of the 4-way handshake.
+type rsnInfo struct {
+	group, pairwise cipherSuite
+	akm             akmSuite
+}
+
+// parseRSNInfo looks for a RSN or WPA element in the key data of an
+// EAPOL-Key frame.
+func parseRSNInfo(data []byte) (info rsnInfo, ok bool) {
+	for len(data) >= 2 && len(data) >= 2+int(data[1]) {
+		id, body := data[0], data[2:2+int(data[1])]
+		data = data[2+int(data[1]):]
+		oui := rsnOUI
+		switch {
+		case id == 48:
+		case id == 221 && len(body) >= 4 && bytes.Equal(body[:3], wpaOUI) && body[3] == 1:
+			oui, body = wpaOUI, body[4:]
+		default:
+			continue
+		}
+		// Version, group cipher, one pairwise cipher and one AKM
+		if len(body) < 18 || !bytes.Equal(body[2:5], oui) || !bytes.Equal(body[8:11], oui) || !bytes.Equal(body[14:17], oui) {
+			return info, false
+		}
+		info.group = cipherSuite(body[5])
+		info.pairwise = cipherSuite(body[11])
+		info.akm = akmSuite(body[17])
+		return info, true
+	}
+	return info, false
+}
+
+// defaultRSNInfo guesses the suites from the descriptor version of the
+// EAPOL-Key frames, when no RSN element was seen.
+func defaultRSNInfo(v layers.EAPOLKeyDescriptorVersion) rsnInfo {
+	switch v {
+	case layers.EAPOLKeyDescriptorVersionRC4HMACMD5:
+		return rsnInfo{group: cipherTKIP, pairwise: cipherTKIP, akm: akmPSK}
+	case layers.EAPOLKeyDescriptorVersionAESHMACSHA1:
+		return rsnInfo{group: cipherCCMP128, pairwise: cipherCCMP128, akm: akmPSK}
+	case layers.EAPOLKeyDescriptorVersionAES128CMAC:
+		return rsnInfo{group: cipherCCMP128, pairwise: cipherCCMP128, akm: akmPSKSHA256}
+	}
+	return rsnInfo{group: cipherCCMP128, pairwise: cipherCCMP128, akm: akmSAE}
+}
+
+// passphraseToPMK derives the PMK of WPA-Personal from a passphrase, with
+// PBKDF2-HMAC-SHA1 (IEEE 802.11, section J.4).
+func passphraseToPMK(passphrase, ssid string) []byte {
+	mac := hmac.New(sha1.New, []byte(passphrase))
+	var pmk []byte
+	for block := uint32(1); len(pmk) < 32; block++ {
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], block)
+		mac.Reset()
+		mac.Write([]byte(ssid))
+		mac.Write(b[:])
+		u := mac.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < 4096; i++ {
+			mac.Reset()
+			mac.Write(u)
+			u = mac.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		pmk = append(pmk, t...)
+	}
+	return pmk[:32]
+}
+
+// prfSHA1 is the PRF of IEEE 802.11, section 12.7.1.2.
+func prfSHA1(key []byte, label string, data []byte, bits int) []byte {
+	mac := hmac.New(sha1.New, key)
+	var out []byte
+	for i := 0; len(out) < bits/8; i++ {
+		mac.Reset()
+		mac.Write([]byte(label))
+		mac.Write([]byte{0})
+		mac.Write(data)
+		mac.Write([]byte{byte(i)})
+		out = mac.Sum(out)
+	}
+	return out[:bits/8]
+}
+
+// kdfSHA256 is the KDF of IEEE 802.11, section 12.7.1.7.2.
+func kdfSHA256(key []byte, label string, context []byte, bits int) []byte {
+	mac := hmac.New(sha256.New, key)
+	var out []byte
+	var b [2]byte
+	for i := uint16(1); len(out) < bits/8; i++ {
+		mac.Reset()
+		binary.LittleEndian.PutUint16(b[:], i)
+		mac.Write(b[:])
+		mac.Write([]byte(label))
+		mac.Write(context)
+		binary.LittleEndian.PutUint16(b[:], uint16(bits))
+		mac.Write(b[:])
+		out = mac.Sum(out)
+	}
+	return out[:bits/8]
+}
+
+// ptk is a pairwise transient key.
+type ptk struct {
+	kck, kek []byte
+	tk       temporalKey
+}
+
+// derivePTK derives the PTK of a station from the PMK and the nonces of the
+// 4-way handshake.
+func derivePTK(pmk []byte, info rsnInfo, aa, spa, anonce, snonce []byte) *ptk {
+	data := make([]byte, 0, 76)
+	if bytes.Compare(aa, spa) < 0 {
+		data = append(append(data, aa...), spa...)
+	} else {
+		data = append(append(data, spa...), aa...)
+	}
+	if bytes.Compare(anonce, snonce) < 0 {
+		data = append(append(data, anonce...), snonce...)
+	} else {
+		data = append(append(data, snonce...), anonce...)
+	}
+	bits := (32 + info.pairwise.keyLen()) * 8
+	var b []byte
+	if info.akm.sha256() {
+		b = kdfSHA256(pmk, "Pairwise key expansion", data, bits)
+	} else {
+		b = prfSHA1(pmk, "Pairwise key expansion", data, bits)
+	}
+	return &ptk{kck: b[:16], kek: b[16:32], tk: temporalKey{cipher: info.pairwise, key: b[32:]}}
+}
+
+// EAPOL-Key frames start with the 4 bytes of the EAPOL header, the MIC is at
+// offset 77 of the EAPOL-Key body and the key data at offset 95.
+const (
+	eapolMICOffset     = 4 + 77
+	eapolKeyDataOffset = 4 + 95
+)
+
+// eapolMIC computes the MIC of an EAPOL-Key frame, whose MIC field is
+// ignored.
+func eapolMIC(v layers.EAPOLKeyDescriptorVersion, kck, frame []byte) []byte {
+	data := append([]byte(nil), frame...)
+	for i := eapolMICOffset; i < eapolMICOffset+16; i++ {
+		data[i] = 0
+	}
+	switch v {
+	case layers.EAPOLKeyDescriptorVersionRC4HMACMD5:
+		mac := hmac.New(md5.New, kck)
+		mac.Write(data)
+		return mac.Sum(nil)
+	case layers.EAPOLKeyDescriptorVersionAESHMACSHA1:
+		mac := hmac.New(sha1.New, kck)
+		mac.Write(data)
+		return mac.Sum(nil)[:16]
+	}
+	return aesCMAC(kck, data)
+}
+
+// validMIC tells whether the MIC of an EAPOL-Key frame is valid for kck.
+func validMIC(v layers.EAPOLKeyDescriptorVersion, kck, frame []byte) bool {
+	if len(frame) < eapolKeyDataOffset {
+		return false
+	}
+	return hmac.Equal(eapolMIC(v, kck, frame), frame[eapolMICOffset:eapolMICOffset+16])
+}
+
+// aesCMAC computes the AES-CMAC of data (RFC 4493).
+func aesCMAC(key, data []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil
+	}
+	var k1, k2, x [16]byte
+	block.Encrypt(k1[:], k1[:])
+	cmacShift(&k1)
+	k2 = k1
+	cmacShift(&k2)
+
+	n := (len(data) + 15) / 16
+	if n == 0 {
+		n = 1
+	}
+	for i := 0; i < n-1; i++ {
+		for j := range x {
+			x[j] ^= data[i*16+j]
+		}
+		block.Encrypt(x[:], x[:])
+	}
+	last := data[(n-1)*16:]
+	if len(last) == 16 {
+		for j := range x {
+			x[j] ^= last[j] ^ k1[j]
+		}
+	} else {
+		var padded [16]byte
+		copy(padded[:], last)
+		padded[len(last)] = 0x80
+		for j := range x {
+			x[j] ^= padded[j] ^ k2[j]
+		}
+	}
+	block.Encrypt(x[:], x[:])
+	return x[:]
+}
+
+// cmacShift shifts k left by one bit, for the generation of CMAC subkeys.
+func cmacShift(k *[16]byte) {
+	msb := k[0] & 0x80
+	for i := 0; i < 15; i++ {
+		k[i] = k[i]<<1 | k[i+1]>>7
+	}
+	k[15] <<= 1
+	if msb != 0 {
+		k[15] ^= 0x87
+	}
+}
+
+var errKeyUnwrap = errors.New("dot11decrypt: AES key unwrap failed")
+
+// aesKeyUnwrap decrypts data wrapped with the AES key wrap algorithm (RFC
+// 3394), like the key data of EAPOL-Key frames.
+func aesKeyUnwrap(kek, data []byte) ([]byte, error) {
+	if len(data)%8 != 0 || len(data) < 24 {
+		return nil, errKeyUnwrap
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(data)/8 - 1
+	var b [16]byte
+	copy(b[:8], data)
+	r := append([]byte(nil), data[8:]...)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(b[:8])^t)
+			copy(b[8:], r[(i-1)*8:i*8])
+			block.Decrypt(b[:], b[:])
+			copy(r[(i-1)*8:], b[8:])
+		}
+	}
+	for _, v := range b[:8] {
+		if v != 0xa6 {
+			return nil, errKeyUnwrap
+		}
+	}
+	return r, nil
+}
+
+// decryptKeyData decrypts the key data of an EAPOL-Key frame with the KEK.
+func decryptKeyData(v layers.EAPOLKeyDescriptorVersion, kek, iv, data []byte) ([]byte, error) {
+	if v != layers.EAPOLKeyDescriptorVersionRC4HMACMD5 {
+		return aesKeyUnwrap(kek, data)
+	}
+	c, err := rc4.NewCipher(append(append([]byte(nil), iv...), kek...))
+	if err != nil {
+		return nil, err
+	}
+	var discard [256]byte
+	c.XORKeyStream(discard[:], discard[:])
+	out := make([]byte, len(data))
+	c.XORKeyStream(out, data)
+	return out, nil
+}
+
+// findGTK looks for the GTK KDE in decrypted key data.
+func findGTK(data []byte) (id uint8, gtk []byte, ok bool) {
+	for len(data) >= 2 && len(data) >= 2+int(data[1]) {
+		id, body := data[0], data[2:2+int(data[1])]
+		data = data[2+int(data[1]):]
+		if id == 221 && len(body) > 6 && bytes.Equal(body[:3], rsnOUI) && body[3] == 1 {
+			return body[4] & 0x3, body[6:], true
+		}
+	}
+	return 0, nil, false
+}
@@ -12,7 +12,6 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
-	"encoding/binary"
 	"encoding/hex"
 	"flag"
 	"fmt"
@@ -416,31 +415,22 @@ func (t *tcpStream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.Ass
 	}
 	data := sg.Fetch(length)
 	if t.isDNS {
-		dns := &layers.DNS{}
-		var decoded []gopacket.LayerType
-		if len(data) < 2 {
-			if len(data) > 0 {
-				sg.KeepFrom(0)
+		// Several messages may be sent over the connection, like the
+		// responses of a zone transfer
+		off := 0
+		for off < len(data) {
+			dns := &layers.DNS{}
+			n, err := dns.DecodeFromTCPBytes(data[off:], gopacket.NilDecodeFeedback)
+			if err == layers.ErrDNSTCPIncomplete {
+				Debug("Incomplete DNS message: %d bytes\n", len(data)-off)
+				sg.KeepFrom(off)
+				break
+			} else if err != nil {
+				Error("DNS-parser", "Failed to decode DNS: %v\n", err)
+				break
 			}
-			return
-		}
-		dnsSize := binary.BigEndian.Uint16(data[:2])
-		missing := int(dnsSize) - len(data[2:])
-		Debug("dnsSize: %d, missing: %d\n", dnsSize, missing)
-		if missing > 0 {
-			Info("Missing some bytes: %d\n", missing)
-			sg.KeepFrom(0)
-			return
-		}
-		p := gopacket.NewDecodingLayerParser(layers.LayerTypeDNS, dns)
-		err := p.DecodeLayers(data[2:], &decoded)
-		if err != nil {
-			Error("DNS-parser", "Failed to decode DNS: %v\n", err)
-		} else {
 			Debug("DNS: %s\n", gopacket.LayerDump(dns))
-		}
-		if len(data) > 2+int(dnsSize) {
-			sg.KeepFrom(2 + int(dnsSize))
+			off += n
 		}
 	} else if t.isHTTP {
 		if length > 0 {
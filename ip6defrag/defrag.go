@@ -0,0 +1,455 @@
+// Copyright 2013 Google, Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package ip6defrag implements a IPv6 defragmenter
+package ip6defrag
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Quick and Easy to use debug code to trace
+// how defrag works.
+var debug debugging = false // or flip to true
+type debugging bool
+
+func (d debugging) Printf(format string, args ...interface{}) {
+	if d {
+		log.Printf(format, args...)
+	}
+}
+
+// Constants determining how to handle fragments.
+// Reference RFC 8200, section 4.5
+const (
+	IPv6MinimumFragmentSize    = 8     // Minimum size of a single non final fragment
+	IPv6MaximumSize            = 65535 // Maximum size of a reassembled payload (2^16)
+	IPv6MaximumFragmentListLen = 8192  // Back out if we get more than this many fragments
+)
+
+// fragmentHeaderLength is the size of the IPv6 Fragment extension header
+const fragmentHeaderLength = 8
+
+// DefragIPv6 takes in an IPv6 packet with a fragment payload.
+//
+// It do not modify the IPv6 layer in place, 'in' remains untouched
+// It returns a ready-to be used IPv6 layer.
+//
+// If the passed-in IPv6 layer is NOT fragmented, it will
+// immediately return it without modifying the layer.
+//
+// If the IPv6 layer is a fragment and we don't have all
+// fragments, it will return nil and store whatever internal
+// information it needs to eventually defrag the packet.
+//
+// If the IPv6 layer is the last fragment needed to reconstruct
+// the packet, a new IPv6 layer will be returned, and will be set to
+// the entire defragmented packet. Its payload starts with the
+// unfragmentable extension headers of the first fragment, without the
+// Fragment header, followed by the reassembled fragmentable part.
+//
+// Atomic fragments (offset 0 and no more fragments) are returned at once,
+// without being mixed with other fragments, as required by RFC 8200.
+//
+// Overlapping fragments are not allowed in IPv6: the whole datagram they
+// belong to is discarded, and so are its fragments received later on, until
+// they are forgotten by DiscardOlderThan.
+//
+// Usage example:
+//
+//	func HandlePacket(in *layers.IPv6) err {
+//	    defragger := ip6defrag.NewIPv6Defragmenter()
+//	    in, err := defragger.DefragIPv6(in)
+//	    if err != nil {
+//	        return err
+//	    } else if in == nil {
+//	        return nil  // packet fragment, we don't have whole packet yet.
+//	    }
+//	    // At this point, we know that 'in' is defragmented.
+//	    ... decode in.Payload starting with in.NextLayerType() ...
+//	}
+func (d *IPv6Defragmenter) DefragIPv6(in *layers.IPv6) (*layers.IPv6, error) {
+	return d.DefragIPv6WithTimestamp(in, time.Now())
+}
+
+// DefragIPv6WithTimestamp provides functionality of DefragIPv6 with
+// an additional timestamp parameter which is used for discarding
+// old fragments instead of time.Now()
+//
+// This is useful when operating on pcap files instead of live captured data
+func (d *IPv6Defragmenter) DefragIPv6WithTimestamp(in *layers.IPv6, t time.Time) (*layers.IPv6, error) {
+	frag, err := findFragment(in)
+	if err != nil {
+		return nil, err
+	}
+	// check if we need to defrag
+	if frag == nil {
+		debug.Printf("defrag: do nothing, do not need anything")
+		return in, nil
+	}
+	// perfom security checks
+	if err := d.securityChecks(frag); err != nil {
+		debug.Printf("defrag: alert security check")
+		return nil, err
+	}
+
+	// RFC 8200: an atomic fragment is a fully reassembled packet on its own
+	if frag.offset == 0 && !frag.more {
+		debug.Printf("defrag: atomic fragment in.Id=%d\n", frag.id)
+		return frag.build(frag.data), nil
+	}
+
+	// ok, got a fragment
+	debug.Printf("defrag: got a new fragment in.Id=%d in.FragOffset=%d in.More=%t\n",
+		frag.id, frag.offset, frag.more)
+
+	// have we already seen a flow between src/dst with that Id?
+	ipf := newIPv6(in, frag.id)
+	d.Lock()
+	defer d.Unlock()
+	fl, exist := d.ipFlows[ipf]
+	if !exist {
+		debug.Printf("defrag: unknown flow, creating a new one\n")
+		fl = new(fragmentList)
+		d.ipFlows[ipf] = fl
+	}
+	// insert, and if final build it
+	out, err2 := fl.insert(frag, t)
+
+	// at last, if we hit the maximum frag list len
+	// without any defrag success, we just drop everything and
+	// raise an error
+	if out == nil && fl.List.Len()+1 > IPv6MaximumFragmentListLen {
+		delete(d.ipFlows, ipf)
+		return nil, fmt.Errorf("defrag: Fragment List hits its maximum"+
+			"size(%d), without success. Flushing the list",
+			IPv6MaximumFragmentListLen)
+	}
+
+	// if we got a packet, it's a new one, and he is defragmented
+	if out != nil {
+		// when defrag is done for a flow between two ip
+		// clean the list
+		delete(d.ipFlows, ipf)
+		return out, nil
+	}
+	return nil, err2
+}
+
+// DiscardOlderThan forgets all packets without any activity since
+// time t. It returns the number of FragmentList aka number of
+// fragment packets it has discarded.
+func (d *IPv6Defragmenter) DiscardOlderThan(t time.Time) int {
+	var nb int
+	d.Lock()
+	for k, v := range d.ipFlows {
+		if v.LastSeen.Before(t) {
+			nb = nb + 1
+			delete(d.ipFlows, k)
+		}
+	}
+	d.Unlock()
+	return nb
+}
+
+// securityChecks performs the needed security checks
+func (d *IPv6Defragmenter) securityChecks(f *fragment) error {
+	fragSize := len(f.data)
+
+	// non final fragments must be a multiple of 8 bytes long
+	if f.more && (fragSize < IPv6MinimumFragmentSize || fragSize%8 != 0) {
+		return fmt.Errorf("defrag: fragment size invalid "+
+			"(handcrafted? %d is not a non-zero multiple of 8)", fragSize)
+	}
+
+	// don't allow fragment that would oversize an IP packet
+	if size := f.headerLength + int(f.offset) + fragSize; size > IPv6MaximumSize {
+		return fmt.Errorf("defrag: fragment will overrun "+
+			"(handcrafted? %d > %d)", size, IPv6MaximumSize)
+	}
+
+	// the first fragment must contain the whole header chain, see RFC 7112
+	if f.offset == 0 && !headerChainComplete(f.nextHeader, f.data) {
+		return errors.New("defrag: first fragment does not " +
+			"contain the whole IPv6 header chain")
+	}
+
+	return nil
+}
+
+// headerChainComplete returns false if the extension headers at the start
+// of data, or the upper-layer header following them, are truncated.  The
+// upper-layer header is only checked for TCP, UDP and ICMPv6.
+func headerChainComplete(next layers.IPProtocol, data []byte) bool {
+	for {
+		switch next {
+		case layers.IPProtocolIPv6HopByHop, layers.IPProtocolIPv6Routing, layers.IPProtocolIPv6Destination:
+			if len(data) < 2 {
+				return false
+			}
+			l := (int(data[1]) + 1) * 8
+			if len(data) < l {
+				return false
+			}
+			next = layers.IPProtocol(data[0])
+			data = data[l:]
+		case layers.IPProtocolIPv6Fragment:
+			return len(data) >= fragmentHeaderLength
+		case layers.IPProtocolTCP:
+			return len(data) >= 20 && len(data) >= int(data[12]>>4)*4
+		case layers.IPProtocolUDP, layers.IPProtocolICMPv6:
+			return len(data) >= 8
+		default:
+			return true
+		}
+	}
+}
+
+// fragment is a decoded IPv6 Fragment header, along with the packet
+// it was found in.
+type fragment struct {
+	ip *layers.IPv6
+	// unfragmentable holds the extension headers found between the IPv6
+	// header (and its HopByHop header) and the Fragment header.
+	unfragmentable []byte
+	// headerLength is the size of the HopByHop and unfragmentable headers.
+	headerLength int
+
+	nextHeader layers.IPProtocol
+	offset     uint16
+	more       bool
+	id         uint32
+	data       []byte
+}
+
+// findFragment walks the extension header chain of ip, and returns the
+// Fragment header it contains, or nil.
+func findFragment(ip *layers.IPv6) (*fragment, error) {
+	next := ip.NextHeader
+	hbhLen := 0
+	if ip.HopByHop != nil {
+		next = ip.HopByHop.NextHeader
+		hbhLen = len(ip.HopByHop.Contents)
+	}
+	data := ip.Payload
+	off := 0
+	for {
+		switch next {
+		case layers.IPProtocolIPv6Routing, layers.IPProtocolIPv6Destination:
+			if len(data) < off+2 {
+				return nil, errors.New("defrag: truncated IPv6 extension header")
+			}
+			l := (int(data[off+1]) + 1) * 8
+			if len(data) < off+l {
+				return nil, errors.New("defrag: truncated IPv6 extension header")
+			}
+			next = layers.IPProtocol(data[off])
+			off += l
+		case layers.IPProtocolIPv6Fragment:
+			if len(data) < off+fragmentHeaderLength {
+				return nil, errors.New("defrag: truncated IPv6 fragment header")
+			}
+			h := data[off : off+fragmentHeaderLength]
+			return &fragment{
+				ip:             ip,
+				unfragmentable: data[:off],
+				headerLength:   hbhLen + off,
+				nextHeader:     layers.IPProtocol(h[0]),
+				offset:         binary.BigEndian.Uint16(h[2:4]) &^ 0x7,
+				more:           h[3]&0x1 != 0,
+				id:             binary.BigEndian.Uint32(h[4:8]),
+				data:           data[off+fragmentHeaderLength:],
+			}, nil
+		default:
+			return nil, nil
+		}
+	}
+}
+
+// build returns a new IPv6 layer for the packet f belongs to, with the
+// Fragment header removed and payload as its fragmentable part.
+func (f *fragment) build(payload []byte) *layers.IPv6 {
+	in := f.ip
+	out := &layers.IPv6{
+		Version:      in.Version,
+		TrafficClass: in.TrafficClass,
+		FlowLabel:    in.FlowLabel,
+		NextHeader:   in.NextHeader,
+		HopLimit:     in.HopLimit,
+		SrcIP:        in.SrcIP,
+		DstIP:        in.DstIP,
+	}
+
+	final := make([]byte, 0, len(f.unfragmentable)+len(payload))
+	final = append(final, f.unfragmentable...)
+	final = append(final, payload...)
+
+	// Link the header that pointed to the Fragment header to the
+	// fragmentable part.
+	var last int
+	for off := 0; off < len(f.unfragmentable); off += (int(final[off+1]) + 1) * 8 {
+		last = off
+	}
+	if in.HopByHop != nil {
+		hbh := *in.HopByHop
+		out.HopByHop = &hbh
+	}
+	switch {
+	case len(f.unfragmentable) > 0:
+		final[last] = byte(f.nextHeader)
+	case out.HopByHop != nil:
+		out.HopByHop.NextHeader = f.nextHeader
+	default:
+		out.NextHeader = f.nextHeader
+	}
+
+	out.Length = uint16(f.headerLength + len(payload))
+	out.Payload = final
+	return out
+}
+
+// fragmentList holds a container/list used to contains IP
+// packets/fragments.  It stores internal counters to track the
+// maximum total of byte, and the current length it has received.
+// It also stores a flag to know if he has seen the last packet.
+type fragmentList struct {
+	List          list.List
+	Highest       int
+	Current       int
+	FinalReceived bool
+	LastSeen      time.Time
+	// Discarded is set once overlapping fragments have been received.
+	Discarded bool
+}
+
+// insert insert an IPv6 fragment into the Fragment List, ordered by
+// offset. Unlike IPv4, overlapping fragments are forbidden (RFC 8200,
+// section 4.5), but exact duplicates are silently ignored.
+func (f *fragmentList) insert(in *fragment, t time.Time) (*layers.IPv6, error) {
+	f.LastSeen = t
+	if f.Discarded {
+		debug.Printf("defrag: ignoring frag %d of a discarded datagram\n", in.offset)
+		return nil, nil
+	}
+
+	start := int(in.offset)
+	end := start + len(in.data)
+	if f.FinalReceived && end > f.Highest || !in.more && end < f.Highest {
+		f.discard()
+		return nil, fmt.Errorf("defrag: fragment %d-%d beyond the end of the datagram", start, end)
+	}
+
+	var at *list.Element
+	for e := f.List.Front(); e != nil; e = e.Next() {
+		frag := e.Value.(*fragment)
+		fStart := int(frag.offset)
+		fEnd := fStart + len(frag.data)
+		if start == fStart && end == fEnd {
+			debug.Printf("defrag: ignoring frag %d as we already have it (duplicate?)\n", start)
+			return nil, nil
+		}
+		if start < fEnd && fStart < end {
+			f.discard()
+			return nil, fmt.Errorf("defrag: fragment %d-%d overlaps fragment %d-%d, discarding the datagram",
+				start, end, fStart, fEnd)
+		}
+		if at == nil && start < fStart {
+			at = e
+		}
+	}
+	if at != nil {
+		f.List.InsertBefore(in, at)
+	} else {
+		f.List.PushBack(in)
+	}
+
+	// After inserting the Fragment, we update the counters
+	if f.Highest < end {
+		f.Highest = end
+	}
+	f.Current += len(in.data)
+
+	debug.Printf("defrag: insert ListLen: %d Highest:%d Current:%d\n",
+		f.List.Len(),
+		f.Highest, f.Current)
+
+	// Final Fragment ?
+	if !in.more {
+		f.FinalReceived = true
+	}
+	// Ready to try defrag ? Since fragments don't overlap, having
+	// received as many bytes as the datagram size means there's no hole.
+	if f.FinalReceived && f.Highest == f.Current {
+		return f.build()
+	}
+	return nil, nil
+}
+
+// discard drops the fragments of the datagram, and marks it so its
+// remaining fragments are ignored.
+func (f *fragmentList) discard() {
+	f.List.Init()
+	f.Discarded = true
+}
+
+// build builds the final datagram. The unfragmentable part and the next
+// header are taken from the first fragment.
+func (f *fragmentList) build() (*layers.IPv6, error) {
+	debug.Printf("defrag: building the datagram \n")
+	first := f.List.Front().Value.(*fragment)
+	if first.offset != 0 {
+		return nil, errors.New("defrag: building - hole found")
+	}
+	payload := make([]byte, 0, f.Highest)
+	for e := f.List.Front(); e != nil; e = e.Next() {
+		frag := e.Value.(*fragment)
+		if int(frag.offset) != len(payload) {
+			debug.Printf("defrag: hole found while building, " +
+				"stopping the defrag process\n")
+			return nil, errors.New("defrag: building - hole found")
+		}
+		payload = append(payload, frag.data...)
+	}
+	return first.build(payload), nil
+}
+
+// ipv6 is a struct to be used as a key.
+type ipv6 struct {
+	ip6 gopacket.Flow
+	id  uint32
+}
+
+// newIPv6 returns a new initialized IPv6 Flow
+func newIPv6(ip *layers.IPv6, id uint32) ipv6 {
+	return ipv6{
+		ip6: ip.NetworkFlow(),
+		id:  id,
+	}
+}
+
+// IPv6Defragmenter is a struct which embedded a map of
+// all fragment/packet.
+type IPv6Defragmenter struct {
+	sync.RWMutex
+	ipFlows map[ipv6]*fragmentList
+}
+
+// NewIPv6Defragmenter returns a new IPv6Defragmenter
+// with an initialized map.
+func NewIPv6Defragmenter() *IPv6Defragmenter {
+	return &IPv6Defragmenter{
+		ipFlows: make(map[ipv6]*fragmentList),
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2013 Google, Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ip6defrag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	testSrcIP = net.ParseIP("2001:db8::1")
+	testDstIP = net.ParseIP("2001:db8::2")
+)
+
+// testPayload is an UDP datagram of 64 bytes
+var testPayload = func() []byte {
+	p := make([]byte, 64)
+	binary.BigEndian.PutUint16(p[0:], 1234)
+	binary.BigEndian.PutUint16(p[2:], 5678)
+	binary.BigEndian.PutUint16(p[4:], 64)
+	for i := 8; i < len(p); i++ {
+		p[i] = byte(i)
+	}
+	return p
+}()
+
+// buildFragment returns a decoded IPv6 packet whose header chain is made of
+// the headers in ext, followed by a Fragment header and data.
+func buildFragment(t *testing.T, id uint32, offset int, more bool, next layers.IPProtocol, ext []byte, data []byte) *layers.IPv6 {
+	first := layers.IPProtocolIPv6Fragment
+	if len(ext) > 0 {
+		first = layers.IPProtocolIPv6Destination
+	}
+	pkt := make([]byte, 40, 40+len(ext)+8+len(data))
+	pkt[0] = 0x60
+	binary.BigEndian.PutUint16(pkt[4:], uint16(len(ext)+8+len(data)))
+	pkt[6] = byte(first)
+	pkt[7] = 64
+	copy(pkt[8:], testSrcIP)
+	copy(pkt[24:], testDstIP)
+	pkt = append(pkt, ext...)
+	frag := make([]byte, 8)
+	frag[0] = byte(next)
+	fo := uint16(offset)
+	if more {
+		fo |= 1
+	}
+	binary.BigEndian.PutUint16(frag[2:], fo)
+	binary.BigEndian.PutUint32(frag[4:], id)
+	pkt = append(pkt, frag...)
+	pkt = append(pkt, data...)
+
+	p := gopacket.NewPacket(pkt, layers.LayerTypeIPv6, gopacket.Default)
+	ip, ok := p.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+	if !ok {
+		t.Fatalf("could not decode IPv6 fragment: %v", p.ErrorLayer())
+	}
+	return ip
+}
+
+// testDestOpts is a Destination Options header, with its next header set to
+// Fragment, containing only padding.
+var testDestOpts = []byte{byte(layers.IPProtocolIPv6Fragment), 0, 1, 4, 0, 0, 0, 0}
+
+func checkUDP(t *testing.T, ip *layers.IPv6, next gopacket.LayerType) {
+	if int(ip.Length) != len(ip.Payload) {
+		t.Errorf("defrag: IPv6 length %d, payload is %d bytes", ip.Length, len(ip.Payload))
+	}
+	p := gopacket.NewPacket(ip.Payload, next, gopacket.Default)
+	udp, ok := p.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok {
+		t.Fatalf("defrag: no UDP layer in defragmented packet: %v", p)
+	}
+	if udp.SrcPort != 1234 || udp.DstPort != 5678 || !bytes.Equal(udp.Payload, testPayload[8:]) {
+		t.Errorf("defrag: payload is not correctly defragmented: %v", udp)
+	}
+}
+
+func TestNotFrag(t *testing.T) {
+	ip := layers.IPv6{
+		Version:    6,
+		NextHeader: layers.IPProtocolUDP,
+		HopLimit:   64,
+		SrcIP:      testSrcIP,
+		DstIP:      testDstIP,
+		BaseLayer:  layers.BaseLayer{Payload: testPayload},
+	}
+	defrag := NewIPv6Defragmenter()
+
+	out, err := defrag.DefragIPv6(&ip)
+	if out != &ip || err != nil {
+		t.Errorf("defrag: this packet do not need to be defrag ['%s']", err)
+	}
+}
+
+func TestDefragUnordered(t *testing.T) {
+	defrag := NewIPv6Defragmenter()
+
+	frags := []*layers.IPv6{
+		buildFragment(t, 1, 32, false, layers.IPProtocolUDP, nil, testPayload[32:]),
+		buildFragment(t, 1, 0, true, layers.IPProtocolUDP, nil, testPayload[:16]),
+		buildFragment(t, 1, 0, true, layers.IPProtocolUDP, nil, testPayload[:16]), // duplicate
+		buildFragment(t, 1, 16, true, layers.IPProtocolUDP, nil, testPayload[16:32]),
+	}
+	for i, f := range frags[:3] {
+		if out, err := defrag.DefragIPv6(f); out != nil || err != nil {
+			t.Fatalf("defrag: fragment %d: got %v, %v", i, out, err)
+		}
+	}
+	out, err := defrag.DefragIPv6(frags[3])
+	if out == nil || err != nil {
+		t.Fatalf("defrag: last fragment: got %v, %v", out, err)
+	}
+	if out.NextHeader != layers.IPProtocolUDP {
+		t.Errorf("defrag: next header is %v", out.NextHeader)
+	}
+	checkUDP(t, out, out.NextLayerType())
+
+	if n := defrag.DiscardOlderThan(time.Now()); n != 0 {
+		t.Errorf("defrag: discarded more fragments then expected: %d", n)
+	}
+}
+
+func TestDefragUnfragmentableHeaders(t *testing.T) {
+	defrag := NewIPv6Defragmenter()
+
+	f1 := buildFragment(t, 2, 0, true, layers.IPProtocolUDP, testDestOpts, testPayload[:40])
+	f2 := buildFragment(t, 2, 40, false, layers.IPProtocolUDP, testDestOpts, testPayload[40:])
+	if out, err := defrag.DefragIPv6(f1); out != nil || err != nil {
+		t.Fatalf("defrag: first fragment: got %v, %v", out, err)
+	}
+	out, err := defrag.DefragIPv6(f2)
+	if out == nil || err != nil {
+		t.Fatalf("defrag: last fragment: got %v, %v", out, err)
+	}
+	if out.NextHeader != layers.IPProtocolIPv6Destination {
+		t.Errorf("defrag: next header is %v", out.NextHeader)
+	}
+	if out.Payload[0] != byte(layers.IPProtocolUDP) {
+		t.Errorf("defrag: destination options next header is %d", out.Payload[0])
+	}
+	checkUDP(t, out, out.NextLayerType())
+}
+
+func TestDefragAtomicFragment(t *testing.T) {
+	defrag := NewIPv6Defragmenter()
+
+	// A pending fragment with the same identification must not be mixed
+	// with the atomic fragment, see RFC 8200 section 4.5.
+	pending := buildFragment(t, 3, 0, true, layers.IPProtocolUDP, nil, testPayload[:16])
+	if out, err := defrag.DefragIPv6(pending); out != nil || err != nil {
+		t.Fatalf("defrag: pending fragment: got %v, %v", out, err)
+	}
+
+	atomic := buildFragment(t, 3, 0, false, layers.IPProtocolUDP, nil, testPayload)
+	out, err := defrag.DefragIPv6(atomic)
+	if out == nil || err != nil {
+		t.Fatalf("defrag: atomic fragment: got %v, %v", out, err)
+	}
+	checkUDP(t, out, out.NextLayerType())
+
+	if n := defrag.DiscardOlderThan(time.Now().Add(time.Second)); n != 1 {
+		t.Errorf("defrag: expected the pending fragment to be kept, discarded %d", n)
+	}
+}
+
+func TestDefragOverlapping(t *testing.T) {
+	defrag := NewIPv6Defragmenter()
+
+	f1 := buildFragment(t, 4, 0, true, layers.IPProtocolUDP, nil, testPayload[:24])
+	f2 := buildFragment(t, 4, 16, true, layers.IPProtocolUDP, nil, testPayload[16:32])
+	f3 := buildFragment(t, 4, 24, false, layers.IPProtocolUDP, nil, testPayload[24:])
+	if out, err := defrag.DefragIPv6(f1); out != nil || err != nil {
+		t.Fatalf("defrag: first fragment: got %v, %v", out, err)
+	}
+	if _, err := defrag.DefragIPv6(f2); err == nil {
+		t.Fatal("defrag: overlapping fragments must be rejected")
+	}
+	// The datagram is discarded, even though f1 and f3 would complete it.
+	if out, err := defrag.DefragIPv6(f3); out != nil || err != nil {
+		t.Fatalf("defrag: fragment of a discarded datagram: got %v, %v", out, err)
+	}
+	if n := defrag.DiscardOlderThan(time.Now().Add(time.Second)); n != 1 {
+		t.Errorf("defrag: discarded %d datagrams, expected 1", n)
+	}
+}
+
+func TestDefragSecurityChecks(t *testing.T) {
+	defrag := NewIPv6Defragmenter()
+
+	// non final fragments must be a multiple of 8 bytes
+	f := buildFragment(t, 5, 0, true, layers.IPProtocolUDP, nil, testPayload[:20])
+	if _, err := defrag.DefragIPv6(f); err == nil {
+		t.Error("defrag: fragment size is supposed to be a multiple of 8")
+	}
+
+	// the reassembled packet can't be bigger than 65535 bytes
+	f = buildFragment(t, 5, 65528, false, layers.IPProtocolUDP, nil, testPayload[:16])
+	if _, err := defrag.DefragIPv6(f); err == nil {
+		t.Error("defrag: fragment is supposed to overrun the maximum size")
+	}
+
+	// the first fragment must contain the whole header chain
+	f = buildFragment(t, 5, 0, true, layers.IPProtocolIPv6Destination, nil, []byte{17, 2, 0, 0, 0, 0, 0, 0})
+	if _, err := defrag.DefragIPv6(f); err == nil {
+		t.Error("defrag: first fragment is supposed to contain the whole header chain")
+	}
+
+	// including the upper-layer header
+	for _, test := range []struct {
+		next layers.IPProtocol
+		data []byte
+		ok   bool
+	}{
+		{layers.IPProtocolUDP, nil, false},
+		{layers.IPProtocolUDP, testPayload[:8], true},
+		{layers.IPProtocolICMPv6, nil, false},
+		{layers.IPProtocolTCP, make([]byte, 16), false},
+		{layers.IPProtocolTCP, append([]byte{12: 0x80}, make([]byte, 11)...), false}, // 32 bytes with options
+		{layers.IPProtocolNoNextHeader, nil, true},
+	} {
+		ext := append([]byte{byte(test.next), 0, 1, 4, 0, 0, 0, 0}, test.data...)
+		f = buildFragment(t, 6, 0, true, layers.IPProtocolIPv6Destination, nil, ext)
+		if _, err := defrag.DefragIPv6(f); (err == nil) != test.ok {
+			t.Errorf("defrag: first fragment with %d bytes of %v header: got %v", len(test.data), test.next, err)
+		}
+		defrag.DiscardOlderThan(time.Now().Add(time.Second))
+	}
+}
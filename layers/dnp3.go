@@ -0,0 +1,718 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/google/gopacket"
+)
+
+// ErrDNP3Incomplete is returned when decoding a DNP3 frame from data ending
+// before the end of the frame.
+var ErrDNP3Incomplete = errors.New("incomplete DNP3 frame")
+
+// DNP3LinkFunction is the function code of a DNP3 data link frame. Its
+// meaning depends on whether the frame is a primary or a secondary frame.
+type DNP3LinkFunction uint8
+
+// DNP3LinkFunction known values, of primary frames then of secondary frames.
+const (
+	DNP3LinkResetLinkStates     DNP3LinkFunction = 0
+	DNP3LinkTestLinkStates      DNP3LinkFunction = 2
+	DNP3LinkConfirmedUserData   DNP3LinkFunction = 3
+	DNP3LinkUnconfirmedUserData DNP3LinkFunction = 4
+	DNP3LinkRequestLinkStatus   DNP3LinkFunction = 9
+
+	DNP3LinkAck          DNP3LinkFunction = 0
+	DNP3LinkNack         DNP3LinkFunction = 1
+	DNP3LinkStatus       DNP3LinkFunction = 11
+	DNP3LinkNotSupported DNP3LinkFunction = 15
+)
+
+// DNP3FunctionCode is the function code of a DNP3 application fragment.
+type DNP3FunctionCode uint8
+
+// DNP3FunctionCode known values.
+const (
+	DNP3Confirm               DNP3FunctionCode = 0
+	DNP3Read                  DNP3FunctionCode = 1
+	DNP3Write                 DNP3FunctionCode = 2
+	DNP3Select                DNP3FunctionCode = 3
+	DNP3Operate               DNP3FunctionCode = 4
+	DNP3DirectOperate         DNP3FunctionCode = 5
+	DNP3DirectOperateNoAck    DNP3FunctionCode = 6
+	DNP3ImmediateFreeze       DNP3FunctionCode = 7
+	DNP3ImmediateFreezeNoAck  DNP3FunctionCode = 8
+	DNP3FreezeClear           DNP3FunctionCode = 9
+	DNP3FreezeClearNoAck      DNP3FunctionCode = 10
+	DNP3FreezeAtTime          DNP3FunctionCode = 11
+	DNP3FreezeAtTimeNoAck     DNP3FunctionCode = 12
+	DNP3ColdRestart           DNP3FunctionCode = 13
+	DNP3WarmRestart           DNP3FunctionCode = 14
+	DNP3InitializeData        DNP3FunctionCode = 15
+	DNP3InitializeApplication DNP3FunctionCode = 16
+	DNP3StartApplication      DNP3FunctionCode = 17
+	DNP3StopApplication       DNP3FunctionCode = 18
+	DNP3SaveConfiguration     DNP3FunctionCode = 19
+	DNP3EnableUnsolicited     DNP3FunctionCode = 20
+	DNP3DisableUnsolicited    DNP3FunctionCode = 21
+	DNP3AssignClass           DNP3FunctionCode = 22
+	DNP3DelayMeasure          DNP3FunctionCode = 23
+	DNP3RecordCurrentTime     DNP3FunctionCode = 24
+	DNP3OpenFile              DNP3FunctionCode = 25
+	DNP3CloseFile             DNP3FunctionCode = 26
+	DNP3DeleteFile            DNP3FunctionCode = 27
+	DNP3GetFileInfo           DNP3FunctionCode = 28
+	DNP3AuthenticateFile      DNP3FunctionCode = 29
+	DNP3AbortFile             DNP3FunctionCode = 30
+	DNP3Response              DNP3FunctionCode = 129
+	DNP3UnsolicitedResponse   DNP3FunctionCode = 130
+	DNP3AuthenticateResponse  DNP3FunctionCode = 131
+)
+
+func (fc DNP3FunctionCode) String() string {
+	switch fc {
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(fc))
+	case DNP3Confirm:
+		return "Confirm"
+	case DNP3Read:
+		return "Read"
+	case DNP3Write:
+		return "Write"
+	case DNP3Select:
+		return "Select"
+	case DNP3Operate:
+		return "Operate"
+	case DNP3DirectOperate:
+		return "Direct Operate"
+	case DNP3DirectOperateNoAck:
+		return "Direct Operate No Ack"
+	case DNP3ImmediateFreeze:
+		return "Immediate Freeze"
+	case DNP3ImmediateFreezeNoAck:
+		return "Immediate Freeze No Ack"
+	case DNP3FreezeClear:
+		return "Freeze and Clear"
+	case DNP3FreezeClearNoAck:
+		return "Freeze and Clear No Ack"
+	case DNP3FreezeAtTime:
+		return "Freeze at Time"
+	case DNP3FreezeAtTimeNoAck:
+		return "Freeze at Time No Ack"
+	case DNP3ColdRestart:
+		return "Cold Restart"
+	case DNP3WarmRestart:
+		return "Warm Restart"
+	case DNP3InitializeData:
+		return "Initialize Data"
+	case DNP3InitializeApplication:
+		return "Initialize Application"
+	case DNP3StartApplication:
+		return "Start Application"
+	case DNP3StopApplication:
+		return "Stop Application"
+	case DNP3SaveConfiguration:
+		return "Save Configuration"
+	case DNP3EnableUnsolicited:
+		return "Enable Unsolicited"
+	case DNP3DisableUnsolicited:
+		return "Disable Unsolicited"
+	case DNP3AssignClass:
+		return "Assign Class"
+	case DNP3DelayMeasure:
+		return "Delay Measure"
+	case DNP3RecordCurrentTime:
+		return "Record Current Time"
+	case DNP3OpenFile:
+		return "Open File"
+	case DNP3CloseFile:
+		return "Close File"
+	case DNP3DeleteFile:
+		return "Delete File"
+	case DNP3GetFileInfo:
+		return "Get File Info"
+	case DNP3AuthenticateFile:
+		return "Authenticate File"
+	case DNP3AbortFile:
+		return "Abort File"
+	case DNP3Response:
+		return "Response"
+	case DNP3UnsolicitedResponse:
+		return "Unsolicited Response"
+	case DNP3AuthenticateResponse:
+		return "Authenticate Response"
+	}
+}
+
+// IsResponse reports whether fc is the function code of a response, which
+// carries internal indications.
+func (fc DNP3FunctionCode) IsResponse() bool {
+	return fc >= DNP3Response
+}
+
+// headersOnly reports whether the object headers of requests with function
+// code fc are followed by no object data, only by index prefixes.
+func (fc DNP3FunctionCode) headersOnly() bool {
+	switch fc {
+	case DNP3Read, DNP3ImmediateFreeze, DNP3ImmediateFreezeNoAck, DNP3FreezeClear,
+		DNP3FreezeClearNoAck, DNP3EnableUnsolicited, DNP3DisableUnsolicited, DNP3AssignClass,
+		DNP3InitializeData:
+		return true
+	}
+	return false
+}
+
+// DNP3 is a frame of the DNP3 (IEEE 1815) data link layer, as sent over TCP
+// port 20000, with the transport segment and application fragment it
+// carries.
+//
+// The frame is made of a 10-byte header, followed by the user data in
+// blocks of up to 16 bytes; the header and each block end with a CRC,
+// checked when decoding and computed when serializing. The user data of
+// primary frames with the confirmed or unconfirmed user data functions is
+// a transport segment. A segment that is both the first and the final one
+// holds a whole application fragment, which is decoded into Application;
+// the fragments of other segments can be decoded with
+// DNP3Application.DecodeFromBytes once put back together.
+//
+// TCP segments often hold several frames: DecodeFromBytes decodes the first
+// one and leaves the data following it as its LayerPayload. When decoding
+// packets, all the complete frames are added as DNP3 layers; the data of a
+// connection is better decoded after reassembly, with DNP3StreamParser.
+type DNP3 struct {
+	BaseLayer
+
+	// Length is the number of bytes of the frame from the control byte
+	// to the end of the user data, CRCs excluded.
+	Length uint8
+	// Direction is set on frames sent by the master.
+	Direction bool
+	Primary   bool
+	// FrameCountBit is only used in primary frames.
+	FrameCountBit bool
+	// FrameCountValid is the FCV bit of primary frames, and the DFC
+	// (data flow control) bit of secondary frames.
+	FrameCountValid bool
+	LinkFunction    DNP3LinkFunction
+	Destination     uint16
+	Source          uint16
+
+	TransportFinal    bool
+	TransportFirst    bool
+	TransportSequence uint8
+	// TransportPayload is the transport segment data, which follows the
+	// transport header.
+	TransportPayload []byte
+
+	// Application is the application fragment of segments that are both
+	// first and final, and nil otherwise. When serializing, it is used
+	// instead of TransportPayload if it is not nil.
+	Application *DNP3Application
+}
+
+// DNP3Application is a DNP3 application layer fragment.
+type DNP3Application struct {
+	First       bool
+	Final       bool
+	Confirm     bool
+	Unsolicited bool
+	Sequence    uint8
+	Function    DNP3FunctionCode
+	// InternalIndications holds the IIN1 (high byte) and IIN2 (low byte)
+	// octets of responses.
+	InternalIndications uint16
+	Objects             []DNP3ObjectHeader
+	// Data holds the fragment data following Objects that could not be
+	// split into objects, because their size is not known.
+	Data []byte
+}
+
+// DNP3ObjectHeader is an object header of a DNP3 application fragment, with
+// the objects following it.
+type DNP3ObjectHeader struct {
+	Group     uint8
+	Variation uint8
+	// Qualifier holds the object prefix code in bits 4 to 6, and the
+	// range specifier code in bits 0 to 3.
+	Qualifier uint8
+	// Start and Stop are the range of start-stop range specifiers, 0 to 5.
+	Start, Stop uint32
+	// Count is the number of objects: the count of count range
+	// specifiers, 7 to 9 and 11, or the size of the start-stop range.
+	Count uint32
+	// Data holds the objects, with their index or size prefixes.
+	Data []byte
+}
+
+// PrefixCode returns the object prefix code of the qualifier.
+func (h *DNP3ObjectHeader) PrefixCode() uint8 {
+	return h.Qualifier >> 4 & 0x07
+}
+
+// RangeCode returns the range specifier code of the qualifier.
+func (h *DNP3ObjectHeader) RangeCode() uint8 {
+	return h.Qualifier & 0x0f
+}
+
+// dnp3ObjectSizes holds the size in bytes of the objects of common group and
+// variations, indexed by group<<8 | variation. Negative sizes are in bits,
+// for packed objects.
+var dnp3ObjectSizes = map[uint16]int{
+	0x0101: -1, 0x0102: 1,
+	0x0201: 1, 0x0202: 7, 0x0203: 3,
+	0x0301: -2, 0x0302: 1,
+	0x0401: 1, 0x0402: 7, 0x0403: 3,
+	0x0a01: -1, 0x0a02: 1,
+	0x0b01: 1, 0x0b02: 7,
+	0x0c01: 11,
+	0x1401: 5, 0x1402: 3, 0x1405: 4, 0x1406: 2,
+	0x1501: 5, 0x1502: 3, 0x1505: 11, 0x1506: 9, 0x1509: 4, 0x150a: 2,
+	0x1601: 5, 0x1602: 3, 0x1605: 11, 0x1606: 9,
+	0x1e01: 5, 0x1e02: 3, 0x1e03: 4, 0x1e04: 2, 0x1e05: 5, 0x1e06: 9,
+	0x2001: 5, 0x2002: 3, 0x2003: 11, 0x2004: 9, 0x2005: 5, 0x2006: 9,
+	0x2801: 5, 0x2802: 3, 0x2803: 5, 0x2804: 9,
+	0x2901: 5, 0x2902: 3, 0x2903: 5, 0x2904: 9,
+	0x3201: 6,
+	0x3301: 6, 0x3302: 6,
+	0x3401: 2, 0x3402: 2,
+	0x5001: -1,
+}
+
+// dnp3PrefixSizes holds the size of the object prefixes of each prefix code.
+var dnp3PrefixSizes = [8]int{0, 1, 2, 4, 1, 2, 4, -1}
+
+// LayerType returns LayerTypeDNP3.
+func (d *DNP3) LayerType() gopacket.LayerType { return LayerTypeDNP3 }
+
+// Payload returns the transport segment data, implementing
+// gopacket.ApplicationLayer. The data following the frame is returned by
+// LayerPayload.
+func (d *DNP3) Payload() []byte {
+	return d.TransportPayload
+}
+
+// CanDecode returns the set of layer types that this DecodingLayer can decode.
+func (d *DNP3) CanDecode() gopacket.LayerClass { return LayerTypeDNP3 }
+
+// NextLayerType returns LayerTypePayload if data follows the frame, and
+// LayerTypeZero otherwise.
+func (d *DNP3) NextLayerType() gopacket.LayerType {
+	if len(d.BaseLayer.Payload) > 0 {
+		return gopacket.LayerTypePayload
+	}
+	return gopacket.LayerTypeZero
+}
+
+// decodeDNP3 decodes the DNP3 frames of a TCP or UDP payload. As for DNS
+// over TCP, a frame ending in the next segments is ignored if complete
+// frames precede it.
+func decodeDNP3(data []byte, p gopacket.PacketBuilder) error {
+	return decodeFramed(data, p, ErrDNP3Incomplete, decodeDNP3Frame)
+}
+
+// decodeDNP3Frame is the framedDecoder of DNP3.
+func decodeDNP3Frame(data []byte, df gopacket.DecodeFeedback) (gopacket.ApplicationLayer, int, error) {
+	d := &DNP3{}
+	if err := d.DecodeFromBytes(data, df); err != nil {
+		return nil, 0, err
+	}
+	return d, len(d.Contents), nil
+}
+
+// dnp3CRC computes the CRC of DNP3 data link frames.
+func dnp3CRC(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa6bc
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return ^crc
+}
+
+// dnp3FrameSize returns the size of a frame with the given length field.
+func dnp3FrameSize(length int) int {
+	user := length - 5
+	return 10 + user + 2*((user+15)/16)
+}
+
+// DecodeFromBytes decodes the DNP3 frame at the beginning of data. If data
+// ends before the end of the frame, ErrDNP3Incomplete is returned.
+func (d *DNP3) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 10 {
+		df.SetTruncated()
+		return ErrDNP3Incomplete
+	}
+	if data[0] != 0x05 || data[1] != 0x64 {
+		return fmt.Errorf("invalid DNP3 start bytes %#02x%02x", data[0], data[1])
+	}
+	if data[2] < 5 {
+		return fmt.Errorf("invalid DNP3 frame length %d", data[2])
+	}
+	if crc := binary.LittleEndian.Uint16(data[8:10]); crc != dnp3CRC(data[:8]) {
+		return fmt.Errorf("invalid DNP3 header CRC %#04x", crc)
+	}
+	size := dnp3FrameSize(int(data[2]))
+	if len(data) < size {
+		df.SetTruncated()
+		return ErrDNP3Incomplete
+	}
+
+	user := make([]byte, 0, int(data[2])-5)
+	for off := 10; off < size; {
+		end := off + 16
+		if end > size-2 {
+			end = size - 2
+		}
+		if crc := binary.LittleEndian.Uint16(data[end:]); crc != dnp3CRC(data[off:end]) {
+			return fmt.Errorf("invalid DNP3 data block CRC %#04x", crc)
+		}
+		user = append(user, data[off:end]...)
+		off = end + 2
+	}
+
+	*d = DNP3{
+		BaseLayer:       BaseLayer{Contents: data[:size], Payload: data[size:]},
+		Length:          data[2],
+		Direction:       data[3]&0x80 != 0,
+		Primary:         data[3]&0x40 != 0,
+		FrameCountBit:   data[3]&0x20 != 0,
+		FrameCountValid: data[3]&0x10 != 0,
+		LinkFunction:    DNP3LinkFunction(data[3] & 0x0f),
+		Destination:     binary.LittleEndian.Uint16(data[4:6]),
+		Source:          binary.LittleEndian.Uint16(data[6:8]),
+	}
+	if len(user) == 0 {
+		return nil
+	}
+	d.TransportFinal = user[0]&0x80 != 0
+	d.TransportFirst = user[0]&0x40 != 0
+	d.TransportSequence = user[0] & 0x3f
+	d.TransportPayload = user[1:]
+	if d.TransportFirst && d.TransportFinal {
+		d.Application = &DNP3Application{}
+		if err := d.Application.DecodeFromBytes(d.TransportPayload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasUserData reports whether the link function of the frame carries user
+// data.
+func (d *DNP3) hasUserData() bool {
+	return d.Primary && (d.LinkFunction == DNP3LinkConfirmedUserData || d.LinkFunction == DNP3LinkUnconfirmedUserData)
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+// See the docs for gopacket.SerializableLayer for more info.
+func (d *DNP3) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	var user []byte
+	if d.hasUserData() {
+		segment := d.TransportPayload
+		if d.Application != nil {
+			var err error
+			if segment, err = d.Application.encode(); err != nil {
+				return err
+			}
+		}
+		transport := d.TransportSequence & 0x3f
+		if d.TransportFinal {
+			transport |= 0x80
+		}
+		if d.TransportFirst {
+			transport |= 0x40
+		}
+		user = append([]byte{transport}, segment...)
+	}
+	if len(user) > 250 {
+		return fmt.Errorf("DNP3 user data too long: %d bytes", len(user))
+	}
+	if opts.FixLengths {
+		d.Length = uint8(5 + len(user))
+	} else if int(d.Length) != 5+len(user) {
+		return fmt.Errorf("DNP3 length %d does not match %d bytes of user data", d.Length, len(user))
+	}
+
+	bytes, err := b.PrependBytes(dnp3FrameSize(int(d.Length)))
+	if err != nil {
+		return err
+	}
+	bytes[0], bytes[1], bytes[2] = 0x05, 0x64, d.Length
+	bytes[3] = uint8(d.LinkFunction) & 0x0f
+	if d.Direction {
+		bytes[3] |= 0x80
+	}
+	if d.Primary {
+		bytes[3] |= 0x40
+	}
+	if d.FrameCountBit {
+		bytes[3] |= 0x20
+	}
+	if d.FrameCountValid {
+		bytes[3] |= 0x10
+	}
+	binary.LittleEndian.PutUint16(bytes[4:], d.Destination)
+	binary.LittleEndian.PutUint16(bytes[6:], d.Source)
+	binary.LittleEndian.PutUint16(bytes[8:], dnp3CRC(bytes[:8]))
+	off := 10
+	for len(user) > 0 {
+		n := len(user)
+		if n > 16 {
+			n = 16
+		}
+		copy(bytes[off:], user[:n])
+		binary.LittleEndian.PutUint16(bytes[off+n:], dnp3CRC(user[:n]))
+		user = user[n:]
+		off += n + 2
+	}
+	return nil
+}
+
+// DecodeFromBytes decodes an application fragment. Objects reference data.
+func (a *DNP3Application) DecodeFromBytes(data []byte) error {
+	if len(data) < 2 {
+		return errors.New("DNP3 application fragment too short")
+	}
+	*a = DNP3Application{
+		First:       data[0]&0x80 != 0,
+		Final:       data[0]&0x40 != 0,
+		Confirm:     data[0]&0x20 != 0,
+		Unsolicited: data[0]&0x10 != 0,
+		Sequence:    data[0] & 0x0f,
+		Function:    DNP3FunctionCode(data[1]),
+	}
+	data = data[2:]
+	if a.Function.IsResponse() {
+		if len(data) < 2 {
+			return errors.New("DNP3 internal indications truncated")
+		}
+		a.InternalIndications = binary.BigEndian.Uint16(data)
+		data = data[2:]
+	}
+
+	for len(data) > 0 {
+		h := DNP3ObjectHeader{}
+		n, err := h.decodeHeader(data)
+		if err != nil {
+			return err
+		}
+		size, ok := h.objectsSize(data[n:], a.Function)
+		if !ok {
+			a.Data = data
+			return nil
+		}
+		if len(data) < n+size {
+			return fmt.Errorf("DNP3 objects of group %d variation %d truncated", h.Group, h.Variation)
+		}
+		if size > 0 {
+			h.Data = data[n : n+size]
+		}
+		a.Objects = append(a.Objects, h)
+		data = data[n+size:]
+	}
+	return nil
+}
+
+// decodeHeader decodes the object header at the beginning of data, and
+// returns its size.
+func (h *DNP3ObjectHeader) decodeHeader(data []byte) (int, error) {
+	if len(data) < 3 {
+		return 0, errors.New("DNP3 object header truncated")
+	}
+	h.Group, h.Variation, h.Qualifier = data[0], data[1], data[2]
+	size := dnp3RangeSize(h.RangeCode())
+	if size < 0 {
+		return 0, fmt.Errorf("unknown DNP3 range specifier code %d", h.RangeCode())
+	}
+	if len(data) < 3+size {
+		return 0, errors.New("DNP3 object header range truncated")
+	}
+	r := data[3 : 3+size]
+	switch h.RangeCode() {
+	case 0, 3:
+		h.Start, h.Stop = uint32(r[0]), uint32(r[1])
+	case 1, 4:
+		h.Start, h.Stop = uint32(binary.LittleEndian.Uint16(r)), uint32(binary.LittleEndian.Uint16(r[2:]))
+	case 2, 5:
+		h.Start, h.Stop = binary.LittleEndian.Uint32(r), binary.LittleEndian.Uint32(r[4:])
+	case 7, 11:
+		h.Count = uint32(r[0])
+	case 8:
+		h.Count = uint32(binary.LittleEndian.Uint16(r))
+	case 9:
+		h.Count = binary.LittleEndian.Uint32(r)
+	}
+	if h.RangeCode() <= 5 {
+		if h.Stop < h.Start {
+			return 0, fmt.Errorf("invalid DNP3 object range %d-%d", h.Start, h.Stop)
+		}
+		h.Count = h.Stop - h.Start + 1
+	}
+	return 3 + size, nil
+}
+
+// dnp3RangeSize returns the size of the range field of a range specifier
+// code, or -1 if the code is not known.
+func dnp3RangeSize(code uint8) int {
+	switch code {
+	case 0, 3:
+		return 2
+	case 1, 4:
+		return 4
+	case 2, 5:
+		return 8
+	case 6:
+		return 0
+	case 7, 11:
+		return 1
+	case 8:
+		return 2
+	case 9:
+		return 4
+	}
+	return -1
+}
+
+// objectsSize returns the size of the objects following the header at the
+// beginning of data, and false if it is not known.
+func (h *DNP3ObjectHeader) objectsSize(data []byte, fc DNP3FunctionCode) (int, bool) {
+	prefix := dnp3PrefixSizes[h.PrefixCode()]
+	if prefix < 0 || h.RangeCode() == 6 {
+		return 0, prefix >= 0
+	}
+	var size int
+	switch {
+	case h.Group == 60 || fc.headersOnly():
+		size = 0
+	case h.PrefixCode() >= 4:
+		// Free-format objects, each prefixed by its size.
+		total := 0
+		for i := uint32(0); i < h.Count; i++ {
+			if len(data) < total+prefix {
+				return 0, false
+			}
+			var n int
+			switch prefix {
+			case 1:
+				n = int(data[total])
+			case 2:
+				n = int(binary.LittleEndian.Uint16(data[total:]))
+			case 4:
+				n = int(binary.LittleEndian.Uint32(data[total:]))
+			}
+			total += prefix + n
+		}
+		return total, true
+	default:
+		var ok bool
+		if size, ok = dnp3ObjectSizes[uint16(h.Group)<<8|uint16(h.Variation)]; !ok {
+			return 0, false
+		}
+		if size < 0 {
+			if prefix != 0 {
+				return 0, false
+			}
+			return (int(h.Count)*-size + 7) / 8, true
+		}
+	}
+	return int(h.Count) * (prefix + size), true
+}
+
+// encode returns the serialized application fragment.
+func (a *DNP3Application) encode() ([]byte, error) {
+	control := a.Sequence & 0x0f
+	if a.First {
+		control |= 0x80
+	}
+	if a.Final {
+		control |= 0x40
+	}
+	if a.Confirm {
+		control |= 0x20
+	}
+	if a.Unsolicited {
+		control |= 0x10
+	}
+	data := []byte{control, byte(a.Function)}
+	if a.Function.IsResponse() {
+		data = append(data, byte(a.InternalIndications>>8), byte(a.InternalIndications))
+	}
+	for i := range a.Objects {
+		h := &a.Objects[i]
+		size := dnp3RangeSize(h.RangeCode())
+		if size < 0 {
+			return nil, fmt.Errorf("unknown DNP3 range specifier code %d", h.RangeCode())
+		}
+		data = append(data, h.Group, h.Variation, h.Qualifier)
+		r := make([]byte, size)
+		switch h.RangeCode() {
+		case 0, 3:
+			r[0], r[1] = uint8(h.Start), uint8(h.Stop)
+		case 1, 4:
+			binary.LittleEndian.PutUint16(r, uint16(h.Start))
+			binary.LittleEndian.PutUint16(r[2:], uint16(h.Stop))
+		case 2, 5:
+			binary.LittleEndian.PutUint32(r, h.Start)
+			binary.LittleEndian.PutUint32(r[4:], h.Stop)
+		case 7, 11:
+			r[0] = uint8(h.Count)
+		case 8:
+			binary.LittleEndian.PutUint16(r, uint16(h.Count))
+		case 9:
+			binary.LittleEndian.PutUint32(r, h.Count)
+		}
+		data = append(data, r...)
+		data = append(data, h.Data...)
+	}
+	data = append(data, a.Data...)
+	if len(data) > 249 {
+		return nil, fmt.Errorf("DNP3 application fragment too long for a single segment: %d bytes", len(data))
+	}
+	return data, nil
+}
+
+// DNP3StreamParser decodes the DNP3 frames sent in one direction of a TCP
+// connection, from the reassembled data, like the data passed to
+// reassembly.Stream.ReassembledSG.  Use one DNP3StreamParser per direction.
+//
+// Frames spanning several calls to Parse are buffered until they are
+// complete.  To avoid copying the data, reassembly.ScatterGather.KeepFrom
+// can be used instead, with DNP3.DecodeFromBytes and ErrDNP3Incomplete.
+type DNP3StreamParser struct {
+	stream framedStream
+}
+
+// Parse decodes the complete frames found in data, following any data
+// buffered by previous calls.
+//
+// The returned frames may reference data, which must not be modified while
+// they are in use.  Once an error is returned, the stream can't be decoded
+// anymore.
+func (p *DNP3StreamParser) Parse(data []byte) ([]*DNP3, error) {
+	var frames []*DNP3
+	err := p.stream.parse(data, ErrDNP3Incomplete, decodeDNP3Frame, func(l gopacket.ApplicationLayer) {
+		frames = append(frames, l.(*DNP3))
+	})
+	return frames, err
+}
+
+// Pending returns the number of bytes buffered, waiting for the end of a
+// frame.
+func (p *DNP3StreamParser) Pending() int {
+	return len(p.stream.pending)
+}
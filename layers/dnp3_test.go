@@ -0,0 +1,201 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+// testDNP3ResetLink is a reset link states request from master 0x0400 to
+// outstation 1.
+var testDNP3ResetLink = []byte{0x05, 0x64, 0x05, 0xc0, 0x01, 0x00, 0x00, 0x04, 0xe9, 0x21}
+
+func TestDNP3LinkFrame(t *testing.T) {
+	var d DNP3
+	if err := d.DecodeFromBytes(testDNP3ResetLink, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	want := DNP3{
+		BaseLayer:    BaseLayer{Contents: testDNP3ResetLink, Payload: []byte{}},
+		Length:       5,
+		Direction:    true,
+		Primary:      true,
+		LinkFunction: DNP3LinkResetLinkStates,
+		Destination:  1,
+		Source:       0x400,
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("decoded\n%+v\nwant\n%+v", d, want)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := d.SerializeTo(buf, gopacket.SerializeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), testDNP3ResetLink) {
+		t.Errorf("serialized %x, want %x", buf.Bytes(), testDNP3ResetLink)
+	}
+
+	bad := append([]byte(nil), testDNP3ResetLink...)
+	bad[9]++
+	if err := d.DecodeFromBytes(bad, gopacket.NilDecodeFeedback); err == nil {
+		t.Error("no error with an invalid header CRC")
+	}
+	if err := d.DecodeFromBytes(testDNP3ResetLink[:9], gopacket.NilDecodeFeedback); err != ErrDNP3Incomplete {
+		t.Errorf("got error %v with a truncated frame, want ErrDNP3Incomplete", err)
+	}
+}
+
+func testDNP3Frames() []*DNP3 {
+	return []*DNP3{
+		{
+			Direction: true, Primary: true, LinkFunction: DNP3LinkUnconfirmedUserData,
+			Destination: 1, Source: 0x400,
+			TransportFinal: true, TransportFirst: true, TransportSequence: 12,
+			Application: &DNP3Application{
+				First: true, Final: true, Sequence: 3, Function: DNP3Read,
+				Objects: []DNP3ObjectHeader{
+					{Group: 60, Variation: 2, Qualifier: 0x06},
+					{Group: 60, Variation: 3, Qualifier: 0x06},
+					{Group: 60, Variation: 1, Qualifier: 0x06},
+					{Group: 30, Variation: 1, Qualifier: 0x17, Count: 2, Data: []byte{4, 9}},
+				},
+			},
+		},
+		{
+			Primary: true, LinkFunction: DNP3LinkUnconfirmedUserData,
+			Destination: 0x400, Source: 1,
+			TransportFinal: true, TransportFirst: true, TransportSequence: 40,
+			Application: &DNP3Application{
+				First: true, Final: true, Sequence: 3, Function: DNP3Response,
+				InternalIndications: 0x8000,
+				Objects: []DNP3ObjectHeader{
+					{Group: 1, Variation: 1, Qualifier: 0x00, Start: 0, Stop: 9, Count: 10, Data: []byte{0xa5, 0x02}},
+					{Group: 1, Variation: 2, Qualifier: 0x01, Start: 10, Stop: 11, Count: 2, Data: []byte{0x81, 0x01}},
+					{Group: 30, Variation: 1, Qualifier: 0x00, Start: 0, Stop: 2, Count: 3, Data: []byte{
+						0x01, 0x10, 0x00, 0x00, 0x00,
+						0x01, 0x20, 0x00, 0x00, 0x00,
+						0x01, 0xff, 0xff, 0xff, 0xff,
+					}},
+					{Group: 32, Variation: 1, Qualifier: 0x28, Count: 1, Data: []byte{0x05, 0x00, 0x01, 0x2a, 0x00, 0x00, 0x00}},
+				},
+			},
+		},
+	}
+}
+
+func TestDNP3RoundTrip(t *testing.T) {
+	frames := testDNP3Frames()
+	var stream []byte
+	for _, f := range frames {
+		buf := gopacket.NewSerializeBuffer()
+		if err := f.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, buf.Bytes()...)
+	}
+
+	ip := &IPv4{Version: 4, TTL: 64, Protocol: IPProtocolTCP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	tcp := &TCP{SrcPort: 20000, DstPort: 40000, ACK: true, PSH: true, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		ip, tcp, gopacket.Payload(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), LayerTypeIPv4, gopacket.DecodeOptions{DecodeStreamsAsDatagrams: true})
+	if p.ErrorLayer() != nil {
+		t.Fatal("Failed to decode packet:", p.ErrorLayer().Error())
+	}
+	checkLayers(p, []gopacket.LayerType{LayerTypeIPv4, LayerTypeTCP, LayerTypeDNP3, LayerTypeDNP3}, t)
+	if app, ok := p.ApplicationLayer().(*DNP3); !ok || app.Application.Function != DNP3Read {
+		t.Errorf("wrong application layer %+v", p.ApplicationLayer())
+	}
+
+	for i, l := range p.Layers()[2:] {
+		got := l.(*DNP3)
+		want := frames[i]
+		if got.Length != want.Length || got.Destination != want.Destination || got.Source != want.Source ||
+			got.TransportSequence != want.TransportSequence || !got.Primary || got.Direction != want.Direction {
+			t.Errorf("frame %d: decoded link header %+v, want %+v", i, got, want)
+		}
+		if !reflect.DeepEqual(got.Application, want.Application) {
+			t.Errorf("frame %d: decoded application fragment\n%+v\nwant\n%+v", i, got.Application, want.Application)
+		}
+	}
+}
+
+func TestDNP3StreamParser(t *testing.T) {
+	var stream []byte
+	for _, f := range testDNP3Frames() {
+		buf := gopacket.NewSerializeBuffer()
+		if err := f.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, buf.Bytes()...)
+	}
+	stream = append(stream, testDNP3ResetLink...)
+
+	var parser DNP3StreamParser
+	var functions []DNP3FunctionCode
+	for off := 0; off < len(stream); off += 7 {
+		end := off + 7
+		if end > len(stream) {
+			end = len(stream)
+		}
+		frames, err := parser.Parse(stream[off:end])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range frames {
+			if f.Application != nil {
+				functions = append(functions, f.Application.Function)
+			} else {
+				functions = append(functions, DNP3FunctionCode(0xff))
+			}
+		}
+	}
+	if want := []DNP3FunctionCode{DNP3Read, DNP3Response, 0xff}; !reflect.DeepEqual(functions, want) {
+		t.Errorf("decoded functions %v, want %v", functions, want)
+	}
+	if parser.Pending() != 0 {
+		t.Errorf("%d bytes pending", parser.Pending())
+	}
+}
+
+func TestDNP3UnknownObjects(t *testing.T) {
+	// Write of a group 70 (file) object of an unknown size.
+	fragment := []byte{0xc1, 0x02, 0x46, 0x05, 0x17, 0x01, 0x02, 0x03}
+	var a DNP3Application
+	if err := a.DecodeFromBytes(fragment); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Objects) != 0 || !bytes.Equal(a.Data, fragment[2:]) {
+		t.Errorf("decoded %+v", a)
+	}
+	// The same object, with a size prefix.
+	fragment = []byte{0xc1, 0x02, 0x46, 0x05, 0x4b, 0x01, 0x02, 0x0a, 0x0b}
+	if err := a.DecodeFromBytes(fragment); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Objects) != 1 || !bytes.Equal(a.Objects[0].Data, fragment[6:]) || a.Data != nil {
+		t.Errorf("decoded %+v", a)
+	}
+	encoded, err := a.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, fragment) {
+		t.Errorf("encoded %x, want %x", encoded, fragment)
+	}
+}
@@ -7,11 +7,15 @@
 package layers
 
 import (
+	"encoding/base32"
+	"encoding/base64"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/google/gopacket"
 )
@@ -52,26 +56,37 @@ type DNSType uint16
 
 // DNSType known values.
 const (
-	DNSTypeA     DNSType = 1   // a host address
-	DNSTypeNS    DNSType = 2   // an authoritative name server
-	DNSTypeMD    DNSType = 3   // a mail destination (Obsolete - use MX)
-	DNSTypeMF    DNSType = 4   // a mail forwarder (Obsolete - use MX)
-	DNSTypeCNAME DNSType = 5   // the canonical name for an alias
-	DNSTypeSOA   DNSType = 6   // marks the start of a zone of authority
-	DNSTypeMB    DNSType = 7   // a mailbox domain name (EXPERIMENTAL)
-	DNSTypeMG    DNSType = 8   // a mail group member (EXPERIMENTAL)
-	DNSTypeMR    DNSType = 9   // a mail rename domain name (EXPERIMENTAL)
-	DNSTypeNULL  DNSType = 10  // a null RR (EXPERIMENTAL)
-	DNSTypeWKS   DNSType = 11  // a well known service description
-	DNSTypePTR   DNSType = 12  // a domain name pointer
-	DNSTypeHINFO DNSType = 13  // host information
-	DNSTypeMINFO DNSType = 14  // mailbox or mail list information
-	DNSTypeMX    DNSType = 15  // mail exchange
-	DNSTypeTXT   DNSType = 16  // text strings
-	DNSTypeAAAA  DNSType = 28  // a IPv6 host address [RFC3596]
-	DNSTypeSRV   DNSType = 33  // server discovery [RFC2782] [RFC6195]
-	DNSTypeOPT   DNSType = 41  // OPT Pseudo-RR [RFC6891]
-	DNSTypeURI   DNSType = 256 // URI RR [RFC7553]
+	DNSTypeA          DNSType = 1   // a host address
+	DNSTypeNS         DNSType = 2   // an authoritative name server
+	DNSTypeMD         DNSType = 3   // a mail destination (Obsolete - use MX)
+	DNSTypeMF         DNSType = 4   // a mail forwarder (Obsolete - use MX)
+	DNSTypeCNAME      DNSType = 5   // the canonical name for an alias
+	DNSTypeSOA        DNSType = 6   // marks the start of a zone of authority
+	DNSTypeMB         DNSType = 7   // a mailbox domain name (EXPERIMENTAL)
+	DNSTypeMG         DNSType = 8   // a mail group member (EXPERIMENTAL)
+	DNSTypeMR         DNSType = 9   // a mail rename domain name (EXPERIMENTAL)
+	DNSTypeNULL       DNSType = 10  // a null RR (EXPERIMENTAL)
+	DNSTypeWKS        DNSType = 11  // a well known service description
+	DNSTypePTR        DNSType = 12  // a domain name pointer
+	DNSTypeHINFO      DNSType = 13  // host information
+	DNSTypeMINFO      DNSType = 14  // mailbox or mail list information
+	DNSTypeMX         DNSType = 15  // mail exchange
+	DNSTypeTXT        DNSType = 16  // text strings
+	DNSTypeAAAA       DNSType = 28  // a IPv6 host address [RFC3596]
+	DNSTypeSRV        DNSType = 33  // server discovery [RFC2782] [RFC6195]
+	DNSTypeOPT        DNSType = 41  // OPT Pseudo-RR [RFC6891]
+	DNSTypeDS         DNSType = 43  // Delegation Signer [RFC4034]
+	DNSTypeSSHFP      DNSType = 44  // SSH Key Fingerprint [RFC4255]
+	DNSTypeRRSIG      DNSType = 46  // RRset signature [RFC4034]
+	DNSTypeNSEC       DNSType = 47  // Next Secure record [RFC4034]
+	DNSTypeDNSKEY     DNSType = 48  // DNS public key [RFC4034]
+	DNSTypeNSEC3      DNSType = 50  // NSEC version 3 [RFC5155]
+	DNSTypeNSEC3PARAM DNSType = 51  // NSEC3 parameters [RFC5155]
+	DNSTypeTLSA       DNSType = 52  // TLSA certificate association [RFC6698]
+	DNSTypeSVCB       DNSType = 64  // General-purpose service binding [RFC9460]
+	DNSTypeHTTPS      DNSType = 65  // SVCB-compatible type for HTTPS [RFC9460]
+	DNSTypeURI        DNSType = 256 // URI RR [RFC7553]
+	DNSTypeCAA        DNSType = 257 // Certification Authority Authorization [RFC8659]
 )
 
 func (dt DNSType) String() string {
@@ -116,8 +131,30 @@ func (dt DNSType) String() string {
 		return "SRV"
 	case DNSTypeOPT:
 		return "OPT"
+	case DNSTypeDS:
+		return "DS"
+	case DNSTypeSSHFP:
+		return "SSHFP"
+	case DNSTypeRRSIG:
+		return "RRSIG"
+	case DNSTypeNSEC:
+		return "NSEC"
+	case DNSTypeDNSKEY:
+		return "DNSKEY"
+	case DNSTypeNSEC3:
+		return "NSEC3"
+	case DNSTypeNSEC3PARAM:
+		return "NSEC3PARAM"
+	case DNSTypeTLSA:
+		return "TLSA"
+	case DNSTypeSVCB:
+		return "SVCB"
+	case DNSTypeHTTPS:
+		return "HTTPS"
 	case DNSTypeURI:
 		return "URI"
+	case DNSTypeCAA:
+		return "CAA"
 	}
 }
 
@@ -445,11 +482,43 @@ func recSize(rr *DNSResourceRecord) int {
 			l += len(opt.Data)
 		}
 		return l
+	case DNSTypeDS:
+		return 4 + len(rr.DS.Digest)
+	case DNSTypeDNSKEY:
+		return 4 + len(rr.DNSKEY.PublicKey)
+	case DNSTypeRRSIG:
+		return 18 + encodedNameSize(rr.RRSIG.SignerName) + len(rr.RRSIG.Signature)
+	case DNSTypeNSEC:
+		return encodedNameSize(rr.NSEC.NextDomain) + len(encodeTypeBitmap(rr.NSEC.Types))
+	case DNSTypeNSEC3:
+		return 6 + len(rr.NSEC3.Salt) + len(rr.NSEC3.NextHashedOwner) + len(encodeTypeBitmap(rr.NSEC3.Types))
+	case DNSTypeNSEC3PARAM:
+		return 5 + len(rr.NSEC3PARAM.Salt)
+	case DNSTypeTLSA:
+		return 3 + len(rr.TLSA.Certificate)
+	case DNSTypeSSHFP:
+		return 2 + len(rr.SSHFP.Fingerprint)
+	case DNSTypeCAA:
+		return 2 + len(rr.CAA.Tag) + len(rr.CAA.Value)
+	case DNSTypeSVCB, DNSTypeHTTPS:
+		l := 2 + encodedNameSize(rr.SVCB.Target)
+		for i := range rr.SVCB.Params {
+			l += 4 + rr.SVCB.Params[i].valueSize()
+		}
+		return l
 	}
 
 	return 0
 }
 
+// encodedNameSize returns the number of bytes written by encodeName.
+func encodedNameSize(name []byte) int {
+	if len(name) == 0 {
+		return 1
+	}
+	return len(name) + 2
+}
+
 func computeSize(recs []DNSResourceRecord) int {
 	sz := 0
 	for _, rr := range recs {
@@ -701,6 +770,16 @@ type DNSResourceRecord struct {
 	MX             DNSMX
 	OPT            []DNSOPT // See RFC 6891, section 6.1.2
 	URI            DNSURI
+	DS             DNSDS
+	DNSKEY         DNSKEY
+	RRSIG          DNSRRSIG
+	NSEC           DNSNSEC
+	NSEC3          DNSNSEC3
+	NSEC3PARAM     DNSNSEC3PARAM
+	TLSA           DNSTLSA
+	SSHFP          DNSSSHFP
+	CAA            DNSCAA
+	SVCB           DNSSVCB // For both SVCB and HTTPS records
 
 	// Undecoded TXT for backward compatibility
 	TXT []byte
@@ -816,6 +895,77 @@ func (rr *DNSResourceRecord) encode(data []byte, offset int, opts gopacket.Seria
 			copy(data[noff2+4:], opt.Data)
 			noff2 += 4 + len(opt.Data)
 		}
+	case DNSTypeDS:
+		binary.BigEndian.PutUint16(data[noff+10:], rr.DS.KeyTag)
+		data[noff+12] = uint8(rr.DS.Algorithm)
+		data[noff+13] = uint8(rr.DS.DigestType)
+		copy(data[noff+14:], rr.DS.Digest)
+	case DNSTypeDNSKEY:
+		binary.BigEndian.PutUint16(data[noff+10:], rr.DNSKEY.Flags)
+		data[noff+12] = rr.DNSKEY.Protocol
+		data[noff+13] = uint8(rr.DNSKEY.Algorithm)
+		copy(data[noff+14:], rr.DNSKEY.PublicKey)
+	case DNSTypeRRSIG:
+		binary.BigEndian.PutUint16(data[noff+10:], uint16(rr.RRSIG.TypeCovered))
+		data[noff+12] = uint8(rr.RRSIG.Algorithm)
+		data[noff+13] = rr.RRSIG.Labels
+		binary.BigEndian.PutUint32(data[noff+14:], rr.RRSIG.OriginalTTL)
+		binary.BigEndian.PutUint32(data[noff+18:], rr.RRSIG.Expiration)
+		binary.BigEndian.PutUint32(data[noff+22:], rr.RRSIG.Inception)
+		binary.BigEndian.PutUint16(data[noff+26:], rr.RRSIG.KeyTag)
+		noff2 := encodeName(rr.RRSIG.SignerName, data, noff+28)
+		copy(data[noff2:], rr.RRSIG.Signature)
+	case DNSTypeNSEC:
+		noff2 := encodeName(rr.NSEC.NextDomain, data, noff+10)
+		copy(data[noff2:], encodeTypeBitmap(rr.NSEC.Types))
+	case DNSTypeNSEC3:
+		if len(rr.NSEC3.Salt) > 255 || len(rr.NSEC3.NextHashedOwner) > 255 {
+			return 0, errors.New("NSEC3 salt or hash too long")
+		}
+		data[noff+10] = rr.NSEC3.HashAlgorithm
+		data[noff+11] = rr.NSEC3.Flags
+		binary.BigEndian.PutUint16(data[noff+12:], rr.NSEC3.Iterations)
+		data[noff+14] = uint8(len(rr.NSEC3.Salt))
+		noff2 := noff + 15 + copy(data[noff+15:], rr.NSEC3.Salt)
+		data[noff2] = uint8(len(rr.NSEC3.NextHashedOwner))
+		noff2 += 1 + copy(data[noff2+1:], rr.NSEC3.NextHashedOwner)
+		copy(data[noff2:], encodeTypeBitmap(rr.NSEC3.Types))
+	case DNSTypeNSEC3PARAM:
+		if len(rr.NSEC3PARAM.Salt) > 255 {
+			return 0, errors.New("NSEC3PARAM salt too long")
+		}
+		data[noff+10] = rr.NSEC3PARAM.HashAlgorithm
+		data[noff+11] = rr.NSEC3PARAM.Flags
+		binary.BigEndian.PutUint16(data[noff+12:], rr.NSEC3PARAM.Iterations)
+		data[noff+14] = uint8(len(rr.NSEC3PARAM.Salt))
+		copy(data[noff+15:], rr.NSEC3PARAM.Salt)
+	case DNSTypeTLSA:
+		data[noff+10] = rr.TLSA.Usage
+		data[noff+11] = rr.TLSA.Selector
+		data[noff+12] = rr.TLSA.MatchingType
+		copy(data[noff+13:], rr.TLSA.Certificate)
+	case DNSTypeSSHFP:
+		data[noff+10] = rr.SSHFP.Algorithm
+		data[noff+11] = rr.SSHFP.FingerprintType
+		copy(data[noff+12:], rr.SSHFP.Fingerprint)
+	case DNSTypeCAA:
+		if len(rr.CAA.Tag) > 255 {
+			return 0, errors.New("CAA tag too long")
+		}
+		data[noff+10] = rr.CAA.Flags
+		data[noff+11] = uint8(len(rr.CAA.Tag))
+		noff2 := noff + 12 + copy(data[noff+12:], rr.CAA.Tag)
+		copy(data[noff2:], rr.CAA.Value)
+	case DNSTypeSVCB, DNSTypeHTTPS:
+		binary.BigEndian.PutUint16(data[noff+10:], rr.SVCB.Priority)
+		noff2 := encodeName(rr.SVCB.Target, data, noff+12)
+		for i := range rr.SVCB.Params {
+			n, err := rr.SVCB.Params[i].encode(data, noff2)
+			if err != nil {
+				return 0, err
+			}
+			noff2 += n
+		}
 	default:
 		return 0, fmt.Errorf("serializing resource record of type %v not supported", rr.Type)
 	}
@@ -843,6 +993,39 @@ func (rr *DNSResourceRecord) String() string {
 	if rr.Type == DNSTypeURI {
 		return fmt.Sprintf("URI %d %d %s", rr.URI.Priority, rr.URI.Weight, string(rr.URI.Target))
 	}
+	switch rr.Type {
+	case DNSTypeDS:
+		return fmt.Sprintf("DS %d %d %d %X", rr.DS.KeyTag, rr.DS.Algorithm, rr.DS.DigestType, rr.DS.Digest)
+	case DNSTypeDNSKEY:
+		return fmt.Sprintf("DNSKEY %d %d %d %s", rr.DNSKEY.Flags, rr.DNSKEY.Protocol, rr.DNSKEY.Algorithm,
+			base64.StdEncoding.EncodeToString(rr.DNSKEY.PublicKey))
+	case DNSTypeRRSIG:
+		s := &rr.RRSIG
+		return fmt.Sprintf("RRSIG %v %d %d %d %s %s %d %s %s", s.TypeCovered, s.Algorithm, s.Labels, s.OriginalTTL,
+			dnssecTime(s.Expiration), dnssecTime(s.Inception), s.KeyTag, dnsPresentationName(s.SignerName),
+			base64.StdEncoding.EncodeToString(s.Signature))
+	case DNSTypeNSEC:
+		return "NSEC " + dnsPresentationName(rr.NSEC.NextDomain) + dnsTypeList(rr.NSEC.Types)
+	case DNSTypeNSEC3:
+		n := &rr.NSEC3
+		return fmt.Sprintf("NSEC3 %d %d %d %s %s%s", n.HashAlgorithm, n.Flags, n.Iterations, dnsSalt(n.Salt),
+			base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString(n.NextHashedOwner), dnsTypeList(n.Types))
+	case DNSTypeNSEC3PARAM:
+		n := &rr.NSEC3PARAM
+		return fmt.Sprintf("NSEC3PARAM %d %d %d %s", n.HashAlgorithm, n.Flags, n.Iterations, dnsSalt(n.Salt))
+	case DNSTypeTLSA:
+		return fmt.Sprintf("TLSA %d %d %d %X", rr.TLSA.Usage, rr.TLSA.Selector, rr.TLSA.MatchingType, rr.TLSA.Certificate)
+	case DNSTypeSSHFP:
+		return fmt.Sprintf("SSHFP %d %d %X", rr.SSHFP.Algorithm, rr.SSHFP.FingerprintType, rr.SSHFP.Fingerprint)
+	case DNSTypeCAA:
+		return fmt.Sprintf("CAA %d %s %q", rr.CAA.Flags, rr.CAA.Tag, rr.CAA.Value)
+	case DNSTypeSVCB, DNSTypeHTTPS:
+		s := fmt.Sprintf("%v %d %s", rr.Type, rr.SVCB.Priority, dnsPresentationName(rr.SVCB.Target))
+		for _, param := range rr.SVCB.Params {
+			s += " " + param.String()
+		}
+		return s
+	}
 	if rr.Class == DNSClassIN {
 		switch rr.Type {
 		case DNSTypeA, DNSTypeAAAA:
@@ -988,10 +1171,186 @@ func (rr *DNSResourceRecord) decodeRData(data []byte, offset int, buffer *[]byte
 			return err
 		}
 		rr.OPT = allOPT
+	case DNSTypeDS:
+		if len(rr.Data) < 4 {
+			return errors.New("DS too small")
+		}
+		rr.DS.KeyTag = binary.BigEndian.Uint16(rr.Data[0:2])
+		rr.DS.Algorithm = DNSSECAlgorithm(rr.Data[2])
+		rr.DS.DigestType = DNSSECDigestType(rr.Data[3])
+		rr.DS.Digest = rr.Data[4:]
+	case DNSTypeDNSKEY:
+		if len(rr.Data) < 4 {
+			return errors.New("DNSKEY too small")
+		}
+		rr.DNSKEY.Flags = binary.BigEndian.Uint16(rr.Data[0:2])
+		rr.DNSKEY.Protocol = rr.Data[2]
+		rr.DNSKEY.Algorithm = DNSSECAlgorithm(rr.Data[3])
+		rr.DNSKEY.PublicKey = rr.Data[4:]
+	case DNSTypeRRSIG:
+		if len(rr.Data) < 19 {
+			return errors.New("RRSIG too small")
+		}
+		rr.RRSIG.TypeCovered = DNSType(binary.BigEndian.Uint16(rr.Data[0:2]))
+		rr.RRSIG.Algorithm = DNSSECAlgorithm(rr.Data[2])
+		rr.RRSIG.Labels = rr.Data[3]
+		rr.RRSIG.OriginalTTL = binary.BigEndian.Uint32(rr.Data[4:8])
+		rr.RRSIG.Expiration = binary.BigEndian.Uint32(rr.Data[8:12])
+		rr.RRSIG.Inception = binary.BigEndian.Uint32(rr.Data[12:16])
+		rr.RRSIG.KeyTag = binary.BigEndian.Uint16(rr.Data[16:18])
+		name, endq, err := decodeName(data, offset+18, buffer, 1)
+		if err != nil {
+			return err
+		}
+		rr.RRSIG.SignerName = name
+		rr.RRSIG.Signature = data[endq:]
+	case DNSTypeNSEC:
+		name, endq, err := decodeName(data, offset, buffer, 1)
+		if err != nil {
+			return err
+		}
+		rr.NSEC.NextDomain = name
+		if rr.NSEC.Types, err = decodeTypeBitmap(data[endq:]); err != nil {
+			return err
+		}
+	case DNSTypeNSEC3:
+		if len(rr.Data) < 5 {
+			return errors.New("NSEC3 too small")
+		}
+		rr.NSEC3.HashAlgorithm = rr.Data[0]
+		rr.NSEC3.Flags = rr.Data[1]
+		rr.NSEC3.Iterations = binary.BigEndian.Uint16(rr.Data[2:4])
+		end := 5 + int(rr.Data[4])
+		if len(rr.Data) < end+1 {
+			return errors.New("NSEC3 too small")
+		}
+		rr.NSEC3.Salt = rr.Data[5:end]
+		start, end := end+1, end+1+int(rr.Data[end])
+		if len(rr.Data) < end {
+			return errors.New("NSEC3 too small")
+		}
+		rr.NSEC3.NextHashedOwner = rr.Data[start:end]
+		var err error
+		if rr.NSEC3.Types, err = decodeTypeBitmap(rr.Data[end:]); err != nil {
+			return err
+		}
+	case DNSTypeNSEC3PARAM:
+		if len(rr.Data) < 5 || len(rr.Data) < 5+int(rr.Data[4]) {
+			return errors.New("NSEC3PARAM too small")
+		}
+		rr.NSEC3PARAM.HashAlgorithm = rr.Data[0]
+		rr.NSEC3PARAM.Flags = rr.Data[1]
+		rr.NSEC3PARAM.Iterations = binary.BigEndian.Uint16(rr.Data[2:4])
+		rr.NSEC3PARAM.Salt = rr.Data[5 : 5+int(rr.Data[4])]
+	case DNSTypeTLSA:
+		if len(rr.Data) < 3 {
+			return errors.New("TLSA too small")
+		}
+		rr.TLSA.Usage = rr.Data[0]
+		rr.TLSA.Selector = rr.Data[1]
+		rr.TLSA.MatchingType = rr.Data[2]
+		rr.TLSA.Certificate = rr.Data[3:]
+	case DNSTypeSSHFP:
+		if len(rr.Data) < 2 {
+			return errors.New("SSHFP too small")
+		}
+		rr.SSHFP.Algorithm = rr.Data[0]
+		rr.SSHFP.FingerprintType = rr.Data[1]
+		rr.SSHFP.Fingerprint = rr.Data[2:]
+	case DNSTypeCAA:
+		if len(rr.Data) < 2 || len(rr.Data) < 2+int(rr.Data[1]) {
+			return errors.New("CAA too small")
+		}
+		rr.CAA.Flags = rr.Data[0]
+		rr.CAA.Tag = rr.Data[2 : 2+int(rr.Data[1])]
+		rr.CAA.Value = rr.Data[2+int(rr.Data[1]):]
+	case DNSTypeSVCB, DNSTypeHTTPS:
+		if len(rr.Data) < 3 {
+			return fmt.Errorf("%v too small", rr.Type)
+		}
+		rr.SVCB.Priority = binary.BigEndian.Uint16(rr.Data[0:2])
+		name, endq, err := decodeName(data, offset+2, buffer, 1)
+		if err != nil {
+			return err
+		}
+		rr.SVCB.Target = name
+		if rr.SVCB.Params, err = decodeSVCParams(data[endq:]); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// decodeTypeBitmap decodes the type bit maps field of NSEC and NSEC3 records,
+// see RFC 4034, section 4.1.2.
+func decodeTypeBitmap(data []byte) ([]DNSType, error) {
+	var types []DNSType
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, errDNSTypeBitmap
+		}
+		window, l := int(data[0]), int(data[1])
+		if l == 0 || l > 32 || len(data) < 2+l {
+			return nil, errDNSTypeBitmap
+		}
+		for i, b := range data[2 : 2+l] {
+			for bit := 0; bit < 8; bit++ {
+				if b&(0x80>>uint(bit)) != 0 {
+					types = append(types, DNSType(window<<8|i<<3|bit))
+				}
+			}
+		}
+		data = data[2+l:]
+	}
+	return types, nil
+}
+
+// dnsTypes sorts DNS types in increasing order.
+type dnsTypes []DNSType
+
+func (t dnsTypes) Len() int           { return len(t) }
+func (t dnsTypes) Less(i, j int) bool { return t[i] < t[j] }
+func (t dnsTypes) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+// encodeTypeBitmap returns the type bit maps field listing the given types.
+func encodeTypeBitmap(types []DNSType) []byte {
+	sorted := append([]DNSType(nil), types...)
+	sort.Sort(dnsTypes(sorted))
+	var bitmap []byte
+	window, start := -1, 0
+	for _, t := range sorted {
+		if int(t>>8) != window {
+			window, start = int(t>>8), len(bitmap)
+			bitmap = append(bitmap, byte(window), 0)
+		}
+		for i := int(t&0xff) >> 3; int(bitmap[start+1]) <= i; bitmap[start+1]++ {
+			bitmap = append(bitmap, 0)
+		}
+		bitmap[start+2+int(t&0xff)>>3] |= 0x80 >> (t & 7)
+	}
+	return bitmap
+}
+
+func decodeSVCParams(data []byte) ([]DNSSVCParam, error) {
+	var params []DNSSVCParam
+	for i := 0; i < len(data); {
+		if i+4 > len(data) {
+			return nil, errDNSSVCParamLength
+		}
+		param := DNSSVCParam{Key: DNSSVCParamKey(binary.BigEndian.Uint16(data[i : i+2]))}
+		end := i + 4 + int(binary.BigEndian.Uint16(data[i+2:i+4]))
+		if end > len(data) {
+			return nil, errDNSSVCParamLength
+		}
+		if err := param.decode(data[i+4 : end]); err != nil {
+			return nil, err
+		}
+		params = append(params, param)
+		i = end
+	}
+	return params, nil
+}
+
 // DNSSOA is a Start of Authority record.  Each domain requires a SOA record at
 // the cutover where a domain is delegated from its parent.
 type DNSSOA struct {
@@ -1019,6 +1378,384 @@ type DNSURI struct {
 	Target           []byte
 }
 
+// DNSSECAlgorithm is the algorithm of a DNSSEC key or signature, see IANA
+// "DNS Security Algorithm Numbers".
+type DNSSECAlgorithm uint8
+
+// DNSSECAlgorithm known values.
+const (
+	DNSSECAlgorithmRSAMD5           DNSSECAlgorithm = 1  // [RFC3110] Deprecated
+	DNSSECAlgorithmDSA              DNSSECAlgorithm = 3  // [RFC2536]
+	DNSSECAlgorithmRSASHA1          DNSSECAlgorithm = 5  // [RFC3110]
+	DNSSECAlgorithmDSANSEC3SHA1     DNSSECAlgorithm = 6  // [RFC5155]
+	DNSSECAlgorithmRSASHA1NSEC3SHA1 DNSSECAlgorithm = 7  // [RFC5155]
+	DNSSECAlgorithmRSASHA256        DNSSECAlgorithm = 8  // [RFC5702]
+	DNSSECAlgorithmRSASHA512        DNSSECAlgorithm = 10 // [RFC5702]
+	DNSSECAlgorithmECCGOST          DNSSECAlgorithm = 12 // [RFC5933]
+	DNSSECAlgorithmECDSAP256SHA256  DNSSECAlgorithm = 13 // [RFC6605]
+	DNSSECAlgorithmECDSAP384SHA384  DNSSECAlgorithm = 14 // [RFC6605]
+	DNSSECAlgorithmED25519          DNSSECAlgorithm = 15 // [RFC8080]
+	DNSSECAlgorithmED448            DNSSECAlgorithm = 16 // [RFC8080]
+)
+
+func (a DNSSECAlgorithm) String() string {
+	switch a {
+	default:
+		return "Unknown"
+	case DNSSECAlgorithmRSAMD5:
+		return "RSAMD5"
+	case DNSSECAlgorithmDSA:
+		return "DSA"
+	case DNSSECAlgorithmRSASHA1:
+		return "RSASHA1"
+	case DNSSECAlgorithmDSANSEC3SHA1:
+		return "DSA-NSEC3-SHA1"
+	case DNSSECAlgorithmRSASHA1NSEC3SHA1:
+		return "RSASHA1-NSEC3-SHA1"
+	case DNSSECAlgorithmRSASHA256:
+		return "RSASHA256"
+	case DNSSECAlgorithmRSASHA512:
+		return "RSASHA512"
+	case DNSSECAlgorithmECCGOST:
+		return "ECC-GOST"
+	case DNSSECAlgorithmECDSAP256SHA256:
+		return "ECDSAP256SHA256"
+	case DNSSECAlgorithmECDSAP384SHA384:
+		return "ECDSAP384SHA384"
+	case DNSSECAlgorithmED25519:
+		return "ED25519"
+	case DNSSECAlgorithmED448:
+		return "ED448"
+	}
+}
+
+// DNSSECDigestType is the digest algorithm of a DS record, see IANA
+// "Delegation Signer (DS) Resource Record (RR) Type Digest Algorithms".
+type DNSSECDigestType uint8
+
+// DNSSECDigestType known values.
+const (
+	DNSSECDigestTypeSHA1   DNSSECDigestType = 1 // [RFC3658]
+	DNSSECDigestTypeSHA256 DNSSECDigestType = 2 // [RFC4509]
+	DNSSECDigestTypeGOST   DNSSECDigestType = 3 // [RFC5933]
+	DNSSECDigestTypeSHA384 DNSSECDigestType = 4 // [RFC6605]
+)
+
+func (dt DNSSECDigestType) String() string {
+	switch dt {
+	default:
+		return "Unknown"
+	case DNSSECDigestTypeSHA1:
+		return "SHA-1"
+	case DNSSECDigestTypeSHA256:
+		return "SHA-256"
+	case DNSSECDigestTypeGOST:
+		return "GOST R 34.11-94"
+	case DNSSECDigestTypeSHA384:
+		return "SHA-384"
+	}
+}
+
+// DNSDS is a Delegation Signer record, identifying a DNSKEY of a delegated
+// zone, see RFC 4034, section 5.
+type DNSDS struct {
+	KeyTag     uint16
+	Algorithm  DNSSECAlgorithm
+	DigestType DNSSECDigestType
+	Digest     []byte
+}
+
+// DNSKEY is a DNS public key record, see RFC 4034, section 2.
+type DNSKEY struct {
+	Flags     uint16 // 0x100: zone key, 0x80: revoked, 0x1: secure entry point
+	Protocol  uint8  // Always 3
+	Algorithm DNSSECAlgorithm
+	PublicKey []byte
+}
+
+// DNSRRSIG is a signature of a RRset, see RFC 4034, section 3.  Expiration
+// and Inception are in seconds since the epoch, modulo 2^32.
+type DNSRRSIG struct {
+	TypeCovered           DNSType
+	Algorithm             DNSSECAlgorithm
+	Labels                uint8
+	OriginalTTL           uint32
+	Expiration, Inception uint32
+	KeyTag                uint16
+	SignerName            []byte
+	Signature             []byte
+}
+
+// DNSNSEC is a Next Secure record, proving the nonexistence of names and
+// types, see RFC 4034, section 4.
+type DNSNSEC struct {
+	NextDomain []byte
+	Types      []DNSType
+}
+
+// DNSNSEC3 is a hashed Next Secure record, see RFC 5155, section 3.
+type DNSNSEC3 struct {
+	HashAlgorithm   uint8
+	Flags           uint8 // 0x1: opt-out
+	Iterations      uint16
+	Salt            []byte
+	NextHashedOwner []byte
+	Types           []DNSType
+}
+
+// DNSNSEC3PARAM holds the NSEC3 parameters of a zone, see RFC 5155,
+// section 4.
+type DNSNSEC3PARAM struct {
+	HashAlgorithm uint8
+	Flags         uint8
+	Iterations    uint16
+	Salt          []byte
+}
+
+// DNSTLSA associates a TLS certificate or public key with a service, see
+// RFC 6698, section 2.
+type DNSTLSA struct {
+	Usage, Selector, MatchingType uint8
+	Certificate                   []byte
+}
+
+// DNSSSHFP is a SSH public key fingerprint record, see RFC 4255.
+type DNSSSHFP struct {
+	Algorithm, FingerprintType uint8
+	Fingerprint                []byte
+}
+
+// DNSCAA is a Certification Authority Authorization record, see RFC 8659.
+type DNSCAA struct {
+	Flags uint8 // 0x80: issuer critical
+	Tag   []byte
+	Value []byte
+}
+
+// DNSSVCB is a service binding record, used by both SVCB and HTTPS records,
+// see RFC 9460.  A Priority of 0 denotes the alias mode, and an empty Target
+// the owner name of the record.
+type DNSSVCB struct {
+	Priority uint16
+	Target   []byte
+	Params   []DNSSVCParam
+}
+
+// DNSSVCParamKey is the key of a SVCB service parameter.
+type DNSSVCParamKey uint16
+
+// DNSSVCParamKey known values, see RFC 9460, section 14.3.2.
+const (
+	DNSSVCParamKeyMandatory     DNSSVCParamKey = 0
+	DNSSVCParamKeyALPN          DNSSVCParamKey = 1
+	DNSSVCParamKeyNoDefaultALPN DNSSVCParamKey = 2
+	DNSSVCParamKeyPort          DNSSVCParamKey = 3
+	DNSSVCParamKeyIPv4Hint      DNSSVCParamKey = 4
+	DNSSVCParamKeyECH           DNSSVCParamKey = 5
+	DNSSVCParamKeyIPv6Hint      DNSSVCParamKey = 6
+)
+
+// String returns the presentation name of the key.
+func (k DNSSVCParamKey) String() string {
+	switch k {
+	default:
+		return fmt.Sprintf("key%d", uint16(k))
+	case DNSSVCParamKeyMandatory:
+		return "mandatory"
+	case DNSSVCParamKeyALPN:
+		return "alpn"
+	case DNSSVCParamKeyNoDefaultALPN:
+		return "no-default-alpn"
+	case DNSSVCParamKeyPort:
+		return "port"
+	case DNSSVCParamKeyIPv4Hint:
+		return "ipv4hint"
+	case DNSSVCParamKeyECH:
+		return "ech"
+	case DNSSVCParamKeyIPv6Hint:
+		return "ipv6hint"
+	}
+}
+
+// DNSSVCParam is a SVCB service parameter.  The value of the mandatory, alpn,
+// port, ipv4hint and ipv6hint keys is decoded in the corresponding field, and
+// these fields are used when serializing; Value is used for the other keys,
+// such as ech, whose value is an ECHConfigList.
+type DNSSVCParam struct {
+	Key   DNSSVCParamKey
+	Value []byte
+
+	// Decoded values
+	Mandatory []DNSSVCParamKey
+	ALPN      [][]byte
+	Port      uint16
+	IPHints   []net.IP // ipv4hint or ipv6hint
+}
+
+func (p *DNSSVCParam) decode(data []byte) error {
+	p.Value = data
+	switch p.Key {
+	case DNSSVCParamKeyMandatory:
+		if len(data) == 0 || len(data)%2 != 0 {
+			return errDNSSVCParamLength
+		}
+		for i := 0; i < len(data); i += 2 {
+			p.Mandatory = append(p.Mandatory, DNSSVCParamKey(binary.BigEndian.Uint16(data[i:i+2])))
+		}
+	case DNSSVCParamKeyALPN:
+		alpn, err := decodeCharacterStrings(data)
+		if err != nil {
+			return err
+		}
+		p.ALPN = alpn
+	case DNSSVCParamKeyNoDefaultALPN:
+		if len(data) != 0 {
+			return errDNSSVCParamLength
+		}
+	case DNSSVCParamKeyPort:
+		if len(data) != 2 {
+			return errDNSSVCParamLength
+		}
+		p.Port = binary.BigEndian.Uint16(data)
+	case DNSSVCParamKeyIPv4Hint, DNSSVCParamKeyIPv6Hint:
+		size := 4
+		if p.Key == DNSSVCParamKeyIPv6Hint {
+			size = 16
+		}
+		if len(data) == 0 || len(data)%size != 0 {
+			return errDNSSVCParamLength
+		}
+		for i := 0; i < len(data); i += size {
+			p.IPHints = append(p.IPHints, net.IP(data[i:i+size]))
+		}
+	}
+	return nil
+}
+
+func (p *DNSSVCParam) valueSize() int {
+	switch p.Key {
+	case DNSSVCParamKeyMandatory:
+		return 2 * len(p.Mandatory)
+	case DNSSVCParamKeyALPN:
+		l := len(p.ALPN)
+		for _, proto := range p.ALPN {
+			l += len(proto)
+		}
+		return l
+	case DNSSVCParamKeyNoDefaultALPN:
+		return 0
+	case DNSSVCParamKeyPort:
+		return 2
+	case DNSSVCParamKeyIPv4Hint:
+		return 4 * len(p.IPHints)
+	case DNSSVCParamKeyIPv6Hint:
+		return 16 * len(p.IPHints)
+	}
+	return len(p.Value)
+}
+
+// encode writes the parameter at offset, returning its length.
+func (p *DNSSVCParam) encode(data []byte, offset int) (int, error) {
+	size := p.valueSize()
+	binary.BigEndian.PutUint16(data[offset:], uint16(p.Key))
+	binary.BigEndian.PutUint16(data[offset+2:], uint16(size))
+	off := offset + 4
+	switch p.Key {
+	case DNSSVCParamKeyMandatory:
+		for _, k := range p.Mandatory {
+			binary.BigEndian.PutUint16(data[off:], uint16(k))
+			off += 2
+		}
+	case DNSSVCParamKeyALPN:
+		for _, proto := range p.ALPN {
+			if len(proto) > 255 {
+				return 0, fmt.Errorf("ALPN protocol %q too long", proto)
+			}
+			data[off] = byte(len(proto))
+			off += 1 + copy(data[off+1:], proto)
+		}
+	case DNSSVCParamKeyNoDefaultALPN:
+	case DNSSVCParamKeyPort:
+		binary.BigEndian.PutUint16(data[off:], p.Port)
+	case DNSSVCParamKeyIPv4Hint:
+		for _, ip := range p.IPHints {
+			ip4 := ip.To4()
+			if ip4 == nil {
+				return 0, fmt.Errorf("invalid ipv4hint %v", ip)
+			}
+			off += copy(data[off:], ip4)
+		}
+	case DNSSVCParamKeyIPv6Hint:
+		for _, ip := range p.IPHints {
+			if len(ip) != net.IPv6len {
+				return 0, fmt.Errorf("invalid ipv6hint %v", ip)
+			}
+			off += copy(data[off:], ip)
+		}
+	default:
+		copy(data[off:], p.Value)
+	}
+	return 4 + size, nil
+}
+
+// String returns the parameter in presentation format, such as "alpn=h2,h3".
+func (p DNSSVCParam) String() string {
+	var values []string
+	switch p.Key {
+	case DNSSVCParamKeyMandatory:
+		for _, k := range p.Mandatory {
+			values = append(values, k.String())
+		}
+	case DNSSVCParamKeyALPN:
+		for _, proto := range p.ALPN {
+			values = append(values, string(proto))
+		}
+	case DNSSVCParamKeyNoDefaultALPN:
+		return p.Key.String()
+	case DNSSVCParamKeyPort:
+		values = append(values, fmt.Sprint(p.Port))
+	case DNSSVCParamKeyIPv4Hint, DNSSVCParamKeyIPv6Hint:
+		for _, ip := range p.IPHints {
+			values = append(values, ip.String())
+		}
+	case DNSSVCParamKeyECH:
+		values = append(values, base64.StdEncoding.EncodeToString(p.Value))
+	default:
+		values = append(values, fmt.Sprintf("%x", p.Value))
+	}
+	return p.Key.String() + "=" + strings.Join(values, ",")
+}
+
+// dnsPresentationName returns a name as written in zone files, with a
+// trailing dot.
+func dnsPresentationName(name []byte) string {
+	return string(name) + "."
+}
+
+// dnssecTime formats a RRSIG timestamp as YYYYMMDDHHmmSS.
+func dnssecTime(t uint32) string {
+	return time.Unix(int64(t), 0).UTC().Format("20060102150405")
+}
+
+func dnsTypeList(types []DNSType) string {
+	s := ""
+	for _, t := range types {
+		if t.String() == "Unknown" {
+			s += fmt.Sprintf(" TYPE%d", uint16(t))
+		} else {
+			s += " " + t.String()
+		}
+	}
+	return s
+}
+
+func dnsSalt(salt []byte) string {
+	if len(salt) == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%X", salt)
+}
+
 // DNSOptionCode represents the code of a DNS Option, see RFC6891, section 6.1.2
 type DNSOptionCode uint16
 
@@ -1101,6 +1838,9 @@ var (
 
 	errDecodeRecordLength = errors.New("resource record length exceeds data")
 
+	errDNSTypeBitmap     = errors.New("malformed DNS type bitmap")
+	errDNSSVCParamLength = errors.New("malformed SVCB service parameter")
+
 	errDecodeQueryBadQDCount = errors.New("Invalid query decoding, not the right number of questions")
 	errDecodeQueryBadANCount = errors.New("Invalid query decoding, not the right number of answers")
 	errDecodeQueryBadNSCount = errors.New("Invalid query decoding, not the right number of authorities")
@@ -1161,3 +1161,147 @@ func TestDNSPacketWriteAnswer(t *testing.T) {
 		t.Fatalf("Encoded size, want %d got %d", want, got)
 	}
 }
+
+// testParseDNSTypeHTTPS is a response with an HTTPS record for example.com,
+// with alpn, ipv4hint and ech parameters.
+var testParseDNSTypeHTTPS = []byte{
+	0x00, 0x01, 0x81, 0x80, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x07, 0x65, 0x78, 0x61,
+	0x6d, 0x70, 0x6c, 0x65, 0x03, 0x63, 0x6f, 0x6d, 0x00, 0x00, 0x41, 0x00, 0x01, 0xc0, 0x0c, 0x00,
+	0x41, 0x00, 0x01, 0x00, 0x00, 0x0e, 0x10, 0x00, 0x1c, 0x00, 0x01, 0x00, 0x00, 0x01, 0x00, 0x06,
+	0x02, 0x68, 0x32, 0x02, 0x68, 0x33, 0x00, 0x04, 0x00, 0x04, 0xc0, 0x00, 0x02, 0x01, 0x00, 0x05,
+	0x00, 0x03, 0x01, 0x02, 0x03,
+}
+
+func TestParseDNSTypeHTTPS(t *testing.T) {
+	var dns DNS
+	if err := dns.DecodeFromBytes(testParseDNSTypeHTTPS, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if len(dns.Answers) != 1 {
+		t.Fatalf("got %d answers", len(dns.Answers))
+	}
+	svcb := dns.Answers[0].SVCB
+	if svcb.Priority != 1 || len(svcb.Target) != 0 || len(svcb.Params) != 3 {
+		t.Fatalf("bad HTTPS record: %+v", svcb)
+	}
+	if alpn := svcb.Params[0].ALPN; len(alpn) != 2 || string(alpn[0]) != "h2" || string(alpn[1]) != "h3" {
+		t.Errorf("bad alpn: %q", alpn)
+	}
+	if hints := svcb.Params[1].IPHints; len(hints) != 1 || !hints[0].Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Errorf("bad ipv4hint: %v", hints)
+	}
+	if svcb.Params[2].Key != DNSSVCParamKeyECH || !bytes.Equal(svcb.Params[2].Value, []byte{1, 2, 3}) {
+		t.Errorf("bad ech: %+v", svcb.Params[2])
+	}
+	if got, want := dns.Answers[0].String(), "HTTPS 1 . alpn=h2,h3 ipv4hint=192.0.2.1 ech=AQID"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Truncated parameters
+	bad := append([]byte(nil), testParseDNSTypeHTTPS...)
+	bad[len(bad)-5] = 4
+	if err := dns.DecodeFromBytes(bad, gopacket.NilDecodeFeedback); err != errDNSSVCParamLength {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestDNSEncodeDNSSEC(t *testing.T) {
+	records := []DNSResourceRecord{
+		{Type: DNSTypeDS, DS: DNSDS{KeyTag: 2371, Algorithm: DNSSECAlgorithmECDSAP256SHA256,
+			DigestType: DNSSECDigestTypeSHA256, Digest: []byte{0xde, 0xad, 0xbe, 0xef}}},
+		{Type: DNSTypeDNSKEY, DNSKEY: DNSKEY{Flags: 257, Protocol: 3, Algorithm: DNSSECAlgorithmED25519,
+			PublicKey: []byte{1, 2, 3, 4, 5, 6}}},
+		{Type: DNSTypeRRSIG, RRSIG: DNSRRSIG{TypeCovered: DNSTypeA, Algorithm: DNSSECAlgorithmRSASHA256, Labels: 2,
+			OriginalTTL: 3600, Expiration: 1700000000, Inception: 1690000000, KeyTag: 12345,
+			SignerName: []byte("example.com"), Signature: []byte{9, 8, 7}}},
+		{Type: DNSTypeNSEC, NSEC: DNSNSEC{NextDomain: []byte("b.example.com"),
+			Types: []DNSType{DNSTypeA, DNSTypeMX, DNSTypeRRSIG, DNSTypeNSEC, DNSTypeCAA}}},
+		{Type: DNSTypeNSEC3, NSEC3: DNSNSEC3{HashAlgorithm: 1, Flags: 1, Iterations: 10, Salt: []byte{0xaa, 0xbb},
+			NextHashedOwner: []byte("0123456789abcdefghij"), Types: []DNSType{DNSTypeNS, DNSTypeSOA, DNSTypeDNSKEY}}},
+		{Type: DNSTypeNSEC3PARAM, NSEC3PARAM: DNSNSEC3PARAM{HashAlgorithm: 1, Iterations: 10, Salt: []byte{0xaa, 0xbb}}},
+		{Type: DNSTypeTLSA, TLSA: DNSTLSA{Usage: 3, Selector: 1, MatchingType: 1, Certificate: []byte{0x12, 0x34}}},
+		{Type: DNSTypeSSHFP, SSHFP: DNSSSHFP{Algorithm: 4, FingerprintType: 2, Fingerprint: []byte{0x56, 0x78}}},
+		{Type: DNSTypeCAA, CAA: DNSCAA{Tag: []byte("issue"), Value: []byte("letsencrypt.org")}},
+		{Type: DNSTypeSVCB, SVCB: DNSSVCB{Priority: 0, Target: []byte("svc.example.net")}},
+		{Type: DNSTypeHTTPS, SVCB: DNSSVCB{Priority: 1, Params: []DNSSVCParam{
+			{Key: DNSSVCParamKeyMandatory, Mandatory: []DNSSVCParamKey{DNSSVCParamKeyALPN}},
+			{Key: DNSSVCParamKeyALPN, ALPN: [][]byte{[]byte("h3")}},
+			{Key: DNSSVCParamKeyNoDefaultALPN},
+			{Key: DNSSVCParamKeyPort, Port: 8443},
+			{Key: DNSSVCParamKeyIPv6Hint, IPHints: []net.IP{net.ParseIP("2001:db8::1")}},
+			{Key: 65000, Value: []byte("x")},
+		}}},
+	}
+	dns := &DNS{ID: 1, QR: true}
+	for _, rr := range records {
+		rr.Name = []byte("example.com")
+		rr.Class = DNSClassIN
+		rr.TTL = 300
+		dns.Answers = append(dns.Answers, rr)
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, dns); err != nil {
+		t.Fatal(err)
+	}
+	var dns2 DNS
+	if err := dns2.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if len(dns2.Answers) != len(records) {
+		t.Fatalf("got %d answers, want %d", len(dns2.Answers), len(records))
+	}
+	for i := range dns.Answers {
+		if got, want := dns2.Answers[i].String(), dns.Answers[i].String(); got != want {
+			t.Errorf("answer %d: got %q, want %q", i, got, want)
+		}
+		if dns2.Answers[i].DataLength != dns.Answers[i].DataLength {
+			t.Errorf("answer %d: got length %d, want %d", i, dns2.Answers[i].DataLength, dns.Answers[i].DataLength)
+		}
+	}
+	for _, want := range []string{
+		"RRSIG A 8 2 3600 20231114221320 20230722042640 12345 example.com. CQgH",
+		"NSEC b.example.com. A MX RRSIG NSEC CAA",
+		`CAA 0 issue "letsencrypt.org"`,
+		"HTTPS 1 . mandatory=alpn alpn=h3 no-default-alpn port=8443 ipv6hint=2001:db8::1 key65000=78",
+	} {
+		found := false
+		for _, rr := range dns2.Answers {
+			found = found || rr.String() == want
+		}
+		if !found {
+			t.Errorf("no record %q", want)
+		}
+	}
+
+	bad := &DNS{Answers: []DNSResourceRecord{{Type: DNSTypeHTTPS, SVCB: DNSSVCB{Params: []DNSSVCParam{
+		{Key: DNSSVCParamKeyIPv4Hint, IPHints: []net.IP{net.ParseIP("2001:db8::1")}},
+	}}}}}
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, bad); err == nil {
+		t.Error("expected an error serializing an IPv6 ipv4hint")
+	}
+}
+
+func TestDNSTypeBitmap(t *testing.T) {
+	types := []DNSType{DNSTypeCAA, DNSTypeA, DNSTypeNS, DNSTypeRRSIG, DNSTypeNSEC, DNSType(1234)}
+	bitmap := encodeTypeBitmap(types)
+	want := []byte{
+		0x00, 0x06, 0x60, 0x00, 0x00, 0x00, 0x00, 0x03, // A NS RRSIG NSEC
+		0x01, 0x01, 0x40, // CAA
+		0x04, 0x1b, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x20, // 1234
+	}
+	if !bytes.Equal(bitmap, want) {
+		t.Fatalf("got bitmap %x, want %x", bitmap, want)
+	}
+	got, err := decodeTypeBitmap(bitmap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 6 || got[0] != DNSTypeA || got[3] != DNSTypeNSEC || got[4] != DNSTypeCAA || got[5] != 1234 {
+		t.Errorf("got types %v", got)
+	}
+	for _, bad := range [][]byte{{0}, {0, 0}, {0, 33}, {0, 2, 0x40}} {
+		if _, err := decodeTypeBitmap(bad); err == nil {
+			t.Errorf("%x: expected an error", bad)
+		}
+	}
+}
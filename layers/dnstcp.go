@@ -0,0 +1,113 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/google/gopacket"
+)
+
+// ErrDNSTCPIncomplete is returned when decoding a DNS message sent over TCP
+// from data ending before the end of the message.
+var ErrDNSTCPIncomplete = errors.New("incomplete DNS message over TCP")
+
+// decodeDNSTCP decodes the DNS messages of a TCP payload, for
+// LayerTypeDNSTCP, used for TCP port 53 with the DecodeStreamsAsDatagrams
+// option.
+//
+// Over TCP, each DNS message is prefixed by its length on two bytes (RFC
+// 1035, section 4.2.2, and RFC 7766), and a connection may carry several
+// messages, like the responses of a zone transfer (AXFR/IXFR).  All the
+// complete messages found in the payload are added as DNS layers.  Since
+// messages often span several segments, the data of a connection is better
+// decoded after reassembly, with DNSStreamParser or DNS.DecodeFromTCPBytes.
+func decodeDNSTCP(data []byte, p gopacket.PacketBuilder) error {
+	return decodeFramed(data, p, ErrDNSTCPIncomplete, decodeDNSTCPMessage)
+}
+
+// decodeDNSTCPMessage is the framedDecoder of DNS over TCP.
+func decodeDNSTCPMessage(data []byte, df gopacket.DecodeFeedback) (gopacket.ApplicationLayer, int, error) {
+	d := &DNS{}
+	n, err := d.DecodeFromTCPBytes(data, df)
+	return d, n, err
+}
+
+// DecodeFromTCPBytes decodes the DNS message at the beginning of data,
+// prefixed by its length as when sent over TCP, and returns the number of
+// bytes used, prefix included.  Data following the message is ignored.
+//
+// If data ends before the end of the message, ErrDNSTCPIncomplete is
+// returned and nothing is decoded.
+func (d *DNS) DecodeFromTCPBytes(data []byte, df gopacket.DecodeFeedback) (int, error) {
+	if len(data) < 2 {
+		df.SetTruncated()
+		return 0, ErrDNSTCPIncomplete
+	}
+	end := 2 + int(binary.BigEndian.Uint16(data))
+	if len(data) < end {
+		df.SetTruncated()
+		return 0, ErrDNSTCPIncomplete
+	}
+	if err := d.DecodeFromBytes(data[2:end], df); err != nil {
+		return 0, err
+	}
+	return end, nil
+}
+
+// SerializeToTCP is like SerializeTo, but prefixes the message with its
+// length, as required when it is sent over TCP.
+func (d *DNS) SerializeToTCP(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	start := len(b.Bytes())
+	if err := d.SerializeTo(b, opts); err != nil {
+		return err
+	}
+	l := len(b.Bytes()) - start
+	if l > 0xffff {
+		return fmt.Errorf("DNS message too long for TCP: %d bytes", l)
+	}
+	bytes, err := b.PrependBytes(2)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint16(bytes, uint16(l))
+	return nil
+}
+
+// DNSStreamParser decodes the DNS messages sent in one direction of a TCP
+// connection, from the reassembled data, like the data passed to
+// reassembly.Stream.ReassembledSG.  Use one DNSStreamParser per direction.
+//
+// Messages spanning several calls to Parse are buffered until they are
+// complete, so that zone transfers are fully decoded.  To avoid copying the
+// data, reassembly.ScatterGather.KeepFrom can be used instead, with
+// DNS.DecodeFromTCPBytes.
+type DNSStreamParser struct {
+	stream framedStream
+}
+
+// Parse decodes the complete messages found in data, following any data
+// buffered by previous calls.
+//
+// The returned messages may reference data, which must not be modified while
+// they are in use.  Once an error is returned, the stream can't be decoded
+// anymore.
+func (p *DNSStreamParser) Parse(data []byte) ([]*DNS, error) {
+	var msgs []*DNS
+	err := p.stream.parse(data, ErrDNSTCPIncomplete, decodeDNSTCPMessage, func(l gopacket.ApplicationLayer) {
+		msgs = append(msgs, l.(*DNS))
+	})
+	return msgs, err
+}
+
+// Pending returns the number of bytes buffered, waiting for the end of a
+// message.
+func (p *DNSStreamParser) Pending() int {
+	return len(p.stream.pending)
+}
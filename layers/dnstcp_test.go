@@ -0,0 +1,106 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+// testDNSTCPMessages returns the responses of a zone transfer, serialized
+// with their length prefix.
+func testDNSTCPMessages(t *testing.T) []byte {
+	soa := DNSResourceRecord{
+		Name: []byte("example.com"), Type: DNSTypeSOA, Class: DNSClassIN, TTL: 3600,
+		SOA: DNSSOA{MName: []byte("ns.example.com"), RName: []byte("admin.example.com"), Serial: 42},
+	}
+	var stream []byte
+	for i, answers := range [][]DNSResourceRecord{
+		{soa, {Name: []byte("www.example.com"), Type: DNSTypeA, Class: DNSClassIN, IP: net.IP{192, 0, 2, 1}}},
+		{{Name: []byte("mail.example.com"), Type: DNSTypeA, Class: DNSClassIN, IP: net.IP{192, 0, 2, 2}}},
+		{soa},
+	} {
+		dns := &DNS{ID: 7, QR: true, AA: true, Answers: answers}
+		if i == 0 {
+			dns.Questions = []DNSQuestion{{Name: []byte("example.com"), Type: 252, Class: DNSClassIN}}
+		}
+		buf := gopacket.NewSerializeBuffer()
+		if err := dns.SerializeToTCP(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+			t.Fatal(err)
+		}
+		if l := binary.BigEndian.Uint16(buf.Bytes()); int(l) != len(buf.Bytes())-2 {
+			t.Fatalf("got length prefix %d for %d bytes", l, len(buf.Bytes()))
+		}
+		stream = append(stream, buf.Bytes()...)
+	}
+	return stream
+}
+
+func TestDNSTCPPacket(t *testing.T) {
+	stream := testDNSTCPMessages(t)
+	tcp := &TCP{SrcPort: 53, DstPort: 40000, ACK: true, Window: 1024, DataOffset: 5}
+	ip := &IPv4{Version: 4, TTL: 64, Protocol: IPProtocolTCP, SrcIP: net.IP{192, 0, 2, 53}, DstIP: net.IP{192, 0, 2, 100}}
+	tcp.SetNetworkLayerForChecksum(ip)
+	// The last message is cut
+	payload := gopacket.Payload(stream[:len(stream)-4])
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ip, tcp, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	p := gopacket.NewPacket(buf.Bytes(), LayerTypeIPv4, gopacket.DecodeOptions{DecodeStreamsAsDatagrams: true})
+	if p.ErrorLayer() != nil {
+		t.Fatal(p.ErrorLayer().Error())
+	}
+	checkLayers(p, []gopacket.LayerType{LayerTypeIPv4, LayerTypeTCP, LayerTypeDNS, LayerTypeDNS}, t)
+	if !p.Metadata().Truncated {
+		t.Error("packet not truncated")
+	}
+	dns := p.ApplicationLayer().(*DNS)
+	if len(dns.Questions) != 1 || len(dns.Answers) != 2 || dns.Answers[0].SOA.Serial != 42 {
+		t.Errorf("bad first message: %v", gopacket.LayerString(dns))
+	}
+
+	// A segment with only the beginning of a message
+	p = gopacket.NewPacket(stream[:20], LayerTypeDNSTCP, gopacket.Default)
+	if p.ErrorLayer() == nil || p.ErrorLayer().Error() != ErrDNSTCPIncomplete {
+		t.Errorf("expected an incomplete message, got %v", p)
+	}
+}
+
+func TestDNSStreamParser(t *testing.T) {
+	stream := testDNSTCPMessages(t)
+	for _, chunk := range []int{1, 7, 100, len(stream)} {
+		var p DNSStreamParser
+		var msgs []*DNS
+		for i := 0; i < len(stream); i += chunk {
+			end := i + chunk
+			if end > len(stream) {
+				end = len(stream)
+			}
+			m, err := p.Parse(stream[i:end])
+			if err != nil {
+				t.Fatalf("chunk %d: %v", chunk, err)
+			}
+			msgs = append(msgs, m...)
+		}
+		if len(msgs) != 3 || p.Pending() != 0 {
+			t.Fatalf("chunk %d: got %d messages, %d bytes pending", chunk, len(msgs), p.Pending())
+		}
+		if string(msgs[1].Answers[0].Name) != "mail.example.com" || msgs[2].Answers[0].Type != DNSTypeSOA {
+			t.Errorf("chunk %d: bad messages %v, %v", chunk, gopacket.LayerString(msgs[1]), gopacket.LayerString(msgs[2]))
+		}
+	}
+
+	var p DNSStreamParser
+	if _, err := p.Parse([]byte{0, 3, 1, 2, 3}); err == nil || err == ErrDNSTCPIncomplete {
+		t.Errorf("got error %v for a malformed message", err)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"github.com/google/gopacket"
+)
+
+// framedDecoder decodes the message at the beginning of data, for protocols
+// sending a sequence of self-delimited messages over TCP, like DNS, DNP3 or
+// IEC 104.  It returns the decoded layer and the number of bytes used, or
+// the incomplete error of the protocol if data ends before the end of the
+// message.
+type framedDecoder func(data []byte, df gopacket.DecodeFeedback) (gopacket.ApplicationLayer, int, error)
+
+// decodeFramed adds the layers decoded by decode from a TCP payload, the first
+// one being the application layer.
+//
+// Since messages often span several segments, a message ending in the next
+// segments is ignored if complete messages precede it.  If data starts with
+// an incomplete message, incomplete is returned.
+func decodeFramed(data []byte, p gopacket.PacketBuilder, incomplete error, decode framedDecoder) error {
+	first := true
+	for len(data) > 0 {
+		l, n, err := decode(data, p)
+		if err == incomplete && !first {
+			// The last message continues in the next segments
+			return nil
+		} else if err != nil {
+			return err
+		}
+		p.AddLayer(l)
+		if first {
+			p.SetApplicationLayer(l)
+			first = false
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// framedStream buffers the data of one direction of a TCP connection between
+// two calls to parse, while it ends with an incomplete message.  It is the
+// common part of the stream parsers of the protocols using a framedDecoder.
+type framedStream struct {
+	pending []byte
+}
+
+// parse calls decode for each complete message found in data, following any
+// data buffered by previous calls, and buffers the remaining data.
+func (s *framedStream) parse(data []byte, incomplete error, decode framedDecoder, msg func(gopacket.ApplicationLayer)) error {
+	if len(s.pending) > 0 {
+		data = append(s.pending, data...)
+		s.pending = nil
+	}
+
+	for len(data) > 0 {
+		l, n, err := decode(data, gopacket.NilDecodeFeedback)
+		if err == incomplete {
+			s.pending = append([]byte(nil), data...)
+			break
+		} else if err != nil {
+			return err
+		}
+		msg(l)
+		data = data[n:]
+	}
+	return nil
+}
@@ -0,0 +1,663 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket"
+)
+
+// ErrHTTPIncomplete is returned when decoding an HTTP message from data
+// ending before the end of the message.
+var ErrHTTPIncomplete = errors.New("incomplete HTTP message")
+
+// HTTPHeader is a header field of an HTTP message.
+type HTTPHeader struct {
+	Name  string
+	Value string
+}
+
+// HTTP is an HTTP/1.x request or response message, as specified by RFC 7230.
+//
+// HTTP is not associated with any TCP port by default, since HTTP messages
+// usually span several TCP segments: they are better decoded from data
+// reassembled by the reassembly package, with HTTPStreamParser or with
+// DecodeFromBytes.  Call RegisterTCPPortLayerType(80, LayerTypeHTTP) to
+// decode the messages found at the beginning of TCP segments.
+//
+// Contents holds the whole message, while Payload holds its body, decoded
+// from the chunked transfer coding if needed.
+type HTTP struct {
+	BaseLayer
+
+	IsResponse bool
+	// Version is the protocol version, like "HTTP/1.1".
+	Version string
+
+	// Request
+	Method     string
+	RequestURI string
+
+	// Response
+	StatusCode int
+	Reason     string
+
+	// Headers holds the header fields, in the order of the message.
+	Headers []HTTPHeader
+
+	// ContentLength is the value of the Content-Length header, or -1 if
+	// there is none.
+	ContentLength int64
+	// Chunked is true if the body uses the chunked transfer coding.
+	Chunked bool
+	// CloseDelimited is true for a response whose body ends when the
+	// connection is closed.
+	CloseDelimited bool
+	// Body is the body of the message, decoded from the chunked transfer
+	// coding if needed.
+	Body []byte
+	// Trailers holds the trailer fields of a chunked body.
+	Trailers []HTTPHeader
+}
+
+// LayerType returns LayerTypeHTTP.
+func (h *HTTP) LayerType() gopacket.LayerType { return LayerTypeHTTP }
+
+// CanDecode returns the set of layer types that this DecodingLayer can decode.
+func (h *HTTP) CanDecode() gopacket.LayerClass { return LayerTypeHTTP }
+
+// NextLayerType returns the layer type contained by this DecodingLayer.
+func (h *HTTP) NextLayerType() gopacket.LayerType { return gopacket.LayerTypePayload }
+
+// Payload returns the body of the message.
+func (h *HTTP) Payload() []byte { return h.Body }
+
+// Header returns the value of the first header with the given name,
+// ignoring case, or an empty string.
+func (h *HTTP) Header(name string) string {
+	for _, hdr := range h.Headers {
+		if strings.EqualFold(hdr.Name, name) {
+			return hdr.Value
+		}
+	}
+	return ""
+}
+
+// HeaderValues returns the values of all the headers with the given name,
+// ignoring case.
+func (h *HTTP) HeaderValues(name string) []string {
+	var values []string
+	for _, hdr := range h.Headers {
+		if strings.EqualFold(hdr.Name, name) {
+			values = append(values, hdr.Value)
+		}
+	}
+	return values
+}
+
+// SetHeader replaces the value of the first header with the given name, or
+// adds the header if there is none.
+func (h *HTTP) SetHeader(name, value string) {
+	for i, hdr := range h.Headers {
+		if strings.EqualFold(hdr.Name, name) {
+			h.Headers[i].Value = value
+			return
+		}
+	}
+	h.Headers = append(h.Headers, HTTPHeader{Name: name, Value: value})
+}
+
+func decodeHTTP(data []byte, p gopacket.PacketBuilder) error {
+	first := true
+	for len(data) > 0 {
+		h := &HTTP{}
+		n, err := h.decode(data, "", true, &httpBody{}, p)
+		if err == ErrHTTPIncomplete && h.Version != "" {
+			// Only the head of the message is there, which is common
+			// when decoding single packets.
+			p.AddLayer(h)
+			if first {
+				p.SetApplicationLayer(h)
+			}
+			return nil
+		} else if err != nil {
+			if first {
+				return err
+			}
+			return p.NextDecoder(gopacket.LayerTypePayload)
+		}
+		p.AddLayer(h)
+		if first {
+			p.SetApplicationLayer(h)
+			first = false
+		}
+		// Pipelined messages
+		data = data[n:]
+	}
+	return nil
+}
+
+// DecodeFromBytes decodes the HTTP message at the beginning of data.  Data
+// following the message, like pipelined messages, is ignored: the length of
+// the message is len(h.Contents).
+//
+// The body of a response without Content-Length or chunked transfer coding is
+// taken to be all the remaining data.  Since DecodeFromBytes doesn't know the
+// method of the request, responses to HEAD requests are better decoded with
+// HTTPStreamParser.
+//
+// If data ends before the end of the message, ErrHTTPIncomplete is returned.
+// If the start line and headers are complete, they are decoded anyway, along
+// with the available part of the body.
+func (h *HTTP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	_, err := h.decode(data, "", true, &httpBody{}, df)
+	return err
+}
+
+// httpBody is the progress of decoding the body of a message, which lets
+// HTTPStreamParser resume decoding when more data is available.
+type httpBody struct {
+	// off is the offset of the body, or of the next chunk of a chunked
+	// body.  It is 0 until the head of the message is decoded.
+	off int
+	// n is the length of the body decoded from complete chunks.
+	n int
+	// need is the length of data needed to decode more of the body.
+	need int64
+}
+
+// decode decodes a message from data, and returns its length.  method is
+// the method of the request a response answers, if known, and eof tells
+// whether data ends with the connection.  If the head of the message was
+// already decoded by a previous call, decoding resumes from b.
+func (h *HTTP) decode(data []byte, method string, eof bool, b *httpBody, df gopacket.DecodeFeedback) (int, error) {
+	if b.off == 0 {
+		*h = HTTP{ContentLength: -1}
+		off, err := h.decodeHead(data)
+		if err != nil {
+			*h = HTTP{ContentLength: -1}
+			if err == ErrHTTPIncomplete {
+				df.SetTruncated()
+			}
+			return 0, err
+		}
+		b.off = off
+	}
+
+	end, err := h.decodeBody(data, b, method, eof)
+	if err == ErrHTTPIncomplete {
+		df.SetTruncated()
+		h.BaseLayer = BaseLayer{Contents: data, Payload: h.Body}
+		return 0, err
+	} else if err != nil {
+		return 0, err
+	}
+	h.BaseLayer = BaseLayer{Contents: data[:end], Payload: h.Body}
+	return end, nil
+}
+
+// httpLine returns the line at the beginning of data, without its line
+// terminator, and the offset of the next line.
+func httpLine(data []byte) ([]byte, int, bool) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return nil, 0, false
+	}
+	return bytes.TrimRight(data[:i], "\r"), i + 1, true
+}
+
+// decodeHead decodes the start line and header fields, returning the offset
+// of the body.
+func (h *HTTP) decodeHead(data []byte) (int, error) {
+	off := 0
+	// Robustness, RFC 7230 section 3.5: ignore empty lines before a request
+	for {
+		line, n, ok := httpLine(data[off:])
+		if !ok {
+			if !httpMaybeStartLine(data[off:]) {
+				return 0, errors.New("invalid HTTP start line")
+			}
+			return 0, ErrHTTPIncomplete
+		}
+		if len(line) > 0 {
+			if err := h.decodeStartLine(string(line)); err != nil {
+				return 0, err
+			}
+			off += n
+			break
+		}
+		off += n
+	}
+
+	headers, n, err := decodeHTTPFields(data[off:])
+	if err == ErrHTTPIncomplete && len(data) > httpMaxHeadLength {
+		return 0, errors.New("HTTP header too long")
+	} else if err != nil {
+		return 0, err
+	}
+	h.Headers = headers
+	return off + n, nil
+}
+
+// httpMaxHeadLength is the maximum length of the start line and headers of
+// a message.  Longer incomplete heads are rejected instead of waiting for
+// their end.
+const httpMaxHeadLength = 1 << 16
+
+// httpMaybeStartLine tells whether an incomplete line may be the beginning
+// of a start line.
+func httpMaybeStartLine(line []byte) bool {
+	if len(line) > httpMaxHeadLength {
+		return false
+	}
+	word := line
+	if i := bytes.IndexByte(line, ' '); i >= 0 {
+		word = line[:i]
+	}
+	return bytes.HasPrefix(word, []byte("HTTP/")) || bytes.HasPrefix([]byte("HTTP/"), word) || httpIsToken(word)
+}
+
+// httpIsToken tells if data is only made of token characters, which is the
+// case for the beginning of a request line.
+func httpIsToken(data []byte) bool {
+	for _, c := range data {
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("\"(),/:;<=>?@[\\]{}", c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *HTTP) decodeStartLine(line string) error {
+	if strings.HasPrefix(line, "HTTP/") {
+		// status-line = HTTP-version SP status-code SP reason-phrase
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) < 2 || len(parts[1]) != 3 {
+			return fmt.Errorf("invalid HTTP status line: %q", line)
+		}
+		code, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return fmt.Errorf("invalid HTTP status code: %q", parts[1])
+		}
+		h.IsResponse = true
+		h.Version = parts[0]
+		h.StatusCode = code
+		if len(parts) == 3 {
+			h.Reason = parts[2]
+		}
+		return nil
+	}
+	// request-line = method SP request-target SP HTTP-version
+	parts := strings.Split(line, " ")
+	if len(parts) != 3 || !httpIsToken([]byte(parts[0])) || parts[1] == "" || !strings.HasPrefix(parts[2], "HTTP/") {
+		return fmt.Errorf("invalid HTTP request line: %q", line)
+	}
+	h.Method, h.RequestURI, h.Version = parts[0], parts[1], parts[2]
+	return nil
+}
+
+// decodeHTTPFields decodes header or trailer fields, up to and including the
+// empty line ending them, and returns their length.
+func decodeHTTPFields(data []byte) ([]HTTPHeader, int, error) {
+	var fields []HTTPHeader
+	off := 0
+	for {
+		line, n, ok := httpLine(data[off:])
+		if !ok {
+			return nil, 0, ErrHTTPIncomplete
+		}
+		off += n
+		if len(line) == 0 {
+			return fields, off, nil
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			// Obsolete line folding
+			if len(fields) == 0 {
+				return nil, 0, errors.New("invalid HTTP header continuation")
+			}
+			fields[len(fields)-1].Value += " " + string(bytes.TrimSpace(line))
+			continue
+		}
+		i := bytes.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, 0, fmt.Errorf("invalid HTTP header: %q", line)
+		}
+		fields = append(fields, HTTPHeader{
+			Name:  string(line[:i]),
+			Value: string(bytes.TrimSpace(line[i+1:])),
+		})
+	}
+}
+
+// decodeBody decodes the body starting at b.off, following the message
+// length rules of RFC 7230 section 3.3.3, and returns the offset of its end.
+func (h *HTTP) decodeBody(data []byte, b *httpBody, method string, eof bool) (int, error) {
+	off := b.off
+	if b.need > 0 {
+		// Resuming, the headers are already decoded
+		return h.decodeBodyData(data, b, eof)
+	}
+	if te := h.HeaderValues("Transfer-Encoding"); len(te) > 0 {
+		codings := strings.Split(te[len(te)-1], ",")
+		h.Chunked = strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+	}
+	if cl := h.HeaderValues("Content-Length"); len(cl) > 0 {
+		n, err := strconv.ParseInt(strings.TrimSpace(cl[0]), 10, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid HTTP Content-Length: %q", cl[0])
+		}
+		for _, v := range cl[1:] {
+			if strings.TrimSpace(v) != strings.TrimSpace(cl[0]) {
+				return 0, errors.New("conflicting HTTP Content-Length headers")
+			}
+		}
+		h.ContentLength = n
+	}
+
+	if h.IsResponse && (h.StatusCode/100 == 1 || h.StatusCode == 204 || h.StatusCode == 304 ||
+		strings.EqualFold(method, "HEAD") || (strings.EqualFold(method, "CONNECT") && h.StatusCode/100 == 2)) {
+		return off, nil
+	}
+	return h.decodeBodyData(data, b, eof)
+}
+
+// decodeBodyData decodes the body of a message whose framing headers are
+// decoded.
+func (h *HTTP) decodeBodyData(data []byte, b *httpBody, eof bool) (int, error) {
+	off := b.off
+	switch {
+	case h.Chunked:
+		return h.decodeChunked(data, b)
+	case h.ContentLength >= 0:
+		if h.ContentLength > int64(len(data)-off) {
+			h.Body = data[off:]
+			b.need = int64(off) + h.ContentLength
+			return 0, ErrHTTPIncomplete
+		}
+		end := off + int(h.ContentLength)
+		h.Body = data[off:end]
+		return end, nil
+	case h.IsResponse:
+		h.CloseDelimited = true
+		h.Body = data[off:]
+		if !eof {
+			b.need = int64(len(data)) + 1
+			return 0, ErrHTTPIncomplete
+		}
+		return len(data), nil
+	}
+	return off, nil
+}
+
+// decodeChunked decodes the chunks starting at b.off.  The data of the
+// complete chunks is kept in the first b.n bytes of h.Body, so that decoding
+// can resume from the first incomplete chunk.
+func (h *HTTP) decodeChunked(data []byte, b *httpBody) (int, error) {
+	body := h.Body[:b.n]
+	defer func() { h.Body = body }()
+	for {
+		off := b.off
+		line, n, ok := httpLine(data[off:])
+		if !ok {
+			if len(data)-off > httpMaxHeadLength {
+				return 0, errors.New("HTTP chunk size line too long")
+			}
+			b.need = int64(len(data)) + 1
+			return 0, ErrHTTPIncomplete
+		}
+		if i := bytes.IndexByte(line, ';'); i >= 0 {
+			// Chunk extensions are ignored
+			line = line[:i]
+		}
+		size, err := strconv.ParseUint(string(bytes.TrimSpace(line)), 16, 63)
+		if err != nil {
+			return 0, fmt.Errorf("invalid HTTP chunk size: %q", line)
+		}
+		off += n
+		if size == 0 {
+			trailers, n, err := decodeHTTPFields(data[off:])
+			if err == ErrHTTPIncomplete {
+				if len(data)-off > httpMaxHeadLength {
+					return 0, errors.New("HTTP trailer too long")
+				}
+				b.need = int64(len(data)) + 1
+				return 0, err
+			} else if err != nil {
+				return 0, err
+			}
+			h.Trailers = trailers
+			return off + n, nil
+		}
+		if uint64(len(data)-off) < size {
+			body = append(body, data[off:]...)
+			// The chunk and its line terminator
+			b.need = math.MaxInt64
+			if size < uint64(math.MaxInt64-off-1) {
+				b.need = int64(off) + int64(size) + 1
+			}
+			return 0, ErrHTTPIncomplete
+		}
+		body = append(body, data[off:off+int(size)]...)
+		off += int(size)
+		crlf, n, ok := httpLine(data[off:])
+		if !ok {
+			if len(data)-off > 1 {
+				return 0, errors.New("invalid HTTP chunk terminator")
+			}
+			b.need = int64(len(data)) + 1
+			return 0, ErrHTTPIncomplete
+		}
+		if len(crlf) != 0 {
+			return 0, errors.New("invalid HTTP chunk terminator")
+		}
+		b.off = off + n
+		b.n = len(body)
+	}
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+//
+// The body is written as a single chunk, followed by the trailers, if Chunked
+// is set.  With FixLengths, the Content-Length header is set to the length of
+// the body, unless Chunked is set or the message is a request without body
+// nor Content-Length header.
+func (h *HTTP) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	if opts.FixLengths && !h.Chunked && (len(h.Body) > 0 || h.IsResponse || h.Header("Content-Length") != "") {
+		h.ContentLength = int64(len(h.Body))
+		h.SetHeader("Content-Length", strconv.Itoa(len(h.Body)))
+	}
+
+	var buf bytes.Buffer
+	version := h.Version
+	if version == "" {
+		version = "HTTP/1.1"
+	}
+	if h.IsResponse {
+		fmt.Fprintf(&buf, "%s %03d %s\r\n", version, h.StatusCode, h.Reason)
+	} else {
+		if h.Method == "" || h.RequestURI == "" {
+			return errors.New("HTTP request without method or URI")
+		}
+		fmt.Fprintf(&buf, "%s %s %s\r\n", h.Method, h.RequestURI, version)
+	}
+	writeHTTPFields(&buf, h.Headers)
+	if h.Chunked {
+		if len(h.Body) > 0 {
+			fmt.Fprintf(&buf, "%x\r\n", len(h.Body))
+			buf.Write(h.Body)
+			buf.WriteString("\r\n")
+		}
+		buf.WriteString("0\r\n")
+		writeHTTPFields(&buf, h.Trailers)
+	} else {
+		buf.Write(h.Body)
+	}
+
+	bytes, err := b.PrependBytes(buf.Len())
+	if err != nil {
+		return err
+	}
+	copy(bytes, buf.Bytes())
+	return nil
+}
+
+func writeHTTPFields(buf *bytes.Buffer, fields []HTTPHeader) {
+	for _, f := range fields {
+		buf.WriteString(f.Name)
+		buf.WriteString(": ")
+		buf.WriteString(f.Value)
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("\r\n")
+}
+
+// DefaultHTTPMaxMessageLength is the default maximum length of a message
+// buffered by HTTPStreamParser.
+const DefaultHTTPMaxMessageLength = 64 << 20
+
+// ErrHTTPTooLong is returned by HTTPStreamParser when a message is longer
+// than its MaxMessageLength.
+var ErrHTTPTooLong = errors.New("HTTP message too long")
+
+// HTTPStreamParser decodes the HTTP messages exchanged over a TCP
+// connection, from the data reassembled for each of its directions, like
+// the data passed to reassembly.Stream.ReassembledSG.
+//
+// It handles pipelined messages, and messages spanning several calls to
+// Parse, which are buffered until they are complete.  Decoding of a
+// buffered message resumes where the previous call stopped, so that a
+// message split over many calls is decoded in linear time.  It pairs
+// responses with requests to find those without body, like responses to
+// HEAD requests.
+type HTTPStreamParser struct {
+	// MaxMessageLength is the maximum length of a message, including its
+	// head, that is buffered for each direction.  Parse returns
+	// ErrHTTPTooLong for longer messages.  If 0,
+	// DefaultHTTPMaxMessageLength is used.
+	MaxMessageLength int
+
+	dirs [2]httpStreamDirection
+	// methods of the requests waiting for a response
+	methods []string
+}
+
+// httpStreamDirection holds the incomplete message of a direction of an
+// HTTP connection.
+type httpStreamDirection struct {
+	// buf holds the data of the message, which is not referenced by
+	// messages returned by Parse.
+	buf []byte
+	// scanned is the length of data searched for the end of the head, and
+	// line tells whether it holds the complete start line.
+	scanned int
+	line    bool
+	// msg is the message whose head is decoded, and body the progress of
+	// its body.
+	msg  *HTTP
+	body httpBody
+}
+
+// ready tells whether data, the buffered data with new data appended, may
+// hold more of the message than when it was last decoded.
+func (d *httpStreamDirection) ready(data []byte) bool {
+	if d.msg != nil {
+		return int64(len(data)) >= d.body.need
+	}
+	if d.scanned == 0 || len(data) > httpMaxHeadLength {
+		return true
+	}
+	if !d.line && bytes.IndexByte(data[d.scanned:], '\n') >= 0 {
+		return true
+	}
+	from := d.scanned - 2
+	if from < 0 {
+		from = 0
+	}
+	return bytes.Contains(data[from:], []byte("\n\n")) || bytes.Contains(data[from:], []byte("\n\r\n"))
+}
+
+// Parse decodes the complete messages found in data, following any data
+// buffered by previous calls for the same direction.  fromServer tells the
+// direction of data, and end tells whether this direction of the connection
+// is closed, which ends close-delimited response bodies.
+//
+// The returned messages may reference data, which must not be modified while
+// they are in use.  Once an error is returned, the direction can't be
+// decoded anymore.
+func (p *HTTPStreamParser) Parse(data []byte, fromServer, end bool) ([]*HTTP, error) {
+	dir := 0
+	if fromServer {
+		dir = 1
+	}
+	d := &p.dirs[dir]
+	buffered := len(d.buf) > 0
+	if buffered {
+		d.buf = append(d.buf, data...)
+		data = d.buf
+	}
+
+	var msgs []*HTTP
+	for len(data) > 0 && (end || d.ready(data)) {
+		h := d.msg
+		if h == nil {
+			h = &HTTP{}
+		}
+		method := ""
+		if fromServer && len(p.methods) > 0 {
+			method = p.methods[0]
+		}
+		n, err := h.decode(data, method, end, &d.body, gopacket.NilDecodeFeedback)
+		if err == ErrHTTPIncomplete && !end {
+			if h.Version != "" {
+				d.msg = h
+			} else {
+				d.line = d.line || bytes.IndexByte(data[d.scanned:], '\n') >= 0
+				d.scanned = len(data)
+			}
+			break
+		} else if err != nil {
+			return msgs, err
+		}
+		if fromServer {
+			// Interim responses don't complete a request
+			if h.StatusCode/100 != 1 && len(p.methods) > 0 {
+				p.methods = p.methods[1:]
+			}
+		} else {
+			p.methods = append(p.methods, h.Method)
+		}
+		msgs = append(msgs, h)
+		data = data[n:]
+		*d = httpStreamDirection{}
+	}
+
+	max := p.MaxMessageLength
+	if max == 0 {
+		max = DefaultHTTPMaxMessageLength
+	}
+	if len(data) > max || (d.msg != nil && d.body.need > int64(max)) {
+		return msgs, ErrHTTPTooLong
+	}
+	switch {
+	case len(data) == 0:
+		d.buf = nil
+	case buffered:
+		// Appending to the rest of the buffer doesn't modify the
+		// returned messages.
+		d.buf = data
+	default:
+		d.buf = append([]byte(nil), data...)
+	}
+	return msgs, nil
+}
@@ -0,0 +1,280 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+const testHTTPPost = "POST /submit?x=1 HTTP/1.1\r\n" +
+	"Host: example.com\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"Content-Length: 11\r\n" +
+	"X-Folded: a\r\n" +
+	" b\r\n" +
+	"\r\n" +
+	"hello world"
+
+const testHTTPChunked = "HTTP/1.1 200 OK\r\n" +
+	"Transfer-Encoding: gzip, chunked\r\n" +
+	"Trailer: X-Checksum\r\n" +
+	"\r\n" +
+	"5;ext=1\r\nhello\r\n" +
+	"7\r\n, world\r\n" +
+	"0\r\n" +
+	"X-Checksum: 42\r\n" +
+	"\r\n"
+
+func TestHTTPDecodeRequest(t *testing.T) {
+	var h HTTP
+	if err := h.DecodeFromBytes([]byte(testHTTPPost+"GET / HTTP/1.1\r\n\r\n"), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	want := []HTTPHeader{
+		{"Host", "example.com"},
+		{"Content-Type", "text/plain"},
+		{"Content-Length", "11"},
+		{"X-Folded", "a b"},
+	}
+	if h.IsResponse || h.Method != "POST" || h.RequestURI != "/submit?x=1" || h.Version != "HTTP/1.1" {
+		t.Errorf("bad request line: %+v", h)
+	}
+	if !reflect.DeepEqual(h.Headers, want) {
+		t.Errorf("got headers %v, want %v", h.Headers, want)
+	}
+	if h.ContentLength != 11 || string(h.Body) != "hello world" || string(h.Payload()) != "hello world" {
+		t.Errorf("bad body: %d %q", h.ContentLength, h.Body)
+	}
+	if string(h.Contents) != testHTTPPost {
+		t.Errorf("bad contents: %q", h.Contents)
+	}
+	if h.Header("content-type") != "text/plain" || h.Header("Accept") != "" {
+		t.Error("bad header lookup")
+	}
+}
+
+func TestHTTPDecodeChunked(t *testing.T) {
+	var h HTTP
+	if err := h.DecodeFromBytes([]byte(testHTTPChunked), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if !h.IsResponse || h.StatusCode != 200 || h.Reason != "OK" || !h.Chunked || h.ContentLength != -1 {
+		t.Errorf("bad response: %+v", h)
+	}
+	if string(h.Body) != "hello, world" {
+		t.Errorf("bad body: %q", h.Body)
+	}
+	if !reflect.DeepEqual(h.Trailers, []HTTPHeader{{"X-Checksum", "42"}}) {
+		t.Errorf("bad trailers: %v", h.Trailers)
+	}
+	if len(h.Contents) != len(testHTTPChunked) {
+		t.Errorf("got %d bytes of contents, want %d", len(h.Contents), len(testHTTPChunked))
+	}
+}
+
+func TestHTTPDecodeIncomplete(t *testing.T) {
+	var h HTTP
+	var df truncatedFeedback
+	err := h.DecodeFromBytes([]byte(testHTTPPost[:len(testHTTPPost)-3]), &df)
+	if err != ErrHTTPIncomplete || !df.truncated {
+		t.Fatalf("got error %v, truncated %v", err, df.truncated)
+	}
+	// The head is decoded anyway
+	if h.Method != "POST" || len(h.Headers) != 4 || string(h.Body) != "hello wo" {
+		t.Errorf("bad partial message: %+v", h)
+	}
+
+	err = h.DecodeFromBytes([]byte("GET /index.ht"), gopacket.NilDecodeFeedback)
+	if err != ErrHTTPIncomplete || h.Method != "" {
+		t.Errorf("got error %v, method %q", err, h.Method)
+	}
+
+	for _, bad := range []string{
+		"\x16\x03\x01\x02\x00",
+		"GET / FTP/1.0\r\n\r\n",
+		"HTTP/1.1 abc OK\r\n\r\n",
+		"GET / HTTP/1.1\r\nNoColon\r\n\r\n",
+		"POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+	} {
+		if err := h.DecodeFromBytes([]byte(bad), gopacket.NilDecodeFeedback); err == nil || err == ErrHTTPIncomplete {
+			t.Errorf("%q: got error %v", bad, err)
+		}
+	}
+}
+
+type truncatedFeedback struct {
+	truncated bool
+}
+
+func (f *truncatedFeedback) SetTruncated() { f.truncated = true }
+
+func TestHTTPPacketPipelining(t *testing.T) {
+	data := []byte("GET /a HTTP/1.1\r\nHost: x\r\n\r\nGET /b HTTP/1.1\r\nHost: x\r\n\r\nGET /c HTTP/1.1\r\n")
+	p := gopacket.NewPacket(data, LayerTypeHTTP, gopacket.Default)
+	if p.ErrorLayer() != nil {
+		t.Fatal(p.ErrorLayer().Error())
+	}
+	var uris []string
+	for _, l := range p.Layers() {
+		uris = append(uris, l.(*HTTP).RequestURI)
+	}
+	if !reflect.DeepEqual(uris, []string{"/a", "/b"}) {
+		t.Errorf("got requests %v", uris)
+	}
+	if p.ApplicationLayer().(*HTTP).RequestURI != "/a" {
+		t.Error("bad application layer")
+	}
+
+	// A single packet with only the beginning of a response
+	p = gopacket.NewPacket([]byte("HTTP/1.1 200 OK\r\nContent-Length: 1000\r\n\r\n<html>"), LayerTypeHTTP, gopacket.Default)
+	h, ok := p.Layer(LayerTypeHTTP).(*HTTP)
+	if !ok || h.StatusCode != 200 || string(h.Body) != "<html>" || !p.Metadata().Truncated {
+		t.Errorf("bad partial response: %v", p)
+	}
+}
+
+func TestHTTPStreamParser(t *testing.T) {
+	var p HTTPStreamParser
+	requests := "HEAD /x HTTP/1.1\r\nHost: a\r\n\r\n" + testHTTPPost + "GET /y HTTP/1.0\r\n\r\n"
+	responses := "HTTP/1.1 200 OK\r\nContent-Length: 1234\r\n\r\n" +
+		"HTTP/1.1 100 Continue\r\n\r\n" +
+		testHTTPChunked +
+		"HTTP/1.0 200 OK\r\n\r\nuntil the end"
+
+	parse := func(data string, fromServer bool, chunk int) []*HTTP {
+		var msgs []*HTTP
+		for i := 0; i < len(data); i += chunk {
+			end := i+chunk >= len(data)
+			part := data[i:]
+			if !end {
+				part = data[i : i+chunk]
+			}
+			m, err := p.Parse([]byte(part), fromServer, end)
+			if err != nil {
+				t.Fatalf("chunk %d: %v", i/chunk, err)
+			}
+			msgs = append(msgs, m...)
+		}
+		return msgs
+	}
+
+	reqs := parse(requests, false, 7)
+	if len(reqs) != 3 || reqs[0].Method != "HEAD" || string(reqs[1].Body) != "hello world" || reqs[2].RequestURI != "/y" {
+		t.Fatalf("bad requests: %v", reqs)
+	}
+	resps := parse(responses, true, 5)
+	if len(resps) != 4 {
+		t.Fatalf("got %d responses, want 4", len(resps))
+	}
+	// The response to HEAD has no body, even with a Content-Length
+	if resps[0].ContentLength != 1234 || len(resps[0].Body) != 0 {
+		t.Errorf("bad response to HEAD: %+v", resps[0])
+	}
+	if resps[1].StatusCode != 100 || string(resps[2].Body) != "hello, world" {
+		t.Errorf("bad responses to POST: %+v, %+v", resps[1], resps[2])
+	}
+	if !resps[3].CloseDelimited || string(resps[3].Body) != "until the end" {
+		t.Errorf("bad close-delimited response: %+v", resps[3])
+	}
+}
+
+func TestHTTPStreamParserResume(t *testing.T) {
+	var p HTTPStreamParser
+	body := strings.Repeat("0123456789", 1000)
+	var chunked bytes.Buffer
+	chunked.WriteString("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n")
+	for i := 0; i < len(body); i += 300 {
+		part := body[i:]
+		if len(part) > 300 {
+			part = part[:300]
+		}
+		fmt.Fprintf(&chunked, "%x\r\n%s\r\n", len(part), part)
+	}
+	chunked.WriteString("0\r\n\r\n")
+	data := "HTTP/1.1 200 OK\r\nContent-Length: 10000\r\n\r\n" + body + chunked.String()
+
+	// One byte at a time
+	var msgs []*HTTP
+	for i := 0; i < len(data); i++ {
+		m, err := p.Parse([]byte{data[i]}, true, false)
+		if err != nil {
+			t.Fatalf("byte %d: %v", i, err)
+		}
+		msgs = append(msgs, m...)
+	}
+	if len(msgs) != 2 || string(msgs[0].Body) != body || string(msgs[1].Body) != body || !msgs[1].Chunked {
+		t.Fatalf("got %d messages", len(msgs))
+	}
+	if len(msgs[0].Contents)+len(msgs[1].Contents) != len(data) {
+		t.Errorf("got %d and %d bytes of contents", len(msgs[0].Contents), len(msgs[1].Contents))
+	}
+
+	p = HTTPStreamParser{MaxMessageLength: 1000}
+	if _, err := p.Parse([]byte("POST / HTTP/1.1\r\nContent-Length: 5000\r\n\r\n"), false, false); err != ErrHTTPTooLong {
+		t.Errorf("got error %v for a long body", err)
+	}
+	p = HTTPStreamParser{MaxMessageLength: 1000}
+	if _, err := p.Parse([]byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"), true, false); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		_, err = p.Parse([]byte("10\r\n0123456789abcdef\r\n"), true, false)
+	}
+	if err != ErrHTTPTooLong {
+		t.Errorf("got error %v for a long chunked body", err)
+	}
+}
+
+func TestHTTPSerialize(t *testing.T) {
+	for _, h := range []*HTTP{
+		{
+			Method:     "PUT",
+			RequestURI: "/upload",
+			Headers:    []HTTPHeader{{"Host", "example.com"}},
+			Body:       []byte("some data"),
+		},
+		{
+			IsResponse: true,
+			Version:    "HTTP/1.1",
+			StatusCode: 404,
+			Reason:     "Not Found",
+		},
+		{
+			IsResponse: true,
+			StatusCode: 200,
+			Reason:     "OK",
+			Headers:    []HTTPHeader{{"Transfer-Encoding", "chunked"}},
+			Chunked:    true,
+			Body:       []byte("chunky"),
+			Trailers:   []HTTPHeader{{"X-Done", "yes"}},
+		},
+	} {
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, h); err != nil {
+			t.Fatal(err)
+		}
+		var got HTTP
+		if err := got.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+			t.Fatalf("%q: %v", buf.Bytes(), err)
+		}
+		if got.Method != h.Method || got.StatusCode != h.StatusCode || string(got.Body) != string(h.Body) ||
+			!reflect.DeepEqual(got.Headers, h.Headers) || !reflect.DeepEqual(got.Trailers, h.Trailers) {
+			t.Errorf("serialized %q, decoded %+v", buf.Bytes(), got)
+		}
+		if !strings.HasSuffix(strings.SplitN(string(buf.Bytes()), "\r\n", 2)[0], "HTTP/1.1") && !got.IsResponse {
+			t.Errorf("bad request line in %q", buf.Bytes())
+		}
+	}
+}
@@ -0,0 +1,461 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/google/gopacket"
+)
+
+// ErrIEC104Incomplete is returned when decoding an IEC 60870-5-104 APDU
+// from data ending before the end of the APDU.
+var ErrIEC104Incomplete = errors.New("incomplete IEC 104 APDU")
+
+// IEC104Format is the format of an IEC 60870-5-104 APDU, given by its
+// control field.
+type IEC104Format uint8
+
+// IEC104Format values.
+const (
+	// IEC104FormatI APDUs carry an ASDU, with numbered information
+	// transfer.
+	IEC104FormatI IEC104Format = iota
+	// IEC104FormatS APDUs acknowledge the I-format APDUs received.
+	IEC104FormatS
+	// IEC104FormatU APDUs control the connection.
+	IEC104FormatU
+)
+
+func (f IEC104Format) String() string {
+	switch f {
+	case IEC104FormatI:
+		return "I"
+	case IEC104FormatS:
+		return "S"
+	case IEC104FormatU:
+		return "U"
+	}
+	return fmt.Sprintf("Unknown(%d)", uint8(f))
+}
+
+// IEC104UFunction is the function of a U-format APDU, as the bits of its
+// first control octet.
+type IEC104UFunction uint8
+
+// IEC104UFunction known values.
+const (
+	IEC104StartDTAct IEC104UFunction = 0x07
+	IEC104StartDTCon IEC104UFunction = 0x0b
+	IEC104StopDTAct  IEC104UFunction = 0x13
+	IEC104StopDTCon  IEC104UFunction = 0x23
+	IEC104TestFRAct  IEC104UFunction = 0x43
+	IEC104TestFRCon  IEC104UFunction = 0x83
+)
+
+func (f IEC104UFunction) String() string {
+	switch f {
+	case IEC104StartDTAct:
+		return "STARTDT act"
+	case IEC104StartDTCon:
+		return "STARTDT con"
+	case IEC104StopDTAct:
+		return "STOPDT act"
+	case IEC104StopDTCon:
+		return "STOPDT con"
+	case IEC104TestFRAct:
+		return "TESTFR act"
+	case IEC104TestFRCon:
+		return "TESTFR con"
+	}
+	return fmt.Sprintf("Unknown(%#02x)", uint8(f))
+}
+
+// IEC104TypeID is the type identification of an ASDU, as defined in IEC
+// 60870-5-101 and 60870-5-104.
+type IEC104TypeID uint8
+
+// IEC104TypeID known values.
+const (
+	IEC104MSpNa1 IEC104TypeID = 1   // single-point information
+	IEC104MSpTa1 IEC104TypeID = 2   // single-point information with time tag
+	IEC104MDpNa1 IEC104TypeID = 3   // double-point information
+	IEC104MDpTa1 IEC104TypeID = 4   // double-point information with time tag
+	IEC104MStNa1 IEC104TypeID = 5   // step position information
+	IEC104MStTa1 IEC104TypeID = 6   // step position information with time tag
+	IEC104MBoNa1 IEC104TypeID = 7   // bitstring of 32 bits
+	IEC104MBoTa1 IEC104TypeID = 8   // bitstring of 32 bits with time tag
+	IEC104MMeNa1 IEC104TypeID = 9   // measured value, normalized value
+	IEC104MMeTa1 IEC104TypeID = 10  // measured value, normalized value with time tag
+	IEC104MMeNb1 IEC104TypeID = 11  // measured value, scaled value
+	IEC104MMeTb1 IEC104TypeID = 12  // measured value, scaled value with time tag
+	IEC104MMeNc1 IEC104TypeID = 13  // measured value, short floating point
+	IEC104MMeTc1 IEC104TypeID = 14  // measured value, short floating point with time tag
+	IEC104MItNa1 IEC104TypeID = 15  // integrated totals
+	IEC104MItTa1 IEC104TypeID = 16  // integrated totals with time tag
+	IEC104MMeNd1 IEC104TypeID = 21  // measured value, normalized value without quality descriptor
+	IEC104MSpTb1 IEC104TypeID = 30  // single-point information with CP56Time2a
+	IEC104MDpTb1 IEC104TypeID = 31  // double-point information with CP56Time2a
+	IEC104MStTb1 IEC104TypeID = 32  // step position information with CP56Time2a
+	IEC104MBoTb1 IEC104TypeID = 33  // bitstring of 32 bits with CP56Time2a
+	IEC104MMeTd1 IEC104TypeID = 34  // measured value, normalized value with CP56Time2a
+	IEC104MMeTe1 IEC104TypeID = 35  // measured value, scaled value with CP56Time2a
+	IEC104MMeTf1 IEC104TypeID = 36  // measured value, short floating point with CP56Time2a
+	IEC104MItTb1 IEC104TypeID = 37  // integrated totals with CP56Time2a
+	IEC104CScNa1 IEC104TypeID = 45  // single command
+	IEC104CDcNa1 IEC104TypeID = 46  // double command
+	IEC104CRcNa1 IEC104TypeID = 47  // regulating step command
+	IEC104CSeNa1 IEC104TypeID = 48  // set point command, normalized value
+	IEC104CSeNb1 IEC104TypeID = 49  // set point command, scaled value
+	IEC104CSeNc1 IEC104TypeID = 50  // set point command, short floating point
+	IEC104CBoNa1 IEC104TypeID = 51  // bitstring of 32 bits command
+	IEC104CScTa1 IEC104TypeID = 58  // single command with CP56Time2a
+	IEC104CDcTa1 IEC104TypeID = 59  // double command with CP56Time2a
+	IEC104CRcTa1 IEC104TypeID = 60  // regulating step command with CP56Time2a
+	IEC104CSeTa1 IEC104TypeID = 61  // set point command, normalized value with CP56Time2a
+	IEC104CSeTb1 IEC104TypeID = 62  // set point command, scaled value with CP56Time2a
+	IEC104CSeTc1 IEC104TypeID = 63  // set point command, short floating point with CP56Time2a
+	IEC104CBoTa1 IEC104TypeID = 64  // bitstring of 32 bits command with CP56Time2a
+	IEC104MEiNa1 IEC104TypeID = 70  // end of initialization
+	IEC104CIcNa1 IEC104TypeID = 100 // interrogation command
+	IEC104CCiNa1 IEC104TypeID = 101 // counter interrogation command
+	IEC104CRdNa1 IEC104TypeID = 102 // read command
+	IEC104CCsNa1 IEC104TypeID = 103 // clock synchronization command
+	IEC104CRpNa1 IEC104TypeID = 105 // reset process command
+	IEC104CTsTa1 IEC104TypeID = 107 // test command with CP56Time2a
+)
+
+// iec104ElementSizes holds the size of the information elements of an
+// information object of each known type, its address excluded.
+var iec104ElementSizes = map[IEC104TypeID]int{
+	IEC104MSpNa1: 1, IEC104MSpTa1: 4, IEC104MDpNa1: 1, IEC104MDpTa1: 4,
+	IEC104MStNa1: 2, IEC104MStTa1: 5, IEC104MBoNa1: 5, IEC104MBoTa1: 8,
+	IEC104MMeNa1: 3, IEC104MMeTa1: 6, IEC104MMeNb1: 3, IEC104MMeTb1: 6,
+	IEC104MMeNc1: 5, IEC104MMeTc1: 8, IEC104MItNa1: 5, IEC104MItTa1: 8,
+	IEC104MMeNd1: 2, IEC104MSpTb1: 8, IEC104MDpTb1: 8, IEC104MStTb1: 9,
+	IEC104MBoTb1: 12, IEC104MMeTd1: 10, IEC104MMeTe1: 10, IEC104MMeTf1: 12,
+	IEC104MItTb1: 12, IEC104CScNa1: 1, IEC104CDcNa1: 1, IEC104CRcNa1: 1,
+	IEC104CSeNa1: 3, IEC104CSeNb1: 3, IEC104CSeNc1: 5, IEC104CBoNa1: 4,
+	IEC104CScTa1: 8, IEC104CDcTa1: 8, IEC104CRcTa1: 8, IEC104CSeTa1: 10,
+	IEC104CSeTb1: 10, IEC104CSeTc1: 12, IEC104CBoTa1: 11, IEC104MEiNa1: 1,
+	IEC104CIcNa1: 1, IEC104CCiNa1: 1, IEC104CRdNa1: 0, IEC104CCsNa1: 7,
+	IEC104CRpNa1: 1, IEC104CTsTa1: 9,
+}
+
+// IEC104Cause is the cause of transmission of an ASDU.
+type IEC104Cause uint8
+
+// IEC104Cause known values.
+const (
+	IEC104CausePeriodic        IEC104Cause = 1
+	IEC104CauseBackground      IEC104Cause = 2
+	IEC104CauseSpontaneous     IEC104Cause = 3
+	IEC104CauseInitialized     IEC104Cause = 4
+	IEC104CauseRequest         IEC104Cause = 5
+	IEC104CauseActivation      IEC104Cause = 6
+	IEC104CauseActivationCon   IEC104Cause = 7
+	IEC104CauseDeactivation    IEC104Cause = 8
+	IEC104CauseDeactivationCon IEC104Cause = 9
+	IEC104CauseActivationTerm  IEC104Cause = 10
+	IEC104CauseInterrogated    IEC104Cause = 20 // station interrogation; 21 to 36 are the groups
+	IEC104CauseUnknownType     IEC104Cause = 44
+	IEC104CauseUnknownCause    IEC104Cause = 45
+	IEC104CauseUnknownAddress  IEC104Cause = 46
+	IEC104CauseUnknownObject   IEC104Cause = 47
+)
+
+// IEC104InformationObject is an information object of an ASDU.
+type IEC104InformationObject struct {
+	Address uint32
+	// Elements holds the information elements, including time tags.
+	Elements []byte
+}
+
+// IEC104 is an APDU of the IEC 60870-5-104 protocol, sent over TCP port
+// 2404: an APCI, made of the start byte, the length and the control field,
+// followed by an ASDU in I-format APDUs.
+//
+// The information objects of ASDUs of known types are decoded into
+// Objects; the sequence of elements of ASDUs with the SQ bit set is split
+// into objects of consecutive addresses. The information objects of other
+// ASDUs are left in Data.
+//
+// TCP segments often hold several APDUs: DecodeFromBytes decodes the first
+// one and leaves the data following it as its LayerPayload. When decoding
+// packets, all the complete APDUs are added as IEC104 layers; the data of a
+// connection is better decoded after reassembly, with IEC104StreamParser.
+type IEC104 struct {
+	BaseLayer
+
+	// Length is the number of bytes of the APDU following it.
+	Length uint8
+	Format IEC104Format
+	// SendSequence is only used in I-format APDUs, and ReceiveSequence
+	// in I-format and S-format APDUs.
+	SendSequence    uint16
+	ReceiveSequence uint16
+	// UFunction is only used in U-format APDUs.
+	UFunction IEC104UFunction
+
+	TypeID IEC104TypeID
+	// Sequence is the SQ bit: the information elements are those of
+	// objects of consecutive addresses, only the first one being sent.
+	Sequence bool
+	// NumberOfObjects is the number of information objects, or of
+	// elements if Sequence is set.
+	NumberOfObjects   uint8
+	Test              bool
+	Negative          bool
+	Cause             IEC104Cause
+	OriginatorAddress uint8
+	CommonAddress     uint16
+	Objects           []IEC104InformationObject
+	// Data holds the information objects of ASDUs of unknown types.
+	Data []byte
+}
+
+// LayerType returns LayerTypeIEC104.
+func (i *IEC104) LayerType() gopacket.LayerType { return LayerTypeIEC104 }
+
+// Payload returns the ASDU of I-format APDUs, implementing
+// gopacket.ApplicationLayer. The data following the APDU is returned by
+// LayerPayload.
+func (i *IEC104) Payload() []byte {
+	if len(i.Contents) <= 6 {
+		return nil
+	}
+	return i.Contents[6:]
+}
+
+// CanDecode returns the set of layer types that this DecodingLayer can decode.
+func (i *IEC104) CanDecode() gopacket.LayerClass { return LayerTypeIEC104 }
+
+// NextLayerType returns LayerTypePayload if data follows the APDU, and
+// LayerTypeZero otherwise.
+func (i *IEC104) NextLayerType() gopacket.LayerType {
+	if len(i.BaseLayer.Payload) > 0 {
+		return gopacket.LayerTypePayload
+	}
+	return gopacket.LayerTypeZero
+}
+
+// decodeIEC104 decodes the APDUs of a TCP payload. As for DNS over TCP, an
+// APDU ending in the next segments is ignored if complete APDUs precede it.
+func decodeIEC104(data []byte, p gopacket.PacketBuilder) error {
+	return decodeFramed(data, p, ErrIEC104Incomplete, decodeIEC104APDU)
+}
+
+// decodeIEC104APDU is the framedDecoder of IEC 104.
+func decodeIEC104APDU(data []byte, df gopacket.DecodeFeedback) (gopacket.ApplicationLayer, int, error) {
+	i := &IEC104{}
+	if err := i.DecodeFromBytes(data, df); err != nil {
+		return nil, 0, err
+	}
+	return i, len(i.Contents), nil
+}
+
+// DecodeFromBytes decodes the APDU at the beginning of data. If data ends
+// before the end of the APDU, ErrIEC104Incomplete is returned.
+func (i *IEC104) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 2 {
+		df.SetTruncated()
+		return ErrIEC104Incomplete
+	}
+	if data[0] != 0x68 {
+		return fmt.Errorf("invalid IEC 104 start byte %#02x", data[0])
+	}
+	if data[1] < 4 {
+		return fmt.Errorf("invalid IEC 104 APDU length %d", data[1])
+	}
+	size := 2 + int(data[1])
+	if len(data) < size {
+		df.SetTruncated()
+		return ErrIEC104Incomplete
+	}
+
+	*i = IEC104{
+		BaseLayer: BaseLayer{Contents: data[:size], Payload: data[size:]},
+		Length:    data[1],
+	}
+	control := data[2:6]
+	switch {
+	case control[0]&0x01 == 0:
+		i.Format = IEC104FormatI
+		i.SendSequence = binary.LittleEndian.Uint16(control) >> 1
+		i.ReceiveSequence = binary.LittleEndian.Uint16(control[2:]) >> 1
+	case control[0]&0x03 == 0x01:
+		i.Format = IEC104FormatS
+		i.ReceiveSequence = binary.LittleEndian.Uint16(control[2:]) >> 1
+	default:
+		i.Format = IEC104FormatU
+		i.UFunction = IEC104UFunction(control[0])
+	}
+	if i.Format != IEC104FormatI {
+		if size != 6 {
+			return fmt.Errorf("IEC 104 %s-format APDU with %d bytes of ASDU", i.Format, size-6)
+		}
+		return nil
+	}
+	return i.decodeASDU(data[6:size])
+}
+
+func (i *IEC104) decodeASDU(data []byte) error {
+	if len(data) < 6 {
+		return errors.New("IEC 104 ASDU too short")
+	}
+	i.TypeID = IEC104TypeID(data[0])
+	i.Sequence = data[1]&0x80 != 0
+	i.NumberOfObjects = data[1] & 0x7f
+	i.Test = data[2]&0x80 != 0
+	i.Negative = data[2]&0x40 != 0
+	i.Cause = IEC104Cause(data[2] & 0x3f)
+	i.OriginatorAddress = data[3]
+	i.CommonAddress = binary.LittleEndian.Uint16(data[4:6])
+	data = data[6:]
+
+	size, ok := iec104ElementSizes[i.TypeID]
+	if !ok {
+		i.Data = data
+		return nil
+	}
+	n := int(i.NumberOfObjects)
+	want := n * (3 + size)
+	if i.Sequence && n > 0 {
+		want = 3 + n*size
+	}
+	if len(data) != want {
+		return fmt.Errorf("IEC 104 ASDU of type %d with %d bytes of information objects, want %d", i.TypeID, len(data), want)
+	}
+	if n == 0 {
+		return nil
+	}
+	i.Objects = make([]IEC104InformationObject, n)
+	address := iec104Address(data)
+	if i.Sequence {
+		data = data[3:]
+	}
+	for j := range i.Objects {
+		if !i.Sequence {
+			address = iec104Address(data)
+			data = data[3:]
+		}
+		i.Objects[j] = IEC104InformationObject{Address: address, Elements: data[:size]}
+		data = data[size:]
+		address++
+	}
+	return nil
+}
+
+func iec104Address(data []byte) uint32 {
+	return uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+// With FixLengths, Length and NumberOfObjects are set; the information
+// objects of ASDUs with Sequence set must have consecutive addresses.
+func (i *IEC104) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	control := make([]byte, 4)
+	var asdu []byte
+	switch i.Format {
+	case IEC104FormatI:
+		binary.LittleEndian.PutUint16(control, i.SendSequence<<1)
+		binary.LittleEndian.PutUint16(control[2:], i.ReceiveSequence<<1)
+		var err error
+		if asdu, err = i.encodeASDU(opts); err != nil {
+			return err
+		}
+	case IEC104FormatS:
+		control[0] = 0x01
+		binary.LittleEndian.PutUint16(control[2:], i.ReceiveSequence<<1)
+	case IEC104FormatU:
+		control[0] = uint8(i.UFunction) | 0x03
+	default:
+		return fmt.Errorf("unknown IEC 104 APDU format %d", i.Format)
+	}
+	length := 4 + len(asdu)
+	if length > 253 {
+		return fmt.Errorf("IEC 104 APDU too long: %d bytes", length)
+	}
+	if opts.FixLengths {
+		i.Length = uint8(length)
+	}
+
+	bytes, err := b.PrependBytes(2 + length)
+	if err != nil {
+		return err
+	}
+	bytes[0], bytes[1] = 0x68, i.Length
+	copy(bytes[2:], control)
+	copy(bytes[6:], asdu)
+	return nil
+}
+
+func (i *IEC104) encodeASDU(opts gopacket.SerializeOptions) ([]byte, error) {
+	if opts.FixLengths && len(i.Objects) > 0 {
+		if len(i.Objects) > 0x7f {
+			return nil, fmt.Errorf("too many IEC 104 information objects: %d", len(i.Objects))
+		}
+		i.NumberOfObjects = uint8(len(i.Objects))
+	}
+	data := []byte{byte(i.TypeID), i.NumberOfObjects & 0x7f, byte(i.Cause) & 0x3f, i.OriginatorAddress, 0, 0}
+	if i.Sequence {
+		data[1] |= 0x80
+	}
+	if i.Test {
+		data[2] |= 0x80
+	}
+	if i.Negative {
+		data[2] |= 0x40
+	}
+	binary.LittleEndian.PutUint16(data[4:], i.CommonAddress)
+	for j, o := range i.Objects {
+		if i.Sequence && j > 0 {
+			if o.Address != i.Objects[0].Address+uint32(j) {
+				return nil, fmt.Errorf("IEC 104 information object address %d is not consecutive", o.Address)
+			}
+		} else {
+			data = append(data, byte(o.Address), byte(o.Address>>8), byte(o.Address>>16))
+		}
+		data = append(data, o.Elements...)
+	}
+	return append(data, i.Data...), nil
+}
+
+// IEC104StreamParser decodes the APDUs sent in one direction of a TCP
+// connection, from the reassembled data, like the data passed to
+// reassembly.Stream.ReassembledSG.  Use one IEC104StreamParser per
+// direction.
+//
+// APDUs spanning several calls to Parse are buffered until they are
+// complete.  To avoid copying the data, reassembly.ScatterGather.KeepFrom
+// can be used instead, with IEC104.DecodeFromBytes and ErrIEC104Incomplete.
+type IEC104StreamParser struct {
+	stream framedStream
+}
+
+// Parse decodes the complete APDUs found in data, following any data
+// buffered by previous calls.
+//
+// The returned APDUs may reference data, which must not be modified while
+// they are in use.  Once an error is returned, the stream can't be decoded
+// anymore.
+func (p *IEC104StreamParser) Parse(data []byte) ([]*IEC104, error) {
+	var apdus []*IEC104
+	err := p.stream.parse(data, ErrIEC104Incomplete, decodeIEC104APDU, func(l gopacket.ApplicationLayer) {
+		apdus = append(apdus, l.(*IEC104))
+	})
+	return apdus, err
+}
+
+// Pending returns the number of bytes buffered, waiting for the end of an
+// APDU.
+func (p *IEC104StreamParser) Pending() int {
+	return len(p.stream.pending)
+}
@@ -0,0 +1,161 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+var (
+	// STARTDT act, then an S-format APDU acknowledging 5 APDUs.
+	testIEC104StartDT = []byte{0x68, 0x04, 0x07, 0x00, 0x00, 0x00}
+	testIEC104S       = []byte{0x68, 0x04, 0x01, 0x00, 0x0a, 0x00}
+	// Station interrogation activation, with send sequence 0 and receive
+	// sequence 0, for common address 1.
+	testIEC104Interrogation = []byte{
+		0x68, 0x0e, 0x00, 0x00, 0x00, 0x00,
+		0x64, 0x01, 0x06, 0x00, 0x01, 0x00,
+		0x00, 0x00, 0x00, 0x14,
+	}
+	// Three short floating point measured values of consecutive addresses
+	// 1000 to 1002, sent in a sequence, in response to the interrogation.
+	testIEC104Floats = []byte{
+		0x68, 0x1c, 0x02, 0x00, 0x02, 0x00,
+		0x0d, 0x83, 0x14, 0x00, 0x01, 0x00,
+		0xe8, 0x03, 0x00,
+		0x00, 0x00, 0x80, 0x3f, 0x00,
+		0x00, 0x00, 0x00, 0x40, 0x00,
+		0x00, 0x00, 0x40, 0x40, 0x80,
+	}
+)
+
+func TestIEC104Decode(t *testing.T) {
+	for _, test := range []struct {
+		data []byte
+		want IEC104
+	}{
+		{testIEC104StartDT, IEC104{Length: 4, Format: IEC104FormatU, UFunction: IEC104StartDTAct}},
+		{testIEC104S, IEC104{Length: 4, Format: IEC104FormatS, ReceiveSequence: 5}},
+		{testIEC104Interrogation, IEC104{
+			Length: 14, Format: IEC104FormatI,
+			TypeID: IEC104CIcNa1, NumberOfObjects: 1, Cause: IEC104CauseActivation, CommonAddress: 1,
+			Objects: []IEC104InformationObject{{Address: 0, Elements: []byte{0x14}}},
+		}},
+		{testIEC104Floats, IEC104{
+			Length: 28, Format: IEC104FormatI, SendSequence: 1, ReceiveSequence: 1,
+			TypeID: IEC104MMeNc1, Sequence: true, NumberOfObjects: 3, Cause: IEC104CauseInterrogated, CommonAddress: 1,
+			Objects: []IEC104InformationObject{
+				{Address: 1000, Elements: []byte{0x00, 0x00, 0x80, 0x3f, 0x00}},
+				{Address: 1001, Elements: []byte{0x00, 0x00, 0x00, 0x40, 0x00}},
+				{Address: 1002, Elements: []byte{0x00, 0x00, 0x40, 0x40, 0x80}},
+			},
+		}},
+	} {
+		var got IEC104
+		if err := got.DecodeFromBytes(test.data, gopacket.NilDecodeFeedback); err != nil {
+			t.Errorf("%x: %v", test.data, err)
+			continue
+		}
+		test.want.BaseLayer = BaseLayer{Contents: test.data, Payload: []byte{}}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%x: decoded\n%+v\nwant\n%+v", test.data, got, test.want)
+		}
+
+		buf := gopacket.NewSerializeBuffer()
+		got.Length = 0
+		if err := got.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+			t.Errorf("%x: %v", test.data, err)
+		} else if !bytes.Equal(buf.Bytes(), test.data) {
+			t.Errorf("serialized %x, want %x", buf.Bytes(), test.data)
+		}
+	}
+}
+
+func TestIEC104DecodeErrors(t *testing.T) {
+	var i IEC104
+	if err := i.DecodeFromBytes(testIEC104Floats[:20], gopacket.NilDecodeFeedback); err != ErrIEC104Incomplete {
+		t.Errorf("got error %v with a truncated APDU, want ErrIEC104Incomplete", err)
+	}
+	for _, data := range [][]byte{
+		{0x69, 0x04, 0x07, 0x00, 0x00, 0x00},
+		{0x68, 0x05, 0x07, 0x00, 0x00, 0x00, 0x00},
+		// Interrogation with two objects announced.
+		{0x68, 0x0e, 0x00, 0x00, 0x00, 0x00, 0x64, 0x02, 0x06, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x14},
+	} {
+		if err := i.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err == nil {
+			t.Errorf("%x: decoded without error: %+v", data, i)
+		}
+	}
+
+	// ASDUs of unknown types keep their information objects as data.
+	data := []byte{0x68, 0x0d, 0x00, 0x00, 0x00, 0x00, 0x7e, 0x01, 0x05, 0x00, 0x01, 0x00, 0x01, 0x02, 0x03}
+	if err := i.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if i.Objects != nil || !bytes.Equal(i.Data, data[12:]) {
+		t.Errorf("decoded %+v", i)
+	}
+}
+
+func TestIEC104Packet(t *testing.T) {
+	var stream []byte
+	for _, apdu := range [][]byte{testIEC104S, testIEC104Floats, testIEC104Interrogation[:9]} {
+		stream = append(stream, apdu...)
+	}
+	ip := &IPv4{Version: 4, TTL: 64, Protocol: IPProtocolTCP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	tcp := &TCP{SrcPort: 2404, DstPort: 40000, ACK: true, PSH: true, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		ip, tcp, gopacket.Payload(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), LayerTypeIPv4, gopacket.DecodeOptions{DecodeStreamsAsDatagrams: true})
+	if p.ErrorLayer() != nil {
+		t.Fatal("Failed to decode packet:", p.ErrorLayer().Error())
+	}
+	// The incomplete APDU at the end of the segment is ignored.
+	checkLayers(p, []gopacket.LayerType{LayerTypeIPv4, LayerTypeTCP, LayerTypeIEC104, LayerTypeIEC104}, t)
+	if i := p.Layers()[3].(*IEC104); i.TypeID != IEC104MMeNc1 || len(i.Objects) != 3 {
+		t.Errorf("wrong second APDU %+v", i)
+	}
+}
+
+func TestIEC104StreamParser(t *testing.T) {
+	var stream []byte
+	for _, apdu := range [][]byte{testIEC104StartDT, testIEC104Interrogation, testIEC104Floats, testIEC104S} {
+		stream = append(stream, apdu...)
+	}
+	var parser IEC104StreamParser
+	var formats []IEC104Format
+	for off := 0; off < len(stream); off += 5 {
+		end := off + 5
+		if end > len(stream) {
+			end = len(stream)
+		}
+		apdus, err := parser.Parse(stream[off:end])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range apdus {
+			formats = append(formats, a.Format)
+		}
+	}
+	want := []IEC104Format{IEC104FormatU, IEC104FormatI, IEC104FormatI, IEC104FormatS}
+	if !reflect.DeepEqual(formats, want) {
+		t.Errorf("decoded formats %v, want %v", formats, want)
+	}
+	if parser.Pending() != 0 {
+		t.Errorf("%d bytes pending", parser.Pending())
+	}
+}
@@ -10,6 +10,7 @@ package layers
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"net"
 	"time"
 
@@ -115,7 +116,7 @@ func (i *IGMPv1or2) decodeResponse(data []byte) error {
 		return errors.New("IGMP packet too small")
 	}
 
-	i.MaxResponseTime = igmpTimeDecode(data[1])
+	i.MaxResponseTime = igmpv2TimeUnit * time.Duration(data[1])
 	i.Checksum = binary.BigEndian.Uint16(data[2:4])
 	i.GroupAddress = net.IP(data[4:8])
 
@@ -175,6 +176,7 @@ func (i *IGMP) decodeIGMPv3MembershipReport(data []byte) error {
 
 	i.Checksum = binary.BigEndian.Uint16(data[2:4])
 	i.NumberOfGroupRecords = binary.BigEndian.Uint16(data[6:8])
+	i.GroupRecords = i.GroupRecords[:0]
 
 	recordOffset := 8
 	for j := 0; j < int(i.NumberOfGroupRecords); j++ {
@@ -199,7 +201,7 @@ func (i *IGMP) decodeIGMPv3MembershipReport(data []byte) error {
 		}
 
 		i.GroupRecords = append(i.GroupRecords, gr)
-		recordOffset += 8 + 4*int(gr.NumberOfSources)
+		recordOffset += 8 + 4*int(gr.NumberOfSources) + 4*int(gr.AuxDataLen)
 	}
 	return nil
 }
@@ -231,8 +233,9 @@ func (i *IGMP) decodeIGMPv3MembershipQuery(data []byte) error {
 	i.SupressRouterProcessing = data[8]&0x8 != 0
 	i.GroupAddress = net.IP(data[4:8])
 	i.RobustnessValue = data[8] & 0x7
-	i.IntervalTime = igmpTimeDecode(data[9])
+	i.IntervalTime = igmpCodeDecode(data[9], time.Second)
 	i.NumberOfSources = binary.BigEndian.Uint16(data[10:12])
+	i.SourceAddresses = i.SourceAddresses[:0]
 
 	if len(data) < 12+int(i.NumberOfSources)*4 {
 		return errors.New("IGMPv3 Membership Query too small #2")
@@ -245,15 +248,43 @@ func (i *IGMP) decodeIGMPv3MembershipQuery(data []byte) error {
 	return nil
 }
 
+// igmpv2TimeUnit is the unit of the IGMPv1/v2 Max Response Time and of the
+// IGMPv3 Max Resp Code.
+const igmpv2TimeUnit = 100 * time.Millisecond
+
 // igmpTimeDecode decodes the duration created by the given byte, using the
 // algorithm in http://www.rfc-base.org/txt/rfc-3376.txt section 4.1.1.
 func igmpTimeDecode(t uint8) time.Duration {
+	return igmpCodeDecode(t, igmpv2TimeUnit)
+}
+
+// igmpCodeDecode decodes an RFC 3376 Max Resp Code or QQIC whose value is
+// expressed in the given unit.
+func igmpCodeDecode(t uint8, unit time.Duration) time.Duration {
 	if t&0x80 == 0 {
-		return time.Millisecond * 100 * time.Duration(t)
+		return unit * time.Duration(t)
+	}
+	exp := (t & 0x70) >> 4
+	mant := t & 0x0F
+	return unit * time.Duration(uint(mant|0x10)<<(exp+3))
+}
+
+// igmpCodeEncode is the inverse of igmpCodeDecode. Durations that cannot be
+// represented exactly are rounded down.
+func igmpCodeEncode(d, unit time.Duration) (uint8, error) {
+	if d < 0 {
+		return 0, fmt.Errorf("IGMP time %v must not be negative", d)
+	}
+	v := d / unit
+	if v < 0x80 {
+		return uint8(v), nil
+	}
+	for exp := uint(0); exp < 8; exp++ {
+		if mant := v >> (exp + 3); mant < 0x20 {
+			return 0x80 | uint8(exp)<<4 | uint8(mant&0x0F), nil
+		}
 	}
-	mant := (t & 0x70) >> 4
-	exp := t & 0x0F
-	return time.Millisecond * 100 * time.Duration((mant|0x10)<<(exp+3))
+	return 0, fmt.Errorf("IGMP time %v exceeds the maximum of %v", d, igmpCodeDecode(0xFF, unit))
 }
 
 // LayerType returns LayerTypeIGMP for the V1,2,3 message protocol formats.
@@ -266,13 +297,37 @@ func (i *IGMPv1or2) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) err
 	}
 
 	i.Type = IGMPType(data[0])
-	i.MaxResponseTime = igmpTimeDecode(data[1])
+	i.MaxResponseTime = igmpv2TimeUnit * time.Duration(data[1])
 	i.Checksum = binary.BigEndian.Uint16(data[2:4])
 	i.GroupAddress = net.IP(data[4:8])
 
 	return nil
 }
 
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+// See the docs for gopacket.SerializableLayer for more info.
+func (i *IGMPv1or2) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	if i.MaxResponseTime < 0 || i.MaxResponseTime > 0xFF*igmpv2TimeUnit {
+		return fmt.Errorf("IGMP max response time %v is out of range", i.MaxResponseTime)
+	}
+	data, err := b.PrependBytes(8)
+	if err != nil {
+		return err
+	}
+	data[0] = byte(i.Type)
+	data[1] = uint8(i.MaxResponseTime / igmpv2TimeUnit)
+	data[2], data[3] = 0, 0
+	if err := igmpPutAddress(data[4:8], i.GroupAddress); err != nil {
+		return err
+	}
+	if opts.ComputeChecksums {
+		i.Checksum = tcpipChecksum(b.Bytes(), 0)
+	}
+	binary.BigEndian.PutUint16(data[2:4], i.Checksum)
+	return nil
+}
+
 func (i *IGMPv1or2) NextLayerType() gopacket.LayerType {
 	return gopacket.LayerTypeZero
 }
@@ -292,13 +347,146 @@ func (i *IGMP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 
 	switch i.Type {
 	case IGMPMembershipQuery:
-		i.decodeIGMPv3MembershipQuery(data)
+		return i.decodeIGMPv3MembershipQuery(data)
 	case IGMPMembershipReportV3:
-		i.decodeIGMPv3MembershipReport(data)
+		return i.decodeIGMPv3MembershipReport(data)
 	default:
 		return errors.New("unsupported IGMP type")
 	}
+}
 
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer. Only the
+// IGMPv3 Membership Query and Membership Report are supported; use
+// IGMPv1or2 for the older message formats.
+func (i *IGMP) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	var err error
+	switch i.Type {
+	case IGMPMembershipQuery:
+		err = i.serializeIGMPv3MembershipQuery(b, opts)
+	case IGMPMembershipReportV3:
+		err = i.serializeIGMPv3MembershipReport(b, opts)
+	default:
+		return fmt.Errorf("cannot serialize %v as an IGMPv3 message", i.Type)
+	}
+	if err != nil {
+		return err
+	}
+	data := b.Bytes()
+	if opts.ComputeChecksums {
+		data[2], data[3] = 0, 0
+		i.Checksum = tcpipChecksum(data, 0)
+	}
+	binary.BigEndian.PutUint16(data[2:4], i.Checksum)
+	return nil
+}
+
+func (i *IGMP) serializeIGMPv3MembershipQuery(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	if len(i.SourceAddresses) > 0xFFFF {
+		return fmt.Errorf("too many IGMP source addresses: %d", len(i.SourceAddresses))
+	}
+	if opts.FixLengths {
+		i.NumberOfSources = uint16(len(i.SourceAddresses))
+	}
+	maxResp, err := igmpCodeEncode(i.MaxResponseTime, igmpv2TimeUnit)
+	if err != nil {
+		return err
+	}
+	qqic, err := igmpCodeEncode(i.IntervalTime, time.Second)
+	if err != nil {
+		return err
+	}
+	data, err := b.PrependBytes(12 + 4*len(i.SourceAddresses))
+	if err != nil {
+		return err
+	}
+	data[0] = byte(i.Type)
+	data[1] = maxResp
+	if err := igmpPutAddress(data[4:8], i.GroupAddress); err != nil {
+		return err
+	}
+	// RFC 3376 section 4.1.6: a robustness variable above 7 is sent as 0.
+	data[8] = 0
+	if i.RobustnessValue <= 7 {
+		data[8] = i.RobustnessValue
+	}
+	if i.SupressRouterProcessing {
+		data[8] |= 0x8
+	}
+	data[9] = qqic
+	binary.BigEndian.PutUint16(data[10:12], i.NumberOfSources)
+	for j, src := range i.SourceAddresses {
+		if err := igmpPutAddress(data[12+j*4:16+j*4], src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *IGMP) serializeIGMPv3MembershipReport(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	if len(i.GroupRecords) > 0xFFFF {
+		return fmt.Errorf("too many IGMP group records: %d", len(i.GroupRecords))
+	}
+	if opts.FixLengths {
+		i.NumberOfGroupRecords = uint16(len(i.GroupRecords))
+	}
+	size := 8
+	for j := range i.GroupRecords {
+		gr := &i.GroupRecords[j]
+		if len(gr.SourceAddresses) > 0xFFFF {
+			return fmt.Errorf("too many IGMP source addresses in group record %d: %d", j, len(gr.SourceAddresses))
+		}
+		if opts.FixLengths {
+			gr.NumberOfSources = uint16(len(gr.SourceAddresses))
+			// Auxiliary data is not modelled, so none is written.
+			gr.AuxDataLen = 0
+		}
+		size += 8 + 4*len(gr.SourceAddresses) + 4*int(gr.AuxDataLen)
+	}
+	data, err := b.PrependBytes(size)
+	if err != nil {
+		return err
+	}
+	data[0] = byte(i.Type)
+	data[1] = 0
+	data[4], data[5] = 0, 0
+	binary.BigEndian.PutUint16(data[6:8], i.NumberOfGroupRecords)
+	off := 8
+	for j := range i.GroupRecords {
+		gr := &i.GroupRecords[j]
+		data[off] = byte(gr.Type)
+		data[off+1] = gr.AuxDataLen
+		binary.BigEndian.PutUint16(data[off+2:off+4], gr.NumberOfSources)
+		if err := igmpPutAddress(data[off+4:off+8], gr.MulticastAddress); err != nil {
+			return err
+		}
+		off += 8
+		for _, src := range gr.SourceAddresses {
+			if err := igmpPutAddress(data[off:off+4], src); err != nil {
+				return err
+			}
+			off += 4
+		}
+		for k := 0; k < 4*int(gr.AuxDataLen); k++ {
+			data[off] = 0
+			off++
+		}
+	}
+	return nil
+}
+
+// igmpPutAddress writes the IPv4 address ip to data, treating a nil address
+// as the unspecified address.
+func igmpPutAddress(data []byte, ip net.IP) error {
+	if ip == nil {
+		copy(data, net.IPv4zero.To4())
+		return nil
+	}
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return fmt.Errorf("invalid IGMP address %v", ip)
+	}
+	copy(data, ip4)
 	return nil
 }
 
@@ -353,3 +541,51 @@ func decodeIGMP(data []byte, p gopacket.PacketBuilder) error {
 
 	return errors.New("Unable to determine IGMP type.")
 }
+
+// Default values for IGMPv3 queries, from RFC 3376 section 8.
+const (
+	IGMPDefaultRobustness    = 2
+	IGMPDefaultQueryInterval = 125 * time.Second
+)
+
+// NewIGMPv1Query returns an IGMPv1 general membership query.
+func NewIGMPv1Query() *IGMPv1or2 {
+	return &IGMPv1or2{Type: IGMPMembershipQuery, Version: 1}
+}
+
+// NewIGMPv2Query returns an IGMPv2 membership query. A nil group makes a
+// general query, otherwise a group-specific query for that group is built.
+// maxResponse must be positive to distinguish it from an IGMPv1 query.
+func NewIGMPv2Query(group net.IP, maxResponse time.Duration) *IGMPv1or2 {
+	return &IGMPv1or2{Type: IGMPMembershipQuery, MaxResponseTime: maxResponse, GroupAddress: group, Version: 2}
+}
+
+// NewIGMPv3Query returns an IGMPv3 membership query using the default
+// robustness and query interval. A nil group makes a general query; a group
+// with sources makes a group-and-source-specific query.
+func NewIGMPv3Query(group net.IP, sources []net.IP, maxResponse time.Duration) *IGMP {
+	return &IGMP{
+		Type:            IGMPMembershipQuery,
+		MaxResponseTime: maxResponse,
+		GroupAddress:    group,
+		RobustnessValue: IGMPDefaultRobustness,
+		IntervalTime:    IGMPDefaultQueryInterval,
+		SourceAddresses: sources,
+		Version:         3,
+	}
+}
+
+// NewIGMPIPv4 returns an IPv4 header for carrying an IGMP message from src to
+// dst. As RFC 2236 and RFC 3376 require, it has a TTL of 1, internetwork
+// control precedence and the Router Alert option.
+func NewIGMPIPv4(src, dst net.IP) *IPv4 {
+	return &IPv4{
+		Version:  4,
+		TOS:      0xc0,
+		TTL:      1,
+		Protocol: IPProtocolIGMP,
+		SrcIP:    src,
+		DstIP:    dst,
+		Options:  []IPv4Option{IPv4RouterAlertOption()},
+	}
+}
@@ -7,7 +7,10 @@
 package layers
 
 import (
+	"bytes"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/google/gopacket"
 )
@@ -169,3 +172,146 @@ func BenchmarkDecodeigmpv3MembershipReport2Records(b *testing.B) {
 		gopacket.NewPacket(igmpv3MembershipReport2Records, LinkTypeEthernet, gopacket.NoCopy)
 	}
 }
+
+func TestIGMPSerializeRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		packet []byte
+	}{
+		{"v1 report", igmpv1MembershipReportPacket},
+		{"v2 query", igmpv2MembershipQueryPacket},
+		{"v2 report", igmpv2MembershipReportPacket},
+		{"v3 query", igmp3v3MembershipQueryPacket},
+		{"v3 report", igmpv3MembershipReport2Records},
+	} {
+		p := gopacket.NewPacket(test.packet, LinkTypeEthernet, gopacket.Default)
+		ip := p.Layer(LayerTypeIPv4).(*IPv4)
+		want := ip.Payload
+		if len(want) > int(ip.Length)-int(ip.IHL)*4 {
+			want = want[:int(ip.Length)-int(ip.IHL)*4]
+		}
+		l := p.Layer(LayerTypeIGMP).(gopacket.SerializableLayer)
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, l); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%s: got\n%x\nwant\n%x", test.name, buf.Bytes(), want)
+		}
+	}
+}
+
+func TestIGMPQueryBuilders(t *testing.T) {
+	src := net.IPv4(192, 168, 1, 254)
+	group := net.IPv4(239, 1, 2, 3)
+	sources := []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)}
+	for _, test := range []struct {
+		name  string
+		dst   net.IP
+		query gopacket.SerializableLayer
+	}{
+		{"v1 general", net.IPv4(224, 0, 0, 1), NewIGMPv1Query()},
+		{"v2 general", net.IPv4(224, 0, 0, 1), NewIGMPv2Query(nil, 10*time.Second)},
+		{"v2 group", group, NewIGMPv2Query(group, time.Second)},
+		{"v3 general", net.IPv4(224, 0, 0, 1), NewIGMPv3Query(nil, nil, 10*time.Second)},
+		{"v3 group and source", group, NewIGMPv3Query(group, sources, 51200*time.Millisecond)},
+	} {
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(buf, opts, NewIGMPIPv4(src, test.dst), test.query); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		data := buf.Bytes()
+		// The Router Alert option follows the fixed IPv4 header.
+		if data[0] != 0x46 || !bytes.Equal(data[20:24], []byte{0x94, 0x04, 0x00, 0x00}) {
+			t.Errorf("%s: missing router alert in IPv4 header %x", test.name, data[:24])
+		}
+		p := gopacket.NewPacket(data, LayerTypeIPv4, gopacket.Default)
+		if p.ErrorLayer() != nil {
+			t.Errorf("%s: %v", test.name, p.ErrorLayer().Error())
+			continue
+		}
+		ip := p.Layer(LayerTypeIPv4).(*IPv4)
+		if ip.TTL != 1 || len(ip.Options) != 1 || ip.Options[0].OptionType != IPv4OptionRouterAlert {
+			t.Errorf("%s: bad IPv4 header %+v", test.name, ip)
+		}
+		if tcpipChecksum(ip.Payload, 0) != 0 {
+			t.Errorf("%s: bad IGMP checksum", test.name)
+		}
+		switch q := test.query.(type) {
+		case *IGMPv1or2:
+			got, ok := p.Layer(LayerTypeIGMP).(*IGMPv1or2)
+			if !ok || got.Version != q.Version || got.MaxResponseTime != q.MaxResponseTime || (q.GroupAddress != nil && !got.GroupAddress.Equal(q.GroupAddress)) {
+				t.Errorf("%s: got %+v", test.name, p.Layer(LayerTypeIGMP))
+			}
+		case *IGMP:
+			got, ok := p.Layer(LayerTypeIGMP).(*IGMP)
+			if !ok || got.MaxResponseTime != q.MaxResponseTime || got.IntervalTime != 125*time.Second ||
+				got.RobustnessValue != 2 || int(got.NumberOfSources) != len(q.SourceAddresses) {
+				t.Errorf("%s: got %+v", test.name, p.Layer(LayerTypeIGMP))
+				continue
+			}
+			for j := range q.SourceAddresses {
+				if !got.SourceAddresses[j].Equal(q.SourceAddresses[j]) {
+					t.Errorf("%s: source %d is %v", test.name, j, got.SourceAddresses[j])
+				}
+			}
+		}
+	}
+}
+
+func TestIGMPv3ReportSerialize(t *testing.T) {
+	report := &IGMP{
+		Type: IGMPMembershipReportV3,
+		GroupRecords: []IGMPv3GroupRecord{
+			{Type: IGMPIsIn, MulticastAddress: net.IPv4(232, 1, 1, 1), SourceAddresses: []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)}},
+			{Type: IGMPToEx, MulticastAddress: net.IPv4(239, 255, 255, 250)},
+			{Type: IGMPBlock, MulticastAddress: net.IPv4(232, 1, 1, 2), SourceAddresses: []net.IP{net.IPv4(10, 0, 0, 3)}},
+		},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, report); err != nil {
+		t.Fatal(err)
+	}
+	if tcpipChecksum(buf.Bytes(), 0) != 0 {
+		t.Error("bad checksum")
+	}
+	p := gopacket.NewPacket(buf.Bytes(), LayerTypeIGMP, gopacket.Default)
+	got := p.Layer(LayerTypeIGMP).(*IGMP)
+	if got.NumberOfGroupRecords != 3 || len(got.GroupRecords) != 3 {
+		t.Fatalf("got %+v", got)
+	}
+	for i, want := range report.GroupRecords {
+		gr := got.GroupRecords[i]
+		if gr.Type != want.Type || !gr.MulticastAddress.Equal(want.MulticastAddress) || len(gr.SourceAddresses) != len(want.SourceAddresses) {
+			t.Errorf("record %d: got %+v", i, gr)
+		}
+	}
+}
+
+func TestIGMPTimeCode(t *testing.T) {
+	for _, test := range []struct {
+		d    time.Duration
+		code uint8
+	}{
+		{0, 0},
+		{10 * time.Second, 100},
+		{12700 * time.Millisecond, 0x7f},
+		{12800 * time.Millisecond, 0x80},
+		{25600 * time.Millisecond, 0x90},
+		{3174400 * time.Millisecond, 0xff},
+	} {
+		code, err := igmpCodeEncode(test.d, igmpv2TimeUnit)
+		if err != nil || code != test.code {
+			t.Errorf("encode %v: got %#x, %v; want %#x", test.d, code, err, test.code)
+		}
+		if d := igmpTimeDecode(test.code); d != test.d {
+			t.Errorf("decode %#x: got %v, want %v", test.code, d, test.d)
+		}
+	}
+	if _, err := igmpCodeEncode(time.Hour, igmpv2TimeUnit); err == nil {
+		t.Error("expected an error for an unrepresentable time")
+	}
+}
@@ -70,6 +70,16 @@ type IPv4Option struct {
 	OptionData   []byte
 }
 
+// IPv4OptionRouterAlert is the option type of the Router Alert option defined
+// in RFC 2113.
+const IPv4OptionRouterAlert = 148
+
+// IPv4RouterAlertOption returns a Router Alert option asking every router on
+// the path to examine the packet, as IGMP and RSVP messages require.
+func IPv4RouterAlertOption() IPv4Option {
+	return IPv4Option{OptionType: IPv4OptionRouterAlert, OptionLength: 4, OptionData: []byte{0, 0}}
+}
+
 func (i IPv4Option) String() string {
 	return fmt.Sprintf("IPv4Option(%v:%v)", i.OptionType, i.OptionData)
 }
@@ -101,6 +111,13 @@ func (ip *IPv4) getIPv4OptionSize() uint8 {
 // SerializeTo writes the serialized form of this layer into the
 // SerializationBuffer, implementing gopacket.SerializableLayer.
 func (ip *IPv4) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	if opts.FixLengths {
+		for i := range ip.Options {
+			if t := ip.Options[i].OptionType; t != 0 && t != 1 {
+				ip.Options[i].OptionLength = uint8(len(ip.Options[i].OptionData) + 2)
+			}
+		}
+	}
 	optionLength := ip.getIPv4OptionSize()
 	bytes, err := b.PrependBytes(20 + int(optionLength))
 	if err != nil {
@@ -136,6 +153,9 @@ func (ip *IPv4) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeO
 			bytes[curLocation] = 1
 			curLocation++
 		default:
+			if opt.OptionLength < 2 {
+				return fmt.Errorf("invalid length %d for IP option type %v", opt.OptionLength, opt.OptionType)
+			}
 			bytes[curLocation] = opt.OptionType
 			bytes[curLocation+1] = opt.OptionLength
 
@@ -147,6 +167,11 @@ func (ip *IPv4) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeO
 			curLocation += int(opt.OptionLength)
 		}
 	}
+	// Pad the options out to a 32 bit boundary with end of list bytes,
+	// since a reused buffer may hold stale data there.
+	for ; curLocation < len(bytes); curLocation++ {
+		bytes[curLocation] = 0
+	}
 
 	if opts.ComputeChecksums {
 		ip.Checksum = checksum(bytes)
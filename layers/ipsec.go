@@ -55,23 +55,60 @@ func decodeIPSecAH(data []byte, p gopacket.PacketBuilder) error {
 
 // IPSecESP is the encapsulating security payload defined in
 // http://tools.ietf.org/html/rfc2406
+//
+// The payload of ESP packets is decrypted when they are decoded with an
+// IPSecSATable holding their SA, like DefaultIPSecSATable.
 type IPSecESP struct {
 	BaseLayer
 	SPI, Seq uint32
 	// Encrypted contains the encrypted set of bytes sent in an ESP
 	Encrypted []byte
+
+	// SA is the security association used to decrypt the packet, or to
+	// encrypt it with SerializeTo.  The following fields are only set when
+	// the packet was decrypted, and the payload of the layer is then the
+	// decrypted payload, without padding.
+	SA         *IPSecSA
+	IV         []byte
+	Padding    []byte
+	NextHeader IPProtocol
+	ICV        []byte
 }
 
 // LayerType returns LayerTypeIPSecESP.
 func (i *IPSecESP) LayerType() gopacket.LayerType { return LayerTypeIPSecESP }
 
-func decodeIPSecESP(data []byte, p gopacket.PacketBuilder) error {
-	i := &IPSecESP{
-		BaseLayer: BaseLayer{data, nil},
-		SPI:       binary.BigEndian.Uint32(data[:4]),
-		Seq:       binary.BigEndian.Uint32(data[4:8]),
-		Encrypted: data[8:],
+// NextLayerType returns the layer type of the decrypted payload, or
+// gopacket.LayerTypeZero if the packet wasn't decrypted.
+func (i *IPSecESP) NextLayerType() gopacket.LayerType {
+	if i.SA == nil {
+		return gopacket.LayerTypeZero
 	}
-	p.AddLayer(i)
+	return i.NextHeader.LayerType()
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+//
+// Without SA, the header is written, followed by Encrypted.  With an SA, the
+// payload is padded and encrypted, with NextHeader set to IPProtocolIPv4 or
+// IPProtocolIPv6 for tunnel mode, or the transport protocol for transport
+// mode.  IV is used if it has the right length, otherwise a random IV is
+// generated.
+func (i *IPSecESP) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	if i.SA != nil {
+		return i.serializeEncrypted(b)
+	}
+	bytes, err := b.PrependBytes(8 + len(i.Encrypted))
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(bytes, i.SPI)
+	binary.BigEndian.PutUint32(bytes[4:], i.Seq)
+	copy(bytes[8:], i.Encrypted)
 	return nil
 }
+
+func decodeIPSecESP(data []byte, p gopacket.PacketBuilder) error {
+	return DefaultIPSecSATable.Decode(data, p)
+}
@@ -7,9 +7,12 @@
 package layers
 
 import (
-	"github.com/google/gopacket"
+	"bytes"
+	"net"
 	"reflect"
 	"testing"
+
+	"github.com/google/gopacket"
 )
 
 // testPacketIPSecAHTransport is the packet:
@@ -152,3 +155,181 @@ func BenchmarkDecodePacketIPSecESP(b *testing.B) {
 		gopacket.NewPacket(testPacketIPSecESP, LinkTypeEthernet, gopacket.NoCopy)
 	}
 }
+
+// testIPSecESPPacket serializes an IPv4 packet sent to 192.0.2.2, whose ESP
+// payload is a UDP datagram, wrapped in an inner IPv4 packet in tunnel mode.
+func testIPSecESPPacket(t *testing.T, sa *IPSecSA, tunnel bool) []byte {
+	outer := &IPv4{Version: 4, TTL: 64, Protocol: IPProtocolESP, SrcIP: net.IP{192, 0, 2, 1}, DstIP: net.IP{192, 0, 2, 2}}
+	inner := &IPv4{Version: 4, TTL: 64, Protocol: IPProtocolUDP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	udp := &UDP{SrcPort: 40000, DstPort: 50000}
+	esp := &IPSecESP{SPI: sa.SPI, Seq: 3, SA: sa, NextHeader: IPProtocolUDP}
+	ls := []gopacket.SerializableLayer{outer, esp, udp, gopacket.Payload("tunnel payload")}
+	udp.SetNetworkLayerForChecksum(outer)
+	if tunnel {
+		esp.NextHeader = IPProtocolIPv4
+		udp.SetNetworkLayerForChecksum(inner)
+		ls = []gopacket.SerializableLayer{outer, esp, inner, udp, gopacket.Payload("tunnel payload")}
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ls...); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("tunnel payload")) {
+		t.Fatal("payload not encrypted")
+	}
+	return buf.Bytes()
+}
+
+func TestIPSecESPDecrypt(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		sa     *IPSecSA
+		tunnel bool
+		want   []gopacket.LayerType
+	}{
+		{
+			"AES-CBC HMAC-SHA1-96 tunnel",
+			&IPSecSA{SPI: 0x100, Dst: net.IP{192, 0, 2, 2}, Encryption: IPSecEncryptionAESCBC, EncryptionKey: bytes.Repeat([]byte{1}, 16),
+				Integrity: IPSecIntegrityHMACSHA196, IntegrityKey: bytes.Repeat([]byte{2}, 20)},
+			true,
+			[]gopacket.LayerType{LayerTypeIPv4, LayerTypeIPSecESP, LayerTypeIPv4, LayerTypeUDP, gopacket.LayerTypePayload},
+		},
+		{
+			"AES-CBC HMAC-SHA256-128 transport",
+			&IPSecSA{SPI: 0x101, Encryption: IPSecEncryptionAESCBC, EncryptionKey: bytes.Repeat([]byte{3}, 32),
+				Integrity: IPSecIntegrityHMACSHA256128, IntegrityKey: bytes.Repeat([]byte{4}, 32)},
+			false,
+			[]gopacket.LayerType{LayerTypeIPv4, LayerTypeIPSecESP, LayerTypeUDP, gopacket.LayerTypePayload},
+		},
+		{
+			"AES-GCM tunnel",
+			&IPSecSA{SPI: 0x102, Dst: net.IP{192, 0, 2, 2}, Encryption: IPSecEncryptionAESGCM, EncryptionKey: bytes.Repeat([]byte{5}, 20)},
+			true,
+			[]gopacket.LayerType{LayerTypeIPv4, LayerTypeIPSecESP, LayerTypeIPv4, LayerTypeUDP, gopacket.LayerTypePayload},
+		},
+	} {
+		data := testIPSecESPPacket(t, test.sa, test.tunnel)
+
+		// Without SA, the payload stays encrypted
+		p := gopacket.NewPacket(data, LayerTypeIPv4, gopacket.Default)
+		checkLayers(p, []gopacket.LayerType{LayerTypeIPv4, LayerTypeIPSecESP}, t)
+
+		DefaultIPSecSATable.Add(test.sa)
+		p = gopacket.NewPacket(data, LayerTypeIPv4, gopacket.Default)
+		if p.ErrorLayer() != nil {
+			t.Errorf("%s: %v", test.name, p.ErrorLayer().Error())
+		}
+		checkLayers(p, test.want, t)
+		esp := p.Layer(LayerTypeIPSecESP).(*IPSecESP)
+		if esp.SA != test.sa || esp.Seq != 3 || len(esp.ICV) != test.sa.icvLen() || len(esp.IV) != test.sa.ivLen() {
+			t.Errorf("%s: bad ESP layer %#v", test.name, esp)
+		}
+		if udp, ok := p.Layer(LayerTypeUDP).(*UDP); !ok || udp.DstPort != 50000 || string(udp.Payload) != "tunnel payload" {
+			t.Errorf("%s: bad UDP layer %v", test.name, p.Layer(LayerTypeUDP))
+		}
+
+		// Tampered packet
+		data[len(data)-1] ^= 1
+		p = gopacket.NewPacket(data, LayerTypeIPv4, gopacket.Default)
+		if p.ErrorLayer() == nil {
+			t.Errorf("%s: tampered packet decoded", test.name)
+		}
+		DefaultIPSecSATable.Remove(test.sa.SPI, test.sa.Dst)
+	}
+}
+
+// testIPSecESPVectors are ESP packets computed with OpenSSL, whose payload
+// is a UDP datagram from port 40000 to port 50000 without checksum, holding
+// "known answer".
+var testIPSecESPVectors = []struct {
+	name string
+	sa   *IPSecSA
+	data []byte
+}{
+	{
+		"AES-CBC HMAC-SHA1-96",
+		&IPSecSA{SPI: 0x200, Encryption: IPSecEncryptionAESCBC,
+			EncryptionKey: []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f},
+			Integrity:     IPSecIntegrityHMACSHA196,
+			IntegrityKey: []byte{0x20, 0x21, 0x22, 0x23, 0x24, 0x25, 0x26, 0x27, 0x28, 0x29, 0x2a, 0x2b, 0x2c, 0x2d, 0x2e, 0x2f,
+				0x30, 0x31, 0x32, 0x33}},
+		[]byte{
+			0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x05, 0xf0, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7,
+			0xf8, 0xf9, 0xfa, 0xfb, 0xfc, 0xfd, 0xfe, 0xff, 0x75, 0x50, 0x74, 0x7d, 0x2a, 0x5b, 0x74, 0x09,
+			0xe1, 0xa8, 0xe4, 0x19, 0x95, 0x36, 0x50, 0xb3, 0x30, 0x08, 0x63, 0x2e, 0x1b, 0x69, 0x57, 0x1f,
+			0xca, 0xad, 0xd2, 0x36, 0x1a, 0x77, 0x0e, 0xa7, 0x60, 0x26, 0x4a, 0xf0, 0x5d, 0x75, 0x08, 0x2a,
+			0xbb, 0xd6, 0xcb, 0xb0,
+		},
+	},
+	{
+		"AES-GCM",
+		&IPSecSA{SPI: 0x201, Encryption: IPSecEncryptionAESGCM,
+			EncryptionKey: []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+				0xca, 0xfe, 0xba, 0xbe}},
+		[]byte{
+			0x00, 0x00, 0x02, 0x01, 0x00, 0x00, 0x00, 0x05, 0xfa, 0xce, 0xdb, 0xad, 0xde, 0xca, 0xf8, 0x88,
+			0x15, 0x39, 0x04, 0xe6, 0x85, 0xe3, 0x81, 0x01, 0xc1, 0x7e, 0xa6, 0xff, 0x33, 0xda, 0xc1, 0x89,
+			0xf5, 0x7c, 0x5d, 0x56, 0x5a, 0xe9, 0xb2, 0xb4, 0x27, 0x21, 0xbe, 0x3b, 0x82, 0xa1, 0x7e, 0x97,
+			0xee, 0x9c, 0x25, 0x15, 0x16, 0x22, 0x4a, 0x10,
+		},
+	},
+}
+
+func TestIPSecESPVectors(t *testing.T) {
+	for _, test := range testIPSecESPVectors {
+		table := NewIPSecSATable()
+		table.Add(test.sa)
+		p := gopacket.NewPacket(test.data, table, gopacket.Default)
+		if p.ErrorLayer() != nil {
+			t.Errorf("%s: %v", test.name, p.ErrorLayer().Error())
+			continue
+		}
+		checkLayers(p, []gopacket.LayerType{LayerTypeIPSecESP, LayerTypeUDP, gopacket.LayerTypePayload}, t)
+		esp := p.Layer(LayerTypeIPSecESP).(*IPSecESP)
+		if esp.Seq != 5 || esp.NextHeader != IPProtocolUDP {
+			t.Errorf("%s: bad ESP layer %#v", test.name, esp)
+		}
+		udp := p.Layer(LayerTypeUDP).(*UDP)
+		if udp.SrcPort != 40000 || udp.DstPort != 50000 || string(udp.Payload) != "known answer" {
+			t.Errorf("%s: bad UDP layer %#v", test.name, udp)
+		}
+
+		// Encrypting with the same IV gives the same packet
+		buf := gopacket.NewSerializeBuffer()
+		out := &IPSecESP{SPI: esp.SPI, Seq: esp.Seq, SA: test.sa, IV: esp.IV, NextHeader: IPProtocolUDP}
+		err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true},
+			out, &UDP{SrcPort: 40000, DstPort: 50000}, gopacket.Payload("known answer"))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !bytes.Equal(buf.Bytes(), test.data) {
+			t.Errorf("%s: serialized\n%x\nwant\n%x", test.name, buf.Bytes(), test.data)
+		}
+	}
+}
+
+func TestIPSecSATable(t *testing.T) {
+	wild := &IPSecSA{SPI: 1}
+	dst := &IPSecSA{SPI: 1, Dst: net.IP{192, 0, 2, 2}}
+	table := NewIPSecSATable()
+	table.Add(wild)
+	table.Add(dst)
+	if sa := table.Lookup(1, net.ParseIP("192.0.2.2")); sa != dst {
+		t.Errorf("got SA %v for 192.0.2.2", sa)
+	}
+	if sa := table.Lookup(1, net.IP{192, 0, 2, 3}); sa != wild {
+		t.Errorf("got SA %v for 192.0.2.3", sa)
+	}
+	if sa := table.Lookup(2, net.IP{192, 0, 2, 2}); sa != nil {
+		t.Errorf("got SA %v for unknown SPI", sa)
+	}
+
+	// Decoding from the ESP header
+	sa := &IPSecSA{SPI: 7, Encryption: IPSecEncryptionAESGCM, EncryptionKey: bytes.Repeat([]byte{1}, 36)}
+	table.Add(sa)
+	data := testIPSecESPPacket(t, sa, false)[20:]
+	p := gopacket.NewPacket(data, table, gopacket.Default)
+	checkLayers(p, []gopacket.LayerType{LayerTypeIPSecESP, LayerTypeUDP, gopacket.LayerTypePayload}, t)
+	if esp := p.Layer(LayerTypeIPSecESP).(*IPSecESP); esp.NextLayerType() != LayerTypeUDP {
+		t.Errorf("got next layer type %v", esp.NextLayerType())
+	}
+}
@@ -0,0 +1,370 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"sync"
+
+	"github.com/google/gopacket"
+)
+
+// IPSecEncryption is the encryption algorithm of an ESP security
+// association.
+type IPSecEncryption uint8
+
+const (
+	// IPSecEncryptionAESCBC is AES in CBC mode (RFC 3602), which must be
+	// used with an integrity algorithm.
+	IPSecEncryptionAESCBC IPSecEncryption = 1
+	// IPSecEncryptionAESGCM is AES-GCM with a 16 bytes ICV (RFC 4106).
+	IPSecEncryptionAESGCM IPSecEncryption = 2
+)
+
+func (e IPSecEncryption) String() string {
+	switch e {
+	case IPSecEncryptionAESCBC:
+		return "AES-CBC"
+	case IPSecEncryptionAESGCM:
+		return "AES-GCM"
+	default:
+		return "Unknown"
+	}
+}
+
+// IPSecIntegrity is the integrity algorithm of an ESP security association.
+type IPSecIntegrity uint8
+
+// Integrity algorithms of ESP security associations.  IPSecIntegrityNone
+// is used with AES-GCM, whose ICV is computed by the encryption algorithm.
+const (
+	IPSecIntegrityNone          IPSecIntegrity = 0
+	IPSecIntegrityHMACSHA196    IPSecIntegrity = 1 // HMAC-SHA1-96 (RFC 2404)
+	IPSecIntegrityHMACSHA256128 IPSecIntegrity = 2 // HMAC-SHA256-128 (RFC 4868)
+)
+
+func (i IPSecIntegrity) String() string {
+	switch i {
+	case IPSecIntegrityNone:
+		return "None"
+	case IPSecIntegrityHMACSHA196:
+		return "HMAC-SHA1-96"
+	case IPSecIntegrityHMACSHA256128:
+		return "HMAC-SHA256-128"
+	default:
+		return "Unknown"
+	}
+}
+
+// IPSecSA is an ESP security association, holding the keys used to decrypt
+// and encrypt the packets of one direction of an IPsec connection.
+// Extended sequence numbers are not supported.
+type IPSecSA struct {
+	SPI uint32
+	// Dst is the destination address of the packets of the SA, or nil to
+	// match any destination.
+	Dst        net.IP
+	Encryption IPSecEncryption
+	// EncryptionKey is the AES key.  With AES-GCM, it is followed by the 4
+	// bytes salt, as in RFC 4106, section 8.1.
+	EncryptionKey []byte
+	Integrity     IPSecIntegrity
+	IntegrityKey  []byte
+}
+
+var errIPSecSAKey = errors.New("invalid IPSec SA key length")
+
+// ivLen returns the length of the IV sent in each packet.
+func (sa *IPSecSA) ivLen() int {
+	if sa.Encryption == IPSecEncryptionAESGCM {
+		return 8
+	}
+	return aes.BlockSize
+}
+
+// icvLen returns the length of the ICV ending each packet.
+func (sa *IPSecSA) icvLen() int {
+	switch {
+	case sa.Encryption == IPSecEncryptionAESGCM:
+		return 16
+	case sa.Integrity == IPSecIntegrityHMACSHA196:
+		return 12
+	case sa.Integrity == IPSecIntegrityHMACSHA256128:
+		return 16
+	}
+	return 0
+}
+
+// blockSize returns the alignment of the encrypted data.
+func (sa *IPSecSA) blockSize() int {
+	if sa.Encryption == IPSecEncryptionAESGCM {
+		return 4
+	}
+	return aes.BlockSize
+}
+
+func (sa *IPSecSA) mac() (hash.Hash, error) {
+	switch sa.Integrity {
+	case IPSecIntegrityHMACSHA196:
+		return hmac.New(sha1.New, sa.IntegrityKey), nil
+	case IPSecIntegrityHMACSHA256128:
+		return hmac.New(sha256.New, sa.IntegrityKey), nil
+	}
+	return nil, fmt.Errorf("IPSec integrity %v unsupported with %v", sa.Integrity, sa.Encryption)
+}
+
+func (sa *IPSecSA) gcm() (cipher.AEAD, []byte, error) {
+	n := len(sa.EncryptionKey) - 4
+	if n < 0 {
+		return nil, nil, errIPSecSAKey
+	}
+	block, err := aes.NewCipher(sa.EncryptionKey[:n])
+	if err != nil {
+		return nil, nil, errIPSecSAKey
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, sa.EncryptionKey[n:], err
+}
+
+// decrypt checks the ICV of an ESP packet and decrypts it, returning the IV
+// and the plaintext.
+func (sa *IPSecSA) decrypt(data []byte) (iv, plain []byte, err error) {
+	ivLen, icvLen := sa.ivLen(), sa.icvLen()
+	if len(data) < 8+ivLen+sa.blockSize()+icvLen {
+		return nil, nil, errors.New("IPSec ESP packet too short")
+	}
+	iv = data[8 : 8+ivLen]
+	ciphertext := data[8+ivLen:]
+
+	switch sa.Encryption {
+	case IPSecEncryptionAESGCM:
+		aead, salt, err := sa.gcm()
+		if err != nil {
+			return nil, nil, err
+		}
+		nonce := append(append(make([]byte, 0, 12), salt...), iv...)
+		plain, err = aead.Open(nil, nonce, ciphertext, data[:8])
+		if err != nil {
+			return nil, nil, errors.New("IPSec ESP integrity check failed")
+		}
+	case IPSecEncryptionAESCBC:
+		mac, err := sa.mac()
+		if err != nil {
+			return nil, nil, err
+		}
+		n := len(data) - icvLen
+		mac.Write(data[:n])
+		if !hmac.Equal(mac.Sum(nil)[:icvLen], data[n:]) {
+			return nil, nil, errors.New("IPSec ESP integrity check failed")
+		}
+		ciphertext = ciphertext[:len(ciphertext)-icvLen]
+		if len(ciphertext)%aes.BlockSize != 0 {
+			return nil, nil, errors.New("IPSec ESP data not aligned on the AES block size")
+		}
+		block, err := aes.NewCipher(sa.EncryptionKey)
+		if err != nil {
+			return nil, nil, errIPSecSAKey
+		}
+		plain = make([]byte, len(ciphertext))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, ciphertext)
+	default:
+		return nil, nil, fmt.Errorf("IPSec encryption %v unsupported", sa.Encryption)
+	}
+	return iv, plain, nil
+}
+
+// encrypt encrypts plain, already padded, and returns the IV, the
+// ciphertext and the ICV.  header is the SPI and sequence number.
+func (sa *IPSecSA) encrypt(header, iv, plain []byte) ([]byte, error) {
+	out := append(append([]byte(nil), header...), iv...)
+	switch sa.Encryption {
+	case IPSecEncryptionAESGCM:
+		aead, salt, err := sa.gcm()
+		if err != nil {
+			return nil, err
+		}
+		nonce := append(append(make([]byte, 0, 12), salt...), iv...)
+		out = aead.Seal(out, nonce, plain, header)
+	case IPSecEncryptionAESCBC:
+		mac, err := sa.mac()
+		if err != nil {
+			return nil, err
+		}
+		block, err := aes.NewCipher(sa.EncryptionKey)
+		if err != nil {
+			return nil, errIPSecSAKey
+		}
+		n := len(out)
+		out = append(out, plain...)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(out[n:], plain)
+		mac.Write(out)
+		out = append(out, mac.Sum(nil)[:sa.icvLen()]...)
+	default:
+		return nil, fmt.Errorf("IPSec encryption %v unsupported", sa.Encryption)
+	}
+	return out[len(header):], nil
+}
+
+type ipsecSAKey struct {
+	spi uint32
+	dst string
+}
+
+// IPSecSATable holds ESP security associations, indexed by SPI and
+// destination address.  It is a gopacket.Decoder for ESP packets, which
+// decrypts the packets of its SAs and passes their payload to the decoder of
+// their next header, like IPv4 or IPv6 in tunnel mode and TCP or UDP in
+// transport mode.  The destination address is taken from the network layer
+// already decoded, if any.
+//
+// ESP packets are decoded with DefaultIPSecSATable, so adding SAs to it is
+// enough to decrypt the ESP packets decoded by gopacket.NewPacket.
+type IPSecSATable struct {
+	mu  sync.RWMutex
+	sas map[ipsecSAKey]*IPSecSA
+}
+
+// DefaultIPSecSATable is the SA table used to decode ESP packets.  It is
+// empty unless SAs are added to it, and the payload of ESP packets is then
+// left encrypted.
+var DefaultIPSecSATable = NewIPSecSATable()
+
+// NewIPSecSATable returns an empty IPSecSATable.
+func NewIPSecSATable() *IPSecSATable {
+	return &IPSecSATable{sas: make(map[ipsecSAKey]*IPSecSA)}
+}
+
+func newIPSecSAKey(spi uint32, dst net.IP) ipsecSAKey {
+	k := ipsecSAKey{spi: spi}
+	if dst != nil {
+		k.dst = string(dst.To16())
+	}
+	return k
+}
+
+// Add adds an SA to the table, replacing any SA with the same SPI and
+// destination.
+func (t *IPSecSATable) Add(sa *IPSecSA) {
+	t.mu.Lock()
+	t.sas[newIPSecSAKey(sa.SPI, sa.Dst)] = sa
+	t.mu.Unlock()
+}
+
+// Remove removes the SA with the given SPI and destination.
+func (t *IPSecSATable) Remove(spi uint32, dst net.IP) {
+	t.mu.Lock()
+	delete(t.sas, newIPSecSAKey(spi, dst))
+	t.mu.Unlock()
+}
+
+// Lookup returns the SA of the packets with the given SPI and destination,
+// falling back to an SA without destination.  It returns nil if no SA
+// matches.
+func (t *IPSecSATable) Lookup(spi uint32, dst net.IP) *IPSecSA {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if sa := t.sas[newIPSecSAKey(spi, dst)]; sa != nil || dst == nil {
+		return sa
+	}
+	return t.sas[newIPSecSAKey(spi, nil)]
+}
+
+// Decode decodes an ESP packet, decrypting it if the table holds its SA.
+func (t *IPSecSATable) Decode(data []byte, p gopacket.PacketBuilder) error {
+	if len(data) < 8 {
+		p.SetTruncated()
+		return errors.New("IPSec ESP packet less than 8 bytes")
+	}
+	i := &IPSecESP{
+		BaseLayer: BaseLayer{Contents: data},
+		SPI:       binary.BigEndian.Uint32(data[:4]),
+		Seq:       binary.BigEndian.Uint32(data[4:8]),
+		Encrypted: data[8:],
+	}
+
+	var dst net.IP
+	if n, ok := p.(interface {
+		NetworkLayer() gopacket.NetworkLayer
+	}); ok && n.NetworkLayer() != nil {
+		dst = net.IP(n.NetworkLayer().NetworkFlow().Dst().Raw())
+	}
+	sa := t.Lookup(i.SPI, dst)
+	if sa == nil {
+		p.AddLayer(i)
+		return nil
+	}
+
+	iv, plain, err := sa.decrypt(data)
+	if err != nil {
+		return err
+	}
+	if len(plain) < 2 || int(plain[len(plain)-2])+2 > len(plain) {
+		return errors.New("IPSec ESP invalid padding length")
+	}
+	padLen := int(plain[len(plain)-2])
+	n := len(plain) - 2 - padLen
+	i.SA = sa
+	i.IV = iv
+	i.NextHeader = IPProtocol(plain[len(plain)-1])
+	i.Padding = plain[n : len(plain)-2]
+	i.ICV = data[len(data)-sa.icvLen():]
+	i.Contents = data[:8+len(iv)]
+	i.Payload = plain[:n]
+	p.AddLayer(i)
+	return p.NextDecoder(i.NextHeader)
+}
+
+// serializeEncrypted is the SerializeTo of an ESP layer with an SA: the
+// payload already in b is padded and encrypted.
+func (i *IPSecESP) serializeEncrypted(b gopacket.SerializeBuffer) error {
+	sa := i.SA
+	iv := i.IV
+	if len(iv) != sa.ivLen() {
+		iv = make([]byte, sa.ivLen())
+		if _, err := rand.Read(iv); err != nil {
+			return err
+		}
+	}
+
+	// Padding with the default contents 1, 2, 3...  (RFC 4303, section 2.4)
+	plain := append([]byte(nil), b.Bytes()...)
+	padLen := (sa.blockSize() - (len(plain)+2)%sa.blockSize()) % sa.blockSize()
+	for j := 1; j <= padLen; j++ {
+		plain = append(plain, byte(j))
+	}
+	plain = append(plain, byte(padLen), byte(i.NextHeader))
+
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], i.SPI)
+	binary.BigEndian.PutUint32(header[4:], i.Seq)
+	out, err := sa.encrypt(header[:], iv, plain)
+	if err != nil {
+		return err
+	}
+
+	// The payload is overwritten by the encrypted data
+	if _, err := b.AppendBytes(len(out) - len(b.Bytes())); err != nil {
+		return err
+	}
+	if _, err := b.PrependBytes(8); err != nil {
+		return err
+	}
+	bytes := b.Bytes()
+	copy(bytes, header[:])
+	copy(bytes[8:], out)
+	return nil
+}
@@ -151,6 +151,15 @@ var (
 	LayerTypeAGUEVar0                     = gopacket.RegisterLayerType(147, gopacket.LayerTypeMetadata{Name: "AGUEVar0", Decoder: gopacket.DecodeFunc(decodeAGUE)})
 	LayerTypeAGUEVar1                     = gopacket.RegisterLayerType(148, gopacket.LayerTypeMetadata{Name: "AGUEVar1", Decoder: gopacket.DecodeFunc(decodeAGUE)})
 	LayerTypeAPSP                         = gopacket.RegisterLayerType(149, gopacket.LayerTypeMetadata{Name: "APSP", Decoder: gopacket.DecodeFunc(decodeAPSP)})
+	LayerTypeHTTP                         = gopacket.RegisterLayerType(150, gopacket.LayerTypeMetadata{Name: "HTTP", Decoder: gopacket.DecodeFunc(decodeHTTP)})
+	LayerTypeDNSTCP                       = gopacket.RegisterLayerType(151, gopacket.LayerTypeMetadata{Name: "DNSTCP", Decoder: gopacket.DecodeFunc(decodeDNSTCP)})
+	LayerTypeSDP                          = gopacket.RegisterLayerType(152, gopacket.LayerTypeMetadata{Name: "SDP", Decoder: gopacket.DecodeFunc(decodeSDP)})
+	LayerTypeRTP                          = gopacket.RegisterLayerType(153, gopacket.LayerTypeMetadata{Name: "RTP", Decoder: gopacket.DecodeFunc(decodeRTP)})
+	LayerTypeRTCP                         = gopacket.RegisterLayerType(154, gopacket.LayerTypeMetadata{Name: "RTCP", Decoder: gopacket.DecodeFunc(decodeRTCP)})
+	LayerTypeModbus                       = gopacket.RegisterLayerType(155, gopacket.LayerTypeMetadata{Name: "Modbus", Decoder: gopacket.DecodeFunc(decodeModbus)})
+	LayerTypeDNP3                         = gopacket.RegisterLayerType(156, gopacket.LayerTypeMetadata{Name: "DNP3", Decoder: gopacket.DecodeFunc(decodeDNP3)})
+	LayerTypeIEC104                       = gopacket.RegisterLayerType(157, gopacket.LayerTypeMetadata{Name: "IEC104", Decoder: gopacket.DecodeFunc(decodeIEC104)})
+	LayerTypeLinuxSLL2                    = gopacket.RegisterLayerType(158, gopacket.LayerTypeMetadata{Name: "Linux SLL2", Decoder: gopacket.DecodeFunc(decodeLinuxSLL2)})
 )
 
 var (
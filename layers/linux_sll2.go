@@ -0,0 +1,121 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/google/gopacket"
+)
+
+// LinuxSLL2 is the header of the Linux cooked capture v2 link type,
+// LinkTypeLinuxSLL2, written by "tcpdump -i any" in recent versions. It
+// differs from LinuxSLL by the interface index it adds and by the layout of
+// its fields.
+//
+//  0                   1                   2                   3
+//  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |         Protocol type         |        Reserved (MBZ)         |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                        Interface index                        |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |         ARPHRD_ type          |  Packet type  | Address length|
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                     Link-layer address                        |
+// |                        (8 bytes)                              |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+type LinuxSLL2 struct {
+	BaseLayer
+	ProtocolType   EthernetType
+	InterfaceIndex uint32
+	// AddrType is the ARPHRD_ type of the interface, like 1 for Ethernet.
+	AddrType   uint16
+	PacketType LinuxSLLPacketType
+	AddrLen    uint8
+	Addr       net.HardwareAddr
+}
+
+// LayerType returns LayerTypeLinuxSLL2.
+func (sll *LinuxSLL2) LayerType() gopacket.LayerType { return LayerTypeLinuxSLL2 }
+
+// CanDecode returns the set of layer types that this DecodingLayer can decode.
+func (sll *LinuxSLL2) CanDecode() gopacket.LayerClass {
+	return LayerTypeLinuxSLL2
+}
+
+// LinkFlow returns a flow with the link-layer address as its source.
+func (sll *LinuxSLL2) LinkFlow() gopacket.Flow {
+	return gopacket.NewFlow(EndpointMAC, sll.Addr, nil)
+}
+
+// NextLayerType returns the layer type of the protocol type.
+func (sll *LinuxSLL2) NextLayerType() gopacket.LayerType {
+	return sll.ProtocolType.LayerType()
+}
+
+// DecodeFromBytes decodes the given bytes into this layer.
+func (sll *LinuxSLL2) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 20 {
+		df.SetTruncated()
+		return errors.New("Linux SLL2 packet too small")
+	}
+	sll.ProtocolType = EthernetType(binary.BigEndian.Uint16(data[0:2]))
+	sll.InterfaceIndex = binary.BigEndian.Uint32(data[4:8])
+	sll.AddrType = binary.BigEndian.Uint16(data[8:10])
+	sll.PacketType = LinuxSLLPacketType(data[10])
+	sll.AddrLen = data[11]
+	// Longer addresses are truncated to the 8 bytes of the header.
+	addrLen := int(sll.AddrLen)
+	if addrLen > 8 {
+		addrLen = 8
+	}
+	sll.Addr = net.HardwareAddr(data[12 : 12+addrLen])
+	sll.BaseLayer = BaseLayer{data[:20], data[20:]}
+	return nil
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+// With FixLengths, AddrLen is set from Addr.
+func (sll *LinuxSLL2) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	if len(sll.Addr) > 8 {
+		return fmt.Errorf("Linux SLL2 address too long: %d bytes", len(sll.Addr))
+	}
+	if sll.PacketType > 0xff {
+		return fmt.Errorf("invalid Linux SLL2 packet type %d", sll.PacketType)
+	}
+	bytes, err := b.PrependBytes(20)
+	if err != nil {
+		return err
+	}
+	if opts.FixLengths {
+		sll.AddrLen = uint8(len(sll.Addr))
+	}
+	binary.BigEndian.PutUint16(bytes[0:], uint16(sll.ProtocolType))
+	bytes[2], bytes[3] = 0, 0
+	binary.BigEndian.PutUint32(bytes[4:], sll.InterfaceIndex)
+	binary.BigEndian.PutUint16(bytes[8:], sll.AddrType)
+	bytes[10] = uint8(sll.PacketType)
+	bytes[11] = sll.AddrLen
+	copy(bytes[12:20], make([]byte, 8))
+	copy(bytes[12:20], sll.Addr)
+	return nil
+}
+
+func decodeLinuxSLL2(data []byte, p gopacket.PacketBuilder) error {
+	sll := &LinuxSLL2{}
+	if err := sll.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(sll)
+	p.SetLinkLayer(sll)
+	return p.NextDecoder(sll.ProtocolType)
+}
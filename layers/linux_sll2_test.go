@@ -0,0 +1,72 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+// testPacketLinuxSLL2 is an outgoing ICMPv4 echo request captured with
+// "tcpdump -i any" on interface 2, an Ethernet interface.
+var testPacketLinuxSLL2 = []byte{
+	0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02,
+	0x00, 0x01, 0x04, 0x06, 0x00, 0x0c, 0x29, 0x3a,
+	0x8c, 0x5e, 0x00, 0x00,
+	0x45, 0x00, 0x00, 0x1c, 0x3c, 0x4e, 0x40, 0x00,
+	0x40, 0x01, 0x80, 0x76, 0xc0, 0xa8, 0x01, 0x02,
+	0xc0, 0xa8, 0x01, 0x01,
+	0x08, 0x00, 0xf7, 0xfe, 0x00, 0x01, 0x00, 0x00,
+}
+
+func TestPacketLinuxSLL2(t *testing.T) {
+	p := gopacket.NewPacket(testPacketLinuxSLL2, LinkTypeLinuxSLL2, gopacket.Default)
+	if p.ErrorLayer() != nil {
+		t.Error("Failed to decode packet:", p.ErrorLayer().Error())
+	}
+	checkLayers(p, []gopacket.LayerType{LayerTypeLinuxSLL2, LayerTypeIPv4, LayerTypeICMPv4}, t)
+
+	sll, ok := p.LinkLayer().(*LinuxSLL2)
+	if !ok {
+		t.Fatalf("link layer is %T, want *LinuxSLL2", p.LinkLayer())
+	}
+	want := &LinuxSLL2{
+		BaseLayer:      BaseLayer{Contents: testPacketLinuxSLL2[:20], Payload: testPacketLinuxSLL2[20:]},
+		ProtocolType:   EthernetTypeIPv4,
+		InterfaceIndex: 2,
+		AddrType:       1,
+		PacketType:     LinuxSLLPacketTypeOutgoing,
+		AddrLen:        6,
+		Addr:           net.HardwareAddr{0x00, 0x0c, 0x29, 0x3a, 0x8c, 0x5e},
+	}
+	if !reflect.DeepEqual(sll, want) {
+		t.Errorf("decoded\n%+v\nwant\n%+v", sll, want)
+	}
+	if got := LinkTypeLinuxSLL2.String(); got != "Linux SLL2" {
+		t.Errorf("LinkTypeLinuxSLL2 is named %q", got)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	sll.AddrLen = 0
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, sll, gopacket.Payload(sll.Payload)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), testPacketLinuxSLL2) {
+		t.Errorf("serialized\n%x\nwant\n%x", buf.Bytes(), testPacketLinuxSLL2)
+	}
+}
+
+func TestLinuxSLL2Truncated(t *testing.T) {
+	var sll LinuxSLL2
+	if err := sll.DecodeFromBytes(testPacketLinuxSLL2[:19], gopacket.NilDecodeFeedback); err == nil {
+		t.Error("decoded a truncated header without error")
+	}
+}
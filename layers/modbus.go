@@ -0,0 +1,530 @@
+// Copyright 2018, The GoPacket Authors, All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+//
+//******************************************************************************
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+//******************************************************************************
+//
+// Modbus PDU Decoding Layer
+// ------------------------------------------
+// This file provides a GoPacket decoding layer for the Modbus Protocol Data
+// Unit (PDU) carried by ModbusTCP, as defined in the MODBUS Application
+// Protocol Specification V1.1b3.
+//
+//******************************************************************************
+
+// ModbusFunctionCode is the function code of a Modbus PDU, without the
+// exception bit.
+type ModbusFunctionCode uint8
+
+// ModbusFunctionCode known values.
+const (
+	ModbusReadCoils                  ModbusFunctionCode = 1
+	ModbusReadDiscreteInputs         ModbusFunctionCode = 2
+	ModbusReadHoldingRegisters       ModbusFunctionCode = 3
+	ModbusReadInputRegisters         ModbusFunctionCode = 4
+	ModbusWriteSingleCoil            ModbusFunctionCode = 5
+	ModbusWriteSingleRegister        ModbusFunctionCode = 6
+	ModbusReadExceptionStatus        ModbusFunctionCode = 7
+	ModbusDiagnostics                ModbusFunctionCode = 8
+	ModbusWriteMultipleCoils         ModbusFunctionCode = 15
+	ModbusWriteMultipleRegisters     ModbusFunctionCode = 16
+	ModbusReportServerID             ModbusFunctionCode = 17
+	ModbusMaskWriteRegister          ModbusFunctionCode = 22
+	ModbusReadWriteMultipleRegisters ModbusFunctionCode = 23
+	ModbusReadFIFOQueue              ModbusFunctionCode = 24
+	ModbusEncapsulatedInterface      ModbusFunctionCode = 43
+)
+
+func (fc ModbusFunctionCode) String() string {
+	switch fc {
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(fc))
+	case ModbusReadCoils:
+		return "Read Coils"
+	case ModbusReadDiscreteInputs:
+		return "Read Discrete Inputs"
+	case ModbusReadHoldingRegisters:
+		return "Read Holding Registers"
+	case ModbusReadInputRegisters:
+		return "Read Input Registers"
+	case ModbusWriteSingleCoil:
+		return "Write Single Coil"
+	case ModbusWriteSingleRegister:
+		return "Write Single Register"
+	case ModbusReadExceptionStatus:
+		return "Read Exception Status"
+	case ModbusDiagnostics:
+		return "Diagnostics"
+	case ModbusWriteMultipleCoils:
+		return "Write Multiple Coils"
+	case ModbusWriteMultipleRegisters:
+		return "Write Multiple Registers"
+	case ModbusReportServerID:
+		return "Report Server ID"
+	case ModbusMaskWriteRegister:
+		return "Mask Write Register"
+	case ModbusReadWriteMultipleRegisters:
+		return "Read/Write Multiple Registers"
+	case ModbusReadFIFOQueue:
+		return "Read FIFO Queue"
+	case ModbusEncapsulatedInterface:
+		return "Encapsulated Interface Transport"
+	}
+}
+
+// ModbusExceptionCode is the exception code of a Modbus exception response.
+type ModbusExceptionCode uint8
+
+// ModbusExceptionCode known values.
+const (
+	ModbusIllegalFunction                    ModbusExceptionCode = 1
+	ModbusIllegalDataAddress                 ModbusExceptionCode = 2
+	ModbusIllegalDataValue                   ModbusExceptionCode = 3
+	ModbusServerDeviceFailure                ModbusExceptionCode = 4
+	ModbusAcknowledge                        ModbusExceptionCode = 5
+	ModbusServerDeviceBusy                   ModbusExceptionCode = 6
+	ModbusMemoryParityError                  ModbusExceptionCode = 8
+	ModbusGatewayPathUnavailable             ModbusExceptionCode = 10
+	ModbusGatewayTargetDeviceFailedToRespond ModbusExceptionCode = 11
+)
+
+func (ec ModbusExceptionCode) String() string {
+	switch ec {
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(ec))
+	case ModbusIllegalFunction:
+		return "Illegal Function"
+	case ModbusIllegalDataAddress:
+		return "Illegal Data Address"
+	case ModbusIllegalDataValue:
+		return "Illegal Data Value"
+	case ModbusServerDeviceFailure:
+		return "Server Device Failure"
+	case ModbusAcknowledge:
+		return "Acknowledge"
+	case ModbusServerDeviceBusy:
+		return "Server Device Busy"
+	case ModbusMemoryParityError:
+		return "Memory Parity Error"
+	case ModbusGatewayPathUnavailable:
+		return "Gateway Path Unavailable"
+	case ModbusGatewayTargetDeviceFailedToRespond:
+		return "Gateway Target Device Failed to Respond"
+	}
+}
+
+//******************************************************************************
+
+// Modbus Type
+// --------
+// Type Modbus implements the DecodingLayer interface. Each Modbus object
+// represents in a structured form a Modbus PDU, the function code and data
+// carried by a ModbusTCP record.
+//
+// Requests and responses of the same function code have different
+// formats. When decoding packets, responses are told from requests by
+// their TCP port, 502 being the server port, and by their length
+// otherwise. Which fields are used depends on FunctionCode and IsResponse:
+//
+//	Read Coils, Read Discrete Inputs: Address and Quantity in requests,
+//	    Coils in responses (a multiple of 8, see ModbusTransactions).
+//	Read Holding/Input Registers: Address and Quantity in requests,
+//	    Registers in responses.
+//	Write Single Coil/Register: Address and Value.
+//	Write Multiple Coils/Registers: Address and Quantity, and the
+//	    Coils or Registers written in requests.
+//	Mask Write Register: Address, AndMask and OrMask.
+//	Read/Write Multiple Registers: Address, Quantity, WriteAddress,
+//	    WriteQuantity and the Registers written in requests, the
+//	    Registers read in responses.
+//	Exception responses: ExceptionCode.
+//	Other function codes: Data.
+type Modbus struct {
+	BaseLayer
+
+	FunctionCode  ModbusFunctionCode
+	IsResponse    bool
+	Exception     bool
+	ExceptionCode ModbusExceptionCode
+
+	Address       uint16
+	Quantity      uint16
+	WriteAddress  uint16
+	WriteQuantity uint16
+	Value         uint16
+	AndMask       uint16
+	OrMask        uint16
+	Coils         []bool
+	Registers     []uint16
+	Data          []byte
+}
+
+// ModbusCoilOn is the Value of a Write Single Coil request that turns the
+// coil on; 0 turns it off.
+const ModbusCoilOn uint16 = 0xff00
+
+// LayerType returns the layer type of the Modbus object, which is LayerTypeModbus.
+func (m *Modbus) LayerType() gopacket.LayerType {
+	return LayerTypeModbus
+}
+
+// CanDecode returns the set of layer types that this DecodingLayer can decode
+func (m *Modbus) CanDecode() gopacket.LayerClass {
+	return LayerTypeModbus
+}
+
+// NextLayerType returns the layer type of the Modbus payload, which is LayerTypeZero.
+func (m *Modbus) NextLayerType() gopacket.LayerType {
+	return gopacket.LayerTypeZero
+}
+
+// decodeModbus decodes a Modbus PDU, using the TCP ports of the packet, if
+// it has a TCP layer, to tell responses from requests.
+func decodeModbus(data []byte, p gopacket.PacketBuilder) error {
+	m := &Modbus{}
+	response := modbusLooksLikeResponse(data)
+	if t, ok := p.(interface {
+		TransportLayer() gopacket.TransportLayer
+	}); ok {
+		if tcp, ok := t.TransportLayer().(*TCP); ok && (tcp.SrcPort == 502) != (tcp.DstPort == 502) {
+			response = tcp.SrcPort == 502
+		}
+	}
+	if err := m.decode(data, response, p); err != nil {
+		return err
+	}
+	p.AddLayer(m)
+	return nil
+}
+
+// DecodeFromBytes analyses a byte slice and attempts to decode it as a
+// Modbus PDU. Without the TCP layer, responses are told from requests by
+// their length, which is ambiguous for some read requests; use
+// DecodeFromBytesAs when the direction is known.
+func (m *Modbus) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	return m.decode(data, modbusLooksLikeResponse(data), df)
+}
+
+// DecodeFromBytesAs decodes the byte slice as a Modbus request, or as a
+// response if response is set.
+func (m *Modbus) DecodeFromBytesAs(data []byte, response bool, df gopacket.DecodeFeedback) error {
+	return m.decode(data, response, df)
+}
+
+// modbusLooksLikeResponse guesses whether a PDU is a response from its
+// function code and length.
+func modbusLooksLikeResponse(data []byte) bool {
+	if len(data) < 2 {
+		return false
+	}
+	if data[0]&0x80 != 0 {
+		return true
+	}
+	switch ModbusFunctionCode(data[0]) {
+	case ModbusReadCoils, ModbusReadDiscreteInputs:
+		// Responses start with a byte count of the rest of the PDU,
+		// which requests can only match by chance.
+		return int(data[1]) == len(data)-2
+	case ModbusReadHoldingRegisters, ModbusReadInputRegisters, ModbusReadWriteMultipleRegisters:
+		return int(data[1]) == len(data)-2 && data[1]%2 == 0
+	case ModbusWriteMultipleCoils, ModbusWriteMultipleRegisters:
+		return len(data) == 5
+	}
+	return false
+}
+
+var errModbusPDULength = errors.New("Modbus PDU with wrong length")
+
+func (m *Modbus) decode(data []byte, response bool, df gopacket.DecodeFeedback) error {
+	if len(data) < modbusPDUMinimumRecordSizeInBytes {
+		df.SetTruncated()
+		return errors.New("Modbus PDU too short")
+	}
+	*m = Modbus{
+		BaseLayer:    BaseLayer{Contents: data},
+		FunctionCode: ModbusFunctionCode(data[0] & 0x7f),
+		IsResponse:   response,
+	}
+	body := data[1:]
+	if data[0]&0x80 != 0 {
+		if len(body) != 1 {
+			return errModbusPDULength
+		}
+		m.IsResponse = true
+		m.Exception = true
+		m.ExceptionCode = ModbusExceptionCode(body[0])
+		return nil
+	}
+
+	fields := func(n int, dst ...*uint16) error {
+		if len(body) < 2*len(dst) || (n >= 0 && len(body) != n) {
+			return errModbusPDULength
+		}
+		for i, d := range dst {
+			*d = binary.BigEndian.Uint16(body[2*i:])
+		}
+		body = body[2*len(dst):]
+		return nil
+	}
+	// byteCount checks the byte count that starts body and returns the
+	// bytes it covers.
+	byteCount := func(unit int) ([]byte, error) {
+		if len(body) < 1 || int(body[0]) != len(body)-1 || (len(body)-1)%unit != 0 {
+			return nil, errModbusPDULength
+		}
+		return body[1:], nil
+	}
+
+	var err error
+	var values []byte
+	switch {
+	case m.FunctionCode >= ModbusReadCoils && m.FunctionCode <= ModbusReadInputRegisters && !response:
+		err = fields(4, &m.Address, &m.Quantity)
+	case (m.FunctionCode == ModbusReadCoils || m.FunctionCode == ModbusReadDiscreteInputs) && response:
+		if values, err = byteCount(1); err == nil {
+			m.Coils = modbusUnpackCoils(values, 8*len(values))
+		}
+	case (m.FunctionCode == ModbusReadHoldingRegisters || m.FunctionCode == ModbusReadInputRegisters ||
+		m.FunctionCode == ModbusReadWriteMultipleRegisters) && response:
+		if values, err = byteCount(2); err == nil {
+			m.Registers = modbusUnpackRegisters(values)
+		}
+	case m.FunctionCode == ModbusWriteSingleCoil, m.FunctionCode == ModbusWriteSingleRegister:
+		err = fields(4, &m.Address, &m.Value)
+	case (m.FunctionCode == ModbusWriteMultipleCoils || m.FunctionCode == ModbusWriteMultipleRegisters) && response:
+		err = fields(4, &m.Address, &m.Quantity)
+	case m.FunctionCode == ModbusWriteMultipleCoils:
+		if err = fields(-1, &m.Address, &m.Quantity); err == nil {
+			if values, err = byteCount(1); err == nil {
+				if len(values) != (int(m.Quantity)+7)/8 {
+					return errModbusPDULength
+				}
+				m.Coils = modbusUnpackCoils(values, int(m.Quantity))
+			}
+		}
+	case m.FunctionCode == ModbusWriteMultipleRegisters:
+		if err = fields(-1, &m.Address, &m.Quantity); err == nil {
+			if values, err = byteCount(2); err == nil {
+				if len(values) != 2*int(m.Quantity) {
+					return errModbusPDULength
+				}
+				m.Registers = modbusUnpackRegisters(values)
+			}
+		}
+	case m.FunctionCode == ModbusMaskWriteRegister:
+		err = fields(6, &m.Address, &m.AndMask, &m.OrMask)
+	case m.FunctionCode == ModbusReadWriteMultipleRegisters:
+		if err = fields(-1, &m.Address, &m.Quantity, &m.WriteAddress, &m.WriteQuantity); err == nil {
+			if values, err = byteCount(2); err == nil {
+				if len(values) != 2*int(m.WriteQuantity) {
+					return errModbusPDULength
+				}
+				m.Registers = modbusUnpackRegisters(values)
+			}
+		}
+	default:
+		m.Data = body
+	}
+	return err
+}
+
+func modbusUnpackCoils(data []byte, n int) []bool {
+	coils := make([]bool, n)
+	for i := range coils {
+		coils[i] = data[i/8]&(1<<uint(i%8)) != 0
+	}
+	return coils
+}
+
+func modbusUnpackRegisters(data []byte) []uint16 {
+	registers := make([]uint16, len(data)/2)
+	for i := range registers {
+		registers[i] = binary.BigEndian.Uint16(data[2*i:])
+	}
+	return registers
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+// With FixLengths, the Quantity of write multiple requests and the
+// WriteQuantity of read/write multiple requests are set from Coils or
+// Registers.
+func (m *Modbus) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	pdu := []byte{byte(m.FunctionCode)}
+	if m.Exception {
+		pdu[0] |= 0x80
+		pdu = append(pdu, byte(m.ExceptionCode))
+		return modbusPrependPDU(b, pdu)
+	}
+
+	put := func(values ...uint16) {
+		for _, v := range values {
+			pdu = append(pdu, byte(v>>8), byte(v))
+		}
+	}
+	coils := func() {
+		packed := make([]byte, (len(m.Coils)+7)/8)
+		for i, c := range m.Coils {
+			if c {
+				packed[i/8] |= 1 << uint(i%8)
+			}
+		}
+		pdu = append(pdu, byte(len(packed)))
+		pdu = append(pdu, packed...)
+	}
+	registers := func() {
+		pdu = append(pdu, byte(2*len(m.Registers)))
+		put(m.Registers...)
+	}
+
+	switch {
+	case m.FunctionCode >= ModbusReadCoils && m.FunctionCode <= ModbusReadInputRegisters && !m.IsResponse:
+		put(m.Address, m.Quantity)
+	case (m.FunctionCode == ModbusReadCoils || m.FunctionCode == ModbusReadDiscreteInputs) && m.IsResponse:
+		coils()
+	case (m.FunctionCode == ModbusReadHoldingRegisters || m.FunctionCode == ModbusReadInputRegisters ||
+		m.FunctionCode == ModbusReadWriteMultipleRegisters) && m.IsResponse:
+		registers()
+	case m.FunctionCode == ModbusWriteSingleCoil, m.FunctionCode == ModbusWriteSingleRegister:
+		put(m.Address, m.Value)
+	case (m.FunctionCode == ModbusWriteMultipleCoils || m.FunctionCode == ModbusWriteMultipleRegisters) && m.IsResponse:
+		put(m.Address, m.Quantity)
+	case m.FunctionCode == ModbusWriteMultipleCoils:
+		if opts.FixLengths {
+			m.Quantity = uint16(len(m.Coils))
+		}
+		put(m.Address, m.Quantity)
+		coils()
+	case m.FunctionCode == ModbusWriteMultipleRegisters:
+		if opts.FixLengths {
+			m.Quantity = uint16(len(m.Registers))
+		}
+		put(m.Address, m.Quantity)
+		registers()
+	case m.FunctionCode == ModbusMaskWriteRegister:
+		put(m.Address, m.AndMask, m.OrMask)
+	case m.FunctionCode == ModbusReadWriteMultipleRegisters:
+		if opts.FixLengths {
+			m.WriteQuantity = uint16(len(m.Registers))
+		}
+		put(m.Address, m.Quantity, m.WriteAddress, m.WriteQuantity)
+		registers()
+	default:
+		pdu = append(pdu, m.Data...)
+	}
+	return modbusPrependPDU(b, pdu)
+}
+
+func modbusPrependPDU(b gopacket.SerializeBuffer, pdu []byte) error {
+	if len(pdu) > modbusPDUMaximumRecordSizeInBytes {
+		return fmt.Errorf("Modbus PDU too long: %d bytes", len(pdu))
+	}
+	bytes, err := b.PrependBytes(len(pdu))
+	if err != nil {
+		return err
+	}
+	copy(bytes, pdu)
+	return nil
+}
+
+//******************************************************************************
+
+// ModbusTransaction is a Modbus request and its response.
+type ModbusTransaction struct {
+	TransactionIdentifier uint16
+	UnitIdentifier        uint8
+	Request               *Modbus
+	Response              *Modbus
+	RequestTime           time.Time
+	ResponseTime          time.Time
+}
+
+type modbusTransactionKey struct {
+	network, transport gopacket.Flow
+	id                 uint16
+}
+
+// ModbusTransactions pairs Modbus requests with their responses, by
+// connection and TransactionIdentifier.
+//
+// Pairing also completes the responses to read coils and read discrete
+// inputs requests, whose Coils are cut down to the requested Quantity.
+type ModbusTransactions struct {
+	pending map[modbusTransactionKey]*ModbusTransaction
+}
+
+// NewModbusTransactions returns an empty ModbusTransactions.
+func NewModbusTransactions() *ModbusTransactions {
+	return &ModbusTransactions{pending: make(map[modbusTransactionKey]*ModbusTransaction)}
+}
+
+// Add records a Modbus PDU sent on the connection identified by the given
+// network and transport flows at time ts. It returns the transaction a
+// response completes, and nil otherwise.
+func (t *ModbusTransactions) Add(network, transport gopacket.Flow, mbap *ModbusTCP, pdu *Modbus, ts time.Time) *ModbusTransaction {
+	if !pdu.IsResponse {
+		key := modbusTransactionKey{network, transport, mbap.TransactionIdentifier}
+		t.pending[key] = &ModbusTransaction{
+			TransactionIdentifier: mbap.TransactionIdentifier,
+			UnitIdentifier:        mbap.UnitIdentifier,
+			Request:               pdu,
+			RequestTime:           ts,
+		}
+		return nil
+	}
+	key := modbusTransactionKey{network.Reverse(), transport.Reverse(), mbap.TransactionIdentifier}
+	tr, ok := t.pending[key]
+	if !ok || tr.Request.FunctionCode != pdu.FunctionCode {
+		return nil
+	}
+	delete(t.pending, key)
+	tr.Response = pdu
+	tr.ResponseTime = ts
+	if !pdu.Exception && (pdu.FunctionCode == ModbusReadCoils || pdu.FunctionCode == ModbusReadDiscreteInputs) &&
+		int(tr.Request.Quantity) < len(pdu.Coils) {
+		pdu.Coils = pdu.Coils[:tr.Request.Quantity]
+	}
+	return tr
+}
+
+// AddPacket calls Add with the layers, flows and timestamp of a packet
+// holding a Modbus PDU. It returns nil for other packets.
+func (t *ModbusTransactions) AddPacket(p gopacket.Packet) *ModbusTransaction {
+	mbap, ok := p.Layer(LayerTypeModbusTCP).(*ModbusTCP)
+	if !ok {
+		return nil
+	}
+	pdu, ok := p.Layer(LayerTypeModbus).(*Modbus)
+	if !ok || p.NetworkLayer() == nil || p.TransportLayer() == nil {
+		return nil
+	}
+	return t.Add(p.NetworkLayer().NetworkFlow(), p.TransportLayer().TransportFlow(), mbap, pdu, p.Metadata().Timestamp)
+}
+
+// Expire removes and returns the requests sent before the given time that
+// got no response.
+func (t *ModbusTransactions) Expire(before time.Time) []*ModbusTransaction {
+	var expired []*ModbusTransaction
+	for key, tr := range t.pending {
+		if tr.RequestTime.Before(before) {
+			expired = append(expired, tr)
+			delete(t.pending, key)
+		}
+	}
+	return expired
+}
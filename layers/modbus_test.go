@@ -0,0 +1,190 @@
+// Copyright 2018, The GoPacket Authors, All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+func modbusPacket(t *testing.T, response bool, tid uint16, pdu *Modbus) gopacket.Packet {
+	ip := &IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: IPProtocolTCP,
+		SrcIP:    net.IP{10, 0, 0, 1},
+		DstIP:    net.IP{10, 0, 0, 2},
+	}
+	tcp := &TCP{SrcPort: 40000, DstPort: 502, ACK: true, PSH: true, Window: 1024}
+	if response {
+		ip.SrcIP, ip.DstIP = ip.DstIP, ip.SrcIP
+		tcp.SrcPort, tcp.DstPort = tcp.DstPort, tcp.SrcPort
+	}
+	tcp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	err := gopacket.SerializeLayers(buf, opts,
+		&Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+			DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+			EthernetType: EthernetTypeIPv4,
+		},
+		ip, tcp,
+		&ModbusTCP{TransactionIdentifier: tid, UnitIdentifier: 1},
+		pdu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), LinkTypeEthernet, gopacket.DecodeOptions{DecodeStreamsAsDatagrams: true})
+	if p.ErrorLayer() != nil {
+		t.Fatal("Failed to decode packet:", p.ErrorLayer().Error())
+	}
+	return p
+}
+
+func TestModbusRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		response bool
+		pdu      *Modbus
+		raw      []byte
+	}{
+		{"read coils request", false,
+			&Modbus{FunctionCode: ModbusReadCoils, Address: 0x13, Quantity: 19},
+			[]byte{0x01, 0x00, 0x13, 0x00, 0x13}},
+		{"read coils response", true,
+			&Modbus{FunctionCode: ModbusReadCoils, IsResponse: true, Coils: modbusUnpackCoils([]byte{0xcd, 0x6b, 0x05}, 24)},
+			[]byte{0x01, 0x03, 0xcd, 0x6b, 0x05}},
+		{"read holding registers request", false,
+			&Modbus{FunctionCode: ModbusReadHoldingRegisters, Address: 0x6b, Quantity: 3},
+			[]byte{0x03, 0x00, 0x6b, 0x00, 0x03}},
+		{"read holding registers response", true,
+			&Modbus{FunctionCode: ModbusReadHoldingRegisters, IsResponse: true, Registers: []uint16{0x022b, 0, 0x64}},
+			[]byte{0x03, 0x06, 0x02, 0x2b, 0x00, 0x00, 0x00, 0x64}},
+		{"write single coil", false,
+			&Modbus{FunctionCode: ModbusWriteSingleCoil, Address: 0xac, Value: ModbusCoilOn},
+			[]byte{0x05, 0x00, 0xac, 0xff, 0x00}},
+		{"write single register response", true,
+			&Modbus{FunctionCode: ModbusWriteSingleRegister, IsResponse: true, Address: 1, Value: 3},
+			[]byte{0x06, 0x00, 0x01, 0x00, 0x03}},
+		{"write multiple coils request", false,
+			&Modbus{FunctionCode: ModbusWriteMultipleCoils, Address: 0x13, Quantity: 10,
+				Coils: []bool{true, false, true, true, false, false, true, true, true, false}},
+			[]byte{0x0f, 0x00, 0x13, 0x00, 0x0a, 0x02, 0xcd, 0x01}},
+		{"write multiple registers request", false,
+			&Modbus{FunctionCode: ModbusWriteMultipleRegisters, Address: 1, Quantity: 2, Registers: []uint16{0x000a, 0x0102}},
+			[]byte{0x10, 0x00, 0x01, 0x00, 0x02, 0x04, 0x00, 0x0a, 0x01, 0x02}},
+		{"write multiple registers response", true,
+			&Modbus{FunctionCode: ModbusWriteMultipleRegisters, IsResponse: true, Address: 1, Quantity: 2},
+			[]byte{0x10, 0x00, 0x01, 0x00, 0x02}},
+		{"mask write register", false,
+			&Modbus{FunctionCode: ModbusMaskWriteRegister, Address: 4, AndMask: 0xf2, OrMask: 0x25},
+			[]byte{0x16, 0x00, 0x04, 0x00, 0xf2, 0x00, 0x25}},
+		{"read/write multiple registers request", false,
+			&Modbus{FunctionCode: ModbusReadWriteMultipleRegisters, Address: 3, Quantity: 6, WriteAddress: 0x0e, WriteQuantity: 3,
+				Registers: []uint16{0xff, 0xff, 0xff}},
+			[]byte{0x17, 0x00, 0x03, 0x00, 0x06, 0x00, 0x0e, 0x00, 0x03, 0x06, 0x00, 0xff, 0x00, 0xff, 0x00, 0xff}},
+		{"exception response", true,
+			&Modbus{FunctionCode: ModbusReadInputRegisters, IsResponse: true, Exception: true, ExceptionCode: ModbusIllegalDataAddress},
+			[]byte{0x84, 0x02}},
+		{"other function code", false,
+			&Modbus{FunctionCode: ModbusDiagnostics, Data: []byte{0x00, 0x00, 0xa5, 0x37}},
+			[]byte{0x08, 0x00, 0x00, 0xa5, 0x37}},
+	} {
+		p := modbusPacket(t, test.response, 7, test.pdu)
+		mbap, ok := p.Layer(LayerTypeModbusTCP).(*ModbusTCP)
+		if !ok {
+			t.Fatalf("%s: no ModbusTCP layer", test.name)
+		}
+		if mbap.Length != uint16(len(test.raw)+1) || mbap.TransactionIdentifier != 7 {
+			t.Errorf("%s: wrong MBAP header %+v", test.name, mbap)
+		}
+		got, ok := p.Layer(LayerTypeModbus).(*Modbus)
+		if !ok {
+			t.Fatalf("%s: no Modbus layer", test.name)
+		}
+		if !bytes.Equal(got.Contents, test.raw) {
+			t.Errorf("%s: serialized\n%x\nwant\n%x", test.name, got.Contents, test.raw)
+		}
+		test.pdu.BaseLayer = got.BaseLayer
+		if !reflect.DeepEqual(got, test.pdu) {
+			t.Errorf("%s: decoded\n%+v\nwant\n%+v", test.name, got, test.pdu)
+		}
+
+		var m Modbus
+		if err := m.DecodeFromBytesAs(test.raw, test.response, gopacket.NilDecodeFeedback); err != nil {
+			t.Errorf("%s: DecodeFromBytesAs: %v", test.name, err)
+		} else if !reflect.DeepEqual(&m, test.pdu) {
+			t.Errorf("%s: DecodeFromBytesAs decoded\n%+v\nwant\n%+v", test.name, &m, test.pdu)
+		}
+	}
+}
+
+func TestModbusDecodeErrors(t *testing.T) {
+	for _, raw := range [][]byte{
+		{0x01},
+		{0x03, 0x00, 0x6b, 0x00},
+		{0x10, 0x00, 0x01, 0x00, 0x02, 0x04, 0x00, 0x0a},
+		{0x0f, 0x00, 0x13, 0x00, 0x0a, 0x01, 0xcd},
+		{0x84, 0x02, 0x00},
+	} {
+		var m Modbus
+		if err := m.DecodeFromBytesAs(raw, false, gopacket.NilDecodeFeedback); err == nil {
+			t.Errorf("%x: decoded without error: %+v", raw, m)
+		}
+	}
+}
+
+func TestModbusTransactions(t *testing.T) {
+	tr := NewModbusTransactions()
+	base := time.Unix(1500000000, 0)
+
+	request := modbusPacket(t, false, 42, &Modbus{FunctionCode: ModbusReadCoils, Address: 0x13, Quantity: 19})
+	request.Metadata().Timestamp = base
+	if got := tr.AddPacket(request); got != nil {
+		t.Fatalf("request completed a transaction: %+v", got)
+	}
+
+	// A response with another transaction identifier does not match.
+	other := modbusPacket(t, true, 43, &Modbus{FunctionCode: ModbusReadCoils, IsResponse: true, Coils: make([]bool, 24)})
+	if got := tr.AddPacket(other); got != nil {
+		t.Fatalf("unrelated response completed a transaction: %+v", got)
+	}
+
+	response := modbusPacket(t, true, 42, &Modbus{FunctionCode: ModbusReadCoils, IsResponse: true,
+		Coils: modbusUnpackCoils([]byte{0xcd, 0x6b, 0x05}, 24)})
+	response.Metadata().Timestamp = base.Add(time.Millisecond)
+	got := tr.AddPacket(response)
+	if got == nil {
+		t.Fatal("response did not complete the transaction")
+	}
+	if got.TransactionIdentifier != 42 || got.UnitIdentifier != 1 || got.Request.Address != 0x13 ||
+		got.ResponseTime.Sub(got.RequestTime) != time.Millisecond {
+		t.Errorf("wrong transaction %+v", got)
+	}
+	if want := modbusUnpackCoils([]byte{0xcd, 0x6b, 0x05}, 19); !reflect.DeepEqual(got.Response.Coils, want) {
+		t.Errorf("response coils %v, want %v", got.Response.Coils, want)
+	}
+	if got := tr.AddPacket(response); got != nil {
+		t.Errorf("duplicate response completed a transaction: %+v", got)
+	}
+
+	lost := modbusPacket(t, false, 44, &Modbus{FunctionCode: ModbusReadHoldingRegisters, Address: 1, Quantity: 1})
+	lost.Metadata().Timestamp = base
+	tr.AddPacket(lost)
+	if expired := tr.Expire(base); len(expired) != 0 {
+		t.Errorf("expired %d transactions too early", len(expired))
+	}
+	if expired := tr.Expire(base.Add(time.Second)); len(expired) != 1 || expired[0].TransactionIdentifier != 44 {
+		t.Errorf("wrong expired transactions %+v", expired)
+	}
+}
@@ -138,9 +138,35 @@ func (d *ModbusTCP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) err
 
 //******************************************************************************
 
-// NextLayerType returns the layer type of the ModbusTCP payload, which is LayerTypePayload.
+// NextLayerType returns the layer type of the ModbusTCP payload, which is
+// LayerTypeModbus, or LayerTypePayload for other protocols.
 func (d *ModbusTCP) NextLayerType() gopacket.LayerType {
-	return gopacket.LayerTypePayload
+	if d.ProtocolIdentifier != ModbusProtocolModbus {
+		return gopacket.LayerTypePayload
+	}
+	return LayerTypeModbus
+}
+
+//******************************************************************************
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+// With FixLengths, Length is set from the size of the Modbus PDU already
+// serialized.
+func (d *ModbusTCP) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	payload := len(b.Bytes())
+	bytes, err := b.PrependBytes(mbapRecordSizeInBytes)
+	if err != nil {
+		return err
+	}
+	if opts.FixLengths {
+		d.Length = uint16(payload + 1)
+	}
+	binary.BigEndian.PutUint16(bytes[0:], d.TransactionIdentifier)
+	binary.BigEndian.PutUint16(bytes[2:], uint16(d.ProtocolIdentifier))
+	binary.BigEndian.PutUint16(bytes[4:], d.Length)
+	bytes[6] = d.UnitIdentifier
+	return nil
 }
 
 //******************************************************************************
@@ -7,9 +7,15 @@
 package layers
 
 import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash"
 
 	"github.com/google/gopacket"
 )
@@ -243,6 +249,12 @@ type OSPFv2 struct {
 	OSPF
 	AuType         uint16
 	Authentication uint64
+	// AuthTrailer is the message digest following the packet with the
+	// cryptographic authentication (AuType 2).
+	AuthTrailer []byte
+	// CryptoAuth, if set, is used by SerializeTo to authenticate the
+	// packet, overriding AuType and Authentication.
+	CryptoAuth *OSPFCryptoAuth
 }
 
 // OSPFv3 extend the OSPF head with version 3 specific fields
@@ -251,6 +263,14 @@ type OSPFv3 struct {
 	OSPF
 	Instance uint8
 	Reserved uint8
+	// AuthTrailer is the authentication trailer following the packet (RFC
+	// 7166), if any.
+	AuthTrailer []byte
+	// CryptoAuth, if set, is used by SerializeTo to append an
+	// authentication trailer.  The AT bit must be set in the options of
+	// Hello and Database Description packets.
+	CryptoAuth *OSPFCryptoAuth
+	tcpipchecksum
 }
 
 // getLSAsv2 parses the LSA information from the packet for OSPFv2
@@ -384,18 +404,44 @@ func extractLSAInformation(lstype, lsalength uint16, data []byte) (interface{},
 	case NSSALSAtype:
 		flags := uint8(data[20])
 		prefixLen := uint8(data[24]) / 8
+		refLSType := binary.BigEndian.Uint16(data[26:28])
+		offset := 28 + ospfPrefixSize(data[24])
+		end := offset
+		if (flags & 0x02) == 0x02 {
+			end += 16
+		}
+		if (flags & 0x01) == 0x01 {
+			end += 4
+		}
+		if refLSType != 0 {
+			end += 4
+		}
+		if uint32(len(data)) < end {
+			return nil, errors.New("ASExternalLSA too small")
+		}
 		var forwardingAddress []byte
+		var tag, refLinkStateID uint32
 		if (flags & 0x02) == 0x02 {
-			forwardingAddress = data[28+uint32(prefixLen) : 28+uint32(prefixLen)+16]
+			forwardingAddress = data[offset : offset+16]
+			offset += 16
+		}
+		if (flags & 0x01) == 0x01 {
+			tag = binary.BigEndian.Uint32(data[offset : offset+4])
+			offset += 4
+		}
+		if refLSType != 0 {
+			refLinkStateID = binary.BigEndian.Uint32(data[offset : offset+4])
 		}
 		content = ASExternalLSA{
 			Flags:             flags,
 			Metric:            binary.BigEndian.Uint32(data[20:24]) & 0x00FFFFFF,
 			PrefixLength:      prefixLen,
 			PrefixOptions:     uint8(data[25]),
-			RefLSType:         binary.BigEndian.Uint16(data[26:28]),
+			RefLSType:         refLSType,
 			AddressPrefix:     data[28 : 28+uint32(prefixLen)],
 			ForwardingAddress: forwardingAddress,
+			ExternalRouteTag:  tag,
+			RefLinkStateID:    refLinkStateID,
 		}
 	case LinkLSAtype:
 		var prefixes []Prefix
@@ -410,7 +456,7 @@ func extractLSAInformation(lstype, lsalength uint16, data []byte) (interface{},
 				AddressPrefix: data[prefixOffset+4 : prefixOffset+4+uint32(prefixLen)/8],
 			}
 			prefixes = append(prefixes, prefix)
-			prefixOffset = prefixOffset + 4 + uint32(prefixLen)/8
+			prefixOffset = prefixOffset + 4 + ospfPrefixSize(prefixLen)
 		}
 		content = LinkLSA{
 			RtrPriority:      uint8(data[20]),
@@ -433,7 +479,7 @@ func extractLSAInformation(lstype, lsalength uint16, data []byte) (interface{},
 				AddressPrefix: data[prefixOffset+4 : prefixOffset+4+uint32(prefixLen)/8],
 			}
 			prefixes = append(prefixes, prefix)
-			prefixOffset = prefixOffset + 4 + uint32(prefixLen)
+			prefixOffset = prefixOffset + 4 + ospfPrefixSize(prefixLen)
 		}
 		content = IntraAreaPrefixLSA{
 			NumOfPrefixes:  numOfPrefixes,
@@ -494,6 +540,12 @@ func (ospf *OSPFv2) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) err
 	ospf.Checksum = binary.BigEndian.Uint16(data[12:14])
 	ospf.AuType = binary.BigEndian.Uint16(data[14:16])
 	ospf.Authentication = binary.BigEndian.Uint64(data[16:24])
+	if ospf.AuType == 2 {
+		end := int(ospf.PacketLength) + int(data[19])
+		if int(ospf.PacketLength) >= 24 && end <= len(data) {
+			ospf.AuthTrailer = data[ospf.PacketLength:end]
+		}
+	}
 
 	switch ospf.Type {
 	case OSPFHello:
@@ -518,7 +570,8 @@ func (ospf *OSPFv2) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) err
 		for i := 32; uint16(i+20) <= ospf.PacketLength; i += 20 {
 			lsa := LSAheader{
 				LSAge:       binary.BigEndian.Uint16(data[i : i+2]),
-				LSType:      binary.BigEndian.Uint16(data[i+2 : i+4]),
+				LSOptions:   data[i+2],
+				LSType:      uint16(data[i+3]),
 				LinkStateID: binary.BigEndian.Uint32(data[i+4 : i+8]),
 				AdvRouter:   binary.BigEndian.Uint32(data[i+8 : i+12]),
 				LSSeqNumber: binary.BigEndian.Uint32(data[i+12 : i+16]),
@@ -591,6 +644,12 @@ func (ospf *OSPFv3) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) err
 	ospf.Checksum = binary.BigEndian.Uint16(data[12:14])
 	ospf.Instance = uint8(data[14])
 	ospf.Reserved = uint8(data[15])
+	if n := int(ospf.PacketLength); n >= 16 && n+16 <= len(data) {
+		end := n + int(binary.BigEndian.Uint16(data[n+2:n+4]))
+		if end <= len(data) {
+			ospf.AuthTrailer = data[n:end]
+		}
+	}
 
 	switch ospf.Type {
 	case OSPFHello:
@@ -713,3 +772,431 @@ func decodeOSPF(data []byte, p gopacket.PacketBuilder) error {
 
 	return fmt.Errorf("Unable to determine OSPF type.")
 }
+
+// OSPFAuthAlgorithm is an algorithm of the OSPF cryptographic
+// authentication.
+type OSPFAuthAlgorithm uint8
+
+// Potential values for OSPFCryptoAuth.Algorithm.
+const (
+	OSPFAuthMD5        OSPFAuthAlgorithm = 1 // Keyed MD5, OSPFv2 only (RFC 2328, D.4.3)
+	OSPFAuthHMACSHA1   OSPFAuthAlgorithm = 2 // RFC 5709 for OSPFv2, RFC 7166 for OSPFv3
+	OSPFAuthHMACSHA256 OSPFAuthAlgorithm = 3
+	OSPFAuthHMACSHA384 OSPFAuthAlgorithm = 4
+	OSPFAuthHMACSHA512 OSPFAuthAlgorithm = 5
+)
+
+// OSPFCryptoAuth holds the parameters of the cryptographic authentication
+// of OSPF packets, used by SerializeTo to compute the authentication
+// trailer.
+type OSPFCryptoAuth struct {
+	Algorithm OSPFAuthAlgorithm
+	Key       []byte
+	// KeyID is the key identifier of OSPFv2.
+	KeyID uint8
+	// SAID is the security association identifier of OSPFv3.
+	SAID uint16
+	// SeqNum is the cryptographic sequence number, of which OSPFv2 only
+	// uses the 32 low bits.
+	SeqNum uint64
+}
+
+// ospfApad is the value of the authentication data while it is computed
+// (RFC 5709, section 3.3).  For OSPFv3, it starts with the IPv6 source
+// address instead (RFC 7166, section 4.5).
+var ospfApad = []byte{0x87, 0x8f, 0xe1, 0xf3}
+
+func (a *OSPFCryptoAuth) hash() (func() hash.Hash, error) {
+	switch a.Algorithm {
+	case OSPFAuthMD5:
+		return md5.New, nil
+	case OSPFAuthHMACSHA1:
+		return sha1.New, nil
+	case OSPFAuthHMACSHA256:
+		return sha256.New, nil
+	case OSPFAuthHMACSHA384:
+		return sha512.New384, nil
+	case OSPFAuthHMACSHA512:
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("Unknown OSPF authentication algorithm %d", a.Algorithm)
+}
+
+// digest computes the authentication data of text, the data covered by the
+// authentication.  The Apad appended to text starts with prefix, and is
+// filled up to the digest size with ospfApad.
+func (a *OSPFCryptoAuth) digest(text, prefix []byte) ([]byte, error) {
+	h, err := a.hash()
+	if err != nil {
+		return nil, err
+	}
+	if a.Algorithm == OSPFAuthMD5 {
+		var key [16]byte
+		copy(key[:], a.Key)
+		d := md5.New()
+		d.Write(text)
+		d.Write(key[:])
+		return d.Sum(nil), nil
+	}
+
+	size := h().Size()
+	key := make([]byte, size)
+	if len(a.Key) > size {
+		d := h()
+		d.Write(a.Key)
+		key = d.Sum(nil)
+	} else {
+		copy(key, a.Key)
+	}
+	mac := hmac.New(h, key)
+	mac.Write(text)
+	mac.Write(prefix)
+	for i := len(prefix); i < size; i += 4 {
+		mac.Write(ospfApad)
+	}
+	return mac.Sum(nil), nil
+}
+
+func (a *OSPFCryptoAuth) size() int {
+	h, err := a.hash()
+	if err != nil {
+		return 0
+	}
+	return h().Size()
+}
+
+// ospfPrefixSize returns the size of an address prefix of prefixLength
+// bits, padded to 32 bits words (RFC 5340, A.4.1).
+func ospfPrefixSize(prefixLength uint8) uint32 {
+	return (uint32(prefixLength) + 31) / 32 * 4
+}
+
+func ospfAppend16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func ospfAppend32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// ospfAppendPrefix appends an address prefix, padded to 32 bits words.
+func ospfAppendPrefix(b []byte, prefixLength uint8, prefix []byte) []byte {
+	p := make([]byte, ospfPrefixSize(prefixLength))
+	copy(p, prefix)
+	return append(b, p...)
+}
+
+// ospfLSAChecksum computes the Fletcher checksum of an LSA whose checksum
+// field is zero, skipping the LS age (RFC 2328, section 12.1.7).
+func ospfLSAChecksum(lsa []byte) uint16 {
+	data := lsa[2:]
+	var c0, c1 int
+	for _, v := range data {
+		c0 = (c0 + int(v)) % 255
+		c1 = (c1 + c0) % 255
+	}
+	// The checksum is at offset 14 of the data
+	x := ((len(data)-15)*c0 - c1) % 255
+	if x <= 0 {
+		x += 255
+	}
+	y := 510 - c0 - x
+	if y > 255 {
+		y -= 255
+	}
+	return uint16(x)<<8 | uint16(y)
+}
+
+func (h *LSAheader) appendTo(b []byte, v2 bool) []byte {
+	b = ospfAppend16(b, h.LSAge)
+	if v2 {
+		b = append(b, h.LSOptions, byte(h.LSType))
+	} else {
+		b = ospfAppend16(b, h.LSType)
+	}
+	b = ospfAppend32(b, h.LinkStateID)
+	b = ospfAppend32(b, h.AdvRouter)
+	b = ospfAppend32(b, h.LSSeqNumber)
+	b = ospfAppend16(b, h.LSChecksum)
+	return ospfAppend16(b, h.Length)
+}
+
+// appendTo appends the LSA to b, fixing its length and checksum according
+// to opts.
+func (lsa *LSA) appendTo(b []byte, v2 bool, opts gopacket.SerializeOptions) ([]byte, error) {
+	start := len(b)
+	b = lsa.LSAheader.appendTo(b, v2)
+	switch c := lsa.Content.(type) {
+	case RouterLSAV2:
+		if opts.FixLengths {
+			c.Links = uint16(len(c.Routers))
+			lsa.Content = c
+		}
+		b = append(b, c.Flags, 0)
+		b = ospfAppend16(b, c.Links)
+		for _, r := range c.Routers {
+			b = ospfAppend32(b, r.LinkID)
+			b = ospfAppend32(b, r.LinkData)
+			b = append(b, r.Type, 0)
+			b = ospfAppend16(b, r.Metric)
+		}
+	case NetworkLSAV2:
+		b = ospfAppend32(b, c.NetworkMask)
+		for _, r := range c.AttachedRouter {
+			b = ospfAppend32(b, r)
+		}
+	case ASExternalLSAV2:
+		b = ospfAppend32(b, c.NetworkMask)
+		b = ospfAppend32(b, uint32(c.ExternalBit&0x80)<<24|c.Metric&0x00FFFFFF)
+		b = ospfAppend32(b, c.ForwardingAddress)
+		b = ospfAppend32(b, c.ExternalRouteTag)
+	case RouterLSA:
+		b = ospfAppend32(b, uint32(c.Flags)<<24|c.Options&0x00FFFFFF)
+		for _, r := range c.Routers {
+			b = append(b, r.Type, 0)
+			b = ospfAppend16(b, r.Metric)
+			b = ospfAppend32(b, r.InterfaceID)
+			b = ospfAppend32(b, r.NeighborInterfaceID)
+			b = ospfAppend32(b, r.NeighborRouterID)
+		}
+	case NetworkLSA:
+		b = ospfAppend32(b, c.Options&0x00FFFFFF)
+		for _, r := range c.AttachedRouter {
+			b = ospfAppend32(b, r)
+		}
+	case InterAreaPrefixLSA:
+		b = ospfAppend32(b, c.Metric&0x00FFFFFF)
+		b = append(b, c.PrefixLength, c.PrefixOptions, 0, 0)
+		b = ospfAppendPrefix(b, c.PrefixLength, c.AddressPrefix)
+	case InterAreaRouterLSA:
+		b = ospfAppend32(b, c.Options&0x00FFFFFF)
+		b = ospfAppend32(b, c.Metric&0x00FFFFFF)
+		b = ospfAppend32(b, c.DestinationRouterID)
+	case ASExternalLSA:
+		// PrefixLength is decoded in bytes
+		b = ospfAppend32(b, uint32(c.Flags)<<24|c.Metric&0x00FFFFFF)
+		b = append(b, c.PrefixLength*8, c.PrefixOptions)
+		b = ospfAppend16(b, c.RefLSType)
+		b = ospfAppendPrefix(b, c.PrefixLength*8, c.AddressPrefix)
+		if c.Flags&0x02 != 0 {
+			b = ospfAppendPrefix(b, 128, c.ForwardingAddress)
+		}
+		if c.Flags&0x01 != 0 {
+			b = ospfAppend32(b, c.ExternalRouteTag)
+		}
+		if c.RefLSType != 0 {
+			b = ospfAppend32(b, c.RefLinkStateID)
+		}
+	case LinkLSA:
+		if opts.FixLengths {
+			c.NumOfPrefixes = uint32(len(c.Prefixes))
+			lsa.Content = c
+		}
+		b = ospfAppend32(b, uint32(c.RtrPriority)<<24|c.Options&0x00FFFFFF)
+		b = ospfAppendPrefix(b, 128, c.LinkLocalAddress)
+		b = ospfAppend32(b, c.NumOfPrefixes)
+		for _, p := range c.Prefixes {
+			b = append(b, p.PrefixLength, p.PrefixOptions, 0, 0)
+			b = ospfAppendPrefix(b, p.PrefixLength, p.AddressPrefix)
+		}
+	case IntraAreaPrefixLSA:
+		if opts.FixLengths {
+			c.NumOfPrefixes = uint16(len(c.Prefixes))
+			lsa.Content = c
+		}
+		b = ospfAppend16(b, c.NumOfPrefixes)
+		b = ospfAppend16(b, c.RefLSType)
+		b = ospfAppend32(b, c.RefLinkStateID)
+		b = ospfAppend32(b, c.RefAdvRouter)
+		for _, p := range c.Prefixes {
+			b = append(b, p.PrefixLength, p.PrefixOptions)
+			b = ospfAppend16(b, p.Metric)
+			b = ospfAppendPrefix(b, p.PrefixLength, p.AddressPrefix)
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported LSA content %T", lsa.Content)
+	}
+
+	data := b[start:]
+	if opts.FixLengths {
+		lsa.Length = uint16(len(data))
+		binary.BigEndian.PutUint16(data[18:20], lsa.Length)
+	}
+	if opts.ComputeChecksums {
+		data[16], data[17] = 0, 0
+		lsa.LSChecksum = ospfLSAChecksum(data)
+		binary.BigEndian.PutUint16(data[16:18], lsa.LSChecksum)
+	}
+	return b, nil
+}
+
+// appendContent appends the packet content following the OSPF header.
+func (ospf *OSPF) appendContent(b []byte, v2 bool, opts gopacket.SerializeOptions) ([]byte, error) {
+	switch c := ospf.Content.(type) {
+	case HelloPkgV2:
+		b = ospfAppend32(b, c.NetworkMask)
+		b = ospfAppend16(b, c.HelloInterval)
+		b = append(b, byte(c.Options), c.RtrPriority)
+		b = ospfAppend32(b, c.RouterDeadInterval)
+		b = ospfAppend32(b, c.DesignatedRouterID)
+		b = ospfAppend32(b, c.BackupDesignatedRouterID)
+		for _, n := range c.NeighborID {
+			b = ospfAppend32(b, n)
+		}
+	case HelloPkg:
+		b = ospfAppend32(b, c.InterfaceID)
+		b = ospfAppend32(b, uint32(c.RtrPriority)<<24|c.Options&0x00FFFFFF)
+		b = ospfAppend16(b, c.HelloInterval)
+		b = ospfAppend16(b, uint16(c.RouterDeadInterval))
+		b = ospfAppend32(b, c.DesignatedRouterID)
+		b = ospfAppend32(b, c.BackupDesignatedRouterID)
+		for _, n := range c.NeighborID {
+			b = ospfAppend32(b, n)
+		}
+	case DbDescPkg:
+		if v2 {
+			b = ospfAppend16(b, c.InterfaceMTU)
+			b = append(b, byte(c.Options), byte(c.Flags))
+		} else {
+			b = ospfAppend32(b, c.Options&0x00FFFFFF)
+			b = ospfAppend16(b, c.InterfaceMTU)
+			b = ospfAppend16(b, c.Flags)
+		}
+		b = ospfAppend32(b, c.DDSeqNumber)
+		for i := range c.LSAinfo {
+			b = c.LSAinfo[i].appendTo(b, v2)
+		}
+	case []LSReq:
+		for _, r := range c {
+			b = ospfAppend32(b, uint32(r.LSType))
+			b = ospfAppend32(b, r.LSID)
+			b = ospfAppend32(b, r.AdvRouter)
+		}
+	case LSUpdate:
+		if opts.FixLengths {
+			c.NumOfLSAs = uint32(len(c.LSAs))
+			ospf.Content = c
+		}
+		b = ospfAppend32(b, c.NumOfLSAs)
+		for i := range c.LSAs {
+			var err error
+			if b, err = c.LSAs[i].appendTo(b, v2, opts); err != nil {
+				return nil, err
+			}
+		}
+	case []LSAheader:
+		for i := range c {
+			b = c[i].appendTo(b, v2)
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("Unsupported OSPF content %T", ospf.Content)
+	}
+	return b, nil
+}
+
+// writeHeader writes the header fields common to both versions, and fixes
+// the packet length.
+func (ospf *OSPF) writeHeader(pkt []byte, opts gopacket.SerializeOptions) {
+	if opts.FixLengths {
+		ospf.PacketLength = uint16(len(pkt))
+	}
+	pkt[0] = ospf.Version
+	pkt[1] = uint8(ospf.Type)
+	binary.BigEndian.PutUint16(pkt[2:4], ospf.PacketLength)
+	binary.BigEndian.PutUint32(pkt[4:8], ospf.RouterID)
+	binary.BigEndian.PutUint32(pkt[8:12], ospf.AreaID)
+	binary.BigEndian.PutUint16(pkt[12:14], ospf.Checksum)
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+//
+// With ComputeChecksums, the checksums of the packet and of its LSAs are
+// computed.  With CryptoAuth, the packet is authenticated and followed by
+// the message digest.
+func (ospf *OSPFv2) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	pkt, err := ospf.appendContent(make([]byte, 24, 64), true, opts)
+	if err != nil {
+		return err
+	}
+	if a := ospf.CryptoAuth; a != nil {
+		ospf.AuType = 2
+		ospf.Authentication = uint64(a.KeyID)<<40 | uint64(a.size())<<32 | uint64(uint32(a.SeqNum))
+	}
+	if opts.ComputeChecksums {
+		ospf.Checksum = 0
+	}
+	ospf.writeHeader(pkt, opts)
+	binary.BigEndian.PutUint16(pkt[14:16], ospf.AuType)
+	if opts.ComputeChecksums && ospf.AuType != 2 {
+		// The authentication field is excluded from the checksum
+		ospf.Checksum = tcpipChecksum(pkt, 0)
+		binary.BigEndian.PutUint16(pkt[12:14], ospf.Checksum)
+	}
+	binary.BigEndian.PutUint64(pkt[16:24], ospf.Authentication)
+
+	if ospf.CryptoAuth != nil {
+		if ospf.AuthTrailer, err = ospf.CryptoAuth.digest(pkt, nil); err != nil {
+			return err
+		}
+	}
+	bytes, err := b.PrependBytes(len(pkt) + len(ospf.AuthTrailer))
+	if err != nil {
+		return err
+	}
+	copy(bytes, pkt)
+	copy(bytes[len(pkt):], ospf.AuthTrailer)
+	return nil
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+//
+// With ComputeChecksums, the checksums of the packet and of its LSAs are
+// computed; the packet checksum requires the IPv6 layer, set with
+// SetNetworkLayerForChecksum.  With CryptoAuth, the packet is followed by an
+// authentication trailer, which also requires the IPv6 layer.
+func (ospf *OSPFv3) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	pkt, err := ospf.appendContent(make([]byte, 16, 64), false, opts)
+	if err != nil {
+		return err
+	}
+	if opts.ComputeChecksums {
+		ospf.Checksum = 0
+	}
+	ospf.writeHeader(pkt, opts)
+	pkt[14] = ospf.Instance
+	pkt[15] = ospf.Reserved
+	if opts.ComputeChecksums {
+		if ospf.Checksum, err = ospf.computeChecksum(pkt, IPProtocolOSPF); err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint16(pkt[12:14], ospf.Checksum)
+	}
+
+	if a := ospf.CryptoAuth; a != nil {
+		ip6, ok := ospf.pseudoheader.(*IPv6)
+		if !ok || a.Algorithm == OSPFAuthMD5 {
+			return errors.New("OSPFv3 authentication requires HMAC-SHA and the IPv6 layer set with SetNetworkLayerForChecksum")
+		}
+		trailer := make([]byte, 16)
+		binary.BigEndian.PutUint16(trailer[0:2], 1)
+		binary.BigEndian.PutUint16(trailer[2:4], uint16(16+a.size()))
+		binary.BigEndian.PutUint16(trailer[6:8], a.SAID)
+		binary.BigEndian.PutUint64(trailer[8:16], a.SeqNum)
+		text := append(append([]byte(nil), pkt...), trailer...)
+		digest, err := a.digest(text, ip6.SrcIP.To16())
+		if err != nil {
+			return err
+		}
+		ospf.AuthTrailer = append(trailer, digest...)
+	}
+	bytes, err := b.PrependBytes(len(pkt) + len(ospf.AuthTrailer))
+	if err != nil {
+		return err
+	}
+	copy(bytes, pkt)
+	copy(bytes[len(pkt):], ospf.AuthTrailer)
+	return nil
+}
@@ -7,6 +7,11 @@
 package layers
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"net"
 	"reflect"
 	"testing"
 
@@ -1215,3 +1220,193 @@ func BenchmarkDecodePacketPacket4(b *testing.B) {
 		gopacket.NewPacket(testPacketOSPF3LSAck, LinkTypeEthernet, gopacket.NoCopy)
 	}
 }
+
+func TestOSPFSerialize(t *testing.T) {
+	fix := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	for _, test := range []struct {
+		data []byte
+		opts gopacket.SerializeOptions
+	}{
+		{testPacketOSPF2Hello, fix}, {testPacketOSPF3Hello, fix},
+		{testPacketOSPF2DBDesc, fix}, {testPacketOSPF3DBDesc, fix},
+		{testPacketOSPF2LSRequest, fix}, {testPacketOSPF3LSRequest, fix},
+		{testPacketOSPF2LSUpdate, fix}, {testPacketOSPF3LSUpdate, fix},
+		// Crafted packets, with a wrong length and no checksum
+		{testPacketOSPF2LSUpdateLSA2, gopacket.SerializeOptions{}},
+		{testPacketOSPF2LSUpdateLSA7, gopacket.SerializeOptions{}},
+		{testPacketOSPF2LSAck, fix}, {testPacketOSPF3LSAck, fix},
+	} {
+		p := gopacket.NewPacket(test.data, LinkTypeEthernet, gopacket.Default)
+		want := p.NetworkLayer().LayerPayload()
+		l := p.Layer(LayerTypeOSPF).(gopacket.SerializableLayer)
+		if ospf, ok := l.(*OSPFv3); ok {
+			ospf.SetNetworkLayerForChecksum(p.NetworkLayer())
+		}
+		buf := gopacket.NewSerializeBuffer()
+		if err := l.SerializeTo(buf, test.opts); err != nil {
+			t.Errorf("%v: %v", p, err)
+			continue
+		}
+		if !reflect.DeepEqual(buf.Bytes(), want) {
+			t.Errorf("serialization failed:\ngot  %x\nwant %x", buf.Bytes(), want)
+		}
+	}
+}
+
+func TestOSPFLSAChecksum(t *testing.T) {
+	lsa := LSA{
+		LSAheader: LSAheader{LSAge: 1, LSType: RouterLSAtypeV2, LinkStateID: 0x0a000001, AdvRouter: 0x0a000001, LSSeqNumber: 0x80000001},
+		Content: RouterLSAV2{Flags: 2, Routers: []RouterV2{
+			{Type: 3, LinkID: 0x0a000000, LinkData: 0xffffff00, Metric: 10},
+			{Type: 1, LinkID: 0x0a000002, LinkData: 0x0a000001, Metric: 1},
+		}},
+	}
+	b, err := lsa.appendTo(nil, true, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lsa.Length != 48 || lsa.Content.(RouterLSAV2).Links != 2 {
+		t.Errorf("bad lengths: %d, %d links", lsa.Length, lsa.Content.(RouterLSAV2).Links)
+	}
+	// A valid Fletcher checksum sums to zero
+	var c0, c1 int
+	for _, v := range b[2:] {
+		c0 = (c0 + int(v)) % 255
+		c1 = (c1 + c0) % 255
+	}
+	if c0 != 0 || c1 != 0 || lsa.LSChecksum == 0 {
+		t.Errorf("bad checksum %#x: %d, %d", lsa.LSChecksum, c0, c1)
+	}
+}
+
+func TestOSPFv2CryptoAuth(t *testing.T) {
+	for _, a := range []*OSPFCryptoAuth{
+		{Algorithm: OSPFAuthMD5, Key: []byte("secret"), KeyID: 1, SeqNum: 42},
+		{Algorithm: OSPFAuthHMACSHA256, Key: []byte("secret"), KeyID: 2, SeqNum: 43},
+	} {
+		ip := &IPv4{Version: 4, TTL: 1, Protocol: IPProtocolOSPF, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{224, 0, 0, 5}}
+		ospf := &OSPFv2{
+			OSPF: OSPF{Version: 2, Type: OSPFHello, RouterID: 0x0a000001, Content: HelloPkgV2{
+				NetworkMask: 0xffffff00,
+				HelloPkg:    HelloPkg{HelloInterval: 10, RouterDeadInterval: 40, Options: 2, RtrPriority: 1},
+			}},
+			CryptoAuth: a,
+		}
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ip, ospf); err != nil {
+			t.Fatal(err)
+		}
+
+		p := gopacket.NewPacket(buf.Bytes(), LayerTypeIPv4, gopacket.Default)
+		got, ok := p.Layer(LayerTypeOSPF).(*OSPFv2)
+		if !ok {
+			t.Fatalf("no OSPF layer in %v", p)
+		}
+		if got.AuType != 2 || got.Checksum != 0 || got.Authentication != uint64(a.KeyID)<<40|uint64(a.size())<<32|a.SeqNum {
+			t.Errorf("bad authentication fields %#v", got)
+		}
+		pkt := buf.Bytes()[20 : 20+got.PacketLength]
+		var want []byte
+		if a.Algorithm == OSPFAuthMD5 {
+			h := md5.New()
+			h.Write(pkt)
+			h.Write(append([]byte("secret"), make([]byte, 10)...))
+			want = h.Sum(nil)
+		} else {
+			h := hmac.New(sha256.New, append([]byte("secret"), make([]byte, 26)...))
+			h.Write(pkt)
+			h.Write(bytes.Repeat([]byte{0x87, 0x8f, 0xe1, 0xf3}, 8))
+			want = h.Sum(nil)
+		}
+		if !bytes.Equal(got.AuthTrailer, want) {
+			t.Errorf("%d: got digest %x, want %x", a.Algorithm, got.AuthTrailer, want)
+		}
+	}
+}
+
+func TestOSPFv3CryptoAuth(t *testing.T) {
+	ip := &IPv6{Version: 6, HopLimit: 1, NextHeader: IPProtocolOSPF, SrcIP: net.ParseIP("fe80::1"), DstIP: net.ParseIP("ff02::5")}
+	ospf := &OSPFv3{
+		OSPF: OSPF{Version: 3, Type: OSPFHello, RouterID: 0x01010101, Content: HelloPkg{
+			InterfaceID: 5, RtrPriority: 1, Options: 0x413, HelloInterval: 10, RouterDeadInterval: 40,
+			NeighborID: []uint32{0x02020202},
+		}},
+		CryptoAuth: &OSPFCryptoAuth{Algorithm: OSPFAuthHMACSHA1, Key: []byte("secret"), SAID: 7, SeqNum: 1 << 32},
+	}
+	if err := ospf.SerializeTo(gopacket.NewSerializeBuffer(), gopacket.SerializeOptions{}); err == nil {
+		t.Error("authentication without network layer")
+	}
+	ospf.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ip, ospf); err != nil {
+		t.Fatal(err)
+	}
+
+	p := gopacket.NewPacket(buf.Bytes(), LayerTypeIPv6, gopacket.Default)
+	got, ok := p.Layer(LayerTypeOSPF).(*OSPFv3)
+	if !ok {
+		t.Fatalf("no OSPF layer in %v", p)
+	}
+	if got.PacketLength != 40 || len(got.AuthTrailer) != 36 || got.Content.(HelloPkg).NeighborID[0] != 0x02020202 {
+		t.Errorf("bad packet %#v", got)
+	}
+	// The checksum doesn't cover the trailer
+	pkt := buf.Bytes()[40 : 40+got.PacketLength]
+	got.SetNetworkLayerForChecksum(p.NetworkLayer())
+	if csum, _ := got.computeChecksum(pkt, IPProtocolOSPF); csum != 0 {
+		t.Errorf("bad checksum %#x", got.Checksum)
+	}
+	wantTrailer := []byte{0x00, 0x01, 0x00, 0x24, 0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}
+	if !bytes.Equal(got.AuthTrailer[:16], wantTrailer) {
+		t.Errorf("got trailer %x, want %x", got.AuthTrailer[:16], wantTrailer)
+	}
+	// HMAC-SHA1 of the packet, the trailer and the Apad fe80::1 878fe1f3
+	// (RFC 7166, section 4.5), computed with Python's hmac module.
+	want := []byte{
+		0x81, 0x8c, 0x71, 0x09, 0x96, 0xe2, 0x01, 0x19, 0x04, 0x75,
+		0xd2, 0xb0, 0xe4, 0xea, 0xc6, 0x81, 0xe1, 0x60, 0xff, 0xbc,
+	}
+	if !bytes.Equal(got.AuthTrailer[16:], want) {
+		t.Errorf("got digest %x, want %x", got.AuthTrailer[16:], want)
+	}
+}
+
+func TestOSPFv3LSARoundTrip(t *testing.T) {
+	lsas := []LSA{
+		{
+			LSAheader: LSAheader{LSType: ASExternalLSAtype, AdvRouter: 1, LSSeqNumber: 0x80000001},
+			Content: ASExternalLSA{
+				Flags: 0x03, Metric: 20, PrefixLength: 6, AddressPrefix: []byte{0x20, 0x01, 0x0d, 0xb8, 0x00, 0x01},
+				ForwardingAddress: net.ParseIP("2001:db8::1"), ExternalRouteTag: 99,
+			},
+		},
+		{
+			LSAheader: LSAheader{LSType: IntraAreaPrefixLSAtype, AdvRouter: 1, LSSeqNumber: 0x80000001},
+			Content: IntraAreaPrefixLSA{
+				RefLSType: RouterLSAtype, RefAdvRouter: 1,
+				Prefixes: []Prefix{
+					{PrefixLength: 48, Metric: 1, AddressPrefix: []byte{0x20, 0x01, 0x0d, 0xb8, 0x00, 0x02}},
+					{PrefixLength: 64, Metric: 2, AddressPrefix: []byte{0x20, 0x01, 0x0d, 0xb8, 0x00, 0x03, 0x00, 0x00}},
+				},
+			},
+		},
+	}
+	ospf := &OSPFv3{OSPF: OSPF{Version: 3, Type: OSPFLinkStateUpdate, RouterID: 1, Content: LSUpdate{LSAs: lsas}}}
+	buf := gopacket.NewSerializeBuffer()
+	if err := ospf.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), LayerTypeOSPF, gopacket.Default)
+	if p.ErrorLayer() != nil {
+		t.Fatal(p.ErrorLayer().Error())
+	}
+	got := p.Layer(LayerTypeOSPF).(*OSPFv3).Content.(LSUpdate)
+	if got.NumOfLSAs != 2 || !reflect.DeepEqual(got.LSAs[0].Content, lsas[0].Content) {
+		t.Errorf("got %#v", got)
+	}
+	// The decoder keeps the address prefix bytes covered by the prefix length
+	intra := got.LSAs[1].Content.(IntraAreaPrefixLSA)
+	if intra.NumOfPrefixes != 2 || intra.Prefixes[1].Metric != 2 || !bytes.Equal(intra.Prefixes[0].AddressPrefix, lsas[1].Content.(IntraAreaPrefixLSA).Prefixes[0].AddressPrefix) {
+		t.Errorf("got %#v", intra)
+	}
+}
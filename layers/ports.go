@@ -60,17 +60,19 @@ func (a TCPPort) LayerType() gopacket.LayerType {
 }
 
 var tcpPortLayerType = [65536]gopacket.LayerType{
-	53:   LayerTypeDNS,
-	443:  LayerTypeTLS,       // https
-	502:  LayerTypeModbusTCP, // modbustcp
-	636:  LayerTypeTLS,       // ldaps
-	989:  LayerTypeTLS,       // ftps-data
-	990:  LayerTypeTLS,       // ftps
-	992:  LayerTypeTLS,       // telnets
-	993:  LayerTypeTLS,       // imaps
-	994:  LayerTypeTLS,       // ircs
-	995:  LayerTypeTLS,       // pop3s
-	5061: LayerTypeTLS,       // ips
+	53:    LayerTypeDNSTCP,
+	443:   LayerTypeTLS,       // https
+	502:   LayerTypeModbusTCP, // modbustcp
+	636:   LayerTypeTLS,       // ldaps
+	989:   LayerTypeTLS,       // ftps-data
+	990:   LayerTypeTLS,       // ftps
+	992:   LayerTypeTLS,       // telnets
+	993:   LayerTypeTLS,       // imaps
+	994:   LayerTypeTLS,       // ircs
+	995:   LayerTypeTLS,       // pop3s
+	2404:  LayerTypeIEC104,    // iec-104
+	5061:  LayerTypeTLS,       // ips
+	20000: LayerTypeDNP3,      // dnp
 }
 
 // RegisterTCPPortLayerType creates a new mapping between a TCPPort
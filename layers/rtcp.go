@@ -0,0 +1,472 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/google/gopacket"
+)
+
+// RTCPPacketType is the type of an RTCP packet.
+type RTCPPacketType uint8
+
+// RTCP packet types, from RFC 3550, RFC 3611 and RFC 4585.
+const (
+	RTCPSenderReport       RTCPPacketType = 200
+	RTCPReceiverReport     RTCPPacketType = 201
+	RTCPSourceDescription  RTCPPacketType = 202
+	RTCPGoodbye            RTCPPacketType = 203
+	RTCPApplicationDefined RTCPPacketType = 204
+	RTCPTransportFeedback  RTCPPacketType = 205
+	RTCPPayloadFeedback    RTCPPacketType = 206
+	RTCPExtendedReport     RTCPPacketType = 207
+)
+
+func (t RTCPPacketType) String() string {
+	switch t {
+	case RTCPSenderReport:
+		return "SR"
+	case RTCPReceiverReport:
+		return "RR"
+	case RTCPSourceDescription:
+		return "SDES"
+	case RTCPGoodbye:
+		return "BYE"
+	case RTCPApplicationDefined:
+		return "APP"
+	case RTCPTransportFeedback:
+		return "RTPFB"
+	case RTCPPayloadFeedback:
+		return "PSFB"
+	case RTCPExtendedReport:
+		return "XR"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(t))
+	}
+}
+
+// RTCPSDESItemType is the type of a source description item.
+type RTCPSDESItemType uint8
+
+// RTCP source description item types.
+const (
+	RTCPSDESCNAME RTCPSDESItemType = 1
+	RTCPSDESName  RTCPSDESItemType = 2
+	RTCPSDESEmail RTCPSDESItemType = 3
+	RTCPSDESPhone RTCPSDESItemType = 4
+	RTCPSDESLoc   RTCPSDESItemType = 5
+	RTCPSDESTool  RTCPSDESItemType = 6
+	RTCPSDESNote  RTCPSDESItemType = 7
+	RTCPSDESPriv  RTCPSDESItemType = 8
+)
+
+// RTCPSenderInfo is the sender information of a sender report.
+type RTCPSenderInfo struct {
+	NTPTimestamp uint64
+	RTPTimestamp uint32
+	PacketCount  uint32
+	OctetCount   uint32
+}
+
+// RTCPReceptionReport is a reception report block of a sender or receiver
+// report, giving the reception statistics of one source.
+type RTCPReceptionReport struct {
+	SSRC             uint32
+	FractionLost     uint8 // fixed point, with the binary point at the left
+	CumulativeLost   int32 // 24-bit signed value
+	HighestSequence  uint32
+	Jitter           uint32
+	LastSenderReport uint32
+	DelaySinceLastSR uint32 // in units of 1/65536 seconds
+}
+
+// RTCPSDESItem is an item of a source description chunk.
+type RTCPSDESItem struct {
+	Type RTCPSDESItemType
+	Text string
+}
+
+// RTCPSDESChunk holds the source description items of one source.
+type RTCPSDESChunk struct {
+	Source uint32
+	Items  []RTCPSDESItem
+}
+
+// RTCPXRBlock is a report block of an extended report, kept undecoded.
+type RTCPXRBlock struct {
+	Type         uint8
+	TypeSpecific uint8
+	Data         []byte // a whole number of 32-bit words
+}
+
+// RTCPPacket is one of the packets of a compound RTCP packet. Which fields
+// are used depends on Type.
+//
+//  0                   1                   2                   3
+//  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |V=2|P|  Count  |      PT       |             length            |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+type RTCPPacket struct {
+	Version uint8
+	// Count is the number of reception reports, chunks or sources of SR,
+	// RR, SDES and BYE packets, the subtype of APP packets and the feedback
+	// message type of feedback packets.
+	Count  uint8
+	Type   RTCPPacketType
+	Length uint16 // in 32-bit words, minus one
+
+	// SSRC is the sender of SR, RR, APP, XR and feedback packets.
+	SSRC       uint32
+	SenderInfo RTCPSenderInfo        // SR
+	Reports    []RTCPReceptionReport // SR and RR
+	Chunks     []RTCPSDESChunk       // SDES
+	Sources    []uint32              // BYE
+	Reason     string                // BYE
+	Name       [4]byte               // APP
+	MediaSSRC  uint32                // feedback
+	XRBlocks   []RTCPXRBlock         // XR
+
+	// Data holds the application data of APP packets, the profile-specific
+	// extensions of SR and RR packets, the feedback control information
+	// of feedback packets, and everything after the header of packets of
+	// other types.
+	Data []byte
+
+	// Padding holds the padding of the packet, including its final count
+	// byte. The P bit is set if it is not empty.
+	Padding []byte
+}
+
+// RTCP is a compound RTCP packet, as defined in RFC 3550.
+type RTCP struct {
+	BaseLayer
+	Packets []RTCPPacket
+}
+
+// LayerType returns LayerTypeRTCP.
+func (r *RTCP) LayerType() gopacket.LayerType { return LayerTypeRTCP }
+
+// CanDecode returns the set of layer types that this DecodingLayer can decode.
+func (r *RTCP) CanDecode() gopacket.LayerClass { return LayerTypeRTCP }
+
+// NextLayerType returns the layer type contained by this DecodingLayer.
+func (r *RTCP) NextLayerType() gopacket.LayerType { return gopacket.LayerTypeZero }
+
+func decodeRTCP(data []byte, p gopacket.PacketBuilder) error {
+	r := &RTCP{}
+	return decodingLayerDecoder(r, data, p)
+}
+
+// DecodeFromBytes decodes the given bytes into this layer.
+func (r *RTCP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	r.BaseLayer = BaseLayer{Contents: data}
+	r.Packets = r.Packets[:0]
+	if len(data) == 0 {
+		return errors.New("empty RTCP packet")
+	}
+	for len(data) > 0 {
+		if len(data) < 4 {
+			df.SetTruncated()
+			return errors.New("RTCP header truncated")
+		}
+		pkt := RTCPPacket{
+			Version: data[0] >> 6,
+			Count:   data[0] & 0x1f,
+			Type:    RTCPPacketType(data[1]),
+			Length:  binary.BigEndian.Uint16(data[2:4]),
+		}
+		if pkt.Version != 2 {
+			return fmt.Errorf("unsupported RTCP version %d", pkt.Version)
+		}
+		size := 4 * (int(pkt.Length) + 1)
+		if len(data) < size {
+			df.SetTruncated()
+			return fmt.Errorf("RTCP %v packet truncated", pkt.Type)
+		}
+		body := data[4:size]
+		if data[0]&0x20 != 0 {
+			n := int(data[size-1])
+			if n == 0 || n > len(body) {
+				return fmt.Errorf("invalid RTCP padding length %d", n)
+			}
+			pkt.Padding = body[len(body)-n:]
+			body = body[:len(body)-n]
+		}
+		if err := pkt.decodeBody(body); err != nil {
+			return err
+		}
+		r.Packets = append(r.Packets, pkt)
+		data = data[size:]
+	}
+	return nil
+}
+
+var errRTCPBodyTruncated = errors.New("RTCP packet body truncated")
+
+func (p *RTCPPacket) decodeBody(body []byte) error {
+	switch p.Type {
+	case RTCPSenderReport, RTCPReceiverReport:
+		offset := 4
+		if p.Type == RTCPSenderReport {
+			offset = 24
+		}
+		if len(body) < offset+24*int(p.Count) {
+			return errRTCPBodyTruncated
+		}
+		p.SSRC = binary.BigEndian.Uint32(body[0:4])
+		if p.Type == RTCPSenderReport {
+			p.SenderInfo = RTCPSenderInfo{
+				NTPTimestamp: binary.BigEndian.Uint64(body[4:12]),
+				RTPTimestamp: binary.BigEndian.Uint32(body[12:16]),
+				PacketCount:  binary.BigEndian.Uint32(body[16:20]),
+				OctetCount:   binary.BigEndian.Uint32(body[20:24]),
+			}
+		}
+		for i := 0; i < int(p.Count); i++ {
+			p.Reports = append(p.Reports, decodeRTCPReceptionReport(body[offset:offset+24]))
+			offset += 24
+		}
+		if offset < len(body) {
+			p.Data = body[offset:]
+		}
+	case RTCPSourceDescription:
+		for i := 0; i < int(p.Count); i++ {
+			if len(body) < 4 {
+				return errRTCPBodyTruncated
+			}
+			chunk := RTCPSDESChunk{Source: binary.BigEndian.Uint32(body[0:4])}
+			offset := 4
+			for {
+				if offset >= len(body) {
+					return errRTCPBodyTruncated
+				}
+				if body[offset] == 0 {
+					// The item list ends with null bytes, up to
+					// the next 32-bit boundary.
+					offset += 4 - offset%4
+					break
+				}
+				if offset+2 > len(body) || offset+2+int(body[offset+1]) > len(body) {
+					return errRTCPBodyTruncated
+				}
+				end := offset + 2 + int(body[offset+1])
+				chunk.Items = append(chunk.Items, RTCPSDESItem{
+					Type: RTCPSDESItemType(body[offset]),
+					Text: string(body[offset+2 : end]),
+				})
+				offset = end
+			}
+			if offset > len(body) {
+				return errRTCPBodyTruncated
+			}
+			p.Chunks = append(p.Chunks, chunk)
+			body = body[offset:]
+		}
+	case RTCPGoodbye:
+		if len(body) < 4*int(p.Count) {
+			return errRTCPBodyTruncated
+		}
+		for i := 0; i < int(p.Count); i++ {
+			p.Sources = append(p.Sources, binary.BigEndian.Uint32(body[4*i:]))
+		}
+		if reason := body[4*p.Count:]; len(reason) > 0 {
+			if 1+int(reason[0]) > len(reason) {
+				return errRTCPBodyTruncated
+			}
+			p.Reason = string(reason[1 : 1+int(reason[0])])
+		}
+	case RTCPApplicationDefined:
+		if len(body) < 8 {
+			return errRTCPBodyTruncated
+		}
+		p.SSRC = binary.BigEndian.Uint32(body[0:4])
+		copy(p.Name[:], body[4:8])
+		if len(body) > 8 {
+			p.Data = body[8:]
+		}
+	case RTCPTransportFeedback, RTCPPayloadFeedback:
+		if len(body) < 8 {
+			return errRTCPBodyTruncated
+		}
+		p.SSRC = binary.BigEndian.Uint32(body[0:4])
+		p.MediaSSRC = binary.BigEndian.Uint32(body[4:8])
+		if len(body) > 8 {
+			p.Data = body[8:]
+		}
+	case RTCPExtendedReport:
+		if len(body) < 4 {
+			return errRTCPBodyTruncated
+		}
+		p.SSRC = binary.BigEndian.Uint32(body[0:4])
+		for body = body[4:]; len(body) > 0; {
+			if len(body) < 4 {
+				return errRTCPBodyTruncated
+			}
+			end := 4 + 4*int(binary.BigEndian.Uint16(body[2:4]))
+			if end > len(body) {
+				return errRTCPBodyTruncated
+			}
+			p.XRBlocks = append(p.XRBlocks, RTCPXRBlock{Type: body[0], TypeSpecific: body[1], Data: body[4:end]})
+			body = body[end:]
+		}
+	default:
+		p.Data = body
+	}
+	return nil
+}
+
+func decodeRTCPReceptionReport(data []byte) RTCPReceptionReport {
+	lost := binary.BigEndian.Uint32(data[4:8]) & 0xffffff
+	if lost&0x800000 != 0 {
+		lost |= 0xff000000
+	}
+	return RTCPReceptionReport{
+		SSRC:             binary.BigEndian.Uint32(data[0:4]),
+		FractionLost:     data[4],
+		CumulativeLost:   int32(lost),
+		HighestSequence:  binary.BigEndian.Uint32(data[8:12]),
+		Jitter:           binary.BigEndian.Uint32(data[12:16]),
+		LastSenderReport: binary.BigEndian.Uint32(data[16:20]),
+		DelaySinceLastSR: binary.BigEndian.Uint32(data[20:24]),
+	}
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+// With FixLengths, the Length of each packet is set, as well as the Count
+// of SR, RR, SDES and BYE packets.
+func (r *RTCP) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	var packets []byte
+	for i := range r.Packets {
+		var err error
+		if packets, err = r.Packets[i].appendTo(packets, opts); err != nil {
+			return err
+		}
+	}
+	data, err := b.PrependBytes(len(packets))
+	if err != nil {
+		return err
+	}
+	copy(data, packets)
+	return nil
+}
+
+func (p *RTCPPacket) appendTo(b []byte, opts gopacket.SerializeOptions) ([]byte, error) {
+	var count int
+	body := make([]byte, 0, 64)
+	switch p.Type {
+	case RTCPSenderReport, RTCPReceiverReport:
+		body = rtcpAppend32(body, p.SSRC)
+		if p.Type == RTCPSenderReport {
+			body = rtcpAppend32(body, uint32(p.SenderInfo.NTPTimestamp>>32))
+			body = rtcpAppend32(body, uint32(p.SenderInfo.NTPTimestamp))
+			body = rtcpAppend32(body, p.SenderInfo.RTPTimestamp)
+			body = rtcpAppend32(body, p.SenderInfo.PacketCount)
+			body = rtcpAppend32(body, p.SenderInfo.OctetCount)
+		}
+		for _, rr := range p.Reports {
+			body = rtcpAppend32(body, rr.SSRC)
+			body = rtcpAppend32(body, uint32(rr.FractionLost)<<24|uint32(rr.CumulativeLost)&0xffffff)
+			body = rtcpAppend32(body, rr.HighestSequence)
+			body = rtcpAppend32(body, rr.Jitter)
+			body = rtcpAppend32(body, rr.LastSenderReport)
+			body = rtcpAppend32(body, rr.DelaySinceLastSR)
+		}
+		body = append(body, p.Data...)
+		count = len(p.Reports)
+	case RTCPSourceDescription:
+		for _, chunk := range p.Chunks {
+			body = rtcpAppend32(body, chunk.Source)
+			for _, item := range chunk.Items {
+				if len(item.Text) > 0xff {
+					return b, fmt.Errorf("RTCP SDES item too long: %d bytes", len(item.Text))
+				}
+				body = append(body, byte(item.Type), byte(len(item.Text)))
+				body = append(body, item.Text...)
+			}
+			// Terminate the item list with at least one null byte.
+			body = append(body, 0)
+			for len(body)%4 != 0 {
+				body = append(body, 0)
+			}
+		}
+		count = len(p.Chunks)
+	case RTCPGoodbye:
+		for _, src := range p.Sources {
+			body = rtcpAppend32(body, src)
+		}
+		if p.Reason != "" {
+			if len(p.Reason) > 0xff {
+				return b, fmt.Errorf("RTCP BYE reason too long: %d bytes", len(p.Reason))
+			}
+			body = append(body, byte(len(p.Reason)))
+			body = append(body, p.Reason...)
+			for len(body)%4 != 0 {
+				body = append(body, 0)
+			}
+		}
+		count = len(p.Sources)
+	case RTCPApplicationDefined:
+		body = rtcpAppend32(body, p.SSRC)
+		body = append(body, p.Name[:]...)
+		body = append(body, p.Data...)
+		count = int(p.Count)
+	case RTCPTransportFeedback, RTCPPayloadFeedback:
+		body = rtcpAppend32(body, p.SSRC)
+		body = rtcpAppend32(body, p.MediaSSRC)
+		body = append(body, p.Data...)
+		count = int(p.Count)
+	case RTCPExtendedReport:
+		body = rtcpAppend32(body, p.SSRC)
+		for _, block := range p.XRBlocks {
+			if len(block.Data)%4 != 0 {
+				return b, fmt.Errorf("RTCP XR block length %d is not a multiple of 4", len(block.Data))
+			}
+			body = append(body, block.Type, block.TypeSpecific)
+			body = rtcpAppend16(body, uint16(len(block.Data)/4))
+			body = append(body, block.Data...)
+		}
+		count = int(p.Count)
+	default:
+		body = append(body, p.Data...)
+		count = int(p.Count)
+	}
+	body = append(body, p.Padding...)
+	if len(p.Padding) > 0 {
+		body[len(body)-1] = byte(len(p.Padding))
+	}
+
+	if len(body)%4 != 0 {
+		return b, fmt.Errorf("RTCP %v packet length %d is not a multiple of 4", p.Type, len(body)+4)
+	}
+	if count > 0x1f {
+		return b, fmt.Errorf("too many items in RTCP %v packet: %d", p.Type, count)
+	}
+	if opts.FixLengths {
+		p.Count = uint8(count)
+		p.Length = uint16(len(body) / 4)
+	}
+	first := p.Version<<6 | p.Count&0x1f
+	if len(p.Padding) > 0 {
+		first |= 0x20
+	}
+	b = append(b, first, byte(p.Type))
+	b = rtcpAppend16(b, p.Length)
+	return append(b, body...), nil
+}
+
+func rtcpAppend16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func rtcpAppend32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
@@ -0,0 +1,329 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket"
+)
+
+// RTP is the header of a Real-time Transport Protocol packet, as defined in
+// RFC 3550. The media it carries is the payload.
+//
+// RTP has no well-known port: its streams are negotiated, usually with SDP.
+// Use RegisterSDPMediaPorts, or RegisterUDPPortLayerType, to have the UDP
+// layer decode them.
+//
+//  0                   1                   2                   3
+//  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |V=2|P|X|  CC   |M|     PT      |       sequence number         |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                           timestamp                           |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |           synchronization source (SSRC) identifier            |
+// +=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+
+// |            contributing source (CSRC) identifiers             |
+// |                             ....                              |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+type RTP struct {
+	BaseLayer
+	Version        uint8
+	Extension      bool
+	Marker         bool
+	PayloadType    uint8
+	SequenceNumber uint16
+	Timestamp      uint32
+	SSRC           uint32
+	CSRC           []uint32
+
+	// ExtensionProfile and ExtensionData are the header extension, if
+	// Extension is set. ExtensionData is a whole number of 32-bit words.
+	ExtensionProfile uint16
+	ExtensionData    []byte
+
+	// Padding holds the padding following the payload, including its
+	// final count byte. The P bit is set if it is not empty.
+	Padding []byte
+}
+
+// RTPHeaderExtensionElement is an element of an RFC 8285 header extension.
+type RTPHeaderExtensionElement struct {
+	ID   uint8
+	Data []byte
+}
+
+// LayerType returns LayerTypeRTP.
+func (r *RTP) LayerType() gopacket.LayerType { return LayerTypeRTP }
+
+// CanDecode returns the set of layer types that this DecodingLayer can decode.
+func (r *RTP) CanDecode() gopacket.LayerClass { return LayerTypeRTP }
+
+// NextLayerType returns the layer type contained by this DecodingLayer.
+func (r *RTP) NextLayerType() gopacket.LayerType { return gopacket.LayerTypePayload }
+
+// DecodeFromBytes decodes the given bytes into this layer.
+func (r *RTP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 12 {
+		df.SetTruncated()
+		return errors.New("RTP packet too short")
+	}
+	r.Version = data[0] >> 6
+	if r.Version != 2 {
+		return fmt.Errorf("unsupported RTP version %d", r.Version)
+	}
+	padding := data[0]&0x20 != 0
+	r.Extension = data[0]&0x10 != 0
+	r.Marker = data[1]&0x80 != 0
+	r.PayloadType = data[1] & 0x7f
+	r.SequenceNumber = binary.BigEndian.Uint16(data[2:4])
+	r.Timestamp = binary.BigEndian.Uint32(data[4:8])
+	r.SSRC = binary.BigEndian.Uint32(data[8:12])
+
+	offset := 12 + 4*int(data[0]&0x0f)
+	if len(data) < offset {
+		df.SetTruncated()
+		return errors.New("RTP CSRC list truncated")
+	}
+	r.CSRC = r.CSRC[:0]
+	for i := 12; i < offset; i += 4 {
+		r.CSRC = append(r.CSRC, binary.BigEndian.Uint32(data[i:i+4]))
+	}
+
+	r.ExtensionProfile, r.ExtensionData = 0, nil
+	if r.Extension {
+		if len(data) < offset+4 {
+			df.SetTruncated()
+			return errors.New("RTP header extension truncated")
+		}
+		r.ExtensionProfile = binary.BigEndian.Uint16(data[offset : offset+2])
+		end := offset + 4 + 4*int(binary.BigEndian.Uint16(data[offset+2:offset+4]))
+		if len(data) < end {
+			df.SetTruncated()
+			return errors.New("RTP header extension truncated")
+		}
+		r.ExtensionData = data[offset+4 : end]
+		offset = end
+	}
+
+	end := len(data)
+	r.Padding = nil
+	if padding {
+		n := int(data[end-1])
+		if n == 0 || offset+n > end {
+			return fmt.Errorf("invalid RTP padding length %d", n)
+		}
+		end -= n
+		r.Padding = data[end:]
+	}
+	r.BaseLayer = BaseLayer{Contents: data[:offset], Payload: data[offset:end]}
+	return nil
+}
+
+// HeaderExtensionElements decodes the header extension as a list of RFC
+// 8285 elements, using either the one-byte or two-byte header format.
+func (r *RTP) HeaderExtensionElements() ([]RTPHeaderExtensionElement, error) {
+	if !r.Extension {
+		return nil, nil
+	}
+	twoByte := r.ExtensionProfile&0xfff0 == 0x1000
+	if r.ExtensionProfile != 0xbede && !twoByte {
+		return nil, fmt.Errorf("RTP header extension profile %#04x is not RFC 8285", r.ExtensionProfile)
+	}
+	var elements []RTPHeaderExtensionElement
+	data := r.ExtensionData
+	for len(data) > 0 {
+		var id uint8
+		var length, hdr int
+		if twoByte {
+			if data[0] == 0 {
+				data = data[1:]
+				continue
+			}
+			if len(data) < 2 {
+				return elements, errors.New("RTP header extension element truncated")
+			}
+			id, length, hdr = data[0], int(data[1]), 2
+		} else {
+			id, length, hdr = data[0]>>4, int(data[0]&0x0f)+1, 1
+			if id == 0 {
+				data = data[1:]
+				continue
+			}
+			if id == 15 {
+				break
+			}
+		}
+		if len(data) < hdr+length {
+			return elements, errors.New("RTP header extension element truncated")
+		}
+		elements = append(elements, RTPHeaderExtensionElement{ID: id, Data: data[hdr : hdr+length]})
+		data = data[hdr+length:]
+	}
+	return elements, nil
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+// See the docs for gopacket.SerializableLayer for more info.
+func (r *RTP) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	if len(r.CSRC) > 15 {
+		return fmt.Errorf("too many RTP CSRC identifiers: %d", len(r.CSRC))
+	}
+	if len(r.ExtensionData)%4 != 0 || len(r.ExtensionData) > 4*0xffff {
+		return fmt.Errorf("invalid RTP header extension length %d", len(r.ExtensionData))
+	}
+	if len(r.Padding) > 0xff {
+		return fmt.Errorf("invalid RTP padding length %d", len(r.Padding))
+	}
+	if len(r.Padding) > 0 {
+		padding, err := b.AppendBytes(len(r.Padding))
+		if err != nil {
+			return err
+		}
+		copy(padding, r.Padding)
+		padding[len(padding)-1] = uint8(len(padding))
+	}
+
+	size := 12 + 4*len(r.CSRC)
+	if r.Extension {
+		size += 4 + len(r.ExtensionData)
+	}
+	data, err := b.PrependBytes(size)
+	if err != nil {
+		return err
+	}
+	data[0] = r.Version<<6 | uint8(len(r.CSRC))
+	if len(r.Padding) > 0 {
+		data[0] |= 0x20
+	}
+	if r.Extension {
+		data[0] |= 0x10
+	}
+	data[1] = r.PayloadType & 0x7f
+	if r.Marker {
+		data[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(data[2:4], r.SequenceNumber)
+	binary.BigEndian.PutUint32(data[4:8], r.Timestamp)
+	binary.BigEndian.PutUint32(data[8:12], r.SSRC)
+	offset := 12
+	for _, csrc := range r.CSRC {
+		binary.BigEndian.PutUint32(data[offset:], csrc)
+		offset += 4
+	}
+	if r.Extension {
+		binary.BigEndian.PutUint16(data[offset:], r.ExtensionProfile)
+		binary.BigEndian.PutUint16(data[offset+2:], uint16(len(r.ExtensionData)/4))
+		copy(data[offset+4:], r.ExtensionData)
+	}
+	return nil
+}
+
+// decodeRTP decodes an RTP packet, or an RTCP packet sharing its port as
+// allowed by RFC 5761, which it tells apart by the packet type.
+func decodeRTP(data []byte, p gopacket.PacketBuilder) error {
+	if len(data) >= 2 && data[1] >= 192 && data[1] <= 223 {
+		return decodeRTCP(data, p)
+	}
+	r := &RTP{}
+	return decodingLayerDecoder(r, data, p)
+}
+
+// SDPMaxMediaPorts is the largest number of ports of a media description
+// registered by RegisterSDPMediaPorts.
+const SDPMaxMediaPorts = 64
+
+// SDPPortRegistration is a UDP port associated with RTP or RTCP by
+// RegisterSDPMediaPorts.
+type SDPPortRegistration struct {
+	Port      UDPPort
+	LayerType gopacket.LayerType
+	// Previous is the layer type the port was associated with before, or
+	// gopacket.LayerTypeZero.
+	Previous gopacket.LayerType
+}
+
+// RegisterSDPMediaPorts associates the UDP ports negotiated for the RTP
+// media of s with LayerTypeRTP and LayerTypeRTCP, using
+// RegisterUDPPortLayerType, so that the streams of a call set up with SIP
+// get decoded. RTCP uses the port of the rtcp attribute, if there is one,
+// and the port following the RTP one otherwise; with the rtcp-mux
+// attribute, it shares the RTP port.
+//
+// Ports already associated with another layer type than RTP or RTCP, like
+// the well-known ports, are left alone, since SDP read from the network
+// can't be trusted. For the same reason, media with more than
+// SDPMaxMediaPorts ports are ignored. It returns the ports it registered,
+// which UnregisterSDPMediaPorts restores once the call is over.
+//
+// Like RegisterUDPPortLayerType, it is not safe to call while packets are
+// decoded by other goroutines.
+func RegisterSDPMediaPorts(s *SDP) []SDPPortRegistration {
+	var regs []SDPPortRegistration
+	register := func(port int, layerType gopacket.LayerType) {
+		if port <= 0 || port > 0xffff {
+			return
+		}
+		prev := udpPortLayerType[port]
+		if prev != 0 && prev != LayerTypeRTP && prev != LayerTypeRTCP {
+			return
+		}
+		RegisterUDPPortLayerType(UDPPort(port), layerType)
+		regs = append(regs, SDPPortRegistration{Port: UDPPort(port), LayerType: layerType, Previous: prev})
+	}
+	for i := range s.Media {
+		m := &s.Media[i]
+		if m.Port == 0 || !strings.Contains(m.Protocol, "RTP") || strings.Contains(m.Protocol, "TCP") {
+			continue
+		}
+		_, mux := m.Attribute("rtcp-mux")
+		n := m.NumberOfPorts
+		if n <= 0 {
+			n = 1
+		} else if n > SDPMaxMediaPorts {
+			continue
+		}
+		for j := 0; j < n; j++ {
+			rtp := m.Port + 2*j
+			if rtp > 0xffff {
+				break
+			}
+			register(rtp, LayerTypeRTP)
+			if mux {
+				continue
+			}
+			rtcp := rtp + 1
+			if attr, ok := m.Attribute("rtcp"); ok && j == 0 {
+				if f := strings.Fields(attr); len(f) > 0 {
+					if port, err := strconv.Atoi(f[0]); err == nil {
+						rtcp = port
+					}
+				}
+			}
+			register(rtcp, LayerTypeRTCP)
+		}
+	}
+	return regs
+}
+
+// UnregisterSDPMediaPorts restores the layer types of the ports registered
+// by RegisterSDPMediaPorts. Registrations of several calls must be undone in
+// the reverse order.
+//
+// Like RegisterUDPPortLayerType, it is not safe to call while packets are
+// decoded by other goroutines.
+func UnregisterSDPMediaPorts(regs []SDPPortRegistration) {
+	for i := len(regs) - 1; i >= 0; i-- {
+		RegisterUDPPortLayerType(regs[i].Port, regs[i].Previous)
+	}
+}
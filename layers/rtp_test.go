@@ -0,0 +1,314 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// testRTPPacket is a PCMU packet with a CSRC, a one-byte header extension
+// carrying an audio level element, and 4 bytes of padding.
+var testRTPPacket = []byte{
+	0xb1, 0x80, 0x12, 0x34, // V=2, P, X, CC=1, M, PT=0, seq
+	0x00, 0x00, 0x1f, 0x40, // timestamp
+	0xca, 0xfe, 0xba, 0xbe, // SSRC
+	0x01, 0x02, 0x03, 0x04, // CSRC
+	0xbe, 0xde, 0x00, 0x01, // one-byte extension, 1 word
+	0x10, 0x85, 0x00, 0x00, // ID 1, length 1, then padding
+	0xff, 0xff, 0x7f, 0x7f, 0xff, 0xff, // payload
+	0x00, 0x00, 0x00, 0x04, // padding
+}
+
+func TestRTPDecode(t *testing.T) {
+	var r RTP
+	if err := r.DecodeFromBytes(testRTPPacket, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if r.Version != 2 || !r.Marker || r.PayloadType != 0 || r.SequenceNumber != 0x1234 ||
+		r.Timestamp != 8000 || r.SSRC != 0xcafebabe || !reflect.DeepEqual(r.CSRC, []uint32{0x01020304}) {
+		t.Errorf("got header %+v", r)
+	}
+	if !bytes.Equal(r.Payload, testRTPPacket[24:30]) || len(r.Padding) != 4 {
+		t.Errorf("got payload %x, padding %x", r.Payload, r.Padding)
+	}
+	elements, err := r.HeaderExtensionElements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RTPHeaderExtensionElement{{ID: 1, Data: []byte{0x85}}}
+	if !reflect.DeepEqual(elements, want) {
+		t.Errorf("got extension elements %+v, want %+v", elements, want)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, &r, gopacket.Payload(r.Payload)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), testRTPPacket) {
+		t.Errorf("got\n%x\nwant\n%x", buf.Bytes(), testRTPPacket)
+	}
+}
+
+func TestRTPDecodeInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		testRTPPacket[:11],
+		testRTPPacket[:14],
+		testRTPPacket[:22],
+		{0x40, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0xa0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x20},
+	} {
+		var r RTP
+		if err := r.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err == nil {
+			t.Errorf("no error decoding %x", data)
+		}
+	}
+}
+
+// testRTCPPacket is a compound packet made of a receiver report, a source
+// description and a goodbye.
+var testRTCPPacket = []byte{
+	0x81, 0xc9, 0x00, 0x07, 0x12, 0x34, 0x56, 0x78,
+	0xde, 0xad, 0xbe, 0xef, 0x01, 0xff, 0xff, 0xfe,
+	0x00, 0x01, 0x00, 0x10, 0x00, 0x00, 0x00, 0x20,
+	0x11, 0x11, 0x22, 0x22, 0x00, 0x00, 0x33, 0x33,
+	0x81, 0xca, 0x00, 0x04, 0x12, 0x34, 0x56, 0x78,
+	0x01, 0x07, 'h', 'o', 's', 't', '@', 'a',
+	'1', 0x00, 0x00, 0x00,
+	0x81, 0xcb, 0x00, 0x03, 0x12, 0x34, 0x56, 0x78,
+	0x04, 'd', 'o', 'n', 'e', 0x00, 0x00, 0x00,
+}
+
+func TestRTCPDecode(t *testing.T) {
+	var r RTCP
+	if err := r.DecodeFromBytes(testRTCPPacket, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Packets) != 3 {
+		t.Fatalf("got %d packets", len(r.Packets))
+	}
+	rr, sdes, bye := r.Packets[0], r.Packets[1], r.Packets[2]
+	wantReport := RTCPReceptionReport{
+		SSRC:             0xdeadbeef,
+		FractionLost:     1,
+		CumulativeLost:   -2,
+		HighestSequence:  0x10010,
+		Jitter:           0x20,
+		LastSenderReport: 0x11112222,
+		DelaySinceLastSR: 0x3333,
+	}
+	if rr.Type != RTCPReceiverReport || rr.SSRC != 0x12345678 || len(rr.Reports) != 1 || rr.Reports[0] != wantReport {
+		t.Errorf("got RR %+v", rr)
+	}
+	wantChunks := []RTCPSDESChunk{{Source: 0x12345678, Items: []RTCPSDESItem{{Type: RTCPSDESCNAME, Text: "host@a1"}}}}
+	if sdes.Type != RTCPSourceDescription || !reflect.DeepEqual(sdes.Chunks, wantChunks) {
+		t.Errorf("got SDES %+v", sdes)
+	}
+	if bye.Type != RTCPGoodbye || !reflect.DeepEqual(bye.Sources, []uint32{0x12345678}) || bye.Reason != "done" {
+		t.Errorf("got BYE %+v", bye)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := r.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), testRTCPPacket) {
+		t.Errorf("got\n%x\nwant\n%x", buf.Bytes(), testRTCPPacket)
+	}
+}
+
+func TestRTCPSerialize(t *testing.T) {
+	r := &RTCP{Packets: []RTCPPacket{
+		{
+			Version: 2,
+			Type:    RTCPSenderReport,
+			SSRC:    1,
+			SenderInfo: RTCPSenderInfo{
+				NTPTimestamp: 0xe000000080000000,
+				RTPTimestamp: 160,
+				PacketCount:  1,
+				OctetCount:   160,
+			},
+			Reports: []RTCPReceptionReport{{SSRC: 2, CumulativeLost: 5}, {SSRC: 3}},
+		},
+		{Version: 2, Type: RTCPApplicationDefined, Count: 3, SSRC: 1, Name: [4]byte{'t', 'e', 's', 't'}, Data: []byte{1, 2, 3, 4}},
+		{Version: 2, Type: RTCPPayloadFeedback, Count: 1, SSRC: 1, MediaSSRC: 2},
+		{Version: 2, Type: RTCPExtendedReport, SSRC: 1, XRBlocks: []RTCPXRBlock{{Type: 4, Data: []byte{0, 0, 0, 1, 2, 3, 4, 5}}}},
+		{Version: 2, Type: RTCPGoodbye, Sources: []uint32{1}, Padding: []byte{0, 0, 0, 0}},
+	}}
+	buf := gopacket.NewSerializeBuffer()
+	if err := r.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	var got RTCP
+	if err := got.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Packets) != len(r.Packets) {
+		t.Fatalf("got %d packets", len(got.Packets))
+	}
+	for i := range r.Packets {
+		want, p := r.Packets[i], got.Packets[i]
+		if want.Type == RTCPGoodbye {
+			// The padding count byte is set on serialization.
+			want.Padding = []byte{0, 0, 0, 4}
+		}
+		if !reflect.DeepEqual(p, want) {
+			t.Errorf("packet %d: got %+v, want %+v", i, p, want)
+		}
+	}
+}
+
+func TestRTCPGoodbyeLongReason(t *testing.T) {
+	reason := string(bytes.Repeat([]byte{'x'}, 0xff))
+	r := &RTCP{Packets: []RTCPPacket{{Version: 2, Type: RTCPGoodbye, Sources: []uint32{1}, Reason: reason}}}
+	buf := gopacket.NewSerializeBuffer()
+	if err := r.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	var got RTCP
+	if err := got.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Packets) != 1 || got.Packets[0].Reason != reason {
+		t.Errorf("got %+v", got.Packets)
+	}
+}
+
+func TestRTPPortRegistrationLimits(t *testing.T) {
+	sdp := &SDP{Media: []SDPMedia{
+		// Would take over all the ports above 40000
+		{Type: "audio", Port: 40000, NumberOfPorts: 400000000, Protocol: "RTP/AVP", Formats: []string{"0"}},
+		// Stops at the last port
+		{Type: "audio", Port: 65532, NumberOfPorts: 4, Protocol: "RTP/AVP", Formats: []string{"0"}},
+	}}
+	regs := RegisterSDPMediaPorts(sdp)
+	defer UnregisterSDPMediaPorts(regs)
+	var ports []UDPPort
+	for _, r := range regs {
+		ports = append(ports, r.Port)
+	}
+	if want := []UDPPort{65532, 65533, 65534, 65535}; !reflect.DeepEqual(ports, want) {
+		t.Errorf("registered ports %v, want %v", ports, want)
+	}
+	if got := UDPPort(40000).LayerType(); got != gopacket.LayerTypePayload {
+		t.Errorf("port 40000: got %v", got)
+	}
+}
+
+func TestRTPPortRegistration(t *testing.T) {
+	sdp := &SDP{
+		Connection: &SDPConnection{NetworkType: "IN", AddressType: "IP4", Address: "192.0.2.1"},
+		Media: []SDPMedia{
+			{Type: "audio", Port: 40000, Protocol: "RTP/AVP", Formats: []string{"0"}},
+			{Type: "video", Port: 40010, Protocol: "RTP/SAVPF", Formats: []string{"96"}, Attributes: []SDPAttribute{{Key: "rtcp-mux"}}},
+			{Type: "audio", Port: 40020, Protocol: "RTP/AVP", Formats: []string{"8"}, Attributes: []SDPAttribute{{Key: "rtcp", Value: "40031 IN IP4 192.0.2.1"}}},
+			{Type: "application", Port: 40040, Protocol: "UDP/DTLS/SCTP", Formats: []string{"webrtc-datachannel"}},
+			// Well-known ports are not taken over
+			{Type: "audio", Port: 52, Protocol: "RTP/AVP", Formats: []string{"0"}},
+		},
+	}
+	regs := RegisterSDPMediaPorts(sdp)
+	want := map[UDPPort]gopacket.LayerType{
+		40000: LayerTypeRTP, 40001: LayerTypeRTCP,
+		40010: LayerTypeRTP, 40011: gopacket.LayerTypePayload,
+		40020: LayerTypeRTP, 40021: gopacket.LayerTypePayload, 40031: LayerTypeRTCP,
+		40040: gopacket.LayerTypePayload,
+		52:    LayerTypeRTP, 53: LayerTypeDNS,
+	}
+	for port, lt := range want {
+		if got := port.LayerType(); got != lt {
+			t.Errorf("port %d: got %v, want %v", port, got, lt)
+		}
+	}
+	if len(regs) != 6 {
+		t.Errorf("got %d ports registered: %v", len(regs), regs)
+	}
+
+	// A second offer for the same ports is undone without losing the
+	// first one.
+	again := RegisterSDPMediaPorts(&SDP{Media: []SDPMedia{{Type: "audio", Port: 40000, Protocol: "RTP/AVP", Attributes: []SDPAttribute{{Key: "rtcp-mux"}}}}})
+	if len(again) != 1 || again[0].Previous != LayerTypeRTP {
+		t.Errorf("got second registration %v", again)
+	}
+	UnregisterSDPMediaPorts(again)
+	if got := UDPPort(40000).LayerType(); got != LayerTypeRTP {
+		t.Errorf("port 40000: got %v after undoing the second offer", got)
+	}
+
+	// RTCP multiplexed on the RTP port is told apart by its packet type.
+	for _, test := range []struct {
+		payload []byte
+		want    gopacket.LayerType
+	}{
+		{testRTPPacket, LayerTypeRTP},
+		{testRTCPPacket, LayerTypeRTCP},
+	} {
+		ip := &IPv4{Version: 4, TTL: 64, Protocol: IPProtocolUDP, SrcIP: net.IP{192, 0, 2, 2}, DstIP: net.IP{192, 0, 2, 1}}
+		udp := &UDP{SrcPort: 50000, DstPort: 40010}
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, ip, udp, gopacket.Payload(test.payload)); err != nil {
+			t.Fatal(err)
+		}
+		p := gopacket.NewPacket(buf.Bytes(), LayerTypeIPv4, gopacket.Default)
+		if p.ErrorLayer() != nil {
+			t.Fatal(p.ErrorLayer().Error())
+		}
+		if p.Layer(test.want) == nil {
+			t.Errorf("no %v layer in %v", test.want, p)
+		}
+	}
+
+	UnregisterSDPMediaPorts(regs)
+	for port, lt := range map[UDPPort]gopacket.LayerType{40000: gopacket.LayerTypePayload, 40031: gopacket.LayerTypePayload, 53: LayerTypeDNS} {
+		if got := port.LayerType(); got != lt {
+			t.Errorf("port %d: got %v after unregistering, want %v", port, got, lt)
+		}
+	}
+}
+
+func TestRTPStats(t *testing.T) {
+	stats := NewRTPStats()
+	start := time.Unix(1500000000, 0)
+	seqs := []uint16{65530, 65531, 65532, 65534, 65533, 65535, 0, 1, 1, 4, 5}
+	for i, seq := range seqs {
+		r := &RTP{Version: 2, PayloadType: 0, SequenceNumber: seq, SSRC: 42, Timestamp: uint32(seq-seqs[0]) * 160}
+		// Packets are sent every 20ms and arrive with a 1ms jitter.
+		arrival := start.Add(time.Duration(int(seq-seqs[0])) * 20 * time.Millisecond)
+		if i%2 == 1 {
+			arrival = arrival.Add(time.Millisecond)
+		}
+		stats.Update(r, arrival)
+	}
+	s := stats.Streams[42]
+	if s.Packets != uint64(len(seqs)) || s.ClockRate != 8000 {
+		t.Errorf("got %d packets at %dHz", s.Packets, s.ClockRate)
+	}
+	// The first packet validates the stream and is not counted, so 65531
+	// to 5 are expected; 2 and 3 are lost, and 1 is a duplicate.
+	if s.Expected() != 11 || s.Lost() != 1 || s.ExtendedHighestSequence() != 1<<16+5 {
+		t.Errorf("expected %d, lost %d, highest %d", s.Expected(), s.Lost(), s.ExtendedHighestSequence())
+	}
+	if s.Gaps != 2 || s.Reordered != 1 {
+		t.Errorf("got %d gaps and %d reordered packets", s.Gaps, s.Reordered)
+	}
+	if j := s.JitterDuration(); j <= 0 || j > time.Millisecond {
+		t.Errorf("got jitter %v", j)
+	}
+	report := s.ReceptionReport()
+	if report.SSRC != 42 || report.CumulativeLost != 1 || report.FractionLost != 1*256/11 || report.HighestSequence != 1<<16+5 {
+		t.Errorf("got report %+v", report)
+	}
+	if report = s.ReceptionReport(); report.FractionLost != 0 {
+		t.Errorf("got fraction lost %d without new packets", report.FractionLost)
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"time"
+)
+
+// Sequence number thresholds of RFC 3550 appendix A.1.
+const (
+	rtpMaxDropout    = 3000
+	rtpMaxMisorder   = 100
+	rtpMinSequential = 2
+)
+
+// RTPStreamStats holds the reception statistics of the RTP packets of one
+// synchronization source, computed as RFC 3550 appendix A describes.
+type RTPStreamStats struct {
+	SSRC uint32
+	// ClockRate is the RTP timestamp rate, in Hz, used to compute the
+	// jitter. The jitter is not computed while it is zero.
+	ClockRate int
+
+	Packets     uint64 // packets received, including duplicates
+	Bytes       uint64 // payload bytes received
+	Gaps        uint64 // times packets were found to be missing
+	Reordered   uint64 // packets older than the highest sequence number
+	Resyncs     uint64 // times the sequence numbers jumped and were resynchronized
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	PayloadType uint8 // payload type of the last packet
+
+	baseSeq       uint32
+	maxSeq        uint16
+	cycles        uint32
+	badSeq        uint32
+	probation     int
+	received      uint64
+	expectedPrior uint64
+	receivedPrior uint64
+	transit       int64
+	jitter        float64
+}
+
+// ExtendedHighestSequence returns the highest sequence number received,
+// extended with the number of sequence number cycles.
+func (s *RTPStreamStats) ExtendedHighestSequence() uint32 {
+	return s.cycles + uint32(s.maxSeq)
+}
+
+// Expected returns the number of packets expected from the sequence
+// numbers received.
+func (s *RTPStreamStats) Expected() uint64 {
+	if s.received == 0 {
+		return 0
+	}
+	return uint64(s.ExtendedHighestSequence()) - uint64(s.baseSeq) + 1
+}
+
+// Lost returns the number of packets lost. It is negative if duplicates
+// were received.
+func (s *RTPStreamStats) Lost() int64 {
+	return int64(s.Expected()) - int64(s.received)
+}
+
+// Jitter returns the interarrival jitter estimate, in RTP timestamp units.
+func (s *RTPStreamStats) Jitter() float64 {
+	return s.jitter
+}
+
+// JitterDuration returns the interarrival jitter estimate as a duration,
+// or 0 if ClockRate is not known.
+func (s *RTPStreamStats) JitterDuration() time.Duration {
+	if s.ClockRate <= 0 {
+		return 0
+	}
+	return time.Duration(s.jitter / float64(s.ClockRate) * float64(time.Second))
+}
+
+// Update accounts for an RTP packet of the stream received at the given
+// time, and reports whether it was counted as valid. As in RFC 3550, a
+// stream is only counted once two packets with sequential sequence numbers
+// have been received, and so is a stream whose sequence numbers made a
+// large jump.
+func (s *RTPStreamStats) Update(r *RTP, arrival time.Time) bool {
+	s.LastSeen = arrival
+	if s.Packets == 0 && s.probation == 0 {
+		s.FirstSeen = arrival
+		s.initSequence(r.SequenceNumber)
+		s.maxSeq = r.SequenceNumber - 1
+		s.probation = rtpMinSequential
+	}
+	s.Packets++
+	s.PayloadType = r.PayloadType
+	if !s.updateSequence(r.SequenceNumber) {
+		return false
+	}
+	s.Bytes += uint64(len(r.Payload))
+	s.updateJitter(r.Timestamp, arrival)
+	return true
+}
+
+func (s *RTPStreamStats) initSequence(seq uint16) {
+	s.baseSeq = uint32(seq)
+	s.maxSeq = seq
+	s.badSeq = 1<<16 + 1 // so that seq == badSeq is false
+	s.cycles = 0
+	s.received = 0
+	s.receivedPrior = 0
+	s.expectedPrior = 0
+}
+
+// updateSequence is the update_seq function of RFC 3550 appendix A.1.
+func (s *RTPStreamStats) updateSequence(seq uint16) bool {
+	delta := seq - s.maxSeq
+
+	// A source is not valid until rtpMinSequential packets with
+	// sequential sequence numbers have been received.
+	if s.probation > 0 {
+		if seq == s.maxSeq+1 {
+			s.probation--
+			s.maxSeq = seq
+			if s.probation == 0 {
+				s.initSequence(seq)
+				s.received++
+				return true
+			}
+		} else {
+			s.probation = rtpMinSequential - 1
+			s.maxSeq = seq
+		}
+		return false
+	}
+
+	switch {
+	case delta == 0:
+		// Duplicate of the last packet.
+		s.received++
+	case delta < rtpMaxDropout:
+		if delta > 1 {
+			s.Gaps++
+		}
+		if seq < s.maxSeq {
+			s.cycles += 1 << 16
+		}
+		s.maxSeq = seq
+		s.received++
+	case delta <= 1<<16-rtpMaxMisorder:
+		// The sequence number made a very large jump.
+		if uint32(seq) == s.badSeq {
+			// Two sequential packets: assume that the other side
+			// restarted without telling us.
+			s.Resyncs++
+			s.initSequence(seq)
+			s.received++
+			return true
+		}
+		s.badSeq = uint32(seq+1) & 0xffff
+		return false
+	default:
+		// Duplicate or reordered packet.
+		s.Reordered++
+		s.received++
+	}
+	return true
+}
+
+// updateJitter is the jitter computation of RFC 3550 appendix A.8.
+func (s *RTPStreamStats) updateJitter(timestamp uint32, arrival time.Time) {
+	if s.ClockRate <= 0 {
+		return
+	}
+	ns := arrival.UnixNano()
+	units := ns/int64(time.Second)*int64(s.ClockRate) + ns%int64(time.Second)*int64(s.ClockRate)/int64(time.Second)
+	transit := units - int64(timestamp)
+	if s.received > 1 {
+		d := transit - s.transit
+		if d < 0 {
+			d = -d
+		}
+		s.jitter += (float64(d) - s.jitter) / 16
+	}
+	s.transit = transit
+}
+
+// ReceptionReport returns an RTCP reception report block for the stream,
+// with the fraction of packets lost since the previous call as RFC 3550
+// appendix A.3 computes it. The fields about sender reports are left
+// empty.
+func (s *RTPStreamStats) ReceptionReport() RTCPReceptionReport {
+	expected := s.Expected()
+	expectedInterval := expected - s.expectedPrior
+	receivedInterval := s.received - s.receivedPrior
+	s.expectedPrior, s.receivedPrior = expected, s.received
+
+	var fraction uint8
+	if lostInterval := int64(expectedInterval) - int64(receivedInterval); expectedInterval != 0 && lostInterval > 0 {
+		fraction = uint8((lostInterval << 8) / int64(expectedInterval))
+	}
+	lost := s.Lost()
+	if lost > 0x7fffff {
+		lost = 0x7fffff
+	} else if lost < -0x800000 {
+		lost = -0x800000
+	}
+	return RTCPReceptionReport{
+		SSRC:            s.SSRC,
+		FractionLost:    fraction,
+		CumulativeLost:  int32(lost),
+		HighestSequence: s.ExtendedHighestSequence(),
+		Jitter:          uint32(s.jitter),
+	}
+}
+
+// RTPStats tracks the reception statistics of RTP streams, one per SSRC.
+type RTPStats struct {
+	// Streams holds the statistics of each SSRC seen.
+	Streams map[uint32]*RTPStreamStats
+	// ClockRates maps dynamic payload types to their RTP clock rate,
+	// usually from the SDPCodec list of the media. The static payload
+	// types of RFC 3551 are known already.
+	ClockRates map[uint8]int
+}
+
+// NewRTPStats returns an empty RTPStats.
+func NewRTPStats() *RTPStats {
+	return &RTPStats{
+		Streams:    make(map[uint32]*RTPStreamStats),
+		ClockRates: make(map[uint8]int),
+	}
+}
+
+// AddCodecs records the clock rates of the given codecs, as returned by
+// SDPMedia.Codecs.
+func (s *RTPStats) AddCodecs(codecs []SDPCodec) {
+	for _, c := range codecs {
+		if c.ClockRate > 0 {
+			s.ClockRates[c.PayloadType] = c.ClockRate
+		}
+	}
+}
+
+// Update accounts for an RTP packet received at the given time, usually
+// the timestamp of its gopacket.CaptureInfo, and returns the statistics of
+// its stream.
+func (s *RTPStats) Update(r *RTP, arrival time.Time) *RTPStreamStats {
+	stream, ok := s.Streams[r.SSRC]
+	if !ok {
+		stream = &RTPStreamStats{SSRC: r.SSRC}
+		s.Streams[r.SSRC] = stream
+	}
+	if rate, ok := s.ClockRates[r.PayloadType]; ok {
+		stream.ClockRate = rate
+	} else if c, ok := sdpStaticCodecs[r.PayloadType]; ok {
+		stream.ClockRate = c.ClockRate
+	}
+	stream.Update(r, arrival)
+	return stream
+}
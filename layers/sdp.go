@@ -0,0 +1,518 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket"
+)
+
+// SDP is a Session Description Protocol message, as defined in RFC 4566.
+// It is usually found in the body of SIP INVITE requests and their answers,
+// where it describes the media streams of a call.
+//
+// Session level fields are decoded into the SDP struct itself, and each
+// media description ("m=" line and the lines following it) into an entry
+// of Media.
+type SDP struct {
+	BaseLayer
+
+	Version       int            // v=
+	Origin        SDPOrigin      // o=
+	SessionName   string         // s=
+	Information   string         // i=
+	URI           string         // u=
+	Emails        []string       // e=
+	Phones        []string       // p=
+	Connection    *SDPConnection // c=
+	Bandwidths    []SDPBandwidth // b=
+	Timings       []SDPTiming    // t= and r=
+	TimeZones     string         // z=
+	EncryptionKey string         // k=
+	Attributes    []SDPAttribute // a=
+	Media         []SDPMedia     // m= and the lines following it
+}
+
+// SDPOrigin is the originator and identifier of a session description.
+type SDPOrigin struct {
+	Username       string
+	SessionID      uint64
+	SessionVersion uint64
+	NetworkType    string
+	AddressType    string
+	Address        string
+}
+
+// SDPConnection holds connection data, the address media is sent to.
+// TTL and NumberOfAddresses are only set for multicast addresses.
+type SDPConnection struct {
+	NetworkType       string
+	AddressType       string
+	Address           string
+	TTL               int
+	NumberOfAddresses int
+}
+
+// IP returns the connection address as an IP, or nil if it is a host name.
+func (c SDPConnection) IP() net.IP {
+	return net.ParseIP(c.Address)
+}
+
+// SDPBandwidth is a proposed bandwidth, in kilobits per second for the
+// standard CT and AS types.
+type SDPBandwidth struct {
+	Type  string
+	Value int
+}
+
+// SDPTiming is the start and stop time of a session, in NTP seconds, with
+// the repeat times that follow it, which are kept verbatim.
+type SDPTiming struct {
+	Start   uint64
+	Stop    uint64
+	Repeats []string
+}
+
+// SDPAttribute is an "a=" line. Property attributes, like "sendrecv", have
+// no value.
+type SDPAttribute struct {
+	Key   string
+	Value string
+}
+
+// SDPMedia is a media description.
+type SDPMedia struct {
+	Type          string // audio, video, application...
+	Port          int
+	NumberOfPorts int // 0 unless a port count was given
+	Protocol      string
+	Formats       []string
+	Information   string
+	Connections   []SDPConnection
+	Bandwidths    []SDPBandwidth
+	EncryptionKey string
+	Attributes    []SDPAttribute
+}
+
+// SDPCodec is a media format of an RTP media description, from its
+// rtpmap and fmtp attributes or from the static payload types of RFC 3551.
+type SDPCodec struct {
+	PayloadType uint8
+	Name        string
+	ClockRate   int
+	Channels    int    // 0 if not given
+	Parameters  string // the fmtp attribute
+}
+
+// sdpStaticCodecs are the static RTP payload types of RFC 3551, which may
+// be used without an rtpmap attribute.
+var sdpStaticCodecs = map[uint8]SDPCodec{
+	0:  {PayloadType: 0, Name: "PCMU", ClockRate: 8000, Channels: 1},
+	3:  {PayloadType: 3, Name: "GSM", ClockRate: 8000, Channels: 1},
+	4:  {PayloadType: 4, Name: "G723", ClockRate: 8000, Channels: 1},
+	5:  {PayloadType: 5, Name: "DVI4", ClockRate: 8000, Channels: 1},
+	6:  {PayloadType: 6, Name: "DVI4", ClockRate: 16000, Channels: 1},
+	7:  {PayloadType: 7, Name: "LPC", ClockRate: 8000, Channels: 1},
+	8:  {PayloadType: 8, Name: "PCMA", ClockRate: 8000, Channels: 1},
+	9:  {PayloadType: 9, Name: "G722", ClockRate: 8000, Channels: 1},
+	10: {PayloadType: 10, Name: "L16", ClockRate: 44100, Channels: 2},
+	11: {PayloadType: 11, Name: "L16", ClockRate: 44100, Channels: 1},
+	12: {PayloadType: 12, Name: "QCELP", ClockRate: 8000, Channels: 1},
+	13: {PayloadType: 13, Name: "CN", ClockRate: 8000, Channels: 1},
+	14: {PayloadType: 14, Name: "MPA", ClockRate: 90000},
+	15: {PayloadType: 15, Name: "G728", ClockRate: 8000, Channels: 1},
+	16: {PayloadType: 16, Name: "DVI4", ClockRate: 11025, Channels: 1},
+	17: {PayloadType: 17, Name: "DVI4", ClockRate: 22050, Channels: 1},
+	18: {PayloadType: 18, Name: "G729", ClockRate: 8000, Channels: 1},
+	25: {PayloadType: 25, Name: "CelB", ClockRate: 90000},
+	26: {PayloadType: 26, Name: "JPEG", ClockRate: 90000},
+	28: {PayloadType: 28, Name: "nv", ClockRate: 90000},
+	31: {PayloadType: 31, Name: "H261", ClockRate: 90000},
+	32: {PayloadType: 32, Name: "MPV", ClockRate: 90000},
+	33: {PayloadType: 33, Name: "MP2T", ClockRate: 90000},
+	34: {PayloadType: 34, Name: "H263", ClockRate: 90000},
+}
+
+// LayerType returns LayerTypeSDP.
+func (s *SDP) LayerType() gopacket.LayerType { return LayerTypeSDP }
+
+// CanDecode returns the set of layer types that this DecodingLayer can decode.
+func (s *SDP) CanDecode() gopacket.LayerClass { return LayerTypeSDP }
+
+// NextLayerType returns the layer type contained by this DecodingLayer.
+func (s *SDP) NextLayerType() gopacket.LayerType { return gopacket.LayerTypeZero }
+
+// Attribute returns the value of the first session level attribute with the
+// given key, and whether there is one.
+func (s *SDP) Attribute(key string) (string, bool) {
+	return sdpAttribute(s.Attributes, key)
+}
+
+// MediaConnection returns the connection data that applies to m: its own
+// first "c=" line if it has one, and the session level one otherwise.
+func (s *SDP) MediaConnection(m *SDPMedia) *SDPConnection {
+	if len(m.Connections) > 0 {
+		return &m.Connections[0]
+	}
+	return s.Connection
+}
+
+// MediaDirection returns the direction attribute (sendrecv, sendonly,
+// recvonly or inactive) that applies to m, which defaults to sendrecv.
+func (s *SDP) MediaDirection(m *SDPMedia) string {
+	for _, attrs := range [][]SDPAttribute{m.Attributes, s.Attributes} {
+		for _, a := range attrs {
+			switch a.Key {
+			case "sendrecv", "sendonly", "recvonly", "inactive":
+				return a.Key
+			}
+		}
+	}
+	return "sendrecv"
+}
+
+// Attribute returns the value of the first attribute of the media
+// description with the given key, and whether there is one.
+func (m *SDPMedia) Attribute(key string) (string, bool) {
+	return sdpAttribute(m.Attributes, key)
+}
+
+// Codecs returns the codecs of an RTP media description, in order of
+// preference. Formats which are neither static payload types nor described
+// by an rtpmap attribute are returned with only their payload type set.
+func (m *SDPMedia) Codecs() []SDPCodec {
+	if !strings.Contains(m.Protocol, "RTP") {
+		return nil
+	}
+	codecs := make([]SDPCodec, 0, len(m.Formats))
+	for _, f := range m.Formats {
+		pt, err := strconv.ParseUint(f, 10, 7)
+		if err != nil {
+			continue
+		}
+		c, ok := sdpStaticCodecs[uint8(pt)]
+		if !ok {
+			c = SDPCodec{PayloadType: uint8(pt)}
+		}
+		for _, a := range m.Attributes {
+			value := strings.TrimPrefix(a.Value, f+" ")
+			if len(value) == len(a.Value) {
+				continue
+			}
+			switch a.Key {
+			case "rtpmap":
+				// <encoding name>/<clock rate>[/<encoding parameters>]
+				parts := strings.Split(strings.TrimSpace(value), "/")
+				c.Name, c.ClockRate, c.Channels = parts[0], 0, 0
+				if len(parts) > 1 {
+					c.ClockRate, _ = strconv.Atoi(parts[1])
+				}
+				if len(parts) > 2 {
+					c.Channels, _ = strconv.Atoi(parts[2])
+				}
+			case "fmtp":
+				c.Parameters = strings.TrimSpace(value)
+			}
+		}
+		codecs = append(codecs, c)
+	}
+	return codecs
+}
+
+func sdpAttribute(attrs []SDPAttribute, key string) (string, bool) {
+	for _, a := range attrs {
+		if a.Key == key {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+func decodeSDP(data []byte, p gopacket.PacketBuilder) error {
+	s := &SDP{}
+	err := s.DecodeFromBytes(data, p)
+	if err != nil {
+		return err
+	}
+	p.AddLayer(s)
+	return nil
+}
+
+// DecodeFromBytes decodes the slice into the SDP struct.
+func (s *SDP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	*s = SDP{BaseLayer: BaseLayer{Contents: data}}
+	var media *SDPMedia
+	for n, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimRight(line, "\r")
+		if len(line) == 0 {
+			continue
+		}
+		if len(line) < 2 || line[1] != '=' {
+			return fmt.Errorf("invalid SDP line %d: %q", n+1, line)
+		}
+		if n == 0 && line[0] != 'v' {
+			return fmt.Errorf("SDP does not start with a version line: %q", line)
+		}
+		value := string(line[2:])
+		var err error
+		if media != nil && line[0] != 'm' {
+			err = media.decodeLine(line[0], value)
+		} else {
+			err = s.decodeLine(line[0], value)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid SDP line %d: %v", n+1, err)
+		}
+		if line[0] == 'm' {
+			media = &s.Media[len(s.Media)-1]
+		}
+	}
+	return nil
+}
+
+func (s *SDP) decodeLine(key byte, value string) (err error) {
+	switch key {
+	case 'v':
+		s.Version, err = strconv.Atoi(value)
+	case 'o':
+		f := strings.Fields(value)
+		if len(f) != 6 {
+			return fmt.Errorf("origin %q does not have 6 fields", value)
+		}
+		s.Origin = SDPOrigin{Username: f[0], NetworkType: f[3], AddressType: f[4], Address: f[5]}
+		if s.Origin.SessionID, err = strconv.ParseUint(f[1], 10, 64); err != nil {
+			return err
+		}
+		s.Origin.SessionVersion, err = strconv.ParseUint(f[2], 10, 64)
+	case 's':
+		s.SessionName = value
+	case 'i':
+		s.Information = value
+	case 'u':
+		s.URI = value
+	case 'e':
+		s.Emails = append(s.Emails, value)
+	case 'p':
+		s.Phones = append(s.Phones, value)
+	case 'c':
+		var c SDPConnection
+		if c, err = decodeSDPConnection(value); err == nil {
+			s.Connection = &c
+		}
+	case 'b':
+		var b SDPBandwidth
+		if b, err = decodeSDPBandwidth(value); err == nil {
+			s.Bandwidths = append(s.Bandwidths, b)
+		}
+	case 't':
+		f := strings.Fields(value)
+		if len(f) != 2 {
+			return fmt.Errorf("timing %q does not have 2 fields", value)
+		}
+		var t SDPTiming
+		if t.Start, err = strconv.ParseUint(f[0], 10, 64); err != nil {
+			return err
+		}
+		if t.Stop, err = strconv.ParseUint(f[1], 10, 64); err != nil {
+			return err
+		}
+		s.Timings = append(s.Timings, t)
+	case 'r':
+		if len(s.Timings) == 0 {
+			return fmt.Errorf("repeat time %q without timing", value)
+		}
+		t := &s.Timings[len(s.Timings)-1]
+		t.Repeats = append(t.Repeats, value)
+	case 'z':
+		s.TimeZones = value
+	case 'k':
+		s.EncryptionKey = value
+	case 'a':
+		s.Attributes = append(s.Attributes, decodeSDPAttribute(value))
+	case 'm':
+		f := strings.Fields(value)
+		if len(f) < 3 {
+			return fmt.Errorf("media %q has less than 3 fields", value)
+		}
+		m := SDPMedia{Type: f[0], Protocol: f[2], Formats: f[3:]}
+		port := f[1]
+		if i := strings.IndexByte(port, '/'); i >= 0 {
+			if m.NumberOfPorts, err = strconv.Atoi(port[i+1:]); err != nil {
+				return err
+			}
+			port = port[:i]
+		}
+		if m.Port, err = strconv.Atoi(port); err != nil {
+			return err
+		}
+		s.Media = append(s.Media, m)
+	}
+	// Other lines are ignored, as RFC 4566 requires.
+	return err
+}
+
+func (m *SDPMedia) decodeLine(key byte, value string) (err error) {
+	switch key {
+	case 'i':
+		m.Information = value
+	case 'c':
+		var c SDPConnection
+		if c, err = decodeSDPConnection(value); err == nil {
+			m.Connections = append(m.Connections, c)
+		}
+	case 'b':
+		var b SDPBandwidth
+		if b, err = decodeSDPBandwidth(value); err == nil {
+			m.Bandwidths = append(m.Bandwidths, b)
+		}
+	case 'k':
+		m.EncryptionKey = value
+	case 'a':
+		m.Attributes = append(m.Attributes, decodeSDPAttribute(value))
+	}
+	return err
+}
+
+func decodeSDPConnection(value string) (c SDPConnection, err error) {
+	f := strings.Fields(value)
+	if len(f) != 3 {
+		return c, fmt.Errorf("connection %q does not have 3 fields", value)
+	}
+	c.NetworkType, c.AddressType = f[0], f[1]
+	parts := strings.Split(f[2], "/")
+	c.Address = parts[0]
+	switch {
+	case len(parts) > 3:
+		return c, fmt.Errorf("invalid connection address %q", f[2])
+	case c.AddressType == "IP6" && len(parts) == 3:
+		return c, fmt.Errorf("invalid IP6 connection address %q", f[2])
+	case c.AddressType == "IP6" && len(parts) == 2:
+		c.NumberOfAddresses, err = strconv.Atoi(parts[1])
+	case len(parts) >= 2:
+		if c.TTL, err = strconv.Atoi(parts[1]); err == nil && len(parts) == 3 {
+			c.NumberOfAddresses, err = strconv.Atoi(parts[2])
+		}
+	}
+	return c, err
+}
+
+func decodeSDPBandwidth(value string) (b SDPBandwidth, err error) {
+	i := strings.IndexByte(value, ':')
+	if i < 0 {
+		return b, fmt.Errorf("bandwidth %q without type", value)
+	}
+	b.Type = value[:i]
+	b.Value, err = strconv.Atoi(value[i+1:])
+	return b, err
+}
+
+func decodeSDPAttribute(value string) SDPAttribute {
+	if i := strings.IndexByte(value, ':'); i >= 0 {
+		return SDPAttribute{Key: value[:i], Value: value[i+1:]}
+	}
+	return SDPAttribute{Key: value}
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+// Fields are written in the order RFC 4566 mandates, and empty optional
+// fields are omitted.
+func (s *SDP) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	var buf bytes.Buffer
+	line := func(key byte, value string) {
+		buf.WriteByte(key)
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteString("\r\n")
+	}
+	optional := func(key byte, value string) {
+		if value != "" {
+			line(key, value)
+		}
+	}
+	line('v', strconv.Itoa(s.Version))
+	o := s.Origin
+	line('o', fmt.Sprintf("%s %d %d %s %s %s", o.Username, o.SessionID, o.SessionVersion, o.NetworkType, o.AddressType, o.Address))
+	line('s', s.SessionName)
+	optional('i', s.Information)
+	optional('u', s.URI)
+	for _, e := range s.Emails {
+		line('e', e)
+	}
+	for _, p := range s.Phones {
+		line('p', p)
+	}
+	if s.Connection != nil {
+		line('c', s.Connection.String())
+	}
+	for _, bw := range s.Bandwidths {
+		line('b', bw.String())
+	}
+	for _, t := range s.Timings {
+		line('t', fmt.Sprintf("%d %d", t.Start, t.Stop))
+		for _, r := range t.Repeats {
+			line('r', r)
+		}
+	}
+	optional('z', s.TimeZones)
+	optional('k', s.EncryptionKey)
+	for _, a := range s.Attributes {
+		line('a', a.String())
+	}
+	for _, m := range s.Media {
+		port := strconv.Itoa(m.Port)
+		if m.NumberOfPorts > 0 {
+			port += "/" + strconv.Itoa(m.NumberOfPorts)
+		}
+		line('m', strings.Join(append([]string{m.Type, port, m.Protocol}, m.Formats...), " "))
+		optional('i', m.Information)
+		for _, c := range m.Connections {
+			line('c', c.String())
+		}
+		for _, bw := range m.Bandwidths {
+			line('b', bw.String())
+		}
+		optional('k', m.EncryptionKey)
+		for _, a := range m.Attributes {
+			line('a', a.String())
+		}
+	}
+
+	bytes, err := b.PrependBytes(buf.Len())
+	if err != nil {
+		return err
+	}
+	copy(bytes, buf.Bytes())
+	return nil
+}
+
+func (c SDPConnection) String() string {
+	addr := c.Address
+	if c.TTL > 0 {
+		addr += "/" + strconv.Itoa(c.TTL)
+	}
+	if c.NumberOfAddresses > 0 {
+		addr += "/" + strconv.Itoa(c.NumberOfAddresses)
+	}
+	return c.NetworkType + " " + c.AddressType + " " + addr
+}
+
+func (b SDPBandwidth) String() string {
+	return b.Type + ":" + strconv.Itoa(b.Value)
+}
+
+func (a SDPAttribute) String() string {
+	if a.Value == "" {
+		return a.Key
+	}
+	return a.Key + ":" + a.Value
+}
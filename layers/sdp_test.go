@@ -0,0 +1,107 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+// testSDP is the example session description of RFC 4566 section 5, with
+// a second media description using a port range and its own connection.
+var testSDP = "v=0\r\n" +
+	"o=jdoe 2890844526 2890842807 IN IP4 10.47.16.5\r\n" +
+	"s=SDP Seminar\r\n" +
+	"i=A Seminar on the session description protocol\r\n" +
+	"u=http://www.example.com/seminars/sdp.pdf\r\n" +
+	"e=j.doe@example.com (Jane Doe)\r\n" +
+	"c=IN IP4 224.2.17.12/127\r\n" +
+	"b=AS:128\r\n" +
+	"t=2873397496 2873404696\r\n" +
+	"r=7d 1h 0 25h\r\n" +
+	"a=recvonly\r\n" +
+	"m=audio 49170 RTP/AVP 0 96\r\n" +
+	"a=rtpmap:96 opus/48000/2\r\n" +
+	"a=fmtp:96 useinbandfec=1\r\n" +
+	"m=video 51372/2 RTP/AVP 99\r\n" +
+	"c=IN IP6 ff15::101/3\r\n" +
+	"a=rtpmap:99 h263-1998/90000\r\n" +
+	"a=sendonly\r\n"
+
+func TestSDPDecode(t *testing.T) {
+	var s SDP
+	if err := s.DecodeFromBytes([]byte(testSDP), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	want := SDPOrigin{Username: "jdoe", SessionID: 2890844526, SessionVersion: 2890842807, NetworkType: "IN", AddressType: "IP4", Address: "10.47.16.5"}
+	if s.Origin != want {
+		t.Errorf("got origin %+v, want %+v", s.Origin, want)
+	}
+	if s.SessionName != "SDP Seminar" || len(s.Emails) != 1 || len(s.Bandwidths) != 1 || s.Bandwidths[0].Value != 128 {
+		t.Errorf("got session %+v", s)
+	}
+	if c := s.Connection; c == nil || c.Address != "224.2.17.12" || c.TTL != 127 || c.NumberOfAddresses != 0 {
+		t.Errorf("got connection %+v", c)
+	}
+	if len(s.Timings) != 1 || s.Timings[0].Stop != 2873404696 || len(s.Timings[0].Repeats) != 1 {
+		t.Errorf("got timings %+v", s.Timings)
+	}
+	if len(s.Media) != 2 {
+		t.Fatalf("got %d media descriptions", len(s.Media))
+	}
+
+	audio, video := &s.Media[0], &s.Media[1]
+	wantCodecs := []SDPCodec{
+		{PayloadType: 0, Name: "PCMU", ClockRate: 8000, Channels: 1},
+		{PayloadType: 96, Name: "opus", ClockRate: 48000, Channels: 2, Parameters: "useinbandfec=1"},
+	}
+	if codecs := audio.Codecs(); !reflect.DeepEqual(codecs, wantCodecs) {
+		t.Errorf("got codecs %+v, want %+v", codecs, wantCodecs)
+	}
+	if s.MediaConnection(audio) != s.Connection || s.MediaDirection(audio) != "recvonly" {
+		t.Error("audio does not use the session connection and direction")
+	}
+	if video.Port != 51372 || video.NumberOfPorts != 2 || video.Formats[0] != "99" {
+		t.Errorf("got video %+v", video)
+	}
+	if c := s.MediaConnection(video); c.AddressType != "IP6" || c.NumberOfAddresses != 3 || c.IP() == nil {
+		t.Errorf("got video connection %+v", c)
+	}
+	if s.MediaDirection(video) != "sendonly" {
+		t.Errorf("got video direction %q", s.MediaDirection(video))
+	}
+}
+
+func TestSDPSerialize(t *testing.T) {
+	var s SDP
+	if err := s.DecodeFromBytes([]byte(testSDP), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := s.SerializeTo(buf, gopacket.SerializeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf.Bytes()); got != testSDP {
+		t.Errorf("got\n%s\nwant\n%s", got, testSDP)
+	}
+}
+
+func TestSDPDecodeInvalid(t *testing.T) {
+	for _, data := range []string{
+		"o=jdoe 1 1 IN IP4 10.47.16.5\r\n",
+		"v=0\r\nthis is not SDP\r\n",
+		"v=0\r\nm=audio port RTP/AVP 0\r\n",
+		"v=0\r\nc=IN IP4\r\n",
+	} {
+		var s SDP
+		if err := s.DecodeFromBytes([]byte(data), gopacket.NilDecodeFeedback); err == nil {
+			t.Errorf("no error decoding %q", data)
+		}
+	}
+}
@@ -5,7 +5,7 @@
 // tree.
 
 /*
-This layer decodes SFlow version 5 datagrams.
+This layer decodes and serializes SFlow version 5 datagrams.
 
 The specification can be found here: http://sflow.org/sflow_version_5.txt
 
@@ -67,7 +67,12 @@ chose to pack together two values like this in some places, and
 in others they use the entire 4-byte value to store a number that
 will never be more than a few bits. In any case, there are a couple
 of types defined to handle the decoding of these bitfields, and
-that's why they're there. */
+that's why they're there.
+
+SFlowDatagram, its samples and records can be serialized with SerializeTo.
+XDR padding is always written as zeros, and with FixLengths the counts and
+lengths of the datagram, its samples and records are set from their
+contents. */
 
 package layers
 
@@ -76,6 +81,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"reflect"
 
 	"github.com/google/gopacket"
 )
@@ -83,9 +89,18 @@ import (
 // SFlowRecord holds both flow sample records and counter sample records.
 // A Record is the structure that actually holds the sampled data
 // and / or counters.
+//
+// The records of this package are serialized by their SerializeTo method,
+// which prepends them to the buffer and, with FixLengths, sets their
+// FlowDataLength.
 type SFlowRecord interface {
 }
 
+// sflowRecordSerializer is implemented by pointers to serializable records.
+type sflowRecordSerializer interface {
+	SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error
+}
+
 // SFlowDataSource encodes a 2-bit SFlowSourceFormat in its most significant
 // 2 bits, and an SFlowSourceValue in its least significant 30 bits.
 // These types and values define the meaning of the inteface information
@@ -156,6 +171,11 @@ type SFlowDatagram struct {
 	SampleCount     uint32
 	FlowSamples     []SFlowFlowSample
 	CounterSamples  []SFlowCounterSample
+	// SampleOrder is the order of the samples in the datagram, if flow and
+	// counter samples are interleaved: SampleOrder[i] is true if the i-th
+	// sample is a counter sample, and false if it is a flow sample.  It is
+	// set by DecodeFromBytes, and nil if all the flow samples come first.
+	SampleOrder []bool
 }
 
 // An SFlow  datagram's outer container has the following
@@ -311,9 +331,12 @@ func (s *SFlowDatagram) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback)
 	if s.SampleCount < 1 {
 		return fmt.Errorf("SFlow Datagram has invalid sample length: %d", s.SampleCount)
 	}
+	s.SampleOrder = nil
+	var order []bool
 	for i := uint32(0); i < s.SampleCount; i++ {
 		sdf := SFlowDataFormat(binary.BigEndian.Uint32(data[:4]))
 		_, sampleType := sdf.decode()
+		order = append(order, sampleType == SFlowTypeCounterSample || sampleType == SFlowTypeExpandedCounterSample)
 		switch sampleType {
 		case SFlowTypeFlowSample:
 			if flowSample, err := decodeFlowSample(&data, false); err == nil {
@@ -344,6 +367,123 @@ func (s *SFlowDatagram) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback)
 			return fmt.Errorf("Unsupported SFlow sample type %d", sampleType)
 		}
 	}
+	for i := 1; i < len(order); i++ {
+		if order[i-1] && !order[i] {
+			s.SampleOrder = order
+			break
+		}
+	}
+	return nil
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+// With FixLengths, SampleCount and the lengths and record counts of all
+// samples and records are set from their contents.
+// Samples are written in the order given by SampleOrder, or with the flow
+// samples first if it doesn't match FlowSamples and CounterSamples.
+func (s *SFlowDatagram) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	n := len(s.FlowSamples) + len(s.CounterSamples)
+	order := s.SampleOrder
+	counters := 0
+	for _, counter := range order {
+		if counter {
+			counters++
+		}
+	}
+	if len(order) != n || counters != len(s.CounterSamples) {
+		order = make([]bool, n)
+		for i := len(s.FlowSamples); i < n; i++ {
+			order[i] = true
+		}
+	}
+	flows, counters := len(s.FlowSamples), len(s.CounterSamples)
+	for i := n - 1; i >= 0; i-- {
+		var err error
+		if order[i] {
+			counters--
+			err = s.CounterSamples[counters].SerializeTo(b, opts)
+		} else {
+			flows--
+			err = s.FlowSamples[flows].SerializeTo(b, opts)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.FixLengths {
+		s.SampleCount = uint32(n)
+	}
+	header := appendSFlowUint32(nil, s.DatagramVersion)
+	header, err := appendSFlowIP(header, s.AgentAddress)
+	if err != nil {
+		return err
+	}
+	header = appendSFlowUint32(header, s.SubAgentID, s.SequenceNumber, s.AgentUptime, s.SampleCount)
+	bytes, err := b.PrependBytes(len(header))
+	if err != nil {
+		return err
+	}
+	copy(bytes, header)
+	return nil
+}
+
+func appendSFlowUint32(b []byte, values ...uint32) []byte {
+	for _, v := range values {
+		b = append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	return b
+}
+
+func appendSFlowUint64(b []byte, values ...uint64) []byte {
+	for _, v := range values {
+		b = appendSFlowUint32(b, uint32(v>>32), uint32(v))
+	}
+	return b
+}
+
+// appendSFlowOpaque appends data as XDR variable-length opaque data, its
+// length followed by data padded with zeros to a multiple of 4 bytes.
+func appendSFlowOpaque(b []byte, data []byte) []byte {
+	b = appendSFlowUint32(b, uint32(len(data)))
+	b = append(b, data...)
+	return append(b, make([]byte, (4-len(data)%4)%4)...)
+}
+
+// appendSFlowIP appends an SFlowIPType followed by the address.
+func appendSFlowIP(b []byte, ip net.IP) ([]byte, error) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return append(appendSFlowUint32(b, uint32(SFlowIPv4)), ip4...), nil
+	}
+	if len(ip) == net.IPv6len {
+		return append(appendSFlowUint32(b, uint32(SFlowIPv6)), ip...), nil
+	}
+	return nil, fmt.Errorf("invalid SFlow IP address %v", ip)
+}
+
+// appendSFlowMAC appends a MAC address padded to 8 bytes.
+func appendSFlowMAC(b []byte, mac net.HardwareAddr) []byte {
+	padded := make([]byte, 8)
+	copy(padded[:6], mac)
+	return append(b, padded...)
+}
+
+// prependSFlowRecord prepends the data format, length and body of a sample
+// or record. nested is the number of bytes of the record already written to
+// the buffer after body, like the records of a sample. With FixLengths,
+// length is set to the length of the record after its header.
+func prependSFlowRecord(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions, format uint32, length *uint32, body []byte, nested int) error {
+	if opts.FixLengths {
+		*length = uint32(len(body) + nested)
+	}
+	bytes, err := b.PrependBytes(8 + len(body))
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(bytes, format)
+	binary.BigEndian.PutUint32(bytes[4:], *length)
+	copy(bytes[8:], body)
 	return nil
 }
 
@@ -678,6 +818,35 @@ func decodeFlowSample(data *[]byte, expanded bool) (SFlowFlowSample, error) {
 	return s, nil
 }
 
+// SerializeTo prepends the flow sample and its records to the buffer.
+// With FixLengths, SampleLength and RecordCount are set from the records.
+func (fs *SFlowFlowSample) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	end := len(b.Bytes())
+	for i := len(fs.Records) - 1; i >= 0; i-- {
+		record, err := serializeSFlowRecord(b, fs.Records[i], opts)
+		if err != nil {
+			return err
+		}
+		fs.Records[i] = record
+	}
+	if opts.FixLengths {
+		fs.RecordCount = uint32(len(fs.Records))
+	}
+
+	body := appendSFlowUint32(nil, fs.SequenceNumber)
+	if fs.Format == SFlowTypeExpandedFlowSample {
+		body = appendSFlowUint32(body, uint32(fs.SourceIDClass), uint32(fs.SourceIDIndex),
+			fs.SamplingRate, fs.SamplePool, fs.Dropped,
+			fs.InputInterfaceFormat, fs.InputInterface, fs.OutputInterfaceFormat, fs.OutputInterface)
+	} else {
+		body = appendSFlowUint32(body, uint32(fs.SourceIDClass)<<30|uint32(fs.SourceIDIndex)&0x3fffffff,
+			fs.SamplingRate, fs.SamplePool, fs.Dropped, fs.InputInterface, fs.OutputInterface)
+	}
+	body = appendSFlowUint32(body, fs.RecordCount)
+	format := uint32(fs.EnterpriseID)<<12 | uint32(fs.Format)
+	return prependSFlowRecord(b, opts, format, &fs.SampleLength, body, len(b.Bytes())-end)
+}
+
 // Counter samples report information about various counter
 // objects. Typically these are items like IfInOctets, or
 // CPU / Memory stats, etc. SFlow will report these at regular
@@ -866,6 +1035,52 @@ func decodeCounterSample(data *[]byte, expanded bool) (SFlowCounterSample, error
 	return s, nil
 }
 
+// SerializeTo prepends the counter sample and its records to the buffer.
+// With FixLengths, SampleLength and RecordCount are set from the records.
+func (cs *SFlowCounterSample) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	end := len(b.Bytes())
+	for i := len(cs.Records) - 1; i >= 0; i-- {
+		record, err := serializeSFlowRecord(b, cs.Records[i], opts)
+		if err != nil {
+			return err
+		}
+		cs.Records[i] = record
+	}
+	if opts.FixLengths {
+		cs.RecordCount = uint32(len(cs.Records))
+	}
+
+	body := appendSFlowUint32(nil, cs.SequenceNumber)
+	if cs.Format == SFlowTypeExpandedCounterSample {
+		body = appendSFlowUint32(body, uint32(cs.SourceIDClass), uint32(cs.SourceIDIndex))
+	} else {
+		body = appendSFlowUint32(body, uint32(cs.SourceIDClass)<<30|uint32(cs.SourceIDIndex)&0x3fffffff)
+	}
+	body = appendSFlowUint32(body, cs.RecordCount)
+	format := uint32(cs.EnterpriseID)<<12 | uint32(cs.Format)
+	return prependSFlowRecord(b, opts, format, &cs.SampleLength, body, len(b.Bytes())-end)
+}
+
+// serializeSFlowRecord prepends a flow or counter record to the buffer and
+// returns it with the lengths that SerializeTo may have fixed.
+func serializeSFlowRecord(b gopacket.SerializeBuffer, record SFlowRecord, opts gopacket.SerializeOptions) (SFlowRecord, error) {
+	if r, ok := record.(sflowRecordSerializer); ok {
+		return record, r.SerializeTo(b, opts)
+	}
+	if record == nil {
+		return nil, errors.New("cannot serialize nil SFlow record")
+	}
+	// Decoded records are values, serialize a copy to fix its lengths.
+	v := reflect.New(reflect.TypeOf(record))
+	v.Elem().Set(reflect.ValueOf(record))
+	r, ok := v.Interface().(sflowRecordSerializer)
+	if !ok {
+		return record, fmt.Errorf("cannot serialize SFlow record of type %T", record)
+	}
+	err := r.SerializeTo(b, opts)
+	return v.Elem().Interface(), err
+}
+
 // SFlowBaseFlowRecord holds the fields common to all records
 // of type SFlowFlowRecordType
 type SFlowBaseFlowRecord struct {
@@ -878,6 +1093,10 @@ func (bfr SFlowBaseFlowRecord) GetType() SFlowFlowRecordType {
 	return bfr.Format
 }
 
+func (bfr SFlowBaseFlowRecord) dataFormat() uint32 {
+	return uint32(bfr.EnterpriseID)<<12 | uint32(bfr.Format)
+}
+
 // SFlowFlowRecordType denotes what kind of Flow Record is
 // represented. See RFC 3176
 type SFlowFlowRecordType uint32
@@ -1075,6 +1294,24 @@ func decodeRawPacketFlowRecord(data *[]byte) (SFlowRawPacketFlowRecord, error) {
 	return rec, nil
 }
 
+// With FixLengths, SerializeTo sets HeaderLength when it is zero or longer
+// than the data of Header. Decoded records keep theirs, as their Header also
+// holds the padding that follows the header.
+func (rec *SFlowRawPacketFlowRecord) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	var header []byte
+	if rec.Header != nil {
+		header = rec.Header.Data()
+	}
+	if opts.FixLengths && (rec.HeaderLength == 0 || int(rec.HeaderLength) > len(header)) {
+		rec.HeaderLength = uint32(len(header))
+	}
+	body := appendSFlowUint32(nil, uint32(rec.HeaderProtocol), rec.FrameLength, rec.PayloadRemoved, rec.HeaderLength)
+	padded := make([]byte, (rec.HeaderLength+3)&^3)
+	copy(padded, header)
+	body = append(body, padded...)
+	return prependSFlowRecord(b, opts, rec.dataFormat(), &rec.FlowDataLength, body, 0)
+}
+
 // SFlowExtendedSwitchFlowRecord give additional information
 // about the sampled packet if it's available. It's mainly
 // useful for getting at the incoming and outgoing VLANs
@@ -1118,6 +1355,11 @@ func decodeExtendedSwitchFlowRecord(data *[]byte) (SFlowExtendedSwitchFlowRecord
 	return es, nil
 }
 
+func (es *SFlowExtendedSwitchFlowRecord) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body := appendSFlowUint32(nil, es.IncomingVLAN, es.IncomingVLANPriority, es.OutgoingVLAN, es.OutgoingVLANPriority)
+	return prependSFlowRecord(b, opts, es.dataFormat(), &es.FlowDataLength, body, 0)
+}
+
 // SFlowExtendedRouterFlowRecord gives additional information
 // about the layer 3 routing information used to forward
 // the packet
@@ -1161,6 +1403,15 @@ func decodeExtendedRouterFlowRecord(data *[]byte) (SFlowExtendedRouterFlowRecord
 	return er, nil
 }
 
+func (er *SFlowExtendedRouterFlowRecord) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body, err := appendSFlowIP(nil, er.NextHop)
+	if err != nil {
+		return err
+	}
+	body = appendSFlowUint32(body, er.NextHopSourceMask, er.NextHopDestinationMask)
+	return prependSFlowRecord(b, opts, er.dataFormat(), &er.FlowDataLength, body, 0)
+}
+
 // SFlowExtendedGatewayFlowRecord describes information treasured by
 // nework engineers everywhere: AS path information listing which
 // BGP peer sent the packet, and various other BGP related info.
@@ -1308,6 +1559,31 @@ func decodeExtendedGatewayFlowRecord(data *[]byte) (SFlowExtendedGatewayFlowReco
 	return eg, nil
 }
 
+// With FixLengths, SerializeTo also sets ASPathCount and the Count of each
+// AS path.
+func (eg *SFlowExtendedGatewayFlowRecord) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body, err := appendSFlowIP(nil, eg.NextHop)
+	if err != nil {
+		return err
+	}
+	if opts.FixLengths {
+		eg.ASPathCount = uint32(len(eg.ASPath))
+	}
+	body = appendSFlowUint32(body, eg.AS, eg.SourceAS, eg.PeerAS, eg.ASPathCount)
+	for i := range eg.ASPath {
+		path := &eg.ASPath[i]
+		if opts.FixLengths {
+			path.Count = uint32(len(path.Members))
+		}
+		body = appendSFlowUint32(body, uint32(path.Type), path.Count)
+		body = appendSFlowUint32(body, path.Members...)
+	}
+	body = appendSFlowUint32(body, uint32(len(eg.Communities)))
+	body = appendSFlowUint32(body, eg.Communities...)
+	body = appendSFlowUint32(body, eg.LocalPref)
+	return prependSFlowRecord(b, opts, eg.dataFormat(), &eg.FlowDataLength, body, 0)
+}
+
 // **************************************************
 //  Extended URL Flow Record
 // **************************************************
@@ -1375,6 +1651,13 @@ func decodeExtendedURLRecord(data *[]byte) (SFlowExtendedURLRecord, error) {
 	return eur, nil
 }
 
+func (eur *SFlowExtendedURLRecord) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body := appendSFlowUint32(nil, uint32(eur.Direction))
+	body = appendSFlowOpaque(body, []byte(eur.URL))
+	body = appendSFlowOpaque(body, []byte(eur.Host))
+	return prependSFlowRecord(b, opts, eur.dataFormat(), &eur.FlowDataLength, body, 0)
+}
+
 // **************************************************
 //  Extended User Flow Record
 // **************************************************
@@ -1692,6 +1975,14 @@ func decodeExtendedUserFlow(data *[]byte) (SFlowExtendedUserFlow, error) {
 	return eu, nil
 }
 
+func (eu *SFlowExtendedUserFlow) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body := appendSFlowUint32(nil, uint32(eu.SourceCharSet))
+	body = appendSFlowOpaque(body, []byte(eu.SourceUserID))
+	body = appendSFlowUint32(body, uint32(eu.DestinationCharSet))
+	body = appendSFlowOpaque(body, []byte(eu.DestinationUserID))
+	return prependSFlowRecord(b, opts, eu.dataFormat(), &eu.FlowDataLength, body, 0)
+}
+
 // **************************************************
 //  Packet IP version 4 Record
 // **************************************************
@@ -1748,6 +2039,16 @@ func decodeSFlowIpv4Record(data *[]byte) (SFlowIpv4Record, error) {
 	return si, nil
 }
 
+func (si SFlowIpv4Record) encode(b []byte) ([]byte, error) {
+	src, dst := si.IPSrc.To4(), si.IPDst.To4()
+	if src == nil || dst == nil {
+		return nil, fmt.Errorf("invalid SFlow IPv4 addresses %v and %v", si.IPSrc, si.IPDst)
+	}
+	b = appendSFlowUint32(b, si.Length, si.Protocol)
+	b = append(append(b, src...), dst...)
+	return appendSFlowUint32(b, si.PortSrc, si.PortDst, si.TCPFlags, si.TOS), nil
+}
+
 // **************************************************
 //  Packet IP version 6 Record
 // **************************************************
@@ -1804,6 +2105,16 @@ func decodeSFlowIpv6Record(data *[]byte) (SFlowIpv6Record, error) {
 	return si, nil
 }
 
+func (si SFlowIpv6Record) encode(b []byte) ([]byte, error) {
+	src, dst := si.IPSrc.To16(), si.IPDst.To16()
+	if src == nil || dst == nil {
+		return nil, fmt.Errorf("invalid SFlow IPv6 addresses %v and %v", si.IPSrc, si.IPDst)
+	}
+	b = appendSFlowUint32(b, si.Length, si.Protocol)
+	b = append(append(b, src...), dst...)
+	return appendSFlowUint32(b, si.PortSrc, si.PortDst, si.TCPFlags, si.Priority), nil
+}
+
 // **************************************************
 //  Extended IPv4 Tunnel Egress
 // **************************************************
@@ -1834,6 +2145,14 @@ func decodeExtendedIpv4TunnelEgress(data *[]byte) (SFlowExtendedIpv4TunnelEgress
 	return rec, nil
 }
 
+func (rec *SFlowExtendedIpv4TunnelEgressRecord) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body, err := rec.SFlowIpv4Record.encode(nil)
+	if err != nil {
+		return err
+	}
+	return prependSFlowRecord(b, opts, rec.dataFormat(), &rec.FlowDataLength, body, 0)
+}
+
 // **************************************************
 //  Extended IPv4 Tunnel Ingress
 // **************************************************
@@ -1864,6 +2183,14 @@ func decodeExtendedIpv4TunnelIngress(data *[]byte) (SFlowExtendedIpv4TunnelIngre
 	return rec, nil
 }
 
+func (rec *SFlowExtendedIpv4TunnelIngressRecord) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body, err := rec.SFlowIpv4Record.encode(nil)
+	if err != nil {
+		return err
+	}
+	return prependSFlowRecord(b, opts, rec.dataFormat(), &rec.FlowDataLength, body, 0)
+}
+
 // **************************************************
 //  Extended IPv6 Tunnel Egress
 // **************************************************
@@ -1894,6 +2221,14 @@ func decodeExtendedIpv6TunnelEgress(data *[]byte) (SFlowExtendedIpv6TunnelEgress
 	return rec, nil
 }
 
+func (rec *SFlowExtendedIpv6TunnelEgressRecord) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body, err := rec.SFlowIpv6Record.encode(nil)
+	if err != nil {
+		return err
+	}
+	return prependSFlowRecord(b, opts, rec.dataFormat(), &rec.FlowDataLength, body, 0)
+}
+
 // **************************************************
 //  Extended IPv6 Tunnel Ingress
 // **************************************************
@@ -1924,6 +2259,14 @@ func decodeExtendedIpv6TunnelIngress(data *[]byte) (SFlowExtendedIpv6TunnelIngre
 	return rec, nil
 }
 
+func (rec *SFlowExtendedIpv6TunnelIngressRecord) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body, err := rec.SFlowIpv6Record.encode(nil)
+	if err != nil {
+		return err
+	}
+	return prependSFlowRecord(b, opts, rec.dataFormat(), &rec.FlowDataLength, body, 0)
+}
+
 // **************************************************
 //  Extended Decapsulate Egress
 // **************************************************
@@ -1953,6 +2296,11 @@ func decodeExtendedDecapsulateEgress(data *[]byte) (SFlowExtendedDecapsulateEgre
 	return rec, nil
 }
 
+func (rec *SFlowExtendedDecapsulateEgressRecord) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body := appendSFlowUint32(nil, rec.InnerHeaderOffset)
+	return prependSFlowRecord(b, opts, rec.dataFormat(), &rec.FlowDataLength, body, 0)
+}
+
 // **************************************************
 //  Extended Decapsulate Ingress
 // **************************************************
@@ -1982,6 +2330,11 @@ func decodeExtendedDecapsulateIngress(data *[]byte) (SFlowExtendedDecapsulateIng
 	return rec, nil
 }
 
+func (rec *SFlowExtendedDecapsulateIngressRecord) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body := appendSFlowUint32(nil, rec.InnerHeaderOffset)
+	return prependSFlowRecord(b, opts, rec.dataFormat(), &rec.FlowDataLength, body, 0)
+}
+
 // **************************************************
 //  Extended VNI Egress
 // **************************************************
@@ -2011,6 +2364,11 @@ func decodeExtendedVniEgress(data *[]byte) (SFlowExtendedVniEgressRecord, error)
 	return rec, nil
 }
 
+func (rec *SFlowExtendedVniEgressRecord) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body := appendSFlowUint32(nil, rec.VNI)
+	return prependSFlowRecord(b, opts, rec.dataFormat(), &rec.FlowDataLength, body, 0)
+}
+
 // **************************************************
 //  Extended VNI Ingress
 // **************************************************
@@ -2040,6 +2398,11 @@ func decodeExtendedVniIngress(data *[]byte) (SFlowExtendedVniIngressRecord, erro
 	return rec, nil
 }
 
+func (rec *SFlowExtendedVniIngressRecord) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body := appendSFlowUint32(nil, rec.VNI)
+	return prependSFlowRecord(b, opts, rec.dataFormat(), &rec.FlowDataLength, body, 0)
+}
+
 // **************************************************
 //  Counter Record
 // **************************************************
@@ -2089,6 +2452,10 @@ func (bcr SFlowBaseCounterRecord) GetType() SFlowCounterRecordType {
 	panic(unrecognized)
 }
 
+func (bcr SFlowBaseCounterRecord) dataFormat() uint32 {
+	return uint32(bcr.EnterpriseID)<<12 | uint32(bcr.Format)
+}
+
 // **************************************************
 //  Counter Record
 // **************************************************
@@ -2192,6 +2559,19 @@ func decodeGenericInterfaceCounters(data *[]byte) (SFlowGenericInterfaceCounters
 	return gic, nil
 }
 
+func (gic *SFlowGenericInterfaceCounters) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body := appendSFlowUint32(nil, gic.IfIndex, gic.IfType)
+	body = appendSFlowUint64(body, gic.IfSpeed)
+	body = appendSFlowUint32(body, gic.IfDirection, gic.IfStatus)
+	body = appendSFlowUint64(body, gic.IfInOctets)
+	body = appendSFlowUint32(body, gic.IfInUcastPkts, gic.IfInMulticastPkts, gic.IfInBroadcastPkts,
+		gic.IfInDiscards, gic.IfInErrors, gic.IfInUnknownProtos)
+	body = appendSFlowUint64(body, gic.IfOutOctets)
+	body = appendSFlowUint32(body, gic.IfOutUcastPkts, gic.IfOutMulticastPkts, gic.IfOutBroadcastPkts,
+		gic.IfOutDiscards, gic.IfOutErrors, gic.IfPromiscuousMode)
+	return prependSFlowRecord(b, opts, gic.dataFormat(), &gic.FlowDataLength, body, 0)
+}
+
 // **************************************************
 //  Counter Record
 // **************************************************
@@ -2288,6 +2668,14 @@ func decodeEthernetCounters(data *[]byte) (SFlowEthernetCounters, error) {
 	return ec, nil
 }
 
+func (ec *SFlowEthernetCounters) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body := appendSFlowUint32(nil, ec.AlignmentErrors, ec.FCSErrors, ec.SingleCollisionFrames,
+		ec.MultipleCollisionFrames, ec.SQETestErrors, ec.DeferredTransmissions, ec.LateCollisions,
+		ec.ExcessiveCollisions, ec.InternalMacTransmitErrors, ec.CarrierSenseErrors, ec.FrameTooLongs,
+		ec.InternalMacReceiveErrors, ec.SymbolErrors)
+	return prependSFlowRecord(b, opts, ec.dataFormat(), &ec.FlowDataLength, body, 0)
+}
+
 // VLAN Counter
 
 type SFlowVLANCounters struct {
@@ -2317,6 +2705,13 @@ func decodeVLANCounters(data *[]byte) (SFlowVLANCounters, error) {
 	return vc, nil
 }
 
+func (vc *SFlowVLANCounters) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body := appendSFlowUint32(nil, vc.VlanID)
+	body = appendSFlowUint64(body, vc.Octets)
+	body = appendSFlowUint32(body, vc.UcastPkts, vc.MulticastPkts, vc.BroadcastPkts, vc.Discards)
+	return prependSFlowRecord(b, opts, vc.dataFormat(), &vc.FlowDataLength, body, 0)
+}
+
 //SFLLACPportState  :  SFlow LACP Port State (All(4) - 32 bit)
 type SFLLACPPortState struct {
 	PortStateAll uint32
@@ -2365,6 +2760,15 @@ func decodeLACPCounters(data *[]byte) (SFlowLACPCounters, error) {
 
 }
 
+func (la *SFlowLACPCounters) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body := appendSFlowMAC(nil, la.ActorSystemID)
+	body = appendSFlowMAC(body, la.PartnerSystemID)
+	body = appendSFlowUint32(body, la.AttachedAggID, la.LacpPortState.PortStateAll,
+		la.LACPDUsRx, la.MarkerPDUsRx, la.MarkerResponsePDUsRx, la.UnknownRx, la.IllegalRx,
+		la.LACPDUsTx, la.MarkerPDUsTx, la.MarkerResponsePDUsTx)
+	return prependSFlowRecord(b, opts, la.dataFormat(), &la.FlowDataLength, body, 0)
+}
+
 // **************************************************
 //  Processor Counter Record
 // **************************************************
@@ -2416,6 +2820,12 @@ func decodeProcessorCounters(data *[]byte) (SFlowProcessorCounters, error) {
 	return pc, nil
 }
 
+func (pc *SFlowProcessorCounters) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body := appendSFlowUint32(nil, pc.FiveSecCpu, pc.OneMinCpu, pc.FiveMinCpu)
+	body = appendSFlowUint64(body, pc.TotalMemory, pc.FreeMemory)
+	return prependSFlowRecord(b, opts, pc.dataFormat(), &pc.FlowDataLength, body, 0)
+}
+
 // SFlowEthernetFrameFlowRecord give additional information
 // about the sampled packet if it's available.
 // An agent may or may not provide this information.
@@ -2457,6 +2867,14 @@ func decodeEthernetFrameFlowRecord(data *[]byte) (SFlowEthernetFrameFlowRecord,
 	return es, nil
 }
 
+func (es *SFlowEthernetFrameFlowRecord) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body := appendSFlowUint32(nil, es.FrameLength)
+	body = appendSFlowMAC(body, es.SrcMac)
+	body = appendSFlowMAC(body, es.DstMac)
+	body = appendSFlowUint32(body, es.Type)
+	return prependSFlowRecord(b, opts, es.dataFormat(), &es.FlowDataLength, body, 0)
+}
+
 //SFlowOpenflowPortCounters  :  OVS-Sflow OpenFlow Port Counter  ( 20 Bytes )
 type SFlowOpenflowPortCounters struct {
 	SFlowBaseCounterRecord
@@ -2477,6 +2895,12 @@ func decodeOpenflowportCounters(data *[]byte) (SFlowOpenflowPortCounters, error)
 	return ofp, nil
 }
 
+func (ofp *SFlowOpenflowPortCounters) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body := appendSFlowUint64(nil, ofp.DatapathID)
+	body = appendSFlowUint32(body, ofp.PortNo)
+	return prependSFlowRecord(b, opts, ofp.dataFormat(), &ofp.FlowDataLength, body, 0)
+}
+
 //SFlowAppresourcesCounters  :  OVS_Sflow App Resources Counter ( 48 Bytes )
 type SFlowAppresourcesCounters struct {
 	SFlowBaseCounterRecord
@@ -2509,6 +2933,13 @@ func decodeAppresourcesCounters(data *[]byte) (SFlowAppresourcesCounters, error)
 	return app, nil
 }
 
+func (app *SFlowAppresourcesCounters) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body := appendSFlowUint32(nil, app.UserTime, app.SystemTime)
+	body = appendSFlowUint64(body, app.MemUsed, app.MemMax)
+	body = appendSFlowUint32(body, app.FdOpen, app.FdMax, app.ConnOpen, app.ConnMax)
+	return prependSFlowRecord(b, opts, app.dataFormat(), &app.FlowDataLength, body, 0)
+}
+
 //SFlowOVSDPCounters  :  OVS-Sflow DataPath Counter  ( 32 Bytes )
 type SFlowOVSDPCounters struct {
 	SFlowBaseCounterRecord
@@ -2537,6 +2968,11 @@ func decodeOVSDPCounters(data *[]byte) (SFlowOVSDPCounters, error) {
 	return dp, nil
 }
 
+func (dp *SFlowOVSDPCounters) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	body := appendSFlowUint32(nil, dp.NHit, dp.NMissed, dp.NLost, dp.NMaskHit, dp.NFlows, dp.NMasks)
+	return prependSFlowRecord(b, opts, dp.dataFormat(), &dp.FlowDataLength, body, 0)
+}
+
 //SFlowPORTNAME  :  OVS-Sflow PORTNAME Counter Sampletype ( 20 Bytes )
 type SFlowPORTNAME struct {
 	SFlowBaseCounterRecord
@@ -2565,3 +3001,13 @@ func decodePortnameCounters(data *[]byte) (SFlowPORTNAME, error) {
 
 	return pn, nil
 }
+
+// With FixLengths, SerializeTo sets Len to the padded length of Str, as
+// decoded.
+func (pn *SFlowPORTNAME) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	if opts.FixLengths {
+		pn.Len = uint32(len(pn.Str)+3) &^ 3
+	}
+	body := appendSFlowOpaque(nil, []byte(pn.Str))
+	return prependSFlowRecord(b, opts, pn.dataFormat(), &pn.FlowDataLength, body, 0)
+}
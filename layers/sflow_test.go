@@ -6,6 +6,7 @@
 package layers
 
 import (
+	"bytes"
 	"net"
 	"reflect"
 	"testing"
@@ -736,6 +737,7 @@ func TestDecodeSFlowDatagram(t *testing.T) {
 					},
 				},
 			},
+			SampleOrder: []bool{false, false, false, true, false, false, false},
 		}
 		if !reflect.DeepEqual(want, got) {
 			t.Errorf("SFlow layer mismatch, \nwant:\n\n%#v\ngot:\n\n\n%#v\n\n", want, got)
@@ -1930,3 +1932,136 @@ func BenchmarkDecodeSFlowLayerPacket2(b *testing.B) {
 		sflow.DecodeFromBytes(SFlowTestPacket2[ /*eth*/ 14+ /*ipv4*/ 20+ /*udp*/ 8:], gopacket.NilDecodeFeedback)
 	}
 }
+
+func TestSFlowSerializeRoundTrip(t *testing.T) {
+	for i, test := range []struct {
+		data []byte
+		// consistent is false for datagrams whose sample lengths don't
+		// match their records, and which FixLengths changes.
+		consistent bool
+		// lost lists the offsets of non-zero bytes which aren't kept by
+		// decoding and are serialized as zeros: XDR padding, and the low
+		// bits of the source ID type of expanded counter samples, of which
+		// only the two high bits are decoded.
+		lost []int
+	}{
+		{SFlowTestPacket1[42:], true, nil},
+		{SFlowTestPacket2[42:], true, []int{123, 151, 471, 499, 879, 907}},
+		{SFlowTestPacket3, true, []int{43}},
+		{SFlowTestPacket4, true, nil},
+		{SFlowTestPacket5, false, nil},
+		{SFlowTestPacket6, true, nil},
+		{SFlowTestPacket7, true, nil},
+		{SFlowTestPacket8, false, nil},
+		{SFlowTestPacket9, true, nil},
+		{SFlowTestPacket10, true, []int{43}},
+		{SFlowTestPacket11, false, []int{63, 70}},
+		{SFlowEthernetFramePacket, true, nil},
+		{SFlowTestPacket12, true, nil},
+		{SFlowTestPacket13, true, nil},
+	} {
+		var s SFlowDatagram
+		if err := s.DecodeFromBytes(test.data, gopacket.NilDecodeFeedback); err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		want := append([]byte(nil), test.data...)
+		for _, off := range test.lost {
+			want[off] = 0
+		}
+		for _, opts := range []gopacket.SerializeOptions{{}, {FixLengths: true}} {
+			if opts.FixLengths && !test.consistent {
+				continue
+			}
+			buf := gopacket.NewSerializeBuffer()
+			if err := s.SerializeTo(buf, opts); err != nil {
+				t.Errorf("%d: %v", i, err)
+			} else if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("%d: serialized with %+v\n%x\nwant\n%x", i, opts, buf.Bytes(), want)
+			}
+		}
+	}
+}
+
+func TestSFlowSerializeFixLengths(t *testing.T) {
+	frame := []byte{
+		0x00, 0x0c, 0x29, 0x3a, 0x8c, 0x5e, 0x00, 0x50, 0x56, 0xc0, 0x00, 0x08, 0x08, 0x00,
+		0x45, 0x00, 0x00, 0x1c, 0x3c, 0x4e, 0x40, 0x00, 0x40, 0x01, 0x80, 0x76,
+		0xc0, 0xa8, 0x01, 0x02, 0xc0, 0xa8, 0x01, 0x01,
+	}
+	s := &SFlowDatagram{
+		DatagramVersion: 5,
+		AgentAddress:    net.IP{10, 0, 0, 1},
+		SequenceNumber:  7,
+		AgentUptime:     1000,
+		FlowSamples: []SFlowFlowSample{{
+			Format:         SFlowTypeFlowSample,
+			SequenceNumber: 1,
+			SourceIDIndex:  3,
+			SamplingRate:   512,
+			SamplePool:     1024,
+			InputInterface: 3,
+			Records: []SFlowRecord{
+				SFlowRawPacketFlowRecord{
+					SFlowBaseFlowRecord: SFlowBaseFlowRecord{Format: SFlowTypeRawPacketFlow},
+					HeaderProtocol:      SFlowProtoEthernet,
+					FrameLength:         46,
+					PayloadRemoved:      4,
+					Header:              gopacket.NewPacket(frame, LayerTypeEthernet, gopacket.Default),
+				},
+				SFlowExtendedSwitchFlowRecord{
+					SFlowBaseFlowRecord: SFlowBaseFlowRecord{Format: SFlowTypeExtendedSwitchFlow},
+					IncomingVLAN:        10,
+					OutgoingVLAN:        20,
+				},
+				SFlowExtendedRouterFlowRecord{
+					SFlowBaseFlowRecord:    SFlowBaseFlowRecord{Format: SFlowTypeExtendedRouterFlow},
+					NextHop:                net.ParseIP("2001:db8::1"),
+					NextHopSourceMask:      64,
+					NextHopDestinationMask: 48,
+				},
+			},
+		}},
+		CounterSamples: []SFlowCounterSample{{
+			Format:         SFlowTypeExpandedCounterSample,
+			SequenceNumber: 2,
+			SourceIDIndex:  1,
+			Records: []SFlowRecord{
+				SFlowProcessorCounters{
+					SFlowBaseCounterRecord: SFlowBaseCounterRecord{Format: SFlowTypeProcessorCounters},
+					FiveSecCpu:             10,
+					TotalMemory:            0x200000000,
+					FreeMemory:             0x10000000,
+				},
+				SFlowPORTNAME{
+					SFlowBaseCounterRecord: SFlowBaseCounterRecord{Format: SFlowTypePORTNAMECounters},
+					Str:                    "eth0.10",
+				},
+			},
+		}},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := s.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	if s.SampleCount != 2 || s.FlowSamples[0].RecordCount != 3 || s.CounterSamples[0].SampleLength != 72 {
+		t.Errorf("lengths not fixed: %+v", s)
+	}
+	if raw := s.FlowSamples[0].Records[0].(SFlowRawPacketFlowRecord); raw.HeaderLength != 34 || raw.FlowDataLength != 52 {
+		t.Errorf("raw packet record lengths not fixed: %+v", raw)
+	}
+
+	var got SFlowDatagram
+	if err := got.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	raw := got.FlowSamples[0].Records[0].(SFlowRawPacketFlowRecord)
+	if !bytes.Equal(raw.Header.Data(), append(frame, 0, 0)) {
+		t.Errorf("decoded header %x", raw.Header.Data())
+	}
+	got.FlowSamples[0].Records[0] = s.FlowSamples[0].Records[0]
+	got.AgentAddress = s.AgentAddress
+	if !reflect.DeepEqual(&got, s) {
+		t.Errorf("decoded\n%#v\nwant\n%#v", &got, s)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -189,6 +190,11 @@ type SIP struct {
 	Method  SIPMethod
 	Headers map[string][]string
 
+	// HeaderNames holds the names of the headers, as written in the
+	// message and in order. SerializeTo uses it to keep the header order
+	// and compact forms of a decoded message.
+	HeaderNames []string
+
 	// Request
 	RequestURI string
 
@@ -213,6 +219,9 @@ func decodeSIP(data []byte, p gopacket.PacketBuilder) error {
 	}
 	p.AddLayer(s)
 	p.SetApplicationLayer(s)
+	if len(s.BaseLayer.Payload) > 0 && s.NextLayerType() == LayerTypeSDP {
+		return p.NextDecoder(LayerTypeSDP)
+	}
 	return nil
 }
 
@@ -238,8 +247,17 @@ func (s *SIP) CanDecode() gopacket.LayerClass {
 	return LayerTypeSIP
 }
 
-// NextLayerType returns the layer type contained by this DecodingLayer
+// NextLayerType returns the layer type contained by this DecodingLayer:
+// LayerTypeSDP if the body is a session description, and
+// gopacket.LayerTypePayload otherwise.
 func (s *SIP) NextLayerType() gopacket.LayerType {
+	contentType := s.GetFirstHeader("Content-Type")
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	if strings.EqualFold(strings.TrimSpace(contentType), "application/sdp") {
+		return LayerTypeSDP
+	}
 	return gopacket.LayerTypePayload
 }
 
@@ -251,6 +269,16 @@ func (s *SIP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 	var err error
 	var offset int
 
+	if s.Headers == nil {
+		s.Headers = make(map[string][]string)
+	}
+	for name := range s.Headers {
+		delete(s.Headers, name)
+	}
+	s.HeaderNames = s.HeaderNames[:0]
+	s.IsResponse = false
+	s.cseq, s.contentLength = 0, 0
+
 	// Iterate on all lines of the SIP Headers
 	// and stop when we reach the SDP (aka when the new line
 	// is at index 0 of the remaining packet)
@@ -400,11 +428,13 @@ func (s *SIP) ParseHeader(header []byte) (err error) {
 	index := bytes.Index(header, []byte(":"))
 	if index >= 0 {
 
-		headerName := strings.ToLower(string(bytes.Trim(header[:index], " ")))
+		rawName := string(bytes.Trim(header[:index], " "))
+		headerName := strings.ToLower(rawName)
 		headerValue := string(bytes.Trim(header[index+1:], " "))
 
 		// Add header to object
 		s.Headers[headerName] = append(s.Headers[headerName], headerValue)
+		s.HeaderNames = append(s.HeaderNames, rawName)
 		s.lastHeaderParsed = headerName
 
 		// Compute specific headers
@@ -457,6 +487,108 @@ func (s *SIP) ParseSpecificHeaders(headerName string, headerValue string) (err e
 	return nil
 }
 
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+//
+// Headers are written in the order of HeaderNames, under the names found
+// there, followed by the headers missing from HeaderNames sorted by name.
+// The bytes already in the buffer, usually an SDP layer, are the body:
+// with FixLengths, the Content-Length header is set to their length.
+func (s *SIP) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	if opts.FixLengths {
+		s.setContentLength(len(b.Bytes()))
+	}
+
+	var buf bytes.Buffer
+	if s.IsResponse {
+		fmt.Fprintf(&buf, "%s %d %s\r\n", s.Version, s.ResponseCode, s.ResponseStatus)
+	} else {
+		if _, err := GetSIPMethod(s.Method.String()); err != nil {
+			return err
+		}
+		if s.RequestURI == "" {
+			return fmt.Errorf("SIP %s request without URI", s.Method)
+		}
+		fmt.Fprintf(&buf, "%s %s %s\r\n", s.Method, s.RequestURI, s.Version)
+	}
+
+	written := make(map[string]int)
+	for _, name := range s.HeaderNames {
+		headerName := strings.ToLower(name)
+		values := s.Headers[headerName]
+		if n := written[headerName]; n < len(values) {
+			fmt.Fprintf(&buf, "%s: %s\r\n", name, values[n])
+			written[headerName] = n + 1
+		}
+	}
+	remaining := make([]string, 0, len(s.Headers))
+	for headerName, values := range s.Headers {
+		if written[headerName] < len(values) {
+			remaining = append(remaining, headerName)
+		}
+	}
+	sort.Strings(remaining)
+	for _, headerName := range remaining {
+		name := canonicalSIPHeaderName(headerName)
+		for _, value := range s.Headers[headerName][written[headerName]:] {
+			fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+		}
+	}
+	buf.WriteString("\r\n")
+
+	bytes, err := b.PrependBytes(buf.Len())
+	if err != nil {
+		return err
+	}
+	copy(bytes, buf.Bytes())
+	return nil
+}
+
+// setContentLength sets the Content-Length header, or its compact form if
+// the message uses it, to n.
+func (s *SIP) setContentLength(n int) {
+	if s.Headers == nil {
+		s.Headers = make(map[string][]string)
+	}
+	headerName := "content-length"
+	if _, ok := s.Headers[headerName]; !ok {
+		if _, ok := s.Headers["l"]; ok {
+			headerName = "l"
+		} else {
+			s.HeaderNames = append(s.HeaderNames, "Content-Length")
+		}
+	}
+	s.Headers[headerName] = []string{strconv.Itoa(n)}
+	s.contentLength = int64(n)
+}
+
+// canonicalSIPHeaderNames holds the header names whose usual spelling is
+// not the capitalization of each of their words.
+var canonicalSIPHeaderNames = map[string]string{
+	"call-id":          "Call-ID",
+	"cseq":             "CSeq",
+	"mime-version":     "MIME-Version",
+	"www-authenticate": "WWW-Authenticate",
+}
+
+// canonicalSIPHeaderName returns the usual spelling of a lower case header
+// name. Compact forms are left in lower case.
+func canonicalSIPHeaderName(headerName string) string {
+	if name, ok := canonicalSIPHeaderNames[headerName]; ok {
+		return name
+	}
+	if len(headerName) == 1 {
+		return headerName
+	}
+	words := strings.Split(headerName, "-")
+	for i, w := range words {
+		if w != "" {
+			words[i] = strings.ToUpper(w[:1]) + w[1:]
+		}
+	}
+	return strings.Join(words, "-")
+}
+
 // GetAllHeaders will return the full headers of the
 // current SIP packets in a map[string][]string
 func (s *SIP) GetAllHeaders() map[string][]string {
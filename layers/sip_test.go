@@ -7,6 +7,9 @@
 package layers
 
 import (
+	"net"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/google/gopacket"
@@ -197,3 +200,86 @@ func _TestPacketSIP(t *testing.T, packetData []byte, methodWanted SIPMethod, isR
 		}
 	}
 }
+
+func TestSIPSerializeRoundTrip(t *testing.T) {
+	for _, data := range [][]byte{testPacketSIPRequest, testPacketSIPResponse, testPacketSIPCompactInvite} {
+		p := gopacket.NewPacket(data, LinkTypeEthernet, gopacket.Default)
+		sip := p.Layer(LayerTypeSIP).(*SIP)
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, sip); err != nil {
+			t.Fatal(err)
+		}
+		// The captures have no space after the header colons.
+		lines := strings.Split(string(sip.Contents), "\r\n")
+		for i := 1; i < len(lines); i++ {
+			if j := strings.Index(lines[i], ":"); j >= 0 {
+				lines[i] = lines[i][:j] + ": " + strings.TrimSpace(lines[i][j+1:])
+			}
+		}
+		want := strings.Join(lines, "\r\n")
+		if got := string(buf.Bytes()); got != want {
+			t.Errorf("got\n%s\nwant\n%s", got, want)
+		}
+		got := NewSIP()
+		if err := got.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Headers, sip.Headers) || !reflect.DeepEqual(got.HeaderNames, sip.HeaderNames) {
+			t.Errorf("got headers %v %v, want %v %v", got.HeaderNames, got.Headers, sip.HeaderNames, sip.Headers)
+		}
+	}
+}
+
+func TestSIPSerializeWithSDP(t *testing.T) {
+	invite := &SIP{
+		Version:    SIPVersion2,
+		Method:     SIPMethodInvite,
+		RequestURI: "sip:alice@example.com",
+		Headers: map[string][]string{
+			"call-id":      {"a84b4c76e66710@pc33.example.com"},
+			"cseq":         {"314159 INVITE"},
+			"content-type": {"application/sdp"},
+			"v":            {"SIP/2.0/UDP pc33.example.com;branch=z9hG4bK776asdhds"},
+		},
+		HeaderNames: []string{"v", "Call-ID"},
+	}
+	sdp := &SDP{
+		Origin:      SDPOrigin{Username: "bob", SessionID: 2890844526, SessionVersion: 2890844526, NetworkType: "IN", AddressType: "IP4", Address: "192.0.2.4"},
+		SessionName: "-",
+		Connection:  &SDPConnection{NetworkType: "IN", AddressType: "IP4", Address: "192.0.2.4"},
+		Timings:     []SDPTiming{{}},
+		Media: []SDPMedia{{
+			Type: "audio", Port: 49170, Protocol: "RTP/AVP", Formats: []string{"0", "101"},
+			Attributes: []SDPAttribute{{Key: "rtpmap", Value: "101 telephone-event/8000"}, {Key: "fmtp", Value: "101 0-15"}},
+		}},
+	}
+	ip := &IPv4{Version: 4, TTL: 64, Protocol: IPProtocolUDP, SrcIP: net.IP{192, 0, 2, 4}, DstIP: net.IP{192, 0, 2, 5}}
+	udp := &UDP{SrcPort: 5060, DstPort: 5060}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		&Ethernet{SrcMAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}, DstMAC: net.HardwareAddr{2, 0, 0, 0, 0, 2}, EthernetType: EthernetTypeIPv4},
+		ip, udp, invite, sdp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), LinkTypeEthernet, gopacket.Default)
+	if p.ErrorLayer() != nil {
+		t.Fatal(p.ErrorLayer().Error())
+	}
+	checkLayers(p, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeUDP, LayerTypeSIP, LayerTypeSDP}, t)
+	sip := p.Layer(LayerTypeSIP).(*SIP)
+	wantNames := []string{"v", "Call-ID", "Content-Length", "Content-Type", "CSeq"}
+	if !reflect.DeepEqual(sip.HeaderNames, wantNames) {
+		t.Errorf("got header names %v, want %v", sip.HeaderNames, wantNames)
+	}
+	if sip.GetContentLength() != int64(len(sip.Payload())) {
+		t.Errorf("content length %d for a %d bytes body", sip.GetContentLength(), len(sip.Payload()))
+	}
+	got := p.Layer(LayerTypeSDP).(*SDP)
+	codecs := got.Media[0].Codecs()
+	if got.MediaConnection(&got.Media[0]).Address != "192.0.2.4" || got.Media[0].Port != 49170 ||
+		len(codecs) != 2 || codecs[0].Name != "PCMU" || codecs[1].Name != "telephone-event" || codecs[1].Parameters != "0-15" {
+		t.Errorf("got SDP %+v", got)
+	}
+}
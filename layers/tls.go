@@ -124,10 +124,12 @@ func (t *TLS) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 	t.AppData = t.AppData[:0]
 	t.Alert = t.Alert[:0]
 
-	return t.decodeTLSRecords(data, df)
+	return t.decodeTLSRecords(data, nil, df)
 }
 
-func (t *TLS) decodeTLSRecords(data []byte, df gopacket.DecodeFeedback) error {
+// decodeTLSRecords decodes the records in data. pending is the fragment of a
+// handshake message ending the previous record.
+func (t *TLS) decodeTLSRecords(data, pending []byte, df gopacket.DecodeFeedback) error {
 	if len(data) < 5 {
 		df.SetTruncated()
 		return errors.New("TLS record too short")
@@ -173,11 +175,16 @@ func (t *TLS) decodeTLSRecords(data []byte, df gopacket.DecodeFeedback) error {
 		t.Alert = append(t.Alert, r)
 	case TLSHandshake:
 		var r TLSHandshakeRecord
-		e := r.decodeFromBytes(h, data[hl:tl], df)
-		if e != nil {
+		if len(t.ChangeCipherSpec) > 0 {
+			// Handshake messages following a ChangeCipherSpec are
+			// encrypted, like the Finished message.
+			r.TLSRecordHeader = h
+			r.EncryptedMsg = data[hl:tl]
+		} else if e := r.decodeFromBytes(h, data[hl:tl], pending, df); e != nil {
 			return e
 		}
 		t.Handshake = append(t.Handshake, r)
+		pending = r.Fragment
 	case TLSApplicationData:
 		var r TLSAppDataRecord
 		e := r.decodeFromBytes(h, data[hl:tl], df)
@@ -190,7 +197,10 @@ func (t *TLS) decodeTLSRecords(data []byte, df gopacket.DecodeFeedback) error {
 	if len(data) == tl {
 		return nil
 	}
-	return t.decodeTLSRecords(data[tl:len(data)], df)
+	if h.ContentType != TLSHandshake {
+		pending = nil
+	}
+	return t.decodeTLSRecords(data[tl:len(data)], pending, df)
 }
 
 // CanDecode implements gopacket.DecodingLayer.
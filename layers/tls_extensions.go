@@ -0,0 +1,368 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// TLSExtensionType defines the type of a hello extension
+type TLSExtensionType uint16
+
+// TLSExtensionType known values.
+const (
+	TLSExtServerName                 TLSExtensionType = 0
+	TLSExtMaxFragmentLength          TLSExtensionType = 1
+	TLSExtStatusRequest              TLSExtensionType = 5
+	TLSExtSupportedGroups            TLSExtensionType = 10
+	TLSExtECPointFormats             TLSExtensionType = 11
+	TLSExtSignatureAlgorithms        TLSExtensionType = 13
+	TLSExtUseSRTP                    TLSExtensionType = 14
+	TLSExtHeartbeat                  TLSExtensionType = 15
+	TLSExtALPN                       TLSExtensionType = 16
+	TLSExtSignedCertificateTimestamp TLSExtensionType = 18
+	TLSExtPadding                    TLSExtensionType = 21
+	TLSExtEncryptThenMAC             TLSExtensionType = 22
+	TLSExtExtendedMasterSecret       TLSExtensionType = 23
+	TLSExtCompressCertificate        TLSExtensionType = 27
+	TLSExtRecordSizeLimit            TLSExtensionType = 28
+	TLSExtSessionTicket              TLSExtensionType = 35
+	TLSExtPreSharedKey               TLSExtensionType = 41
+	TLSExtEarlyData                  TLSExtensionType = 42
+	TLSExtSupportedVersions          TLSExtensionType = 43
+	TLSExtCookie                     TLSExtensionType = 44
+	TLSExtPSKKeyExchangeModes        TLSExtensionType = 45
+	TLSExtCertificateAuthorities     TLSExtensionType = 47
+	TLSExtPostHandshakeAuth          TLSExtensionType = 49
+	TLSExtSignatureAlgorithmsCert    TLSExtensionType = 50
+	TLSExtKeyShare                   TLSExtensionType = 51
+	TLSExtEncryptedClientHello       TLSExtensionType = 0xfe0d
+	TLSExtRenegotiationInfo          TLSExtensionType = 0xff01
+)
+
+// String shows the extension type nicely formatted
+func (et TLSExtensionType) String() string {
+	switch et {
+	default:
+		if IsTLSGrease(uint16(et)) {
+			return "GREASE"
+		}
+		return fmt.Sprintf("Unknown(%d)", uint16(et))
+	case TLSExtServerName:
+		return "server_name"
+	case TLSExtMaxFragmentLength:
+		return "max_fragment_length"
+	case TLSExtStatusRequest:
+		return "status_request"
+	case TLSExtSupportedGroups:
+		return "supported_groups"
+	case TLSExtECPointFormats:
+		return "ec_point_formats"
+	case TLSExtSignatureAlgorithms:
+		return "signature_algorithms"
+	case TLSExtUseSRTP:
+		return "use_srtp"
+	case TLSExtHeartbeat:
+		return "heartbeat"
+	case TLSExtALPN:
+		return "application_layer_protocol_negotiation"
+	case TLSExtSignedCertificateTimestamp:
+		return "signed_certificate_timestamp"
+	case TLSExtPadding:
+		return "padding"
+	case TLSExtEncryptThenMAC:
+		return "encrypt_then_mac"
+	case TLSExtExtendedMasterSecret:
+		return "extended_master_secret"
+	case TLSExtCompressCertificate:
+		return "compress_certificate"
+	case TLSExtRecordSizeLimit:
+		return "record_size_limit"
+	case TLSExtSessionTicket:
+		return "session_ticket"
+	case TLSExtPreSharedKey:
+		return "pre_shared_key"
+	case TLSExtEarlyData:
+		return "early_data"
+	case TLSExtSupportedVersions:
+		return "supported_versions"
+	case TLSExtCookie:
+		return "cookie"
+	case TLSExtPSKKeyExchangeModes:
+		return "psk_key_exchange_modes"
+	case TLSExtCertificateAuthorities:
+		return "certificate_authorities"
+	case TLSExtPostHandshakeAuth:
+		return "post_handshake_auth"
+	case TLSExtSignatureAlgorithmsCert:
+		return "signature_algorithms_cert"
+	case TLSExtKeyShare:
+		return "key_share"
+	case TLSExtEncryptedClientHello:
+		return "encrypted_client_hello"
+	case TLSExtRenegotiationInfo:
+		return "renegotiation_info"
+	}
+}
+
+// IsTLSGrease returns true if v is one of the GREASE values reserved by
+// RFC 8701 for cipher suites, extensions, groups and versions.
+func IsTLSGrease(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// TLSExtension is a raw hello extension
+type TLSExtension struct {
+	Type TLSExtensionType
+	Data []byte
+}
+
+// TLSKeyShare is a key_share entry
+type TLSKeyShare struct {
+	Group       uint16
+	KeyExchange []byte
+}
+
+// TLSPSKIdentity is an identity offered in the pre_shared_key extension
+type TLSPSKIdentity struct {
+	Identity            []byte
+	ObfuscatedTicketAge uint32
+}
+
+// TLSPreSharedKey is the pre_shared_key extension sent in a ClientHello
+type TLSPreSharedKey struct {
+	Identities []TLSPSKIdentity
+	Binders    [][]byte
+}
+
+func decodeTLSExtensions(data []byte) ([]TLSExtension, error) {
+	if len(data) < 2 {
+		return nil, errors.New("TLS extensions too short")
+	}
+	l := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) != l {
+		return nil, errors.New("TLS extensions length mismatch")
+	}
+	var exts []TLSExtension
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errors.New("TLS extension too short")
+		}
+		el := int(binary.BigEndian.Uint16(data[2:]))
+		if len(data) < 4+el {
+			return nil, errors.New("TLS extension length mismatch")
+		}
+		exts = append(exts, TLSExtension{
+			Type: TLSExtensionType(binary.BigEndian.Uint16(data)),
+			Data: data[4 : 4+el],
+		})
+		data = data[4+el:]
+	}
+	return exts, nil
+}
+
+// tlsVector returns the content of a vector prefixed by a size byte length
+// and checks that it covers exactly data.
+func tlsVector(data []byte, size int) ([]byte, error) {
+	if len(data) < size {
+		return nil, errors.New("TLS vector too short")
+	}
+	var l int
+	if size == 1 {
+		l = int(data[0])
+	} else {
+		l = int(binary.BigEndian.Uint16(data))
+	}
+	if len(data) != size+l {
+		return nil, errors.New("TLS vector length mismatch")
+	}
+	return data[size:], nil
+}
+
+func tlsUint16List(data []byte, size int) ([]uint16, error) {
+	v, err := tlsVector(data, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(v)%2 != 0 {
+		return nil, errors.New("TLS vector odd length")
+	}
+	l := make([]uint16, len(v)/2)
+	for i := range l {
+		l[i] = binary.BigEndian.Uint16(v[2*i:])
+	}
+	return l, nil
+}
+
+func decodeTLSALPN(data []byte) ([]string, error) {
+	v, err := tlsVector(data, 2)
+	if err != nil {
+		return nil, err
+	}
+	var protos []string
+	for len(v) > 0 {
+		l := int(v[0])
+		if len(v) < 1+l {
+			return nil, errors.New("TLS ALPN protocol length mismatch")
+		}
+		protos = append(protos, string(v[1:1+l]))
+		v = v[1+l:]
+	}
+	return protos, nil
+}
+
+func decodeTLSKeyShare(data []byte) (TLSKeyShare, int, error) {
+	if len(data) < 4 {
+		return TLSKeyShare{}, 0, errors.New("TLS key share too short")
+	}
+	l := int(binary.BigEndian.Uint16(data[2:]))
+	if len(data) < 4+l {
+		return TLSKeyShare{}, 0, errors.New("TLS key share length mismatch")
+	}
+	return TLSKeyShare{
+		Group:       binary.BigEndian.Uint16(data),
+		KeyExchange: data[4 : 4+l],
+	}, 4 + l, nil
+}
+
+func (ch *TLSClientHello) decodeExtension(e TLSExtension) error {
+	var err error
+	switch e.Type {
+	case TLSExtServerName:
+		var v []byte
+		if v, err = tlsVector(e.Data, 2); err != nil {
+			return err
+		}
+		for len(v) >= 3 {
+			l := int(binary.BigEndian.Uint16(v[1:]))
+			if len(v) < 3+l {
+				return errors.New("TLS server name length mismatch")
+			}
+			if v[0] == 0 { // host_name
+				ch.ServerName = string(v[3 : 3+l])
+			}
+			v = v[3+l:]
+		}
+	case TLSExtALPN:
+		ch.ALPNProtocols, err = decodeTLSALPN(e.Data)
+	case TLSExtSupportedVersions:
+		var l []uint16
+		l, err = tlsUint16List(e.Data, 1)
+		for _, v := range l {
+			ch.SupportedVersions = append(ch.SupportedVersions, TLSVersion(v))
+		}
+	case TLSExtSupportedGroups:
+		ch.SupportedGroups, err = tlsUint16List(e.Data, 2)
+	case TLSExtECPointFormats:
+		ch.ECPointFormats, err = tlsVector(e.Data, 1)
+	case TLSExtSignatureAlgorithms:
+		ch.SignatureAlgorithms, err = tlsUint16List(e.Data, 2)
+	case TLSExtPSKKeyExchangeModes:
+		ch.PSKModes, err = tlsVector(e.Data, 1)
+	case TLSExtKeyShare:
+		var v []byte
+		if v, err = tlsVector(e.Data, 2); err != nil {
+			return err
+		}
+		for len(v) > 0 {
+			ks, n, err := decodeTLSKeyShare(v)
+			if err != nil {
+				return err
+			}
+			ch.KeyShares = append(ch.KeyShares, ks)
+			v = v[n:]
+		}
+	case TLSExtPreSharedKey:
+		ch.PreSharedKey, err = decodeTLSPreSharedKey(e.Data)
+	}
+	return err
+}
+
+func decodeTLSPreSharedKey(data []byte) (*TLSPreSharedKey, error) {
+	if len(data) < 2 {
+		return nil, errors.New("TLS pre shared key too short")
+	}
+	il := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+il {
+		return nil, errors.New("TLS pre shared key identities length mismatch")
+	}
+	psk := &TLSPreSharedKey{}
+	ids := data[2 : 2+il]
+	for len(ids) > 0 {
+		if len(ids) < 2 {
+			return nil, errors.New("TLS pre shared key identity too short")
+		}
+		l := int(binary.BigEndian.Uint16(ids))
+		if len(ids) < 2+l+4 {
+			return nil, errors.New("TLS pre shared key identity length mismatch")
+		}
+		psk.Identities = append(psk.Identities, TLSPSKIdentity{
+			Identity:            ids[2 : 2+l],
+			ObfuscatedTicketAge: binary.BigEndian.Uint32(ids[2+l:]),
+		})
+		ids = ids[2+l+4:]
+	}
+	binders, err := tlsVector(data[2+il:], 2)
+	if err != nil {
+		return nil, err
+	}
+	for len(binders) > 0 {
+		l := int(binders[0])
+		if len(binders) < 1+l {
+			return nil, errors.New("TLS pre shared key binder length mismatch")
+		}
+		psk.Binders = append(psk.Binders, binders[1:1+l])
+		binders = binders[1+l:]
+	}
+	return psk, nil
+}
+
+func (sh *TLSServerHello) decodeExtension(e TLSExtension) error {
+	switch e.Type {
+	case TLSExtALPN:
+		protos, err := decodeTLSALPN(e.Data)
+		if err != nil {
+			return err
+		}
+		if len(protos) != 1 {
+			return errors.New("TLS Server Hello must select a single ALPN protocol")
+		}
+		sh.ALPNProtocol = protos[0]
+	case TLSExtSupportedVersions:
+		if len(e.Data) != 2 {
+			return errors.New("TLS Server Hello supported versions length mismatch")
+		}
+		sh.SelectedVersion = TLSVersion(binary.BigEndian.Uint16(e.Data))
+	case TLSExtKeyShare:
+		if sh.IsHelloRetryRequest() {
+			// A HelloRetryRequest only carries the selected group
+			if len(e.Data) != 2 {
+				return errors.New("TLS Hello Retry Request key share length mismatch")
+			}
+			sh.KeyShare = &TLSKeyShare{Group: binary.BigEndian.Uint16(e.Data)}
+			return nil
+		}
+		ks, n, err := decodeTLSKeyShare(e.Data)
+		if err != nil {
+			return err
+		}
+		if n != len(e.Data) {
+			return errors.New("TLS Server Hello key share length mismatch")
+		}
+		sh.KeyShare = &ks
+	case TLSExtPreSharedKey:
+		if len(e.Data) != 2 {
+			return errors.New("TLS Server Hello pre shared key length mismatch")
+		}
+		id := binary.BigEndian.Uint16(e.Data)
+		sh.SelectedPSKIdentity = &id
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The fingerprints below only depend on the hello messages, so they can be
+// computed on a TLS layer decoded from a single packet as well as on one
+// decoded from a stream reassembled by the reassembly package, which is
+// needed when a hello spans several TCP segments.
+
+// JA3 returns the MD5 JA3 fingerprint of the first ClientHello of t, or an
+// empty string if t doesn't carry a ClientHello.
+func (t *TLS) JA3() string {
+	if ch := t.ClientHello(); ch != nil {
+		return ch.JA3()
+	}
+	return ""
+}
+
+// JA3S returns the MD5 JA3S fingerprint of the first ServerHello of t, or an
+// empty string if t doesn't carry a ServerHello.
+func (t *TLS) JA3S() string {
+	if sh := t.ServerHello(); sh != nil {
+		return sh.JA3S()
+	}
+	return ""
+}
+
+// JA4 returns the JA4 fingerprint of the first ClientHello of t, or an empty
+// string if t doesn't carry a ClientHello.
+func (t *TLS) JA4() string {
+	if ch := t.ClientHello(); ch != nil {
+		return ch.JA4()
+	}
+	return ""
+}
+
+// JA3String returns the JA3 fingerprint string of the ClientHello, before
+// hashing: SSLVersion,Ciphers,Extensions,EllipticCurves,EllipticCurvePointFormats
+// GREASE values are ignored.
+func (ch *TLSClientHello) JA3String() string {
+	var exts []uint16
+	for _, e := range ch.Extensions {
+		exts = append(exts, uint16(e.Type))
+	}
+	formats := make([]uint16, len(ch.ECPointFormats))
+	for i, f := range ch.ECPointFormats {
+		formats[i] = uint16(f)
+	}
+	return strings.Join([]string{
+		strconv.Itoa(int(ch.Version)),
+		ja3List(ch.CipherSuites),
+		ja3List(exts),
+		ja3List(ch.SupportedGroups),
+		ja3List(formats),
+	}, ",")
+}
+
+// JA3 returns the hex encoded MD5 hash of JA3String.
+func (ch *TLSClientHello) JA3() string {
+	h := md5.Sum([]byte(ch.JA3String()))
+	return hex.EncodeToString(h[:])
+}
+
+// JA3SString returns the JA3S fingerprint string of the ServerHello, before
+// hashing: SSLVersion,Cipher,Extensions
+func (sh *TLSServerHello) JA3SString() string {
+	var exts []uint16
+	for _, e := range sh.Extensions {
+		exts = append(exts, uint16(e.Type))
+	}
+	return strings.Join([]string{
+		strconv.Itoa(int(sh.Version)),
+		strconv.Itoa(int(sh.CipherSuite)),
+		ja3List(exts),
+	}, ",")
+}
+
+// JA3S returns the hex encoded MD5 hash of JA3SString.
+func (sh *TLSServerHello) JA3S() string {
+	h := md5.Sum([]byte(sh.JA3SString()))
+	return hex.EncodeToString(h[:])
+}
+
+func ja3List(l []uint16) string {
+	s := make([]string, 0, len(l))
+	for _, v := range l {
+		if !IsTLSGrease(v) {
+			s = append(s, strconv.Itoa(int(v)))
+		}
+	}
+	return strings.Join(s, "-")
+}
+
+// JA4 returns the JA4 fingerprint of the ClientHello, as seen over TCP.
+func (ch *TLSClientHello) JA4() string {
+	a, ciphers, exts := ch.ja4Parts()
+	return a + "_" + ja4Hash(ciphers) + "_" + ja4Hash(exts)
+}
+
+// JA4Raw returns the JA4 fingerprint of the ClientHello with the sorted
+// cipher suite and extension lists left unhashed, also known as JA4_r.
+func (ch *TLSClientHello) JA4Raw() string {
+	a, ciphers, exts := ch.ja4Parts()
+	return a + "_" + ciphers + "_" + exts
+}
+
+func (ch *TLSClientHello) ja4Parts() (a, ciphers, exts string) {
+	version := ch.Version
+	for _, v := range ch.SupportedVersions {
+		if !IsTLSGrease(uint16(v)) && v > version {
+			version = v
+		}
+	}
+
+	sni := "i"
+	var cs, es []string
+	for _, c := range ch.CipherSuites {
+		if !IsTLSGrease(c) {
+			cs = append(cs, fmt.Sprintf("%04x", c))
+		}
+	}
+	extCount := 0
+	for _, e := range ch.Extensions {
+		if IsTLSGrease(uint16(e.Type)) {
+			continue
+		}
+		extCount++
+		switch e.Type {
+		case TLSExtServerName:
+			sni = "d"
+		case TLSExtALPN:
+		default:
+			es = append(es, fmt.Sprintf("%04x", uint16(e.Type)))
+		}
+	}
+	sort.Strings(cs)
+	sort.Strings(es)
+
+	a = fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(version), sni, ja4Count(len(cs)), ja4Count(extCount), ja4ALPN(ch.ALPNProtocols))
+
+	ciphers = strings.Join(cs, ",")
+	exts = strings.Join(es, ",")
+	if len(ch.SignatureAlgorithms) > 0 {
+		algs := make([]string, len(ch.SignatureAlgorithms))
+		for i, s := range ch.SignatureAlgorithms {
+			algs[i] = fmt.Sprintf("%04x", s)
+		}
+		exts += "_" + strings.Join(algs, ",")
+	}
+	return
+}
+
+func ja4Version(v TLSVersion) string {
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case 0x0200:
+		return "s2"
+	}
+	return "00"
+}
+
+func ja4Count(n int) int {
+	if n > 99 {
+		return 99
+	}
+	return n
+}
+
+func ja4ALPN(protos []string) string {
+	if len(protos) == 0 || len(protos[0]) == 0 {
+		return "00"
+	}
+	p := protos[0]
+	first, last := p[0], p[len(p)-1]
+	if isASCIIAlnum(first) && isASCIIAlnum(last) {
+		return string([]byte{first, last})
+	}
+	h := hex.EncodeToString([]byte(p))
+	return string([]byte{h[0], h[len(h)-1]})
+}
+
+func isASCIIAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func ja4Hash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])[:12]
+}
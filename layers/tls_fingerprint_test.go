@@ -0,0 +1,99 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+func TestTLSFingerprints(t *testing.T) {
+	for _, test := range []struct {
+		name            string
+		data            []byte
+		ja3, ja3s, ja4  string
+		ja3Str, ja3sStr string
+	}{
+		{
+			name:   "ClientHello",
+			data:   testClientHello[54:],
+			ja3Str: "769,49172-49162-57-56-136-135-49167-49157-53-132-49171-49161-51-50-154-153-69-68-49166-49156-47-150-65-49169-49159-49164-49154-5-4-49170-49160-22-19-49165-49155-10-21-18-9-20-17-8-6-3-255,11-10-35-15,14-13-25-11-12-24-9-10-22-23-8-6-7-20-21-4-5-18-19-1-2-3-15-16-17,0-1-2",
+			ja3:    "2648a3430cfab8cd9cfe83dd572bee30",
+			ja4:    "t10i450400_a38737f0bdfa_282f11336259",
+		},
+		{
+			name:    "ServerHello",
+			data:    testServerHello,
+			ja3sStr: "769,47,65281-35-15",
+			ja3s:    "d34cdf3ab2ca82a6542791bde391a97e",
+		},
+		{
+			name:   "TLS13ClientHello",
+			data:   testTLS13ClientHello,
+			ja3Str: "771,49195-49199-49196-49200-52393-52392-49161-49171-49162-49172-4865-4866-4867,0-11-65281-23-18-5-10-13-50-16-43-51,29,0",
+			ja3:    "6a32724b58a400f4cfe7e1a93a55e41e",
+			ja4:    "t13d1312h2_f57a46bbacb6_a089bac06eae",
+		},
+	} {
+		var tls TLS
+		if err := tls.DecodeFromBytes(test.data, gopacket.NilDecodeFeedback); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := tls.JA3(); got != test.ja3 {
+			t.Errorf("%s: JA3 got %q, want %q", test.name, got, test.ja3)
+		}
+		if got := tls.JA3S(); got != test.ja3s {
+			t.Errorf("%s: JA3S got %q, want %q", test.name, got, test.ja3s)
+		}
+		if got := tls.JA4(); got != test.ja4 {
+			t.Errorf("%s: JA4 got %q, want %q", test.name, got, test.ja4)
+		}
+		if ch := tls.ClientHello(); ch != nil && ch.JA3String() != test.ja3Str {
+			t.Errorf("%s: JA3 string got %q, want %q", test.name, ch.JA3String(), test.ja3Str)
+		}
+		if sh := tls.ServerHello(); sh != nil && sh.JA3SString() != test.ja3sStr {
+			t.Errorf("%s: JA3S string got %q, want %q", test.name, sh.JA3SString(), test.ja3sStr)
+		}
+	}
+}
+
+func TestTLSFingerprintGrease(t *testing.T) {
+	// Example from the JA4 specification, with GREASE values added
+	ch := &TLSClientHello{
+		Version: 0x0303,
+		CipherSuites: []uint16{
+			0x1a1a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9,
+			0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035,
+		},
+		Extensions: []TLSExtension{
+			{Type: 0x2a2a}, {Type: TLSExtServerName}, {Type: TLSExtExtendedMasterSecret},
+			{Type: TLSExtRenegotiationInfo}, {Type: TLSExtSupportedGroups}, {Type: TLSExtECPointFormats},
+			{Type: TLSExtSessionTicket}, {Type: TLSExtALPN}, {Type: TLSExtStatusRequest},
+			{Type: TLSExtSignatureAlgorithms}, {Type: TLSExtSignedCertificateTimestamp},
+			{Type: TLSExtKeyShare}, {Type: TLSExtPSKKeyExchangeModes}, {Type: TLSExtSupportedVersions},
+			{Type: TLSExtCompressCertificate}, {Type: 0x4469}, {Type: TLSExtPadding}, {Type: 0x3a3a},
+		},
+		ALPNProtocols:       []string{"h2", "http/1.1"},
+		SupportedVersions:   []TLSVersion{0x4a4a, 0x0304, 0x0303},
+		SupportedGroups:     []uint16{0x5a5a, 0x001d, 0x0017, 0x0018},
+		ECPointFormats:      []uint8{0},
+		SignatureAlgorithms: []uint16{0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601},
+	}
+
+	if want := "t13d1516h2_8daaf6152771_e5627efa2ab1"; ch.JA4() != want {
+		t.Errorf("JA4 got %q, want %q", ch.JA4(), want)
+	}
+	wantRaw := "t13d1516h2_002f,0035,009c,009d,1301,1302,1303,c013,c014,c02b,c02c,c02f,c030,cca8,cca9_0005,000a,000b,000d,0012,0015,0017,001b,0023,002b,002d,0033,4469,ff01_0403,0804,0401,0503,0805,0501,0806,0601"
+	if ch.JA4Raw() != wantRaw {
+		t.Errorf("JA4_r got %q, want %q", ch.JA4Raw(), wantRaw)
+	}
+	wantJA3 := "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53,0-23-65281-10-11-35-16-5-13-18-51-45-43-27-17513-21,29-23-24,0"
+	if ch.JA3String() != wantJA3 {
+		t.Errorf("JA3 string got %q, want %q", ch.JA3String(), wantJA3)
+	}
+}
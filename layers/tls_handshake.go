@@ -7,22 +7,515 @@
 package layers
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
 	"github.com/google/gopacket"
 )
 
+// TLSHandshakeType defines the type of a handshake message
+type TLSHandshakeType uint8
+
+// TLSHandshakeType known values.
+const (
+	TLSHandshakeHelloRequest        TLSHandshakeType = 0
+	TLSHandshakeClientHello         TLSHandshakeType = 1
+	TLSHandshakeServerHello         TLSHandshakeType = 2
+	TLSHandshakeHelloVerifyRequest  TLSHandshakeType = 3
+	TLSHandshakeNewSessionTicket    TLSHandshakeType = 4
+	TLSHandshakeEndOfEarlyData      TLSHandshakeType = 5
+	TLSHandshakeEncryptedExtensions TLSHandshakeType = 8
+	TLSHandshakeCertificate         TLSHandshakeType = 11
+	TLSHandshakeServerKeyExchange   TLSHandshakeType = 12
+	TLSHandshakeCertificateRequest  TLSHandshakeType = 13
+	TLSHandshakeServerHelloDone     TLSHandshakeType = 14
+	TLSHandshakeCertificateVerify   TLSHandshakeType = 15
+	TLSHandshakeClientKeyExchange   TLSHandshakeType = 16
+	TLSHandshakeFinished            TLSHandshakeType = 20
+	TLSHandshakeCertificateStatus   TLSHandshakeType = 22
+	TLSHandshakeKeyUpdate           TLSHandshakeType = 24
+	TLSHandshakeMessageHash         TLSHandshakeType = 254
+)
+
+// String shows the handshake type nicely formatted
+func (ht TLSHandshakeType) String() string {
+	switch ht {
+	default:
+		return "Unknown"
+	case TLSHandshakeHelloRequest:
+		return "Hello Request"
+	case TLSHandshakeClientHello:
+		return "Client Hello"
+	case TLSHandshakeServerHello:
+		return "Server Hello"
+	case TLSHandshakeHelloVerifyRequest:
+		return "Hello Verify Request"
+	case TLSHandshakeNewSessionTicket:
+		return "New Session Ticket"
+	case TLSHandshakeEndOfEarlyData:
+		return "End Of Early Data"
+	case TLSHandshakeEncryptedExtensions:
+		return "Encrypted Extensions"
+	case TLSHandshakeCertificate:
+		return "Certificate"
+	case TLSHandshakeServerKeyExchange:
+		return "Server Key Exchange"
+	case TLSHandshakeCertificateRequest:
+		return "Certificate Request"
+	case TLSHandshakeServerHelloDone:
+		return "Server Hello Done"
+	case TLSHandshakeCertificateVerify:
+		return "Certificate Verify"
+	case TLSHandshakeClientKeyExchange:
+		return "Client Key Exchange"
+	case TLSHandshakeFinished:
+		return "Finished"
+	case TLSHandshakeCertificateStatus:
+		return "Certificate Status"
+	case TLSHandshakeKeyUpdate:
+		return "Key Update"
+	case TLSHandshakeMessageHash:
+		return "Message Hash"
+	}
+}
+
+//  TLS Handshake Message
+//  0  1  2  3  4  5  6  7  8
+//  +--+--+--+--+--+--+--+--+
+//  |     Handshake Type    |
+//  +--+--+--+--+--+--+--+--+
+//  |        Length         |
+//  +--+--+--+--+--+--+--+--+
+//  |        Length         |
+//  +--+--+--+--+--+--+--+--+
+//  |        Length         |
+//  +--+--+--+--+--+--+--+--+
+//  |         Body          |
+//  |          ...          |
+
 // TLSHandshakeRecord defines the structure of a Handshare Record
 type TLSHandshakeRecord struct {
 	TLSRecordHeader
+
+	// Messages holds the complete handshake messages carried by the
+	// record. It is empty when the record could not be framed as cleartext
+	// handshake messages, e.g. an encrypted Finished message, or when it
+	// follows a ChangeCipherSpec record in the same TLS layer.
+	Messages []TLSHandshakeMessage
+
+	// Fragment holds the start of a handshake message that continues in
+	// the next handshake record, e.g. a Certificate following a
+	// ServerHello. If the next record is decoded in the same TLS layer, the
+	// message is completed there, and Fragment of that record holds all
+	// the bytes of the message when it spans more than two records.
+	Fragment []byte
+
+	// EncryptedMsg holds the record payload if it is not a cleartext
+	// sequence of handshake messages.
+	EncryptedMsg []byte
 }
 
-// DecodeFromBytes decodes the slice into the TLS struct.
-func (t *TLSHandshakeRecord) decodeFromBytes(h TLSRecordHeader, data []byte, df gopacket.DecodeFeedback) error {
+// TLSHandshakeMessage is a single handshake message. Body always holds the
+// raw message body, and at most one of the typed fields is set according to
+// Type.
+type TLSHandshakeMessage struct {
+	Type   TLSHandshakeType
+	Length uint32
+	Body   []byte
+
+	ClientHello       *TLSClientHello
+	ServerHello       *TLSServerHello
+	Certificate       *TLSCertificate
+	ServerKeyExchange *TLSServerKeyExchange
+	Finished          *TLSFinished
+}
+
+// TLSClientHello is the body of a ClientHello handshake message.
+type TLSClientHello struct {
+	Version            TLSVersion
+	Random             []byte
+	SessionID          []byte
+	CipherSuites       []uint16
+	CompressionMethods []uint8
+	Extensions         []TLSExtension
+
+	// Values decoded from well known extensions.
+	ServerName          string
+	ALPNProtocols       []string
+	SupportedVersions   []TLSVersion
+	SupportedGroups     []uint16
+	ECPointFormats      []uint8
+	SignatureAlgorithms []uint16
+	KeyShares           []TLSKeyShare
+	PSKModes            []uint8
+	PreSharedKey        *TLSPreSharedKey
+
+	// ExtensionErrors holds the errors of the malformed extensions, whose
+	// values are left unset. They don't fail the decoding of the message.
+	ExtensionErrors []error
+}
+
+// TLSServerHello is the body of a ServerHello handshake message.
+type TLSServerHello struct {
+	Version           TLSVersion
+	Random            []byte
+	SessionID         []byte
+	CipherSuite       uint16
+	CompressionMethod uint8
+	Extensions        []TLSExtension
+
+	// Values decoded from well known extensions.
+	ALPNProtocol        string
+	SelectedVersion     TLSVersion
+	KeyShare            *TLSKeyShare
+	SelectedPSKIdentity *uint16
+
+	// ExtensionErrors holds the errors of the malformed extensions, whose
+	// values are left unset. They don't fail the decoding of the message.
+	ExtensionErrors []error
+}
+
+// tlsHelloRetryRequestRandom is the special Random value that marks a
+// ServerHello as a HelloRetryRequest, see RFC 8446 section 4.1.3.
+var tlsHelloRetryRequestRandom = []byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11, 0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e, 0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+}
+
+// IsHelloRetryRequest returns true if the ServerHello is a TLS 1.3
+// HelloRetryRequest.
+func (sh *TLSServerHello) IsHelloRetryRequest() bool {
+	return bytes.Equal(sh.Random, tlsHelloRetryRequestRandom)
+}
+
+// NegotiatedVersion returns the negotiated protocol version, taking the
+// supported_versions extension into account.
+func (sh *TLSServerHello) NegotiatedVersion() TLSVersion {
+	if sh.SelectedVersion != 0 {
+		return sh.SelectedVersion
+	}
+	return sh.Version
+}
+
+// TLSCertificate is the body of a Certificate handshake message. Context is
+// only present in the TLS 1.3 form of the message.
+type TLSCertificate struct {
+	Context []byte
+	// Certificates holds the raw DER encoded certificate chain, sender's
+	// certificate first.
+	Certificates [][]byte
+}
+
+// TLSServerKeyExchange is the body of a ServerKeyExchange handshake message.
+// Only the ECDHE named curve form is decoded, the content of other key
+// exchange methods depends on the negotiated cipher suite and is only
+// available in Data.
+type TLSServerKeyExchange struct {
+	Data []byte
+
+	CurveType          uint8
+	NamedCurve         uint16
+	PublicKey          []byte
+	SignatureAlgorithm uint16
+	Signature          []byte
+}
+
+// TLSFinished is the body of a Finished handshake message.
+type TLSFinished struct {
+	VerifyData []byte
+}
+
+// tlsMaxHandshakeLength is the largest handshake message length accepted
+// for a message continuing in the next record, as in crypto/tls.
+const tlsMaxHandshakeLength = 1 << 18
+
+// DecodeFromBytes decodes the slice into the TLS struct. pending is the
+// Fragment of the previous record, which data continues.
+func (t *TLSHandshakeRecord) decodeFromBytes(h TLSRecordHeader, data, pending []byte, df gopacket.DecodeFeedback) error {
 	// TLS Record Header
 	t.ContentType = h.ContentType
 	t.Version = h.Version
 	t.Length = h.Length
 
-	// TODO
+	msgs := data
+	if len(pending) > 0 {
+		msgs = append(append([]byte(nil), pending...), data...)
+	}
+	n, ok := tlsHandshakeFramed(msgs)
+	if !ok {
+		// Handshake messages sent after the ChangeCipherSpec are
+		// encrypted and can't be decoded.
+		t.EncryptedMsg = data
+		return nil
+	}
+
+	if n < len(msgs) {
+		t.Fragment = msgs[n:]
+	}
+	msgs = msgs[:n]
+	for len(msgs) > 0 {
+		var m TLSHandshakeMessage
+		m.Type = TLSHandshakeType(msgs[0])
+		m.Length = uint32(msgs[1])<<16 | uint32(msgs[2])<<8 | uint32(msgs[3])
+		m.Body = msgs[4 : 4+m.Length]
+		if err := m.decodeBody(); err != nil {
+			df.SetTruncated()
+			return err
+		}
+		t.Messages = append(t.Messages, m)
+		msgs = msgs[4+m.Length:]
+	}
+
+	return nil
+}
+
+// tlsHandshakeFramed returns the length of the complete handshake messages
+// of known types at the start of data. The rest of data must be the start of
+// a message continuing in the next record, otherwise ok is false.
+func tlsHandshakeFramed(data []byte) (n int, ok bool) {
+	for n < len(data) {
+		rest := data[n:]
+		if TLSHandshakeType(rest[0]).String() == "Unknown" {
+			return 0, false
+		}
+		if len(rest) < 4 {
+			return n, n > 0
+		}
+		l := int(rest[1])<<16 | int(rest[2])<<8 | int(rest[3])
+		if len(rest) < 4+l {
+			// Encrypted data starting with a known type has a random
+			// length, which is rarely this small.
+			return n, l <= tlsMaxHandshakeLength
+		}
+		n += 4 + l
+	}
+	return n, n > 0
+}
+
+func (m *TLSHandshakeMessage) decodeBody() error {
+	switch m.Type {
+	case TLSHandshakeClientHello:
+		m.ClientHello = &TLSClientHello{}
+		return m.ClientHello.decodeFromBytes(m.Body)
+	case TLSHandshakeServerHello:
+		m.ServerHello = &TLSServerHello{}
+		return m.ServerHello.decodeFromBytes(m.Body)
+	case TLSHandshakeCertificate:
+		m.Certificate = &TLSCertificate{}
+		return m.Certificate.decodeFromBytes(m.Body)
+	case TLSHandshakeServerKeyExchange:
+		m.ServerKeyExchange = &TLSServerKeyExchange{}
+		m.ServerKeyExchange.decodeFromBytes(m.Body)
+	case TLSHandshakeFinished:
+		m.Finished = &TLSFinished{VerifyData: m.Body}
+	}
+	return nil
+}
+
+//  TLS Client Hello
+//  +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//  |         Version       |    Random (32 bytes)  |
+//  +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//  | SessionID (8-bit len) | Ciphers (16-bit len)  |
+//  +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//  | Compression (8-bit)   | Extensions (16-bit)   |
+//  +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+
+func (ch *TLSClientHello) decodeFromBytes(data []byte) error {
+	if len(data) < 35 {
+		return errors.New("TLS Client Hello too short")
+	}
+	ch.Version = TLSVersion(binary.BigEndian.Uint16(data[0:2]))
+	ch.Random = data[2:34]
+	off := 34
+
+	sl := int(data[off])
+	off++
+	if len(data) < off+sl+2 {
+		return errors.New("TLS Client Hello session id length mismatch")
+	}
+	ch.SessionID = data[off : off+sl]
+	off += sl
+
+	cl := int(binary.BigEndian.Uint16(data[off : off+2]))
+	off += 2
+	if cl%2 != 0 || len(data) < off+cl+1 {
+		return errors.New("TLS Client Hello cipher suites length mismatch")
+	}
+	ch.CipherSuites = make([]uint16, cl/2)
+	for i := range ch.CipherSuites {
+		ch.CipherSuites[i] = binary.BigEndian.Uint16(data[off+2*i:])
+	}
+	off += cl
+
+	ml := int(data[off])
+	off++
+	if len(data) < off+ml {
+		return errors.New("TLS Client Hello compression methods length mismatch")
+	}
+	ch.CompressionMethods = data[off : off+ml]
+	off += ml
+
+	// Extensions are optional before TLS 1.2
+	if off == len(data) {
+		return nil
+	}
+	ch.Extensions, ch.ExtensionErrors = decodeTLSHelloExtensions(data[off:], ch.decodeExtension)
+	return nil
+}
+
+//  TLS Server Hello
+//  +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//  |         Version       |    Random (32 bytes)  |
+//  +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//  | SessionID (8-bit len) |     Cipher Suite      |
+//  +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//  |     Compression       | Extensions (16-bit)   |
+//  +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+
+func (sh *TLSServerHello) decodeFromBytes(data []byte) error {
+	if len(data) < 35 {
+		return errors.New("TLS Server Hello too short")
+	}
+	sh.Version = TLSVersion(binary.BigEndian.Uint16(data[0:2]))
+	sh.Random = data[2:34]
+	off := 34
+
+	sl := int(data[off])
+	off++
+	if len(data) < off+sl+3 {
+		return errors.New("TLS Server Hello session id length mismatch")
+	}
+	sh.SessionID = data[off : off+sl]
+	off += sl
+
+	sh.CipherSuite = binary.BigEndian.Uint16(data[off : off+2])
+	sh.CompressionMethod = data[off+2]
+	off += 3
+
+	if off == len(data) {
+		return nil
+	}
+	sh.Extensions, sh.ExtensionErrors = decodeTLSHelloExtensions(data[off:], sh.decodeExtension)
+	return nil
+}
+
+// decodeTLSHelloExtensions decodes the extensions of a hello message, and
+// the values of each extension with decode. It returns the errors of the
+// malformed extensions instead of failing.
+func decodeTLSHelloExtensions(data []byte, decode func(TLSExtension) error) ([]TLSExtension, []error) {
+	exts, err := decodeTLSExtensions(data)
+	if err != nil {
+		return nil, []error{err}
+	}
+	var errs []error
+	for _, e := range exts {
+		if err := decode(e); err != nil {
+			errs = append(errs, fmt.Errorf("%v extension: %v", e.Type, err))
+		}
+	}
+	return exts, errs
+}
+
+func (c *TLSCertificate) decodeFromBytes(data []byte) error {
+	if len(data) < 3 {
+		return errors.New("TLS Certificate too short")
+	}
+	// The TLS 1.3 message starts with a certificate_request_context, which
+	// can be detected since the TLS 1.2 form starts with a length covering
+	// the rest of the message.
+	tls13 := tlsUint24(data) != len(data)-3
+	if tls13 {
+		cl := int(data[0])
+		if len(data) < 1+cl+3 {
+			return errors.New("TLS Certificate context length mismatch")
+		}
+		c.Context = data[1 : 1+cl]
+		data = data[1+cl:]
+	}
+	if tlsUint24(data) != len(data)-3 {
+		return errors.New("TLS Certificate list length mismatch")
+	}
+	data = data[3:]
+
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return errors.New("TLS Certificate entry too short")
+		}
+		l := tlsUint24(data)
+		if len(data) < 3+l {
+			return errors.New("TLS Certificate entry length mismatch")
+		}
+		c.Certificates = append(c.Certificates, data[3:3+l])
+		data = data[3+l:]
+		if tls13 {
+			// Skip the per certificate extensions
+			if len(data) < 2 {
+				return errors.New("TLS Certificate entry extensions too short")
+			}
+			el := int(binary.BigEndian.Uint16(data))
+			if len(data) < 2+el {
+				return errors.New("TLS Certificate entry extensions length mismatch")
+			}
+			data = data[2+el:]
+		}
+	}
+	return nil
+}
+
+func (s *TLSServerKeyExchange) decodeFromBytes(data []byte) {
+	s.Data = data
+
+	// ECParameters with named_curve(3), followed by the public key and the
+	// TLS 1.2 digitally-signed struct.
+	if len(data) < 4 || data[0] != 3 {
+		return
+	}
+	kl := int(data[3])
+	if len(data) < 4+kl+2 {
+		return
+	}
+	s.CurveType = data[0]
+	s.NamedCurve = binary.BigEndian.Uint16(data[1:3])
+	s.PublicKey = data[4 : 4+kl]
+	sig := data[4+kl:]
+	if len(sig) >= 4 && int(binary.BigEndian.Uint16(sig[2:])) == len(sig)-4 {
+		s.SignatureAlgorithm = binary.BigEndian.Uint16(sig)
+		s.Signature = sig[4:]
+	} else if int(binary.BigEndian.Uint16(sig)) == len(sig)-2 {
+		// Versions before TLS 1.2 don't carry the signature algorithm
+		s.Signature = sig[2:]
+	}
+}
+
+func tlsUint24(b []byte) int {
+	return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+}
+
+// ClientHello returns the first ClientHello message found in the handshake
+// records of t, or nil.
+func (t *TLS) ClientHello() *TLSClientHello {
+	for _, r := range t.Handshake {
+		for _, m := range r.Messages {
+			if m.ClientHello != nil {
+				return m.ClientHello
+			}
+		}
+	}
+	return nil
+}
 
+// ServerHello returns the first ServerHello message found in the handshake
+// records of t, or nil.
+func (t *TLS) ServerHello() *TLSServerHello {
+	for _, r := range t.Handshake {
+		for _, m := range r.Messages {
+			if m.ServerHello != nil {
+				return m.ServerHello
+			}
+		}
+	}
 	return nil
 }
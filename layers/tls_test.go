@@ -5,6 +5,7 @@
 package layers
 
 import (
+	"bytes"
 	"reflect"
 	"testing"
 
@@ -47,6 +48,39 @@ var testClientHelloDecoded = &TLS{
 				Version:     0x0301,
 				Length:      209,
 			},
+			[]TLSHandshakeMessage{
+				{
+					Type:   TLSHandshakeClientHello,
+					Length: 205,
+					Body:   testClientHello[63:],
+					ClientHello: &TLSClientHello{
+						Version:   0x0301,
+						Random:    testClientHello[65:97],
+						SessionID: testClientHello[98:98],
+						CipherSuites: []uint16{
+							0xc014, 0xc00a, 0x0039, 0x0038, 0x0088, 0x0087, 0xc00f, 0xc005,
+							0x0035, 0x0084, 0xc013, 0xc009, 0x0033, 0x0032, 0x009a, 0x0099,
+							0x0045, 0x0044, 0xc00e, 0xc004, 0x002f, 0x0096, 0x0041, 0xc011,
+							0xc007, 0xc00c, 0xc002, 0x0005, 0x0004, 0xc012, 0xc008, 0x0016,
+							0x0013, 0xc00d, 0xc003, 0x000a, 0x0015, 0x0012, 0x0009, 0x0014,
+							0x0011, 0x0008, 0x0006, 0x0003, 0x00ff,
+						},
+						CompressionMethods: testClientHello[191:193],
+						Extensions: []TLSExtension{
+							{Type: TLSExtECPointFormats, Data: testClientHello[199:203]},
+							{Type: TLSExtSupportedGroups, Data: testClientHello[207:259]},
+							{Type: TLSExtSessionTicket, Data: testClientHello[263:263]},
+							{Type: TLSExtHeartbeat, Data: testClientHello[267:268]},
+						},
+						SupportedGroups: []uint16{
+							14, 13, 25, 11, 12, 24, 9, 10, 22, 23, 8, 6, 7, 20, 21, 4, 5, 18, 19, 1, 2, 3, 15, 16, 17,
+						},
+						ECPointFormats: testClientHello[200:203],
+					},
+				},
+			},
+			nil,
+			nil,
 		},
 	},
 	AppData: nil,
@@ -121,6 +155,15 @@ var testClientKeyExchangeDecoded = &TLS{
 				Version:     0x0301,
 				Length:      70,
 			},
+			[]TLSHandshakeMessage{
+				{
+					Type:   TLSHandshakeClientKeyExchange,
+					Length: 66,
+					Body:   testClientKeyExchange[9:75],
+				},
+			},
+			nil,
+			nil,
 		},
 		{
 			TLSRecordHeader{
@@ -128,6 +171,9 @@ var testClientKeyExchangeDecoded = &TLS{
 				Version:     0x0301,
 				Length:      48,
 			},
+			nil,
+			nil,
+			testClientKeyExchange[86:],
 		},
 	},
 	AppData: nil,
@@ -389,3 +435,224 @@ func TestSerializeTLSAlertEncrypted(t *testing.T) {
 		t.Error("No TLS layer type found in reconstructed packet")
 	}
 }
+
+// TLS 1.3 Client Hello sent by crypto/tls with SNI, ALPN and a X25519 key share
+var testTLS13ClientHello = []byte{
+	0x16, 0x03, 0x01, 0x01, 0x2c, 0x01, 0x00, 0x01, 0x28, 0x03, 0x03, 0xdc, 0xce, 0x17, 0xc5, 0x10,
+	0x35, 0x18, 0x27, 0x50, 0x2c, 0xac, 0x0e, 0x11, 0x3b, 0x6c, 0x64, 0x45, 0x36, 0xb8, 0x07, 0x2f,
+	0xee, 0x16, 0x90, 0x19, 0x90, 0x55, 0xd1, 0xe7, 0x10, 0x9e, 0xdb, 0x20, 0x4a, 0x2b, 0x7d, 0x94,
+	0x77, 0xbb, 0xfe, 0xd1, 0xf9, 0xae, 0x3e, 0x9e, 0x1a, 0x71, 0xb9, 0xaf, 0xfc, 0x7c, 0x16, 0xdf,
+	0x67, 0xcf, 0x30, 0x2f, 0x5f, 0x0d, 0x7a, 0xc5, 0x81, 0x47, 0x13, 0xa6, 0x00, 0x1a, 0xc0, 0x2b,
+	0xc0, 0x2f, 0xc0, 0x2c, 0xc0, 0x30, 0xcc, 0xa9, 0xcc, 0xa8, 0xc0, 0x09, 0xc0, 0x13, 0xc0, 0x0a,
+	0xc0, 0x14, 0x13, 0x01, 0x13, 0x02, 0x13, 0x03, 0x01, 0x00, 0x00, 0xc5, 0x00, 0x00, 0x00, 0x10,
+	0x00, 0x0e, 0x00, 0x00, 0x0b, 0x65, 0x78, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x2e, 0x63, 0x6f, 0x6d,
+	0x00, 0x0b, 0x00, 0x02, 0x01, 0x00, 0xff, 0x01, 0x00, 0x01, 0x00, 0x00, 0x17, 0x00, 0x00, 0x00,
+	0x12, 0x00, 0x00, 0x00, 0x05, 0x00, 0x05, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0a, 0x00, 0x04,
+	0x00, 0x02, 0x00, 0x1d, 0x00, 0x0d, 0x00, 0x20, 0x00, 0x1e, 0x09, 0x04, 0x09, 0x05, 0x09, 0x06,
+	0x08, 0x04, 0x04, 0x03, 0x08, 0x07, 0x08, 0x05, 0x08, 0x06, 0x04, 0x01, 0x05, 0x01, 0x06, 0x01,
+	0x05, 0x03, 0x06, 0x03, 0x02, 0x01, 0x02, 0x03, 0x00, 0x32, 0x00, 0x20, 0x00, 0x1e, 0x09, 0x04,
+	0x09, 0x05, 0x09, 0x06, 0x08, 0x04, 0x04, 0x03, 0x08, 0x07, 0x08, 0x05, 0x08, 0x06, 0x04, 0x01,
+	0x05, 0x01, 0x06, 0x01, 0x05, 0x03, 0x06, 0x03, 0x02, 0x01, 0x02, 0x03, 0x00, 0x10, 0x00, 0x0e,
+	0x00, 0x0c, 0x02, 0x68, 0x32, 0x08, 0x68, 0x74, 0x74, 0x70, 0x2f, 0x31, 0x2e, 0x31, 0x00, 0x2b,
+	0x00, 0x05, 0x04, 0x03, 0x04, 0x03, 0x03, 0x00, 0x33, 0x00, 0x26, 0x00, 0x24, 0x00, 0x1d, 0x00,
+	0x20, 0x57, 0x83, 0x48, 0x97, 0x0d, 0x4f, 0x2a, 0xde, 0x70, 0x9b, 0x14, 0x09, 0x43, 0x7f, 0xde,
+	0x1c, 0x97, 0x58, 0xe4, 0x41, 0x1b, 0x3b, 0x94, 0x68, 0x6e, 0x46, 0x3a, 0x71, 0xa1, 0x6c, 0x64,
+	0x0f,
+}
+
+func TestParseTLSServerHello(t *testing.T) {
+	p := gopacket.NewPacket(testServerHello, LayerTypeTLS, testTLSDecodeOptions)
+	if p.ErrorLayer() != nil {
+		t.Fatal("Failed to decode packet:", p.ErrorLayer().Error())
+	}
+	tls := p.Layer(LayerTypeTLS).(*TLS)
+
+	var types []TLSHandshakeType
+	for _, r := range tls.Handshake {
+		for _, m := range r.Messages {
+			types = append(types, m.Type)
+		}
+	}
+	wantTypes := []TLSHandshakeType{TLSHandshakeServerHello, TLSHandshakeCertificate, TLSHandshakeServerHelloDone}
+	if !reflect.DeepEqual(types, wantTypes) {
+		t.Errorf("handshake messages: got %v, want %v", types, wantTypes)
+	}
+
+	sh := tls.ServerHello()
+	if sh == nil {
+		t.Fatal("No Server Hello found")
+	}
+	if sh.NegotiatedVersion() != 0x0301 || sh.CipherSuite != 0x002f || sh.IsHelloRetryRequest() {
+		t.Errorf("unexpected Server Hello %+v", sh)
+	}
+	var exts []TLSExtensionType
+	for _, e := range sh.Extensions {
+		exts = append(exts, e.Type)
+	}
+	wantExts := []TLSExtensionType{TLSExtRenegotiationInfo, TLSExtSessionTicket, TLSExtHeartbeat}
+	if !reflect.DeepEqual(exts, wantExts) {
+		t.Errorf("extensions: got %v, want %v", exts, wantExts)
+	}
+
+	cert := tls.Handshake[1].Messages[0].Certificate
+	if cert == nil || len(cert.Certificates) != 1 {
+		t.Fatalf("unexpected certificate chain %+v", cert)
+	}
+	if want := testServerHello[78 : 78+390]; !reflect.DeepEqual(cert.Certificates[0], want) {
+		t.Errorf("certificate: got %x, want %x", cert.Certificates[0], want)
+	}
+}
+
+// tlsTestHandshakeRecords returns handshake records carrying the given
+// payloads.
+func tlsTestHandshakeRecords(payloads ...[]byte) []byte {
+	var data []byte
+	for _, p := range payloads {
+		data = append(data, 0x16, 0x03, 0x01, byte(len(p)>>8), byte(len(p)))
+		data = append(data, p...)
+	}
+	return data
+}
+
+func TestParseTLSHandshakeFragment(t *testing.T) {
+	sh := testServerHello[5:63]
+	cert := testServerHello[68:468]
+	done := testServerHello[473:477]
+	join := func(b ...[]byte) []byte { return bytes.Join(b, nil) }
+
+	// The Certificate starting in the record of the ServerHello doesn't
+	// hide the ServerHello.
+	var tls TLS
+	if err := tls.DecodeFromBytes(tlsTestHandshakeRecords(join(sh, cert[:100])), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	r := tls.Handshake[0]
+	if len(r.Messages) != 1 || r.EncryptedMsg != nil || !bytes.Equal(r.Fragment, cert[:100]) {
+		t.Errorf("unexpected record %+v", r)
+	}
+	if tls.ServerHello() == nil || tls.JA3S() == "" {
+		t.Error("No Server Hello found")
+	}
+
+	// Records decoded together complete the message.
+	data := tlsTestHandshakeRecords(join(sh, cert[:100]), cert[100:300], join(cert[300:], done))
+	if err := tls.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if len(tls.Handshake) != 3 {
+		t.Fatalf("got %d records", len(tls.Handshake))
+	}
+	if r := tls.Handshake[1]; len(r.Messages) != 0 || !bytes.Equal(r.Fragment, cert[:300]) {
+		t.Errorf("unexpected second record %+v", r)
+	}
+	r = tls.Handshake[2]
+	if len(r.Messages) != 2 || r.Fragment != nil || r.Messages[1].Type != TLSHandshakeServerHelloDone {
+		t.Fatalf("unexpected third record %+v", r)
+	}
+	if c := r.Messages[0].Certificate; c == nil || len(c.Certificates) != 1 || !bytes.Equal(c.Certificates[0], cert[10:]) {
+		t.Errorf("unexpected certificate %+v", c)
+	}
+
+	// The continuation alone can't be decoded.
+	if err := tls.DecodeFromBytes(tlsTestHandshakeRecords(cert[100:300]), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if r := tls.Handshake[0]; len(r.Messages) != 0 || r.EncryptedMsg == nil {
+		t.Errorf("unexpected record %+v", r)
+	}
+}
+
+func TestParseTLSHandshakeAfterChangeCipherSpec(t *testing.T) {
+	// An encrypted Finished message which could be framed as the start of
+	// a Finished message of 256 bytes.
+	finished := append([]byte{0x14, 0x00, 0x01, 0x00}, bytes.Repeat([]byte{0x5a}, 36)...)
+	data := append([]byte{0x14, 0x03, 0x03, 0x00, 0x01, 0x01}, tlsTestHandshakeRecords(finished)...)
+	var tls TLS
+	if err := tls.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if len(tls.ChangeCipherSpec) != 1 || len(tls.Handshake) != 1 {
+		t.Fatalf("unexpected records %+v", tls)
+	}
+	if r := tls.Handshake[0]; len(r.Messages) != 0 || r.Fragment != nil || !bytes.Equal(r.EncryptedMsg, finished) {
+		t.Errorf("unexpected record %+v", r)
+	}
+}
+
+func TestParseTLSMalformedExtension(t *testing.T) {
+	data := append([]byte(nil), testTLS13ClientHello...)
+	// Make the protocol list of the ALPN extension longer than the extension
+	i := bytes.Index(data, []byte{0x00, 0x10, 0x00, 0x0e, 0x00, 0x0c})
+	data[i+5]++
+	p := gopacket.NewPacket(data, LayerTypeTLS, testTLSDecodeOptions)
+	if p.ErrorLayer() != nil {
+		t.Fatal("Failed to decode packet:", p.ErrorLayer().Error())
+	}
+	ch := p.Layer(LayerTypeTLS).(*TLS).ClientHello()
+	if ch == nil {
+		t.Fatal("No Client Hello found")
+	}
+	if ch.ServerName != "example.com" || len(ch.KeyShares) != 1 || len(ch.Extensions) != 12 {
+		t.Errorf("unexpected Client Hello %+v", ch)
+	}
+	if ch.ALPNProtocols != nil || len(ch.ExtensionErrors) != 1 {
+		t.Errorf("ALPN: got %v, errors %v", ch.ALPNProtocols, ch.ExtensionErrors)
+	}
+}
+
+func TestParseTLS13ClientHello(t *testing.T) {
+	p := gopacket.NewPacket(testTLS13ClientHello, LayerTypeTLS, testTLSDecodeOptions)
+	if p.ErrorLayer() != nil {
+		t.Fatal("Failed to decode packet:", p.ErrorLayer().Error())
+	}
+	ch := p.Layer(LayerTypeTLS).(*TLS).ClientHello()
+	if ch == nil {
+		t.Fatal("No Client Hello found")
+	}
+	if ch.ServerName != "example.com" {
+		t.Errorf("server name: got %q", ch.ServerName)
+	}
+	if want := []string{"h2", "http/1.1"}; !reflect.DeepEqual(ch.ALPNProtocols, want) {
+		t.Errorf("ALPN: got %v, want %v", ch.ALPNProtocols, want)
+	}
+	if want := []TLSVersion{0x0304, 0x0303}; !reflect.DeepEqual(ch.SupportedVersions, want) {
+		t.Errorf("supported versions: got %v, want %v", ch.SupportedVersions, want)
+	}
+	if len(ch.KeyShares) != 1 || ch.KeyShares[0].Group != 0x001d || len(ch.KeyShares[0].KeyExchange) != 32 {
+		t.Errorf("unexpected key shares %+v", ch.KeyShares)
+	}
+	if len(ch.SignatureAlgorithms) != 15 || ch.SignatureAlgorithms[0] != 0x0904 {
+		t.Errorf("unexpected signature algorithms %v", ch.SignatureAlgorithms)
+	}
+	if len(ch.CipherSuites) != 13 || len(ch.SessionID) != 32 || len(ch.Extensions) != 12 {
+		t.Errorf("unexpected Client Hello %+v", ch)
+	}
+}
+
+func TestTLSPreSharedKeyExtension(t *testing.T) {
+	ext := TLSExtension{
+		Type: TLSExtPreSharedKey,
+		Data: []byte{
+			0x00, 0x0a, // identities
+			0x00, 0x04, 0x01, 0x02, 0x03, 0x04, 0x00, 0x00, 0x10, 0x00,
+			0x00, 0x05, // binders
+			0x04, 0xaa, 0xbb, 0xcc, 0xdd,
+		},
+	}
+	var ch TLSClientHello
+	if err := ch.decodeExtension(ext); err != nil {
+		t.Fatal(err)
+	}
+	want := &TLSPreSharedKey{
+		Identities: []TLSPSKIdentity{{Identity: ext.Data[4:8], ObfuscatedTicketAge: 0x1000}},
+		Binders:    [][]byte{ext.Data[15:19]},
+	}
+	if !reflect.DeepEqual(ch.PreSharedKey, want) {
+		t.Errorf("got %+v, want %+v", ch.PreSharedKey, want)
+	}
+
+	ext.Data = ext.Data[:len(ext.Data)-1]
+	if err := ch.decodeExtension(ext); err == nil {
+		t.Error("No error decoding truncated pre_shared_key extension")
+	}
+}
@@ -0,0 +1,769 @@
+// Copyright 2012 Google, Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package pcapfilter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+)
+
+// MaxInstructions is the maximum size of a BPF program accepted by the
+// kernel.
+const MaxInstructions = 4096
+
+// defaultCaptureLength is returned by accepting programs when Compile is
+// called with a non positive capture length, as done by libpcap.
+const defaultCaptureLength = 262144
+
+// Compile compiles a pcap-filter(7) expression into a BPF program for
+// packets of the given link type. Matching packets are accepted with a
+// return value of captureLength.
+//
+// Only literal addresses are accepted for hosts and networks: unlike
+// libpcap, Compile never resolves host names.
+func Compile(linkType layers.LinkType, captureLength int, expr string) ([]bpf.Instruction, error) {
+	link, ok := linkLayers[linkType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported link type %v", linkType)
+	}
+	root, err := parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	if captureLength <= 0 {
+		captureLength = defaultCaptureLength
+	}
+
+	c := &compiler{link: link}
+	accept, reject := c.newLabel(), c.newLabel()
+	if err := root.gen(c, accept, reject); err != nil {
+		return nil, err
+	}
+	c.place(accept)
+	c.emit(bpf.RetConstant{Val: uint32(captureLength)})
+	c.place(reject)
+	c.emit(bpf.RetConstant{Val: 0})
+
+	prog := c.resolve()
+	if len(prog) > MaxInstructions {
+		return nil, fmt.Errorf("filter too large: %d instructions, maximum is %d", len(prog), MaxInstructions)
+	}
+	return prog, nil
+}
+
+// CompileBPFFilter compiles a pcap-filter(7) expression like Compile, and
+// assembles the resulting program, which can be handed to
+// afpacket.TPacket.SetBPF or pcapgo.EthernetHandle.SetBPF.
+func CompileBPFFilter(linkType layers.LinkType, captureLength int, expr string) ([]bpf.RawInstruction, error) {
+	prog, err := Compile(linkType, captureLength, expr)
+	if err != nil {
+		return nil, err
+	}
+	return bpf.Assemble(prog)
+}
+
+// linkLayer describes where the network layer starts for a link type.
+type linkLayer struct {
+	// netOffset is the offset of the network layer header.
+	netOffset uint32
+	// typeOffset is the offset of the 16 bits ethertype field, or -1 if
+	// the link type carries raw IP packets.
+	typeOffset int
+	// ether is true if the link header holds ethernet addresses.
+	ether bool
+}
+
+var linkLayers = map[layers.LinkType]linkLayer{
+	layers.LinkTypeEthernet: {netOffset: 14, typeOffset: 12, ether: true},
+	layers.LinkTypeLinuxSLL: {netOffset: 16, typeOffset: 14},
+	layers.LinkTypeRaw:      {netOffset: 0, typeOffset: -1},
+	layers.LinkTypeIPv4:     {netOffset: 0, typeOffset: -1},
+	layers.LinkTypeIPv6:     {netOffset: 0, typeOffset: -1},
+}
+
+// label is a jump target, placed on an instruction with compiler.place.
+type label int
+
+// insn is an instruction of a program being compiled, whose jumps are
+// expressed with labels.
+type insn struct {
+	ins bpf.Instruction
+
+	// conditional jump, comparing A with val, or with X if x is set
+	cond   bool
+	test   bpf.JumpTest
+	val    uint32
+	x      bool
+	jt, jf label
+
+	// unconditional jump
+	jump   bool
+	target label
+}
+
+type compiler struct {
+	link    linkLayer
+	prog    []insn
+	labels  []int
+	scratch int
+}
+
+func (c *compiler) newLabel() label {
+	c.labels = append(c.labels, -1)
+	return label(len(c.labels) - 1)
+}
+
+func (c *compiler) place(l label) {
+	c.labels[l] = len(c.prog)
+}
+
+func (c *compiler) emit(ins ...bpf.Instruction) {
+	for _, i := range ins {
+		c.prog = append(c.prog, insn{ins: i})
+	}
+}
+
+func (c *compiler) jumpIf(test bpf.JumpTest, val uint32, t, f label) {
+	if t == f {
+		c.prog = append(c.prog, insn{jump: true, target: t})
+		return
+	}
+	c.prog = append(c.prog, insn{cond: true, test: test, val: val, jt: t, jf: f})
+}
+
+func (c *compiler) jumpIfX(test bpf.JumpTest, t, f label) {
+	c.prog = append(c.prog, insn{cond: true, test: test, x: true, jt: t, jf: f})
+}
+
+// resolve resolves labels into relative jumps. Conditional jumps can only
+// skip 255 instructions: those which need to go further are turned into a
+// conditional jump over two unconditional ones.
+func (c *compiler) resolve() []bpf.Instruction {
+	long := make([]bool, len(c.prog))
+	pos := make([]int, len(c.prog)+1)
+	for {
+		for i := range c.prog {
+			pos[i+1] = pos[i] + 1
+			if long[i] {
+				pos[i+1] += 2
+			}
+		}
+		changed := false
+		for i, in := range c.prog {
+			if !in.cond || long[i] {
+				continue
+			}
+			if pos[c.labels[in.jt]]-pos[i]-1 > 255 || pos[c.labels[in.jf]]-pos[i]-1 > 255 {
+				long[i] = true
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	out := make([]bpf.Instruction, 0, pos[len(c.prog)])
+	for i, in := range c.prog {
+		switch {
+		case in.jump:
+			out = append(out, bpf.Jump{Skip: uint32(pos[c.labels[in.target]] - pos[i] - 1)})
+		case in.cond:
+			st, sf := pos[c.labels[in.jt]]-pos[i]-1, pos[c.labels[in.jf]]-pos[i]-1
+			if long[i] {
+				st, sf = 0, 1
+			}
+			if in.x {
+				out = append(out, bpf.JumpIfX{Cond: in.test, SkipTrue: uint8(st), SkipFalse: uint8(sf)})
+			} else {
+				out = append(out, bpf.JumpIf{Cond: in.test, Val: in.val, SkipTrue: uint8(st), SkipFalse: uint8(sf)})
+			}
+			if long[i] {
+				out = append(out,
+					bpf.Jump{Skip: uint32(pos[c.labels[in.jt]] - pos[i] - 2)},
+					bpf.Jump{Skip: uint32(pos[c.labels[in.jf]] - pos[i] - 3)})
+			}
+		default:
+			out = append(out, in.ins)
+		}
+	}
+	return out
+}
+
+// node is a boolean expression, which generates code jumping to t if it
+// is true, f otherwise.
+type node interface {
+	gen(c *compiler, t, f label) error
+}
+
+type trueNode struct{}
+
+func (trueNode) gen(c *compiler, t, f label) error {
+	c.jumpIf(bpf.JumpEqual, 0, t, t)
+	return nil
+}
+
+type falseNode struct{}
+
+func (falseNode) gen(c *compiler, t, f label) error {
+	c.jumpIf(bpf.JumpEqual, 0, f, f)
+	return nil
+}
+
+type andNode struct{ l, r node }
+
+func (n andNode) gen(c *compiler, t, f label) error {
+	m := c.newLabel()
+	if err := n.l.gen(c, m, f); err != nil {
+		return err
+	}
+	c.place(m)
+	return n.r.gen(c, t, f)
+}
+
+type orNode struct{ l, r node }
+
+func (n orNode) gen(c *compiler, t, f label) error {
+	m := c.newLabel()
+	if err := n.l.gen(c, t, m); err != nil {
+		return err
+	}
+	c.place(m)
+	return n.r.gen(c, t, f)
+}
+
+type notNode struct{ n node }
+
+func (n notNode) gen(c *compiler, t, f label) error {
+	return n.n.gen(c, f, t)
+}
+
+// cmpNode runs load, which must leave a value in A, and compares it with
+// val.
+type cmpNode struct {
+	load []bpf.Instruction
+	test bpf.JumpTest
+	val  uint32
+}
+
+func (n cmpNode) gen(c *compiler, t, f label) error {
+	c.emit(n.load...)
+	c.jumpIf(n.test, n.val, t, f)
+	return nil
+}
+
+func ldb(off uint32) bpf.Instruction { return bpf.LoadAbsolute{Off: off, Size: 1} }
+func ldh(off uint32) bpf.Instruction { return bpf.LoadAbsolute{Off: off, Size: 2} }
+func ld(off uint32) bpf.Instruction  { return bpf.LoadAbsolute{Off: off, Size: 4} }
+
+func eq(val uint32, load ...bpf.Instruction) node {
+	return cmpNode{load: load, test: bpf.JumpEqual, val: val}
+}
+
+// combine joins the source and destination checks of a primitive
+// according to its direction qualifier.
+func combine(dir string, src, dst node) node {
+	switch dir {
+	case "src":
+		return src
+	case "dst":
+		return dst
+	case "src and dst":
+		return andNode{src, dst}
+	}
+	return orNode{src, dst}
+}
+
+// netOffset returns the offset of the network layer, after offset bytes of
+// VLAN tags.
+func (c *compiler) netOffset(offset uint32) uint32 {
+	return c.link.netOffset + offset
+}
+
+// etherType checks the protocol of the network layer.
+func (c *compiler) etherType(val uint32, offset uint32) node {
+	if c.link.typeOffset >= 0 {
+		return eq(val, ldh(uint32(c.link.typeOffset)+offset))
+	}
+	// Raw IP link types, check the IP version
+	load := []bpf.Instruction{ldb(0), bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: 0xf0}}
+	switch layers.EthernetType(val) {
+	case layers.EthernetTypeIPv4:
+		return eq(0x40, load...)
+	case layers.EthernetTypeIPv6:
+		return eq(0x60, load...)
+	}
+	return falseNode{}
+}
+
+// ipProto checks the protocol carried by IPv4.
+func (c *compiler) ipProto(val uint32, offset uint32) node {
+	return andNode{
+		c.etherType(uint32(layers.EthernetTypeIPv4), offset),
+		eq(val, ldb(c.netOffset(offset)+9)),
+	}
+}
+
+// ip6Proto checks the protocol carried by IPv6, possibly after a fragment
+// header.
+func (c *compiler) ip6Proto(val uint32, offset uint32) node {
+	l3 := c.netOffset(offset)
+	return andNode{
+		c.etherType(uint32(layers.EthernetTypeIPv6), offset),
+		orNode{
+			eq(val, ldb(l3+6)),
+			andNode{
+				eq(uint32(layers.IPProtocolIPv6Fragment), ldb(l3+6)),
+				eq(val, ldb(l3+40)),
+			},
+		},
+	}
+}
+
+// notFragment checks that an IPv4 packet is the first fragment, so its
+// transport header can be read.
+func (c *compiler) notFragment(offset uint32) node {
+	return notNode{cmpNode{load: []bpf.Instruction{ldh(c.netOffset(offset) + 6)}, test: bpf.JumpBitsSet, val: 0x1fff}}
+}
+
+var transportProtos = map[string]uint32{
+	"tcp": uint32(layers.IPProtocolTCP), "udp": uint32(layers.IPProtocolUDP),
+	"sctp": uint32(layers.IPProtocolSCTP), "icmp": uint32(layers.IPProtocolICMPv4),
+	"igmp": uint32(layers.IPProtocolIGMP), "icmp6": uint32(layers.IPProtocolICMPv6),
+}
+
+var etherTypes = map[string]uint32{
+	"ip": uint32(layers.EthernetTypeIPv4), "ip6": uint32(layers.EthernetTypeIPv6),
+	"arp": uint32(layers.EthernetTypeARP), "rarp": 0x8035,
+}
+
+// protoNode matches a protocol given alone, like "tcp" or "ip6".
+type protoNode struct {
+	proto  string
+	offset uint32
+}
+
+func (n protoNode) gen(c *compiler, t, f label) error {
+	var e node
+	switch n.proto {
+	case "ether", "link":
+		e = trueNode{}
+	case "ip", "ip6", "arp", "rarp":
+		e = c.etherType(etherTypes[n.proto], n.offset)
+	case "tcp", "udp", "sctp":
+		v := transportProtos[n.proto]
+		e = orNode{c.ipProto(v, n.offset), c.ip6Proto(v, n.offset)}
+	case "icmp", "igmp":
+		e = c.ipProto(transportProtos[n.proto], n.offset)
+	case "icmp6":
+		e = c.ip6Proto(transportProtos[n.proto], n.offset)
+	default:
+		return fmt.Errorf("unknown protocol %s", n.proto)
+	}
+	return e.gen(c, t, f)
+}
+
+// etherProtoNode matches "ether proto N".
+type etherProtoNode struct {
+	val    uint32
+	offset uint32
+}
+
+func (n etherProtoNode) gen(c *compiler, t, f label) error {
+	return c.etherType(n.val, n.offset).gen(c, t, f)
+}
+
+// ipProtoNode matches "ip proto N" and "ip6 proto N".
+type ipProtoNode struct {
+	proto  string
+	val    uint32
+	offset uint32
+}
+
+func (n ipProtoNode) gen(c *compiler, t, f label) error {
+	if n.proto == "ip6" {
+		return c.ip6Proto(n.val, n.offset).gen(c, t, f)
+	}
+	return c.ipProto(n.val, n.offset).gen(c, t, f)
+}
+
+// hostNode matches IP hosts and networks of IPv4, ARP, RARP and IPv6
+// packets.
+type hostNode struct {
+	proto  string
+	ip     net.IP
+	prefix int
+	dir    string
+	offset uint32
+}
+
+func (n hostNode) gen(c *compiler, t, f label) error {
+	l3 := c.netOffset(n.offset)
+	var src, dst uint32
+	switch n.proto {
+	case "ip":
+		src, dst = l3+12, l3+16
+	case "arp", "rarp":
+		if c.link.typeOffset < 0 {
+			return falseNode{}.gen(c, t, f)
+		}
+		src, dst = l3+14, l3+24
+	case "ip6":
+		src, dst = l3+8, l3+24
+	}
+	e := andNode{
+		c.etherType(etherTypes[n.proto], n.offset),
+		combine(n.dir, addrMatch(n.ip, n.prefix, src), addrMatch(n.ip, n.prefix, dst)),
+	}
+	return e.gen(c, t, f)
+}
+
+// addrMatch compares the address at off with the first prefix bits of ip,
+// one 32 bits word at a time.
+func addrMatch(ip net.IP, prefix int, off uint32) node {
+	var n node = trueNode{}
+	for i := 0; i < len(ip); i += 4 {
+		bits := prefix - 8*i
+		if bits <= 0 {
+			break
+		}
+		mask := ^uint32(0)
+		if bits < 32 {
+			mask <<= uint(32 - bits)
+		}
+		word := uint32(ip[i])<<24 | uint32(ip[i+1])<<16 | uint32(ip[i+2])<<8 | uint32(ip[i+3])
+		load := []bpf.Instruction{ld(off + uint32(i))}
+		if mask != ^uint32(0) {
+			load = append(load, bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: mask})
+		}
+		cmp := eq(word&mask, load...)
+		if _, ok := n.(trueNode); ok {
+			n = cmp
+		} else {
+			n = andNode{n, cmp}
+		}
+	}
+	return n
+}
+
+// etherHostNode matches ethernet addresses.
+type etherHostNode struct {
+	mac net.HardwareAddr
+	dir string
+}
+
+func (n etherHostNode) gen(c *compiler, t, f label) error {
+	if !c.link.ether {
+		return errors.New("ethernet addresses are not supported on this link type")
+	}
+	return combine(n.dir, macMatch(n.mac, 6), macMatch(n.mac, 0)).gen(c, t, f)
+}
+
+func macMatch(mac net.HardwareAddr, off uint32) node {
+	low := uint32(mac[2])<<24 | uint32(mac[3])<<16 | uint32(mac[4])<<8 | uint32(mac[5])
+	high := uint32(mac[0])<<8 | uint32(mac[1])
+	return andNode{eq(low, ld(off+2)), eq(high, ldh(off))}
+}
+
+// portNode matches TCP, UDP and SCTP ports and port ranges.
+type portNode struct {
+	proto  string
+	lo, hi uint32
+	dir    string
+	offset uint32
+}
+
+func (n portNode) gen(c *compiler, t, f label) error {
+	l3 := c.netOffset(n.offset)
+	proto := transportProtos[n.proto]
+
+	// IPv4, the transport header follows the variable length IP header
+	ip4Port := func(off uint32) node {
+		return portMatch(n.lo, n.hi, bpf.LoadMemShift{Off: l3}, bpf.LoadIndirect{Off: l3 + off, Size: 2})
+	}
+	ip4 := andNode{
+		andNode{c.ipProto(proto, n.offset), c.notFragment(n.offset)},
+		combine(n.dir, ip4Port(0), ip4Port(2)),
+	}
+
+	// IPv6, without extension headers
+	ip6Port := func(off uint32) node {
+		return portMatch(n.lo, n.hi, ldh(l3+40+off))
+	}
+	ip6 := andNode{
+		andNode{c.etherType(uint32(layers.EthernetTypeIPv6), n.offset), eq(proto, ldb(l3+6))},
+		combine(n.dir, ip6Port(0), ip6Port(2)),
+	}
+	return orNode{ip4, ip6}.gen(c, t, f)
+}
+
+func portMatch(lo, hi uint32, load ...bpf.Instruction) node {
+	if lo == hi {
+		return eq(lo, load...)
+	}
+	return andNode{
+		cmpNode{load: load, test: bpf.JumpGreaterOrEqual, val: lo},
+		cmpNode{load: load, test: bpf.JumpLessOrEqual, val: hi},
+	}
+}
+
+// castNode matches broadcast and multicast packets.
+type castNode struct {
+	proto     string
+	multicast bool
+	offset    uint32
+}
+
+func (n castNode) gen(c *compiler, t, f label) error {
+	l3 := c.netOffset(n.offset)
+	var e node
+	switch {
+	case n.proto == "ether" && !c.link.ether:
+		return errors.New("ethernet broadcast and multicast are not supported on this link type")
+	case n.proto == "ether" && n.multicast:
+		e = cmpNode{load: []bpf.Instruction{ldb(0)}, test: bpf.JumpBitsSet, val: 1}
+	case n.proto == "ether":
+		e = macMatch(net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 0)
+	case n.proto == "ip" && n.multicast:
+		e = andNode{
+			c.etherType(uint32(layers.EthernetTypeIPv4), n.offset),
+			cmpNode{load: []bpf.Instruction{ldb(l3 + 16)}, test: bpf.JumpGreaterOrEqual, val: 224},
+		}
+	case n.proto == "ip6" && n.multicast:
+		e = andNode{
+			c.etherType(uint32(layers.EthernetTypeIPv6), n.offset),
+			eq(0xff, ldb(l3+24)),
+		}
+	default:
+		return fmt.Errorf("%s broadcast is not supported", n.proto)
+	}
+	return e.gen(c, t, f)
+}
+
+// vlanNode matches 802.1Q and 802.1ad tagged frames, optionally with a
+// given VLAN id.
+type vlanNode struct {
+	offset uint32
+	id     int
+}
+
+func (n vlanNode) gen(c *compiler, t, f label) error {
+	if !c.link.ether {
+		return errors.New("vlan is not supported on this link type")
+	}
+	off := uint32(c.link.typeOffset) + n.offset
+	var e node = orNode{
+		orNode{
+			eq(uint32(layers.EthernetTypeDot1Q), ldh(off)),
+			eq(uint32(layers.EthernetTypeQinQ), ldh(off)),
+		},
+		eq(0x9100, ldh(off)),
+	}
+	if n.id >= 0 {
+		e = andNode{e, eq(uint32(n.id), ldh(off+2), bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: 0xfff})}
+	}
+	return e.gen(c, t, f)
+}
+
+// lengthNode matches "less N" and "greater N".
+type lengthNode struct {
+	less bool
+	val  uint32
+}
+
+func (n lengthNode) gen(c *compiler, t, f label) error {
+	test := bpf.JumpGreaterOrEqual
+	if n.less {
+		test = bpf.JumpLessOrEqual
+	}
+	return cmpNode{load: []bpf.Instruction{bpf.LoadExtension{Num: bpf.ExtLen}}, test: test, val: n.val}.gen(c, t, f)
+}
+
+// relNode compares two arithmetic expressions.
+type relNode struct {
+	op   string
+	l, r arith
+}
+
+var relTests = map[string]bpf.JumpTest{
+	">": bpf.JumpGreaterThan, "<": bpf.JumpLessThan, ">=": bpf.JumpGreaterOrEqual,
+	"<=": bpf.JumpLessOrEqual, "=": bpf.JumpEqual, "==": bpf.JumpEqual, "!=": bpf.JumpNotEqual,
+}
+
+func (n relNode) gen(c *compiler, t, f label) error {
+	// Packet loads are only valid if their protocol is present
+	var checks []node
+	n.l.checks(c, &checks)
+	n.r.checks(c, &checks)
+	var e node = relCmp(n)
+	for i := len(checks) - 1; i >= 0; i-- {
+		e = andNode{checks[i], e}
+	}
+	return e.gen(c, t, f)
+}
+
+type relCmp relNode
+
+func (n relCmp) gen(c *compiler, t, f label) error {
+	test := relTests[n.op]
+	if k, ok := n.r.(constArith); ok {
+		if err := n.l.gen(c); err != nil {
+			return err
+		}
+		c.jumpIf(test, uint32(k), t, f)
+		return nil
+	}
+	if err := c.genScratch(n.r, n.l); err != nil {
+		return err
+	}
+	c.jumpIfX(test, t, f)
+	return nil
+}
+
+// arith is an arithmetic expression, which generates code leaving its
+// value in A.
+type arith interface {
+	gen(c *compiler) error
+	// checks appends the protocol checks needed by the loads of the
+	// expression.
+	checks(c *compiler, checks *[]node)
+}
+
+type constArith uint32
+
+func (a constArith) gen(c *compiler) error {
+	c.emit(bpf.LoadConstant{Dst: bpf.RegA, Val: uint32(a)})
+	return nil
+}
+
+func (a constArith) checks(c *compiler, checks *[]node) {}
+
+type lenArith struct{}
+
+func (lenArith) gen(c *compiler) error {
+	c.emit(bpf.LoadExtension{Num: bpf.ExtLen})
+	return nil
+}
+
+func (lenArith) checks(c *compiler, checks *[]node) {}
+
+type binArith struct {
+	op   string
+	l, r arith
+}
+
+var aluOps = map[string]bpf.ALUOp{
+	"+": bpf.ALUOpAdd, "-": bpf.ALUOpSub, "*": bpf.ALUOpMul, "/": bpf.ALUOpDiv, "%": bpf.ALUOpMod,
+	"&": bpf.ALUOpAnd, "|": bpf.ALUOpOr, "^": bpf.ALUOpXor, "<<": bpf.ALUOpShiftLeft, ">>": bpf.ALUOpShiftRight,
+}
+
+func (a binArith) gen(c *compiler) error {
+	op := aluOps[a.op]
+	if k, ok := a.r.(constArith); ok {
+		if k == 0 && (op == bpf.ALUOpDiv || op == bpf.ALUOpMod) {
+			return errors.New("division by zero")
+		}
+		if err := a.l.gen(c); err != nil {
+			return err
+		}
+		c.emit(bpf.ALUOpConstant{Op: op, Val: uint32(k)})
+		return nil
+	}
+	if err := c.genScratch(a.r, a.l); err != nil {
+		return err
+	}
+	c.emit(bpf.ALUOpX{Op: op})
+	return nil
+}
+
+func (a binArith) checks(c *compiler, checks *[]node) {
+	a.l.checks(c, checks)
+	a.r.checks(c, checks)
+}
+
+// genScratch leaves the value of l in A and the value of r in X, using a
+// scratch memory slot.
+func (c *compiler) genScratch(r, l arith) error {
+	if c.scratch >= 16 {
+		return errors.New("expression too complex")
+	}
+	if err := r.gen(c); err != nil {
+		return err
+	}
+	slot := c.scratch
+	c.emit(bpf.StoreScratch{Src: bpf.RegA, N: slot})
+	c.scratch++
+	err := l.gen(c)
+	c.scratch--
+	if err != nil {
+		return err
+	}
+	c.emit(bpf.LoadScratch{Dst: bpf.RegX, N: slot})
+	return nil
+}
+
+// loadArith is a packet load, like "tcp[13]" or "ip[2:2]".
+type loadArith struct {
+	proto  string
+	idx    arith
+	size   int
+	offset uint32
+}
+
+func (a loadArith) gen(c *compiler) error {
+	l3 := c.netOffset(a.offset)
+	var base uint32
+	transport := false
+	switch a.proto {
+	case "ether", "link":
+		base = 0
+	case "ip", "ip6", "arp", "rarp":
+		base = l3
+	case "icmp6":
+		base = l3 + 40
+	default:
+		// Transport protocols over IPv4
+		base = l3
+		transport = true
+	}
+
+	if k, ok := a.idx.(constArith); ok {
+		if transport {
+			c.emit(bpf.LoadMemShift{Off: l3}, bpf.LoadIndirect{Off: base + uint32(k), Size: a.size})
+		} else {
+			c.emit(bpf.LoadAbsolute{Off: base + uint32(k), Size: a.size})
+		}
+		return nil
+	}
+	if err := a.idx.gen(c); err != nil {
+		return err
+	}
+	if transport {
+		c.emit(bpf.LoadMemShift{Off: l3}, bpf.ALUOpX{Op: bpf.ALUOpAdd})
+	}
+	c.emit(bpf.TAX{}, bpf.LoadIndirect{Off: base, Size: a.size})
+	return nil
+}
+
+func (a loadArith) checks(c *compiler, checks *[]node) {
+	switch a.proto {
+	case "ip", "ip6", "arp", "rarp":
+		*checks = append(*checks, c.etherType(etherTypes[a.proto], a.offset))
+	case "icmp6":
+		*checks = append(*checks, andNode{
+			c.etherType(uint32(layers.EthernetTypeIPv6), a.offset),
+			eq(uint32(layers.IPProtocolICMPv6), ldb(c.netOffset(a.offset)+6)),
+		})
+	case "tcp", "udp", "sctp", "icmp", "igmp":
+		*checks = append(*checks, andNode{c.ipProto(transportProtos[a.proto], a.offset), c.notFragment(a.offset)})
+	}
+	a.idx.checks(c, checks)
+}
@@ -0,0 +1,40 @@
+// Copyright 2012 Google, Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+/*
+Package pcapfilter compiles pcap-filter(7) expressions, as used by tcpdump,
+into BPF programs without requiring C libpcap.
+
+The supported grammar covers the primitives commonly used for capture
+filters:
+
+  - ether/ip/ip6/arp/rarp/tcp/udp/sctp/icmp/icmp6/igmp protocols
+  - [src|dst] host, net (CIDR, mask or classful notation), port, portrange
+  - ether host, broadcast and multicast
+  - ip proto, ip6 proto, ether proto
+  - vlan [id], which shifts the following primitives past the VLAN tag
+  - less, greater and len
+  - packet loads like tcp[tcpflags] or ip[2:2], arithmetic and comparisons
+  - and (&&), or (||), not (!) and parentheses
+
+Compiled programs can be used with afpacket.TPacket.SetBPF or
+pcapgo.EthernetHandle.SetBPF:
+
+	prog, err := pcapfilter.CompileBPFFilter(layers.LinkTypeEthernet, 65535, "tcp port 80")
+	if err != nil {
+		...
+	}
+	if err := handle.SetBPF(prog); err != nil {
+		...
+	}
+
+Offline captures, like those read by pcapgo.Reader, can be filtered with a
+Source, which runs BPF programs with a pure Go VM.
+
+Host names and port names outside of the IANA registries known to the
+layers package are not resolved.
+*/
+package pcapfilter
@@ -0,0 +1,663 @@
+// Copyright 2012 Google, Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package pcapfilter
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/google/gopacket/layers"
+)
+
+// token is a lexical element of a filter expression. Words (keywords,
+// numbers, addresses) and operators are both represented by their text.
+type token struct {
+	text string
+	pos  int
+	word bool
+}
+
+// lex splits a filter expression into tokens. Colons are part of words so
+// MAC and IPv6 addresses are single tokens, except inside brackets where
+// they separate the offset from the size of a load.
+func lex(expr string) ([]token, error) {
+	var toks []token
+	depth := 0
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isWordChar(c, depth):
+			start := i
+			for i < len(expr) && (isWordChar(expr[i], depth) || expr[i] == '-' && i+1 < len(expr) && isWordChar(expr[i+1], depth)) {
+				i++
+			}
+			toks = append(toks, token{text: expr[start:i], pos: start, word: true})
+		default:
+			op := ""
+			for _, o := range []string{"&&", "||", "==", "!=", "<=", ">=", "<<", ">>"} {
+				if strings.HasPrefix(expr[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				if !strings.ContainsRune("()[]!&|^+-*/%<>=:", rune(c)) {
+					return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+				}
+				op = expr[i : i+1]
+			}
+			switch op {
+			case "[":
+				depth++
+			case "]":
+				depth--
+			}
+			toks = append(toks, token{text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return toks, nil
+}
+
+func isWordChar(c byte, depth int) bool {
+	return c < unicode.MaxASCII && (unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))) ||
+		c == '_' || c == '.' || c == '\\' || c == ':' && depth == 0
+}
+
+// Qualifiers of a primitive, as described in pcap-filter(7).
+type qualifiers struct {
+	proto string // ether, ip, ip6, arp, rarp, tcp, udp, sctp, ...
+	dir   string // src, dst, "src or dst", "src and dst"
+	typ   string // host, net, port, portrange
+}
+
+var (
+	protoQualifiers = map[string]bool{
+		"ether": true, "link": true, "ip": true, "ip6": true, "arp": true, "rarp": true,
+		"tcp": true, "udp": true, "sctp": true, "icmp": true, "icmp6": true, "igmp": true,
+	}
+	typeQualifiers = map[string]bool{
+		"host": true, "net": true, "port": true, "portrange": true,
+	}
+)
+
+type parser struct {
+	toks []token
+	pos  int
+	// last holds the qualifiers of the last primitive, used by
+	// abbreviated primitives such as "port 80 or 443".
+	last qualifiers
+	// vlanOffset is added to link layer offsets of the primitives
+	// following a vlan primitive.
+	vlanOffset uint32
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos].text
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	if p.pos < len(p.toks) {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(texts ...string) bool {
+	for _, t := range texts {
+		if p.peek() == t {
+			p.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	pos := "end of expression"
+	if p.pos < len(p.toks) {
+		pos = fmt.Sprintf("%q at offset %d", p.toks[p.pos].text, p.toks[p.pos].pos)
+	}
+	return fmt.Errorf("syntax error near %s: %s", pos, fmt.Sprintf(format, args...))
+}
+
+// parse returns the boolean expression tree of a filter. An empty
+// expression matches every packet.
+func parse(expr string) (node, error) {
+	toks, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	if len(toks) == 0 {
+		return trueNode{}, nil
+	}
+	n, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, p.errorf("unexpected token")
+	}
+	return n, nil
+}
+
+// parseExpr parses a sequence of terms joined by and/or. As in libpcap,
+// both operators have the same precedence and associate to the left.
+func (p *parser) parseExpr() (node, error) {
+	n, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek() {
+		case "and", "&&":
+			p.next()
+			r, err := p.parseTerm()
+			if err != nil {
+				return nil, err
+			}
+			n = andNode{n, r}
+		case "or", "||":
+			p.next()
+			r, err := p.parseTerm()
+			if err != nil {
+				return nil, err
+			}
+			n = orNode{n, r}
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *parser) parseTerm() (node, error) {
+	if p.accept("not", "!") {
+		n, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+
+	// Both relations and parenthesized expressions may start with a
+	// parenthesis, try to parse a relation first.
+	start := p.pos
+	if n, err := p.parseRelation(); err == nil {
+		return n, nil
+	}
+	p.pos = start
+
+	if p.accept("(") {
+		n, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.errorf("expected ')'")
+		}
+		return n, nil
+	}
+	return p.parsePrimitive()
+}
+
+func (p *parser) parsePrimitive() (node, error) {
+	switch p.peek() {
+	case "":
+		return nil, p.errorf("expected a primitive")
+	case "vlan":
+		p.next()
+		n := vlanNode{offset: p.vlanOffset, id: -1}
+		if id, ok := p.peekNumber(); ok {
+			p.next()
+			if id > 0xfff {
+				return nil, p.errorf("invalid VLAN id %d", id)
+			}
+			n.id = int(id)
+		}
+		p.vlanOffset += 4
+		return n, nil
+	case "less", "greater":
+		op := p.next()
+		v, ok := p.peekNumber()
+		if !ok {
+			return nil, p.errorf("expected a length")
+		}
+		p.next()
+		if op == "less" {
+			return lengthNode{less: true, val: v}, nil
+		}
+		return lengthNode{val: v}, nil
+	case "broadcast", "multicast":
+		return castNode{proto: "ether", multicast: p.next() == "multicast"}, nil
+	case "proto":
+		// "proto" alone is "ip proto or ip6 proto"
+		p.next()
+		v, err := p.parseProtoNumber()
+		if err != nil {
+			return nil, err
+		}
+		return orNode{ipProtoNode{proto: "ip", val: v, offset: p.vlanOffset}, ipProtoNode{proto: "ip6", val: v, offset: p.vlanOffset}}, nil
+	}
+
+	var q qualifiers
+	explicit := false
+	if protoQualifiers[p.peek()] {
+		q.proto = p.next()
+		explicit = true
+		switch p.peek() {
+		case "proto":
+			p.next()
+			return p.parseProtoPrimitive(q.proto)
+		case "broadcast", "multicast":
+			if q.proto != "ether" && q.proto != "ip" && q.proto != "ip6" {
+				return nil, p.errorf("%s does not support %s", q.proto, p.peek())
+			}
+			return castNode{proto: q.proto, multicast: p.next() == "multicast", offset: p.vlanOffset}, nil
+		}
+	}
+	if p.peek() == "src" || p.peek() == "dst" {
+		q.dir = p.next()
+		explicit = true
+		if (p.peek() == "or" || p.peek() == "and") && p.pos+1 < len(p.toks) &&
+			(p.toks[p.pos+1].text == "src" || p.toks[p.pos+1].text == "dst") {
+			q.dir = "src " + p.next() + " dst"
+			p.next()
+		}
+	}
+	if typeQualifiers[p.peek()] {
+		q.typ = p.next()
+		explicit = true
+	}
+
+	if !explicit {
+		// An identifier alone reuses the qualifiers of the previous
+		// primitive, as in "host a or b".
+		if p.last == (qualifiers{}) {
+			return nil, p.errorf("expected a primitive")
+		}
+		q = p.last
+	} else if q.typ == "" && q.dir == "" {
+		// A protocol alone, like "tcp"
+		p.last = qualifiers{}
+		return protoNode{proto: q.proto, offset: p.vlanOffset}, nil
+	}
+
+	tok := p.peek()
+	if tok == "" || p.pos < len(p.toks) && !p.toks[p.pos].word {
+		return nil, p.errorf("expected an identifier")
+	}
+	p.next()
+	if q.typ == "" {
+		q.typ = "host"
+	}
+	// Network given as "addr/len" or "addr mask mask"
+	if q.typ == "net" && p.accept("/") {
+		if _, ok := p.peekNumber(); !ok {
+			return nil, p.errorf("expected a prefix length")
+		}
+		tok += "/" + p.next()
+	} else if q.typ == "net" && p.accept("mask") {
+		mask := net.ParseIP(p.next())
+		if mask == nil || mask.To4() == nil {
+			return nil, p.errorf("invalid netmask")
+		}
+		ones, bits := net.IPMask(mask.To4()).Size()
+		if bits == 0 {
+			return nil, p.errorf("non contiguous netmask")
+		}
+		tok += "/" + strconv.Itoa(ones)
+	}
+	p.last = q
+	return p.makePrimitive(q, tok)
+}
+
+func (p *parser) peekNumber() (uint32, bool) {
+	if p.pos >= len(p.toks) || !p.toks[p.pos].word {
+		return 0, false
+	}
+	v, err := parseNumber(p.peek())
+	return v, err == nil
+}
+
+func parseNumber(s string) (uint32, error) {
+	v, err := strconv.ParseUint(s, 0, 32)
+	return uint32(v), err
+}
+
+// protocol numbers accepted by "ip proto", "ip6 proto" and "proto"
+var ipProtoNames = map[string]uint32{
+	"icmp": 1, "igmp": 2, "tcp": 6, "udp": 17, "ip6": 41, "esp": 50, "ah": 51,
+	"icmp6": 58, "pim": 103, "vrrp": 112, "sctp": 132,
+}
+
+// protocol numbers accepted by "ether proto"
+var etherProtoNames = map[string]uint32{
+	"ip": uint32(layers.EthernetTypeIPv4), "ip6": uint32(layers.EthernetTypeIPv6),
+	"arp": uint32(layers.EthernetTypeARP), "rarp": 0x8035,
+}
+
+func (p *parser) parseProtoNumber() (uint32, error) {
+	tok := strings.TrimPrefix(p.next(), "\\")
+	if v, ok := ipProtoNames[tok]; ok {
+		return v, nil
+	}
+	v, err := parseNumber(tok)
+	if err != nil || v > 0xff {
+		return 0, p.errorf("invalid protocol %q", tok)
+	}
+	return v, nil
+}
+
+func (p *parser) parseProtoPrimitive(proto string) (node, error) {
+	switch proto {
+	case "ether", "link":
+		tok := strings.TrimPrefix(p.next(), "\\")
+		v, ok := etherProtoNames[tok]
+		if !ok {
+			var err error
+			if v, err = parseNumber(tok); err != nil || v > 0xffff {
+				return nil, p.errorf("invalid ethernet protocol %q", tok)
+			}
+		}
+		return etherProtoNode{val: v, offset: p.vlanOffset}, nil
+	case "ip", "ip6":
+		v, err := p.parseProtoNumber()
+		if err != nil {
+			return nil, err
+		}
+		return ipProtoNode{proto: proto, val: v, offset: p.vlanOffset}, nil
+	}
+	return nil, p.errorf("%s does not support proto", proto)
+}
+
+// makePrimitive builds the node of a qualified primitive.
+func (p *parser) makePrimitive(q qualifiers, id string) (node, error) {
+	dir := q.dir
+	if dir == "" {
+		dir = "src or dst"
+	}
+	switch q.typ {
+	case "host":
+		if q.proto == "ether" || q.proto == "link" {
+			mac, err := net.ParseMAC(id)
+			if err != nil || len(mac) != 6 {
+				return nil, fmt.Errorf("invalid ethernet address %q", id)
+			}
+			return etherHostNode{mac: mac, dir: dir}, nil
+		}
+		ip := net.ParseIP(id)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid host %q: only literal addresses are supported", id)
+		}
+		return p.hostNet(q.proto, ip, -1, dir)
+	case "net":
+		_, ipnet, err := net.ParseCIDR(id)
+		if err != nil {
+			// Class-full network, e.g. "net 10" or "net 192.168"
+			parts := strings.Split(id, ".")
+			if len(parts) > 4 {
+				return nil, fmt.Errorf("invalid network %q", id)
+			}
+			ip := make(net.IP, 4)
+			for i, s := range parts {
+				v, err := strconv.ParseUint(s, 10, 8)
+				if err != nil {
+					return nil, fmt.Errorf("invalid network %q", id)
+				}
+				ip[i] = byte(v)
+			}
+			ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(parts), 32)}
+		}
+		ones, _ := ipnet.Mask.Size()
+		return p.hostNet(q.proto, ipnet.IP, ones, dir)
+	case "port", "portrange":
+		lo, hi, err := parsePortRange(q.typ, id)
+		if err != nil {
+			return nil, err
+		}
+		var protos []string
+		switch q.proto {
+		case "":
+			protos = []string{"tcp", "udp", "sctp"}
+		case "tcp", "udp", "sctp":
+			protos = []string{q.proto}
+		default:
+			return nil, fmt.Errorf("%s does not support %s", q.proto, q.typ)
+		}
+		var n node
+		for _, proto := range protos {
+			var pn node = portNode{proto: proto, lo: lo, hi: hi, dir: dir, offset: p.vlanOffset}
+			if n == nil {
+				n = pn
+			} else {
+				n = orNode{n, pn}
+			}
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("unsupported primitive %s", q.typ)
+}
+
+func (p *parser) hostNet(proto string, ip net.IP, prefix int, dir string) (node, error) {
+	ip4 := ip.To4()
+	if ip4 != nil {
+		if prefix < 0 {
+			prefix = 32
+		}
+		switch proto {
+		case "":
+			return orNode{
+				orNode{
+					hostNode{proto: "ip", ip: ip4, prefix: prefix, dir: dir, offset: p.vlanOffset},
+					hostNode{proto: "arp", ip: ip4, prefix: prefix, dir: dir, offset: p.vlanOffset},
+				},
+				hostNode{proto: "rarp", ip: ip4, prefix: prefix, dir: dir, offset: p.vlanOffset},
+			}, nil
+		case "ip", "arp", "rarp":
+			return hostNode{proto: proto, ip: ip4, prefix: prefix, dir: dir, offset: p.vlanOffset}, nil
+		}
+		return nil, fmt.Errorf("%s does not support IPv4 addresses", proto)
+	}
+	if prefix < 0 {
+		prefix = 128
+	}
+	if proto != "" && proto != "ip6" {
+		return nil, fmt.Errorf("%s does not support IPv6 addresses", proto)
+	}
+	return hostNode{proto: "ip6", ip: ip.To16(), prefix: prefix, dir: dir, offset: p.vlanOffset}, nil
+}
+
+func parsePortRange(typ, id string) (uint32, uint32, error) {
+	if typ == "port" {
+		v, err := parsePort(id)
+		return v, v, err
+	}
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid port range %q", id)
+	}
+	lo, err := parsePort(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	hi, err := parsePort(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	return lo, hi, nil
+}
+
+// parsePort parses a port number or an IANA service name.
+func parsePort(s string) (uint32, error) {
+	if v, err := parseNumber(s); err == nil {
+		if v > 0xffff {
+			return 0, fmt.Errorf("invalid port %q", s)
+		}
+		return v, nil
+	}
+	port := -1
+	for p, name := range layers.TCPPortNames {
+		if name == s && (port < 0 || int(p) < port) {
+			port = int(p)
+		}
+	}
+	for p, name := range layers.UDPPortNames {
+		if name == s && (port < 0 || int(p) < port) {
+			port = int(p)
+		}
+	}
+	if port < 0 {
+		return 0, fmt.Errorf("unknown port %q", s)
+	}
+	return uint32(port), nil
+}
+
+// Relations compare arithmetic expressions, e.g. "tcp[13] & 2 != 0".
+
+var relOps = map[string]bool{
+	">": true, "<": true, ">=": true, "<=": true, "=": true, "==": true, "!=": true,
+}
+
+func (p *parser) parseRelation() (node, error) {
+	l, err := p.parseArith(0)
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek()
+	if !relOps[op] {
+		return nil, p.errorf("expected a relational operator")
+	}
+	p.next()
+	r, err := p.parseArith(0)
+	if err != nil {
+		return nil, err
+	}
+	return relNode{op: op, l: l, r: r}, nil
+}
+
+// Arithmetic operator precedences, as in C.
+var arithPrec = map[string]int{
+	"|": 1, "^": 2, "&": 3, "<<": 4, ">>": 4, "+": 5, "-": 5, "*": 6, "/": 6, "%": 6,
+}
+
+// parseArith parses a binary arithmetic expression using precedence
+// climbing.
+func (p *parser) parseArith(minPrec int) (arith, error) {
+	l, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		prec, ok := arithPrec[op]
+		if !ok || prec <= minPrec {
+			return l, nil
+		}
+		p.next()
+		r, err := p.parseArith(prec)
+		if err != nil {
+			return nil, err
+		}
+		l = binArith{op: op, l: l, r: r}
+	}
+}
+
+// Named constants usable in arithmetic expressions.
+var arithConstants = map[string]uint32{
+	"icmptype": 0, "icmpcode": 1, "icmp6type": 0, "icmp6code": 1, "tcpflags": 13,
+
+	"tcp-fin": 0x01, "tcp-syn": 0x02, "tcp-rst": 0x04, "tcp-push": 0x08,
+	"tcp-ack": 0x10, "tcp-urg": 0x20, "tcp-ece": 0x40, "tcp-cwr": 0x80,
+
+	"icmp-echoreply": 0, "icmp-unreach": 3, "icmp-sourcequench": 4, "icmp-redirect": 5,
+	"icmp-echo": 8, "icmp-routeradvert": 9, "icmp-routersolicit": 10, "icmp-timxceed": 11,
+	"icmp-paramprob": 12, "icmp-tstamp": 13, "icmp-tstampreply": 14, "icmp-ireq": 15,
+	"icmp-ireqreply": 16, "icmp-maskreq": 17, "icmp-maskreply": 18,
+
+	"icmp6-destinationunreach": 1, "icmp6-packettoobig": 2, "icmp6-timeexceeded": 3,
+	"icmp6-parameterproblem": 4, "icmp6-echo": 128, "icmp6-echoreply": 129,
+	"icmp6-multicastlistenerquery": 130, "icmp6-multicastlistenerreportv1": 131,
+	"icmp6-multicastlistenerdone": 132, "icmp6-routersolicit": 133,
+	"icmp6-routeradvert": 134, "icmp6-neighborsolicit": 135,
+	"icmp6-neighboradvert": 136, "icmp6-redirect": 137,
+}
+
+// protocols which can be indexed with proto[expr:size]
+var loadProtos = map[string]bool{
+	"ether": true, "link": true, "ip": true, "ip6": true, "arp": true, "rarp": true,
+	"tcp": true, "udp": true, "sctp": true, "icmp": true, "icmp6": true, "igmp": true,
+}
+
+func (p *parser) parseOperand() (arith, error) {
+	if p.accept("(") {
+		a, err := p.parseArith(0)
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.errorf("expected ')'")
+		}
+		return a, nil
+	}
+	if p.accept("-") {
+		a, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return binArith{op: "-", l: constArith(0), r: a}, nil
+	}
+	tok := p.peek()
+	if p.pos >= len(p.toks) || !p.toks[p.pos].word {
+		return nil, p.errorf("expected an operand")
+	}
+	if tok == "len" {
+		p.next()
+		return lenArith{}, nil
+	}
+	if v, ok := arithConstants[tok]; ok {
+		p.next()
+		return constArith(v), nil
+	}
+	if v, err := parseNumber(tok); err == nil {
+		p.next()
+		return constArith(v), nil
+	}
+	if loadProtos[tok] && p.pos+1 < len(p.toks) && p.toks[p.pos+1].text == "[" {
+		p.pos += 2
+		idx, err := p.parseArith(0)
+		if err != nil {
+			return nil, err
+		}
+		size := uint32(1)
+		if p.accept(":") {
+			v, ok := p.peekNumber()
+			if !ok || v != 1 && v != 2 && v != 4 {
+				return nil, p.errorf("load size must be 1, 2 or 4")
+			}
+			p.next()
+			size = v
+		}
+		if !p.accept("]") {
+			return nil, p.errorf("expected ']'")
+		}
+		return loadArith{proto: tok, idx: idx, size: int(size), offset: p.vlanOffset}, nil
+	}
+	return nil, p.errorf("expected an operand")
+}
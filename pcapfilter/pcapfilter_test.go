@@ -0,0 +1,307 @@
+// Copyright 2012 Google, Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package pcapfilter
+
+import (
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"golang.org/x/net/bpf"
+)
+
+var (
+	macA = net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	macB = net.HardwareAddr{0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb}
+)
+
+func serialize(t *testing.T, ls ...gopacket.SerializableLayer) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ls...); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tcp4(t *testing.T, src, dst string, sport, dport layers.TCPPort, syn, ack bool) []byte {
+	ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolTCP,
+		SrcIP: net.ParseIP(src).To4(), DstIP: net.ParseIP(dst).To4(),
+		// Options make sure transport loads honor the IP header length
+		Options: []layers.IPv4Option{{OptionType: 1}, {OptionType: 1}, {OptionType: 1}, {OptionType: 0}}}
+	tcp := &layers.TCP{SrcPort: sport, DstPort: dport, SYN: syn, ACK: ack, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(ip)
+	return serialize(t,
+		&layers.Ethernet{SrcMAC: macA, DstMAC: macB, EthernetType: layers.EthernetTypeIPv4},
+		ip, tcp, gopacket.Payload("hello"))
+}
+
+func udp6(t *testing.T, src, dst string, sport, dport layers.UDPPort) []byte {
+	ip := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP,
+		SrcIP: net.ParseIP(src), DstIP: net.ParseIP(dst)}
+	udp := &layers.UDP{SrcPort: sport, DstPort: dport}
+	udp.SetNetworkLayerForChecksum(ip)
+	return serialize(t,
+		&layers.Ethernet{SrcMAC: macB, DstMAC: macA, EthernetType: layers.EthernetTypeIPv6},
+		ip, udp, gopacket.Payload("hello"))
+}
+
+func vlanUDP4(t *testing.T, id uint16) []byte {
+	ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP,
+		SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{224, 0, 0, 251}}
+	udp := &layers.UDP{SrcPort: 5353, DstPort: 5353}
+	udp.SetNetworkLayerForChecksum(ip)
+	return serialize(t,
+		&layers.Ethernet{SrcMAC: macA, DstMAC: net.HardwareAddr{0x01, 0x00, 0x5e, 0, 0, 0xfb}, EthernetType: layers.EthernetTypeDot1Q},
+		&layers.Dot1Q{VLANIdentifier: id, Type: layers.EthernetTypeIPv4},
+		ip, udp)
+}
+
+func run(t *testing.T, prog []bpf.Instruction, data []byte) bool {
+	vm, err := bpf.NewVM(prog)
+	if err != nil {
+		t.Fatalf("invalid program: %v\n%v", err, prog)
+	}
+	n, err := vm.Run(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n != 0
+}
+
+func TestCompile(t *testing.T) {
+	synack := tcp4(t, "192.168.1.1", "10.1.2.3", 443, 34567, true, true)
+	syn := tcp4(t, "10.1.2.3", "192.168.1.1", 34567, 443, true, false)
+	dns6 := udp6(t, "2001:db8::1", "2001:db8:1::53", 40000, 53)
+	vlan := vlanUDP4(t, 42)
+	packets := map[string][]byte{"synack": synack, "syn": syn, "dns6": dns6, "vlan": vlan}
+
+	for _, test := range []struct {
+		expr  string
+		match []string
+	}{
+		{"", []string{"synack", "syn", "dns6", "vlan"}},
+		{"tcp", []string{"synack", "syn"}},
+		{"udp", []string{"dns6"}},
+		{"ip", []string{"synack", "syn"}},
+		{"ip6", []string{"dns6"}},
+		{"not ip6", []string{"synack", "syn", "vlan"}},
+		{"tcp port 443", []string{"synack", "syn"}},
+		{"tcp src port 443", []string{"synack"}},
+		{"dst port https", []string{"syn"}},
+		{"port 53", []string{"dns6"}},
+		{"udp dst port domain", []string{"dns6"}},
+		{"portrange 34000-35000", []string{"synack", "syn"}},
+		{"tcp dst portrange 34000-35000", []string{"synack"}},
+		{"host 10.1.2.3", []string{"synack", "syn"}},
+		{"src host 10.1.2.3", []string{"syn"}},
+		{"dst 10.1.2.3 and tcp", []string{"synack"}},
+		{"net 192.168.0.0/16", []string{"synack", "syn"}},
+		{"src net 192.168", []string{"synack"}},
+		{"net 10.0.0.0 mask 255.0.0.0", []string{"synack", "syn"}},
+		{"host 2001:db8::1", []string{"dns6"}},
+		{"ip6 dst net 2001:db8:1::/48", []string{"dns6"}},
+		{"src net 2001:db8:1::/48", nil},
+		{"ether src 00:11:22:33:44:55", []string{"synack", "syn", "vlan"}},
+		{"ether host 66:77:88:99:aa:bb and ip6", []string{"dns6"}},
+		{"ether multicast", []string{"vlan"}},
+		{"ether broadcast", nil},
+		{"vlan", []string{"vlan"}},
+		{"vlan 42 and udp port 5353", []string{"vlan"}},
+		{"vlan 43", nil},
+		{"vlan and ip multicast", []string{"vlan"}},
+		{"udp port 5353", nil},
+		{"tcp[tcpflags] & (tcp-syn|tcp-ack) == (tcp-syn|tcp-ack)", []string{"synack"}},
+		{"tcp[tcpflags] & tcp-syn != 0 and not tcp[tcpflags] & tcp-ack != 0", []string{"syn"}},
+		{"tcp[13] = 2", []string{"syn"}},
+		{"ip[9] = 6 and len > 60", []string{"synack", "syn"}},
+		{"ip[0] & 0xf = 6", []string{"synack", "syn"}},
+		{"tcp[tcp[12] >> 4 << 2 : 4] = 0x68656c6c", []string{"synack", "syn"}},
+		{"greater 100", nil},
+		{"less 60", []string{"vlan"}},
+		{"ip proto 6", []string{"synack", "syn"}},
+		{"ip6 proto 17", []string{"dns6"}},
+		{"ether proto 0x86dd", []string{"dns6"}},
+		{"(tcp or udp) and not port 443", []string{"dns6"}},
+		{"tcp and (src port 443 or dst port 53)", []string{"synack"}},
+		{"host 10.1.2.3 and port 443 or ip6", []string{"synack", "syn", "dns6"}},
+	} {
+		prog, err := Compile(layers.LinkTypeEthernet, 65535, test.expr)
+		if err != nil {
+			t.Errorf("%q: %v", test.expr, err)
+			continue
+		}
+		want := map[string]bool{}
+		for _, m := range test.match {
+			want[m] = true
+		}
+		for name, data := range packets {
+			if got := run(t, prog, data); got != want[name] {
+				t.Errorf("%q on %s: got %v, want %v\n%v", test.expr, name, got, want[name], prog)
+			}
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, expr := range []string{
+		"tcp port",
+		"host",
+		"host example.com",
+		"port 70000",
+		"net 10.0.0.1/8/2",
+		"tcp and",
+		"(tcp",
+		"tcp)",
+		"ip[1:3] = 1",
+		"ip[0] / 0 = 1",
+		"foo",
+		"portrange 20",
+	} {
+		if _, err := Compile(layers.LinkTypeEthernet, 65535, expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+	if _, err := Compile(layers.LinkTypeRaw, 65535, "ether host 00:11:22:33:44:55"); err == nil {
+		t.Error("expected an error for ethernet addresses on a raw link")
+	}
+	if _, err := Compile(layers.LinkTypeNull, 65535, "tcp"); err == nil {
+		t.Error("expected an error for an unsupported link type")
+	}
+}
+
+func TestCompileRaw(t *testing.T) {
+	data := tcp4(t, "192.168.1.1", "10.1.2.3", 443, 34567, true, true)[14:]
+	for expr, want := range map[string]bool{
+		"tcp port 443":     true,
+		"ip6":              false,
+		"host 10.1.2.3":    true,
+		"ip and not udp":   true,
+		"ip[0] & 0xf0 = 0": false,
+	} {
+		prog, err := Compile(layers.LinkTypeRaw, 0, expr)
+		if err != nil {
+			t.Fatalf("%q: %v", expr, err)
+		}
+		if got := run(t, prog, data); got != want {
+			t.Errorf("%q: got %v, want %v", expr, got, want)
+		}
+	}
+}
+
+func TestCompileLongJumps(t *testing.T) {
+	// Enough alternatives for the jumps to the reject label to go further
+	// than 255 instructions.
+	expr := "host 10.0.0.1"
+	for i := 2; i < 60; i++ {
+		expr += " or host 10.0.0." + strconv.Itoa(i)
+	}
+	expr = "tcp and (" + expr + ")"
+	prog, err := Compile(layers.LinkTypeEthernet, 65535, expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prog) < 300 {
+		t.Fatalf("program too short to test long jumps: %d instructions", len(prog))
+	}
+	if run(t, prog, tcp4(t, "192.168.1.1", "10.1.2.3", 443, 34567, true, true)) {
+		t.Error("unexpected match")
+	}
+	if !run(t, prog, tcp4(t, "192.168.1.1", "10.0.0.59", 443, 34567, true, true)) {
+		t.Error("unexpected mismatch")
+	}
+	if _, err := bpf.Assemble(prog); err != nil {
+		t.Error(err)
+	}
+}
+
+// rawInstruction has the layout of bpf.RawInstruction, for shorter literals.
+type rawInstruction struct {
+	Op     uint16
+	Jt, Jf uint8
+	K      uint32
+}
+
+// The reference programs below are the output of tcpdump -dd.
+var referencePrograms = []struct {
+	expr string
+	prog []rawInstruction
+}{
+	{"tcp[tcpflags] & (tcp-syn|tcp-ack) == (tcp-syn|tcp-ack)", []rawInstruction{
+		{0x28, 0, 0, 0x0000000c}, {0x15, 0, 9, 0x00000800}, {0x30, 0, 0, 0x00000017},
+		{0x15, 0, 7, 0x00000006}, {0x28, 0, 0, 0x00000014}, {0x45, 5, 0, 0x00001fff},
+		{0xb1, 0, 0, 0x0000000e}, {0x50, 0, 0, 0x0000001b}, {0x54, 0, 0, 0x00000012},
+		{0x15, 0, 1, 0x00000012}, {0x6, 0, 0, 0x0000ffff}, {0x6, 0, 0, 0x00000000},
+	}},
+	{"tcp[tcpflags] & (tcp-syn|tcp-ack) == tcp-ack", []rawInstruction{
+		{0x28, 0, 0, 0x0000000c}, {0x15, 0, 9, 0x00000800}, {0x30, 0, 0, 0x00000017},
+		{0x15, 0, 7, 0x00000006}, {0x28, 0, 0, 0x00000014}, {0x45, 5, 0, 0x00001fff},
+		{0xb1, 0, 0, 0x0000000e}, {0x50, 0, 0, 0x0000001b}, {0x54, 0, 0, 0x00000012},
+		{0x15, 0, 1, 0x00000010}, {0x6, 0, 0, 0x0000ffff}, {0x6, 0, 0, 0x00000000},
+	}},
+	{"udp", []rawInstruction{
+		{0x28, 0, 0, 0x0000000c}, {0x15, 0, 5, 0x000086dd}, {0x30, 0, 0, 0x00000014},
+		{0x15, 6, 0, 0x00000011}, {0x15, 0, 6, 0x0000002c}, {0x30, 0, 0, 0x00000036},
+		{0x15, 3, 4, 0x00000011}, {0x15, 0, 3, 0x00000800}, {0x30, 0, 0, 0x00000017},
+		{0x15, 0, 1, 0x00000011}, {0x6, 0, 0, 0x0000ffff}, {0x6, 0, 0, 0x00000000},
+	}},
+}
+
+// TestCompileReference checks that compiled programs agree with libpcap on
+// a capture file.
+func TestCompileReference(t *testing.T) {
+	var packets [][]byte
+	for _, name := range []string{"../pcap/test_ethernet.pcap", "../pcap/test_dns.pcap"} {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := pcapgo.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			data, _, err := r.ReadPacketData()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			packets = append(packets, data)
+		}
+		f.Close()
+	}
+	packets = append(packets,
+		tcp4(t, "192.168.1.1", "10.1.2.3", 443, 34567, true, true),
+		tcp4(t, "192.168.1.1", "10.1.2.3", 443, 34567, false, true),
+		udp6(t, "2001:db8::1", "2001:db8:1::53", 40000, 53))
+
+	for _, ref := range referencePrograms {
+		raw := make([]bpf.RawInstruction, len(ref.prog))
+		for i, ins := range ref.prog {
+			raw[i] = bpf.RawInstruction(ins)
+		}
+		want, ok := bpf.Disassemble(raw)
+		if !ok {
+			t.Fatalf("%q: can't disassemble reference program", ref.expr)
+		}
+		got, err := Compile(layers.LinkTypeEthernet, 65535, ref.expr)
+		if err != nil {
+			t.Fatalf("%q: %v", ref.expr, err)
+		}
+		for i, data := range packets {
+			if g, w := run(t, got, data), run(t, want, data); g != w {
+				t.Errorf("%q on packet %d: got %v, want %v", ref.expr, i, g, w)
+			}
+		}
+	}
+}
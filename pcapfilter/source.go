@@ -0,0 +1,79 @@
+// Copyright 2012 Google, Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package pcapfilter
+
+import (
+	"github.com/google/gopacket"
+	"golang.org/x/net/bpf"
+)
+
+// Source is a gopacket.PacketDataSource returning the packets of another
+// source which are accepted by a BPF program, like pcap.Handle.SetBPFFilter
+// does for live captures. It can be used to filter packets read by
+// pcapgo.Reader or pcapgo.NgReader:
+//
+//	prog, err := pcapfilter.CompileBPFFilter(r.LinkType(), 65535, "tcp port 80")
+//	...
+//	src, err := pcapfilter.NewSource(r, prog)
+//	...
+//	packetSource := gopacket.NewPacketSource(src, r.LinkType())
+//
+// As in the kernel, a program returning less than the captured length of a
+// packet truncates it: the returned data and CaptureInfo.CaptureLength are
+// shortened, while CaptureInfo.Length is left untouched.
+type Source struct {
+	src gopacket.PacketDataSource
+	vm  *VM
+
+	// Accepted and Rejected count the packets read from the underlying
+	// source which matched the program and which didn't.
+	Accepted, Rejected uint64
+}
+
+// NewSource returns a Source filtering the packets of src with prog.
+func NewSource(src gopacket.PacketDataSource, prog []bpf.RawInstruction) (*Source, error) {
+	vm, err := NewVM(prog)
+	if err != nil {
+		return nil, err
+	}
+	return &Source{src: src, vm: vm}, nil
+}
+
+// ReadPacketData returns the next packet of the underlying source matching
+// the program. Errors of the underlying source are returned as is.
+func (s *Source) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	return s.read(s.src.ReadPacketData)
+}
+
+// ZeroCopyReadPacketData is like ReadPacketData, using the
+// ZeroCopyReadPacketData method of the underlying source if it has one.
+func (s *Source) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if zc, ok := s.src.(gopacket.ZeroCopyPacketDataSource); ok {
+		return s.read(zc.ZeroCopyReadPacketData)
+	}
+	return s.read(s.src.ReadPacketData)
+}
+
+func (s *Source) read(next func() ([]byte, gopacket.CaptureInfo, error)) ([]byte, gopacket.CaptureInfo, error) {
+	for {
+		data, ci, err := next()
+		if err != nil {
+			return data, ci, err
+		}
+		keep := s.vm.Run(data, ci.Length)
+		if keep == 0 {
+			s.Rejected++
+			continue
+		}
+		s.Accepted++
+		if uint64(keep) < uint64(len(data)) {
+			data = data[:keep]
+			ci.CaptureLength = int(keep)
+		}
+		return data, ci, nil
+	}
+}
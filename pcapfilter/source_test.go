@@ -0,0 +1,110 @@
+// Copyright 2012 Google, Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package pcapfilter
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"golang.org/x/net/bpf"
+)
+
+type sliceSource struct {
+	packets [][]byte
+}
+
+func (s *sliceSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if len(s.packets) == 0 {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	data := s.packets[0]
+	s.packets = s.packets[1:]
+	return data, gopacket.CaptureInfo{CaptureLength: len(data), Length: len(data)}, nil
+}
+
+func TestSource(t *testing.T) {
+	prog, err := CompileBPFFilter(layers.LinkTypeEthernet, 54, "tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := NewSource(&sliceSource{packets: [][]byte{
+		udp6(t, "2001:db8::1", "2001:db8:1::53", 40000, 53),
+		tcp4(t, "192.168.1.1", "10.1.2.3", 443, 34567, true, true),
+		vlanUDP4(t, 42),
+	}}, prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, ci, err := src.ReadPacketData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The packet is truncated to the capture length returned by the program
+	if len(data) != 54 || ci.CaptureLength != 54 || ci.Length != 63 {
+		t.Errorf("got %d bytes, capture info %+v", len(data), ci)
+	}
+	p := gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default)
+	if p.Layer(layers.LayerTypeTCP) == nil {
+		t.Errorf("expected a TCP packet, got %v", p)
+	}
+
+	if _, _, err := src.ZeroCopyReadPacketData(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+	if src.Accepted != 1 || src.Rejected != 2 {
+		t.Errorf("got %d accepted and %d rejected packets", src.Accepted, src.Rejected)
+	}
+}
+
+// TestSourcePcapProgram filters a capture file with a program generated by
+// libpcap, as returned by pcap.CompileBPFFilter.
+func TestSourcePcapProgram(t *testing.T) {
+	f, err := os.Open("../pcap/test_dns.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var prog []bpf.RawInstruction
+	for _, ref := range referencePrograms {
+		if ref.expr == "udp" {
+			for _, ins := range ref.prog {
+				prog = append(prog, bpf.RawInstruction(ins))
+			}
+		}
+	}
+	src, err := NewSource(r, prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for {
+		data, _, err := src.ReadPacketData()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		p := gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default)
+		if p.Layer(layers.LayerTypeUDP) == nil {
+			t.Errorf("expected an UDP packet, got %v", p)
+		}
+		n++
+	}
+	if n == 0 || uint64(n) != src.Accepted {
+		t.Errorf("got %d packets, %d accepted and %d rejected", n, src.Accepted, src.Rejected)
+	}
+}
@@ -0,0 +1,337 @@
+// Copyright 2012 Google, Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package pcapfilter
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/google/gopacket"
+	"golang.org/x/net/bpf"
+)
+
+// Classic BPF opcode fields, see linux/filter.h.
+const (
+	opClassMask = 0x07
+	opClassLD   = 0x00
+	opClassLDX  = 0x01
+	opClassST   = 0x02
+	opClassSTX  = 0x03
+	opClassALU  = 0x04
+	opClassJMP  = 0x05
+	opClassRET  = 0x06
+	opClassMISC = 0x07
+
+	opSizeMask = 0x18
+	opSizeW    = 0x00
+	opSizeH    = 0x08
+	opSizeB    = 0x10
+
+	opModeMask = 0xe0
+	opModeIMM  = 0x00
+	opModeABS  = 0x20
+	opModeIND  = 0x40
+	opModeMEM  = 0x60
+	opModeLEN  = 0x80
+	opModeMSH  = 0xa0
+
+	opOpMask = 0xf0
+	opSrcX   = 0x08
+
+	opALUAdd = 0x00
+	opALUSub = 0x10
+	opALUMul = 0x20
+	opALUDiv = 0x30
+	opALUOr  = 0x40
+	opALUAnd = 0x50
+	opALULsh = 0x60
+	opALURsh = 0x70
+	opALUNeg = 0x80
+	opALUMod = 0x90
+	opALUXor = 0xa0
+
+	opJmpJA   = 0x00
+	opJmpJEQ  = 0x10
+	opJmpJGT  = 0x20
+	opJmpJGE  = 0x30
+	opJmpJSET = 0x40
+
+	opRetMask = 0x18
+	opRetK    = 0x00
+	opRetX    = 0x08
+	opRetA    = 0x10
+
+	opMiscTXA = 0x80
+
+	// extOffset is SKF_AD_OFF, the offset of ancillary data loads.
+	extOffset = 0xfffff000
+	// scratchSize is BPF_MEMWORDS, the number of scratch memory slots.
+	scratchSize = 16
+)
+
+// VM runs classic BPF programs on packet data, like the Linux kernel does
+// for socket filters.
+//
+// Loads are bounded by the captured data, and a load past its end rejects
+// the packet, while the packet length read by "ld len" is the length of the
+// packet on the wire. The only supported ancillary data load is the packet
+// length, since offline packets don't carry the socket buffer metadata
+// needed by the other ones.
+type VM struct {
+	prog []bpf.RawInstruction
+}
+
+// NewVM validates prog and returns a VM running it. Programs from
+// pcap.CompileBPFFilter can be converted field by field to
+// bpf.RawInstruction.
+func NewVM(prog []bpf.RawInstruction) (*VM, error) {
+	if len(prog) == 0 {
+		return nil, errors.New("empty BPF program")
+	}
+	if len(prog) > MaxInstructions {
+		return nil, fmt.Errorf("BPF program too large: %d instructions, maximum is %d", len(prog), MaxInstructions)
+	}
+	for pc, ins := range prog {
+		if err := validate(prog, pc, ins); err != nil {
+			return nil, fmt.Errorf("invalid BPF instruction %d (%#v): %v", pc, ins, err)
+		}
+	}
+	if prog[len(prog)-1].Op&opClassMask != opClassRET {
+		return nil, errors.New("BPF program doesn't end with a return instruction")
+	}
+	return &VM{prog: prog}, nil
+}
+
+func validate(prog []bpf.RawInstruction, pc int, ins bpf.RawInstruction) error {
+	switch ins.Op & opClassMask {
+	case opClassLD:
+		switch ins.Op & opModeMask {
+		case opModeIMM, opModeLEN:
+		case opModeABS:
+			if ins.K >= extOffset {
+				return errors.New("unsupported ancillary data load")
+			}
+			fallthrough
+		case opModeIND:
+			if ins.Op&opSizeMask == 0x18 {
+				return errors.New("invalid load size")
+			}
+		case opModeMEM:
+			if ins.K >= scratchSize {
+				return errors.New("scratch memory index out of range")
+			}
+		default:
+			return errors.New("invalid load mode")
+		}
+	case opClassLDX:
+		switch ins.Op {
+		case opClassLDX | opSizeW | opModeIMM, opClassLDX | opSizeW | opModeLEN,
+			opClassLDX | opSizeB | opModeMSH:
+		case opClassLDX | opSizeW | opModeMEM:
+			if ins.K >= scratchSize {
+				return errors.New("scratch memory index out of range")
+			}
+		default:
+			return errors.New("invalid load mode")
+		}
+	case opClassST, opClassSTX:
+		if ins.K >= scratchSize {
+			return errors.New("scratch memory index out of range")
+		}
+	case opClassALU:
+		switch ins.Op & opOpMask {
+		case opALUDiv, opALUMod:
+			if ins.Op&opSrcX == 0 && ins.K == 0 {
+				return errors.New("division by zero")
+			}
+		case opALUAdd, opALUSub, opALUMul, opALUOr, opALUAnd, opALULsh, opALURsh, opALUNeg, opALUXor:
+		default:
+			return errors.New("invalid ALU operation")
+		}
+	case opClassJMP:
+		switch ins.Op & opOpMask {
+		case opJmpJA:
+			if uint64(pc)+1+uint64(ins.K) >= uint64(len(prog)) {
+				return errors.New("jump out of range")
+			}
+		case opJmpJEQ, opJmpJGT, opJmpJGE, opJmpJSET:
+			if pc+1+int(ins.Jt) >= len(prog) || pc+1+int(ins.Jf) >= len(prog) {
+				return errors.New("jump out of range")
+			}
+		default:
+			return errors.New("invalid jump")
+		}
+	case opClassRET:
+		if ins.Op&opRetMask == 0x18 {
+			return errors.New("invalid return value")
+		}
+	case opClassMISC:
+		if ins.Op&0xf8 != 0 && ins.Op&0xf8 != opMiscTXA {
+			return errors.New("invalid misc operation")
+		}
+	}
+	return nil
+}
+
+// Run runs the program on data, the captured part of a packet which was
+// length bytes long on the wire. It returns the value returned by the
+// program: the number of bytes of the packet to keep, 0 meaning the packet
+// is rejected.
+func (v *VM) Run(data []byte, length int) uint32 {
+	if length < len(data) {
+		length = len(data)
+	}
+	var a, x uint32
+	var mem [scratchSize]uint32
+
+	load := func(off uint32, size uint8) (uint32, bool) {
+		end := uint64(off) + uint64(size)
+		if end > uint64(len(data)) {
+			return 0, false
+		}
+		switch size {
+		case 1:
+			return uint32(data[off]), true
+		case 2:
+			return uint32(binary.BigEndian.Uint16(data[off:])), true
+		}
+		return binary.BigEndian.Uint32(data[off:]), true
+	}
+	size := func(op uint16) uint8 {
+		switch op & opSizeMask {
+		case opSizeH:
+			return 2
+		case opSizeB:
+			return 1
+		}
+		return 4
+	}
+
+	for pc := 0; pc < len(v.prog); pc++ {
+		ins := v.prog[pc]
+		switch ins.Op & opClassMask {
+		case opClassLD:
+			switch ins.Op & opModeMask {
+			case opModeIMM:
+				a = ins.K
+			case opModeLEN:
+				a = uint32(length)
+			case opModeMEM:
+				a = mem[ins.K]
+			case opModeABS, opModeIND:
+				off := ins.K
+				if ins.Op&opModeMask == opModeIND {
+					off += x
+				}
+				val, ok := load(off, size(ins.Op))
+				if !ok {
+					return 0
+				}
+				a = val
+			}
+		case opClassLDX:
+			switch ins.Op & opModeMask {
+			case opModeIMM:
+				x = ins.K
+			case opModeLEN:
+				x = uint32(length)
+			case opModeMEM:
+				x = mem[ins.K]
+			case opModeMSH:
+				val, ok := load(ins.K, 1)
+				if !ok {
+					return 0
+				}
+				x = (val & 0xf) << 2
+			}
+		case opClassST:
+			mem[ins.K] = a
+		case opClassSTX:
+			mem[ins.K] = x
+		case opClassALU:
+			operand := ins.K
+			if ins.Op&opSrcX != 0 {
+				operand = x
+			}
+			switch ins.Op & opOpMask {
+			case opALUAdd:
+				a += operand
+			case opALUSub:
+				a -= operand
+			case opALUMul:
+				a *= operand
+			case opALUDiv:
+				if operand == 0 {
+					return 0
+				}
+				a /= operand
+			case opALUMod:
+				if operand == 0 {
+					return 0
+				}
+				a %= operand
+			case opALUOr:
+				a |= operand
+			case opALUAnd:
+				a &= operand
+			case opALUXor:
+				a ^= operand
+			case opALULsh:
+				a <<= operand
+			case opALURsh:
+				a >>= operand
+			case opALUNeg:
+				a = -a
+			}
+		case opClassJMP:
+			operand := ins.K
+			if ins.Op&opSrcX != 0 {
+				operand = x
+			}
+			var cond bool
+			switch ins.Op & opOpMask {
+			case opJmpJA:
+				pc += int(ins.K)
+				continue
+			case opJmpJEQ:
+				cond = a == operand
+			case opJmpJGT:
+				cond = a > operand
+			case opJmpJGE:
+				cond = a >= operand
+			case opJmpJSET:
+				cond = a&operand != 0
+			}
+			if cond {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case opClassRET:
+			switch ins.Op & opRetMask {
+			case opRetA:
+				return a
+			case opRetX:
+				return x
+			}
+			return ins.K
+		case opClassMISC:
+			if ins.Op&opMiscTXA != 0 {
+				a = x
+			} else {
+				x = a
+			}
+		}
+	}
+	return 0
+}
+
+// Matches returns true if the given packet data matches the program.
+func (v *VM) Matches(ci gopacket.CaptureInfo, data []byte) bool {
+	return v.Run(data, ci.Length) != 0
+}
@@ -0,0 +1,148 @@
+// Copyright 2012 Google, Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package pcapfilter
+
+import (
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+)
+
+func assemble(t *testing.T, prog []bpf.Instruction) []bpf.RawInstruction {
+	raw, err := bpf.Assemble(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestVMAgreesWithNetBPF(t *testing.T) {
+	packets := [][]byte{
+		tcp4(t, "192.168.1.1", "10.1.2.3", 443, 34567, true, true),
+		tcp4(t, "10.1.2.3", "192.168.1.1", 34567, 443, true, false),
+		udp6(t, "2001:db8::1", "2001:db8:1::53", 40000, 53),
+		vlanUDP4(t, 42),
+		{0x01, 0x02},
+	}
+	for _, expr := range []string{
+		"tcp", "udp port 53", "vlan 42 and ip multicast", "len > 60",
+		"tcp[tcp[12] >> 4 << 2 : 4] = 0x68656c6c", "ip[2:2] / 4 % 3 = 1",
+		"ether src 00:11:22:33:44:55 or host 2001:db8::1",
+	} {
+		prog, err := Compile(layers.LinkTypeEthernet, 1500, expr)
+		if err != nil {
+			t.Fatalf("%q: %v", expr, err)
+		}
+		vm, err := NewVM(assemble(t, prog))
+		if err != nil {
+			t.Fatalf("%q: %v", expr, err)
+		}
+		ref, err := bpf.NewVM(prog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, data := range packets {
+			want, err := ref.Run(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := vm.Run(data, len(data)); got != uint32(want) {
+				t.Errorf("%q on packet %d: got %d, want %d", expr, i, got, want)
+			}
+		}
+	}
+}
+
+func TestVMCaptureLength(t *testing.T) {
+	data := tcp4(t, "192.168.1.1", "10.1.2.3", 443, 34567, true, true)
+	ci := gopacket.CaptureInfo{CaptureLength: 40, Length: len(data)}
+	for expr, want := range map[string]bool{
+		// The wire length is used by len
+		"greater 60": true,
+		"less 40":    false,
+		// Loads within the captured data work
+		"host 10.1.2.3": true,
+		// Loads past the captured data reject the packet, even when negated
+		"tcp dst port 34567":     false,
+		"not tcp dst port 34567": false,
+	} {
+		prog, err := CompileBPFFilter(layers.LinkTypeEthernet, 65535, expr)
+		if err != nil {
+			t.Fatalf("%q: %v", expr, err)
+		}
+		vm, err := NewVM(prog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := vm.Matches(ci, data[:ci.CaptureLength]); got != want {
+			t.Errorf("%q: got %v, want %v", expr, got, want)
+		}
+	}
+}
+
+func TestVMRuntime(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		prog []bpf.Instruction
+		want uint32
+	}{
+		{"division by zero", []bpf.Instruction{
+			bpf.LoadConstant{Dst: bpf.RegA, Val: 10},
+			bpf.LoadConstant{Dst: bpf.RegX, Val: 0},
+			bpf.ALUOpX{Op: bpf.ALUOpDiv},
+			bpf.RetConstant{Val: 1},
+		}, 0},
+		{"scratch", []bpf.Instruction{
+			bpf.LoadConstant{Dst: bpf.RegA, Val: 7},
+			bpf.StoreScratch{Src: bpf.RegA, N: 15},
+			bpf.LoadConstant{Dst: bpf.RegA, Val: 3},
+			bpf.LoadScratch{Dst: bpf.RegX, N: 15},
+			bpf.ALUOpX{Op: bpf.ALUOpMul},
+			bpf.RetA{},
+		}, 21},
+		{"msh", []bpf.Instruction{
+			bpf.LoadMemShift{Off: 1},
+			bpf.TXA{},
+			bpf.RetA{},
+		}, 20},
+		{"jumps", []bpf.Instruction{
+			bpf.LoadAbsolute{Off: 0, Size: 2},
+			bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x0100, SkipTrue: 1},
+			bpf.RetConstant{Val: 1},
+			bpf.Jump{Skip: 1},
+			bpf.RetConstant{Val: 2},
+			bpf.RetConstant{Val: 3},
+		}, 3},
+	} {
+		vm, err := NewVM(assemble(t, test.prog))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := vm.Run([]byte{0x01, 0x45}, 2); got != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestVMInvalid(t *testing.T) {
+	for _, prog := range [][]bpf.RawInstruction{
+		nil,
+		{{Op: opClassLD | opModeIMM, K: 1}},
+		{{Op: opClassJMP | opJmpJA, K: 1}, {Op: opClassRET}},
+		{{Op: opClassJMP | opJmpJEQ, Jt: 0, Jf: 1}, {Op: opClassRET}},
+		{{Op: opClassALU | opALUDiv, K: 0}, {Op: opClassRET}},
+		{{Op: opClassST, K: 16}, {Op: opClassRET}},
+		{{Op: opClassLD | opModeABS, K: extOffset + 4}, {Op: opClassRET}},
+		{{Op: opClassLDX | opSizeH | opModeABS}, {Op: opClassRET}},
+	} {
+		if _, err := NewVM(prog); err == nil {
+			t.Errorf("%v: expected an error", prog)
+		}
+	}
+}
@@ -13,14 +13,18 @@ This package contains implementations for native PCAP support. Currently support
 
  * pcap-files read/write: Reader, Writer
  * pcapng-files read/write: NgReader, NgWriter
+ * random access to pcap- and pcapng-files: IndexedReader
+ * rotating pcap- and pcapng-files: RotatingWriter
  * raw socket capture (linux only): EthernetHandle
 
 Basic Usage pcapng
 
 Pcapng files can be read and written. Reading supports both big and little endian files, packet blocks,
-simple packet blocks, enhanced packets blocks, interface blocks, and interface statistics blocks. All
-the options also by Wireshark are supported. The default reader options match libpcap behaviour. Have
-a look at NgReaderOptions for more advanced usage. Both ReadPacketData and ZeroCopyReadPacketData is
+simple packet blocks, enhanced packets blocks, interface blocks, interface statistics blocks, name
+resolution blocks, decryption secrets blocks, and custom blocks. Unknown blocks can be passed through
+with NgReaderOptions.UnknownBlockCallback and NgWriter.WriteRawBlock. All the options also by Wireshark are supported. The
+default reader options match libpcap behaviour. Have a look at NgReaderOptions for more advanced usage,
+like reading the options of enhanced packet blocks. Both ReadPacketData and ZeroCopyReadPacketData is
 supported (which means PacketDataSource and ZeroCopyPacketDataSource is supported).
 
 		f, err := os.Open("somefile.pcapng")
@@ -37,8 +41,9 @@ supported (which means PacketDataSource and ZeroCopyPacketDataSource is supporte
 		data, ci, err := r.ReadPacketData()
 		...
 
-Write supports only little endian, enhanced packets blocks, interface blocks, and interface statistics
-blocks. The same options as with writing are supported. Interface timestamp resolution is fixed to
+Write supports only little endian, enhanced packets blocks (with WritePacketWithOptions also with their
+options), interface blocks, interface statistics blocks, name resolution blocks, decryption secrets
+blocks, and custom blocks. The same options as with writing are supported. Interface timestamp resolution is fixed to
 10^-9s to match time.Time. Any other values are ignored. Upon creating a writer, a section, and an
 interface block is automatically written. Additional interfaces can be added at any time. Since
 the writer uses a bufio.Writer internally, Flush must be called before closing the file! Have a look
@@ -59,5 +64,26 @@ at NewNgWriterInterface for more advanced usage.
 		err = r.WritePacket(ci, data)
 		...
 
+Random Access
+
+IndexedReader reads packets of pcap and pcapng files in arbitrary order, using an index of the
+packets. Building the index needs a pass over the whole file, so it can be saved with WriteIndex and
+loaded with NewIndexedReaderFromIndex.
+
+		f, err := os.Open("somefile.pcapng")
+		if err != nil {
+			...
+		}
+		defer f.Close()
+
+		r, err := NewIndexedReader(f)
+		if err != nil {
+			...
+		}
+
+		r.SeekToTime(t)
+		data, ci, err := r.ReadPacketData()
+		...
+
 */
 package pcapgo
@@ -0,0 +1,424 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package pcapgo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// ErrIndexMismatch gets returned if a loaded index doesn't belong to the capture file.
+var ErrIndexMismatch = errors.New("Index does not match capture file")
+
+const (
+	indexMagic       = 0x58495047 // "GPIX"
+	indexVersion     = 1
+	indexHeaderLen   = 24
+	indexEntryLength = 32
+	indexNoTimestamp = math.MinInt64 // timestamp of packets without timestamp, like pcapng simple packets
+
+	// whence values of Seek; seekStart and seekEnd need Go 1.7
+	seekStart = 0
+	seekEnd   = 2
+)
+
+// IndexEntry locates a single packet in a capture file.
+type IndexEntry struct {
+	// Offset is the position of the packet data in the file.
+	Offset int64
+	// CaptureInfo describes the packet. For pcapng files, InterfaceIndex is the index of the interface in the section of the packet.
+	CaptureInfo gopacket.CaptureInfo
+	// LinkType is the link type of the packet. It might differ between packets of pcapng files.
+	LinkType layers.LinkType
+}
+
+// IndexedReader reads packets of a pcap or pcapng file in arbitrary order. It uses an index of the offsets and timestamps of all the packets, which is
+// either built by scanning the file once, or loaded from a file written by WriteIndex.
+//
+// Compressed files are not supported, since they can't be seeked.
+type IndexedReader struct {
+	rs       io.ReadSeeker
+	size     int64
+	linkType layers.LinkType
+	index    []IndexEntry
+	sorted   bool
+	next     int
+	// br reads from rs, which is positioned at pos. pos is -1 if rs has to be seeked before reading.
+	br        *bufio.Reader
+	pos       int64
+	packetBuf []byte
+}
+
+// NewIndexedReader returns a new reader for the pcap or pcapng file read by rs. The whole file is scanned to build the index of the packets, but the
+// packet data is not decoded.
+//
+// A truncated packet at the end of the file, as left by an interrupted capture, is not part of the index.
+func NewIndexedReader(rs io.ReadSeeker) (*IndexedReader, error) {
+	r, header, err := newIndexedReader(rs)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		err = r.indexNg()
+	} else {
+		err = r.indexPcap(header)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.indexed()
+	return r, nil
+}
+
+// NewIndexedReaderFromIndex returns a new reader for the pcap or pcapng file read by rs, with the index read from index. The index must have been written
+// by WriteIndex for the same file, otherwise ErrIndexMismatch is returned. Only the size of the file is used to detect a changed file.
+func NewIndexedReaderFromIndex(rs io.ReadSeeker, index io.Reader) (*IndexedReader, error) {
+	r, _, err := newIndexedReader(rs)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.readIndex(index); err != nil {
+		return nil, err
+	}
+	r.indexed()
+	return r, nil
+}
+
+// newIndexedReader checks the format of the file read by rs and returns a reader without index. For pcap files, the parsed file header is returned
+// as well.
+func newIndexedReader(rs io.ReadSeeker) (r *IndexedReader, header *Reader, err error) {
+	size, err := rs.Seek(0, seekEnd)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := rs.Seek(0, seekStart); err != nil {
+		return nil, nil, err
+	}
+	r = &IndexedReader{
+		rs:   rs,
+		size: size,
+		br:   bufio.NewReader(rs),
+		pos:  -1,
+	}
+
+	var magic [4]byte
+	if _, err := io.ReadFull(rs, magic[:]); err != nil {
+		return nil, nil, err
+	}
+	if magic[0] == magicGzip1 && magic[1] == magicGzip2 {
+		return nil, nil, errors.New("Compressed files can't be indexed")
+	}
+	if binary.LittleEndian.Uint32(magic[:]) == uint32(ngBlockTypeSectionHeader) {
+		return r, nil, nil
+	}
+
+	if _, err := rs.Seek(0, seekStart); err != nil {
+		return nil, nil, err
+	}
+	header = &Reader{r: rs}
+	if err := header.readHeader(); err != nil {
+		return nil, nil, err
+	}
+	r.linkType = header.linkType
+	return r, header, nil
+}
+
+// indexPcap builds the index of a pcap file with the given file header.
+func (r *IndexedReader) indexPcap(header *Reader) error {
+	offset := int64(24)
+	if _, err := r.rs.Seek(offset, seekStart); err != nil {
+		return err
+	}
+	br := bufio.NewReader(r.rs)
+	header.r = br
+	for {
+		ci, err := header.readPacketHeader()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if ci.CaptureLength > ci.Length {
+			return fmt.Errorf("capture length exceeds original packet length: %d > %d", ci.CaptureLength, ci.Length)
+		}
+		offset += 16
+		if _, err := br.Discard(ci.CaptureLength); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		r.index = append(r.index, IndexEntry{
+			Offset:      offset,
+			CaptureInfo: ci,
+			LinkType:    header.linkType,
+		})
+		offset += int64(ci.CaptureLength)
+	}
+}
+
+// ngCountingReader counts the bytes read from r.
+type ngCountingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *ngCountingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// indexNg builds the index of a pcapng file. Sections of unknown versions are skipped.
+func (r *IndexedReader) indexNg() error {
+	if _, err := r.rs.Seek(0, seekStart); err != nil {
+		return err
+	}
+	cr := &ngCountingReader{r: r.rs}
+	ng, err := NewNgReader(cr, NgReaderOptions{WantMixedLinkType: true, SkipUnknownVersion: true})
+	if err != nil {
+		return err
+	}
+	for {
+		if err := ng.readPacketHeader(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if uint32(ng.ci.CaptureLength) > ng.currentBlock.length {
+			return fmt.Errorf("Capture length %d exceeds the length of the packet block", ng.ci.CaptureLength)
+		}
+		entry := IndexEntry{
+			Offset:      cr.n - int64(ng.r.Buffered()),
+			CaptureInfo: ng.ci,
+			LinkType:    ng.ifaces[ng.ci.InterfaceIndex].LinkType,
+		}
+		if _, err := ng.r.Discard(int(ng.currentBlock.length)); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		r.index = append(r.index, entry)
+	}
+}
+
+// indexed finishes setting up the reader after the index was built or loaded.
+func (r *IndexedReader) indexed() {
+	r.sorted = true
+	for i := 1; i < len(r.index); i++ {
+		if r.index[i].CaptureInfo.Timestamp.Before(r.index[i-1].CaptureInfo.Timestamp) {
+			r.sorted = false
+			break
+		}
+	}
+	if len(r.index) > 0 && r.linkType == 0 {
+		r.linkType = r.index[0].LinkType
+	}
+}
+
+// WriteIndex writes the index of the file to w, so that it can be loaded with NewIndexedReaderFromIndex instead of scanning the file again.
+func (r *IndexedReader) WriteIndex(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var buf [indexEntryLength]byte
+	binary.LittleEndian.PutUint32(buf[0:4], indexMagic)
+	binary.LittleEndian.PutUint32(buf[4:8], indexVersion)
+	binary.LittleEndian.PutUint64(buf[8:16], uint64(r.size))
+	binary.LittleEndian.PutUint64(buf[16:24], uint64(len(r.index)))
+	if _, err := bw.Write(buf[:indexHeaderLen]); err != nil {
+		return err
+	}
+	for _, e := range r.index {
+		binary.LittleEndian.PutUint64(buf[0:8], uint64(e.Offset))
+		ts := e.CaptureInfo.Timestamp.UnixNano()
+		if e.CaptureInfo.Timestamp.IsZero() {
+			ts = indexNoTimestamp
+		}
+		binary.LittleEndian.PutUint64(buf[8:16], uint64(ts))
+		binary.LittleEndian.PutUint32(buf[16:20], uint32(e.CaptureInfo.CaptureLength))
+		binary.LittleEndian.PutUint32(buf[20:24], uint32(e.CaptureInfo.Length))
+		binary.LittleEndian.PutUint32(buf[24:28], uint32(e.CaptureInfo.InterfaceIndex))
+		binary.LittleEndian.PutUint32(buf[28:32], uint32(e.LinkType))
+		if _, err := bw.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// readIndex loads an index written by WriteIndex.
+func (r *IndexedReader) readIndex(index io.Reader) error {
+	br := bufio.NewReader(index)
+	var buf [indexEntryLength]byte
+	if _, err := io.ReadFull(br, buf[:indexHeaderLen]); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(buf[0:4]) != indexMagic {
+		return errors.New("Unknown index format")
+	}
+	if version := binary.LittleEndian.Uint32(buf[4:8]); version != indexVersion {
+		return fmt.Errorf("Unknown index version %d", version)
+	}
+	if int64(binary.LittleEndian.Uint64(buf[8:16])) != r.size {
+		return ErrIndexMismatch
+	}
+	n := binary.LittleEndian.Uint64(buf[16:24])
+	// every packet needs at least 16 octets in the file
+	if n > uint64(r.size/16) {
+		return ErrIndexMismatch
+	}
+	r.index = make([]IndexEntry, n)
+	for i := range r.index {
+		if _, err := io.ReadFull(br, buf[:]); err != nil {
+			return err
+		}
+		e := &r.index[i]
+		e.Offset = int64(binary.LittleEndian.Uint64(buf[0:8]))
+		if ts := int64(binary.LittleEndian.Uint64(buf[8:16])); ts != indexNoTimestamp {
+			e.CaptureInfo.Timestamp = time.Unix(0, ts).UTC()
+		}
+		e.CaptureInfo.CaptureLength = int(binary.LittleEndian.Uint32(buf[16:20]))
+		e.CaptureInfo.Length = int(binary.LittleEndian.Uint32(buf[20:24]))
+		e.CaptureInfo.InterfaceIndex = int(binary.LittleEndian.Uint32(buf[24:28]))
+		e.LinkType = layers.LinkType(binary.LittleEndian.Uint32(buf[28:32]))
+		if e.Offset < 0 || e.Offset+int64(e.CaptureInfo.CaptureLength) > r.size {
+			return ErrIndexMismatch
+		}
+	}
+	return nil
+}
+
+// NumPackets returns the number of packets in the file.
+func (r *IndexedReader) NumPackets() int {
+	return len(r.index)
+}
+
+// Entry returns the index entry of packet n.
+func (r *IndexedReader) Entry(n int) (IndexEntry, error) {
+	if n < 0 || n >= len(r.index) {
+		return IndexEntry{}, fmt.Errorf("Packet %d invalid. There are only %d packets", n, len(r.index))
+	}
+	return r.index[n], nil
+}
+
+// LinkType returns the link type of the file. For pcapng files, this is the link type of the first packet.
+func (r *IndexedReader) LinkType() layers.LinkType {
+	return r.linkType
+}
+
+// Position returns the number of the packet read by the next call to ReadPacketData.
+func (r *IndexedReader) Position() int {
+	return r.next
+}
+
+// SeekToPacket makes packet n the next one read by ReadPacketData. Packets are numbered from 0. Seeking to NumPackets is allowed, after which
+// ReadPacketData returns io.EOF.
+func (r *IndexedReader) SeekToPacket(n int) error {
+	if n < 0 || n > len(r.index) {
+		return fmt.Errorf("Can't seek to packet %d. There are only %d packets", n, len(r.index))
+	}
+	r.next = n
+	return nil
+}
+
+// SeekToTime makes the first packet with a timestamp not before t the next one read by ReadPacketData, and returns its number. If there is no such
+// packet, NumPackets is returned. The index is binary searched if the timestamps of the packets are in order, otherwise all packets are checked.
+func (r *IndexedReader) SeekToTime(t time.Time) int {
+	if r.sorted {
+		r.next = sort.Search(len(r.index), func(i int) bool {
+			return !r.index[i].CaptureInfo.Timestamp.Before(t)
+		})
+		return r.next
+	}
+	for r.next = 0; r.next < len(r.index); r.next++ {
+		if !r.index[r.next].CaptureInfo.Timestamp.Before(t) {
+			break
+		}
+	}
+	return r.next
+}
+
+// readPacket reads the data of the given packet into data.
+func (r *IndexedReader) readPacket(e IndexEntry, data []byte) error {
+	if r.pos != e.Offset {
+		if skip := e.Offset - r.pos; r.pos >= 0 && skip > 0 && skip <= int64(r.br.Buffered()) {
+			if _, err := r.br.Discard(int(skip)); err != nil {
+				return err
+			}
+		} else {
+			if _, err := r.rs.Seek(e.Offset, seekStart); err != nil {
+				r.pos = -1
+				return err
+			}
+			r.br.Reset(r.rs)
+		}
+		r.pos = e.Offset
+	}
+	n, err := io.ReadFull(r.br, data)
+	r.pos += int64(n)
+	return err
+}
+
+// ReadPacketData reads the next packet from the file.
+func (r *IndexedReader) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
+	if r.next >= len(r.index) {
+		err = io.EOF
+		return
+	}
+	e := r.index[r.next]
+	data = make([]byte, e.CaptureInfo.CaptureLength)
+	if err = r.readPacket(e, data); err != nil {
+		return
+	}
+	r.next++
+	return data, e.CaptureInfo, nil
+}
+
+// ZeroCopyReadPacketData reads the next packet from the file. The data buffer is owned by the IndexedReader,
+// and each call to ZeroCopyReadPacketData invalidates data returned by the previous one.
+func (r *IndexedReader) ZeroCopyReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
+	if r.next >= len(r.index) {
+		err = io.EOF
+		return
+	}
+	e := r.index[r.next]
+	if cap(r.packetBuf) < e.CaptureInfo.CaptureLength {
+		r.packetBuf = make([]byte, e.CaptureInfo.CaptureLength)
+	}
+	data = r.packetBuf[:e.CaptureInfo.CaptureLength]
+	if err = r.readPacket(e, data); err != nil {
+		return
+	}
+	r.next++
+	return data, e.CaptureInfo, nil
+}
+
+// ReadPacketRange reads the packets from start up to, but not including, end. Afterwards, end is the next packet read by ReadPacketData.
+func (r *IndexedReader) ReadPacketRange(start, end int) (data [][]byte, ci []gopacket.CaptureInfo, err error) {
+	if start < 0 || start > end || end > len(r.index) {
+		return nil, nil, fmt.Errorf("Invalid packet range %d-%d. There are only %d packets", start, end, len(r.index))
+	}
+	r.next = start
+	data = make([][]byte, 0, end-start)
+	ci = make([]gopacket.CaptureInfo, 0, end-start)
+	for r.next < end {
+		d, c, err := r.ReadPacketData()
+		if err != nil {
+			return data, ci, err
+		}
+		data = append(data, d)
+		ci = append(ci, c)
+	}
+	return data, ci, nil
+}
@@ -0,0 +1,219 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package pcapgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// indexTestPcap returns a pcap file with the given magic and byte order, holding the given packets. Timestamps are truncated to microseconds unless nanos
+// is true.
+func indexTestPcap(order binary.ByteOrder, magic uint32, nanos bool, data [][]byte, ci []gopacket.CaptureInfo) []byte {
+	file := make([]byte, 24)
+	order.PutUint32(file[0:4], magic)
+	order.PutUint16(file[4:6], versionMajor)
+	order.PutUint16(file[6:8], versionMinor)
+	order.PutUint32(file[16:20], 0xffff)
+	order.PutUint32(file[20:24], uint32(layers.LinkTypeEthernet))
+	for i := range data {
+		var header [16]byte
+		frac := ci[i].Timestamp.Nanosecond()
+		if !nanos {
+			frac /= 1000
+		}
+		order.PutUint32(header[0:4], uint32(ci[i].Timestamp.Unix()))
+		order.PutUint32(header[4:8], uint32(frac))
+		order.PutUint32(header[8:12], uint32(ci[i].CaptureLength))
+		order.PutUint32(header[12:16], uint32(ci[i].Length))
+		file = append(file, header[:]...)
+		file = append(file, data[i]...)
+	}
+	return file
+}
+
+// indexReadAll reads all remaining packets from r.
+func indexReadAll(r gopacket.PacketDataSource, t *testing.T) (data [][]byte, ci []gopacket.CaptureInfo) {
+	for {
+		d, c, err := r.ReadPacketData()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			t.Fatal("Couldn't read packet:", err)
+		}
+		data = append(data, d)
+		ci = append(ci, c)
+	}
+}
+
+func TestIndexedReaderPcap(t *testing.T) {
+	var data [][]byte
+	var ci []gopacket.CaptureInfo
+	for i, d := range ngPacketSource {
+		if i == 2 {
+			d = d[:100]
+		}
+		data = append(data, d)
+		ci = append(ci, gopacket.CaptureInfo{
+			Timestamp:     time.Unix(1519128000+int64(i), int64(i)*1000).UTC(),
+			CaptureLength: len(d),
+			Length:        len(ngPacketSource[i]),
+		})
+	}
+	for _, test := range []struct {
+		name  string
+		order binary.ByteOrder
+		magic uint32
+		nanos bool
+	}{
+		{"micro", binary.LittleEndian, magicMicroseconds, false},
+		{"nano", binary.LittleEndian, magicNanoseconds, true},
+		{"micro big endian", binary.BigEndian, magicMicroseconds, false},
+		{"nano big endian", binary.BigEndian, magicNanoseconds, true},
+	} {
+		file := indexTestPcap(test.order, test.magic, test.nanos, data, ci)
+		// a truncated packet is left out
+		truncated := append(file, file[24:24+16+10]...)
+		r, err := NewIndexedReader(bytes.NewReader(truncated))
+		if err != nil {
+			t.Fatalf("[%s] Couldn't create reader: %v", test.name, err)
+		}
+		if r.NumPackets() != len(data) || r.LinkType() != layers.LinkTypeEthernet {
+			t.Fatalf("[%s] Got %d packets of link type %s", test.name, r.NumPackets(), r.LinkType())
+		}
+		gotData, gotCI := indexReadAll(r, t)
+		if !reflect.DeepEqual(gotData, data) || !reflect.DeepEqual(gotCI, ci) {
+			t.Errorf("[%s] Packets mismatch:\ngot:\n%v\nwant:\n%v", test.name, gotCI, ci)
+		}
+
+		if err := r.SeekToPacket(3); err != nil {
+			t.Fatal(err)
+		}
+		if d, c, err := r.ZeroCopyReadPacketData(); err != nil || !bytes.Equal(d, data[3]) || !reflect.DeepEqual(c, ci[3]) {
+			t.Errorf("[%s] Packet 3 mismatch: %v %v", test.name, c, err)
+		}
+		if n := r.SeekToTime(ci[2].Timestamp.Add(-time.Millisecond)); n != 2 || r.Position() != 2 {
+			t.Errorf("[%s] Seeking to time of packet 2 went to %d", test.name, n)
+		}
+		if n := r.SeekToTime(ci[4].Timestamp.Add(time.Second)); n != len(data) {
+			t.Errorf("[%s] Seeking past the end went to %d", test.name, n)
+		}
+		if _, _, err := r.ReadPacketData(); err != io.EOF {
+			t.Errorf("[%s] Got %v reading past the end", test.name, err)
+		}
+		gotData, gotCI, err = r.ReadPacketRange(1, 3)
+		if err != nil || !reflect.DeepEqual(gotData, data[1:3]) || !reflect.DeepEqual(gotCI, ci[1:3]) {
+			t.Errorf("[%s] Range mismatch: %v %v", test.name, gotCI, err)
+		}
+		if r.Position() != 3 {
+			t.Errorf("[%s] Position after range is %d", test.name, r.Position())
+		}
+		if err := r.SeekToPacket(len(data) + 1); err == nil {
+			t.Errorf("[%s] Seeking beyond the end succeeded", test.name)
+		}
+	}
+}
+
+func TestIndexedReaderNg(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("tests", "*", "*.pcapng"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range files {
+		contents, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ng, err := NewNgReader(bytes.NewReader(contents), NgReaderOptions{WantMixedLinkType: true, SkipUnknownVersion: true})
+		if err != nil {
+			continue
+		}
+		var data [][]byte
+		var ci []gopacket.CaptureInfo
+		var linkTypes []layers.LinkType
+		for {
+			d, c, err := ng.ReadPacketData()
+			if err != nil {
+				break
+			}
+			data = append(data, d)
+			linkTypes = append(linkTypes, c.AncillaryData[0].(layers.LinkType))
+			c.AncillaryData = nil
+			ci = append(ci, c)
+		}
+
+		r, err := NewIndexedReader(bytes.NewReader(contents))
+		if err != nil {
+			// broken files fail with the sequential reader after the last valid packet
+			if len(data) == 0 {
+				continue
+			}
+			t.Fatalf("[%s] Couldn't create reader: %v", name, err)
+		}
+		if r.NumPackets() != len(data) {
+			t.Fatalf("[%s] Got %d packets, want %d", name, r.NumPackets(), len(data))
+		}
+		// read backwards to check the seeking
+		for i := len(data) - 1; i >= 0; i-- {
+			if err := r.SeekToPacket(i); err != nil {
+				t.Fatal(err)
+			}
+			d, c, err := r.ReadPacketData()
+			if err != nil {
+				t.Fatalf("[%s] Couldn't read packet %d: %v", name, i, err)
+			}
+			if !bytes.Equal(d, data[i]) || !reflect.DeepEqual(c, ci[i]) {
+				t.Errorf("[%s] Packet %d mismatch:\ngot:\n%v\nwant:\n%v", name, i, c, ci[i])
+			}
+			if e, _ := r.Entry(i); e.LinkType != linkTypes[i] {
+				t.Errorf("[%s] Packet %d has link type %s, want %s", name, i, e.LinkType, linkTypes[i])
+			}
+		}
+
+		var index bytes.Buffer
+		if err := r.WriteIndex(&index); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := NewIndexedReaderFromIndex(bytes.NewReader(contents), bytes.NewReader(index.Bytes()))
+		if err != nil {
+			t.Fatalf("[%s] Couldn't load index: %v", name, err)
+		}
+		if (len(loaded.index) > 0 || len(r.index) > 0) && !reflect.DeepEqual(loaded.index, r.index) {
+			t.Errorf("[%s] Loaded index mismatch:\ngot:\n%v\nwant:\n%v", name, loaded.index, r.index)
+		}
+		if gotData, gotCI := indexReadAll(loaded, t); !reflect.DeepEqual(gotData, data) || !reflect.DeepEqual(gotCI, ci) {
+			t.Errorf("[%s] Packets read with loaded index mismatch", name)
+		}
+	}
+}
+
+func TestIndexedReaderIndexMismatch(t *testing.T) {
+	data := [][]byte{ngPacketSource[0]}
+	ci := []gopacket.CaptureInfo{{Timestamp: time.Unix(0, 0).UTC(), CaptureLength: len(data[0]), Length: len(data[0])}}
+	file := indexTestPcap(binary.LittleEndian, magicMicroseconds, false, data, ci)
+	r, err := NewIndexedReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index bytes.Buffer
+	if err := r.WriteIndex(&index); err != nil {
+		t.Fatal(err)
+	}
+	file = append(file, 0)
+	if _, err := NewIndexedReaderFromIndex(bytes.NewReader(file), bytes.NewReader(index.Bytes())); err != ErrIndexMismatch {
+		t.Errorf("Got %v loading the index of a different file", err)
+	}
+}
@@ -8,10 +8,13 @@ package pcapgo
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/google/gopacket"
@@ -33,6 +36,18 @@ type NgReaderOptions struct {
 	SectionEndCallback func([]NgInterface, NgSectionInfo)
 	// StatisticsCallback is called when a interface statistics block is read. The interface id and the read statistics are provided.
 	StatisticsCallback func(int, NgInterfaceStatistics)
+	// NameResolutionCallback is called when a name resolution block is read. If it is nil, name resolution blocks are skipped.
+	NameResolutionCallback func(NgNameResolution)
+	// WantPacketOptions enables parsing the options of enhanced packet blocks. If true, the options of each packet are appended to ci.AncillaryData as an NgPacketOptions, after the link type if WantMixedLinkType is true.
+	// Packets without options, like simple packets, get DefaultNgPacketOptions.
+	WantPacketOptions bool
+	// DecryptionSecretsCallback is called when a decryption secrets block is read. If it is nil, decryption secrets blocks are skipped.
+	DecryptionSecretsCallback func(NgDecryptionSecrets)
+	// CustomBlockHandlers maps private enterprise numbers to functions which are called when a custom block with that PEN is read.
+	CustomBlockHandlers map[uint32]func(NgCustomBlock)
+	// UnknownBlockCallback is called when a block of an unknown type, or a custom block without a handler in CustomBlockHandlers, is read. If it is nil, those blocks are skipped.
+	// The block can be written unchanged with NgWriter.WriteRawBlock.
+	UnknownBlockCallback func(NgRawBlock)
 }
 
 // DefaultNgReaderOptions provides sane defaults for a pcapng reader.
@@ -50,7 +65,8 @@ type NgReader struct {
 	buf               [24]byte
 	packetBuf         []byte
 	ci                gopacket.CaptureInfo
-	ancil             [1]interface{}
+	ancil             [2]interface{}
+	nancil            int
 	blen              int
 	firstSectionFound bool
 	activeSection     bool
@@ -98,6 +114,17 @@ func (r *NgReader) readBytes(buffer []byte) error {
 	return nil
 }
 
+// readBlockBytes reads n bytes of the current block after prefix, and returns them with prefix prepended. The buffer grows as the data is read,
+// so that a corrupt block length doesn't lead to a huge allocation.
+func (r *NgReader) readBlockBytes(prefix []byte, n uint32) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(prefix)
+	if _, err := io.CopyN(&buf, r.r, int64(n)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 // The following functions make the binary.* functions inlineable (except for getUint64, which is too big, but not in any hot path anyway)
 // Compared to storing binary.*Endian in a binary.ByteOrder this shaves off about 20% for (ZeroCopy)ReadPacketData, which is caused by the needed itab lookup + indirect go call
 func (r *NgReader) getUint16(buffer []byte) uint16 {
@@ -314,6 +341,28 @@ func (r *NgReader) firstInterface() error {
 			return nil
 		case ngBlockTypePacket, ngBlockTypeEnhancedPacket, ngBlockTypeSimplePacket, ngBlockTypeInterfaceStatistics:
 			return errors.New("A section must have an interface before a packet block")
+		case ngBlockTypeNameResolution:
+			if err := r.readNameResolution(); err != nil {
+				return err
+			}
+			continue
+		case ngBlockTypeDecryptionSecrets:
+			if err := r.readDecryptionSecrets(); err != nil {
+				return err
+			}
+			continue
+		case ngBlockTypeCustom, ngBlockTypeCustomNoCopy:
+			if err := r.readCustomBlock(); err != nil {
+				return err
+			}
+			continue
+		case ngBlockTypeSectionHeader:
+			// skipped
+		default:
+			if err := r.readRawBlock(nil); err != nil {
+				return err
+			}
+			continue
 		}
 		if _, err := r.r.Discard(int(r.currentBlock.length)); err != nil {
 			return err
@@ -437,9 +486,187 @@ OPTIONS:
 	return nil
 }
 
+// readNameResolution parses a name resolution block and hands it to NameResolutionCallback. Records of unknown types are skipped.
+func (r *NgReader) readNameResolution() error {
+	if r.options.NameResolutionCallback == nil {
+		_, err := r.r.Discard(int(r.currentBlock.length))
+		return err
+	}
+	var nrb NgNameResolution
+
+	for {
+		if r.currentBlock.length < 8 {
+			return errors.New("Name resolution block is missing the end of records")
+		}
+		if err := r.readBytes(r.buf[:4]); err != nil {
+			return err
+		}
+		r.currentBlock.length -= 4
+		typ := ngNameRecordType(r.getUint16(r.buf[:2]))
+		length := uint32(r.getUint16(r.buf[2:4]))
+		if typ == ngNameRecordEnd {
+			break
+		}
+		padded := length + (4-length&3)&3
+		if padded > r.currentBlock.length-4 {
+			return errors.New("Name resolution record exceeds block length")
+		}
+		value := make([]byte, padded)
+		if err := r.readBytes(value); err != nil {
+			return err
+		}
+		r.currentBlock.length -= padded
+		switch typ {
+		case ngNameRecordIPv4:
+			nrb.Records = appendNgNameRecord(nrb.Records, value[:length], net.IPv4len)
+		case ngNameRecordIPv6:
+			nrb.Records = appendNgNameRecord(nrb.Records, value[:length], net.IPv6len)
+		}
+	}
+
+OPTIONS:
+	for {
+		if err := r.readOption(); err != nil {
+			return err
+		}
+		switch r.currentOption.code {
+		case ngOptionCodeEndOfOptions:
+			break OPTIONS
+		case ngOptionCodeComment:
+			nrb.Comment = string(r.currentOption.value)
+		case ngOptionCodeNameResolutionDNSName:
+			nrb.DNSName = string(r.currentOption.value)
+		case ngOptionCodeNameResolutionDNSIPv4Address:
+			if len(r.currentOption.value) >= net.IPv4len {
+				nrb.DNSIPv4Address = append(net.IP(nil), r.currentOption.value[:net.IPv4len]...)
+			}
+		case ngOptionCodeNameResolutionDNSIPv6Address:
+			if len(r.currentOption.value) >= net.IPv6len {
+				nrb.DNSIPv6Address = append(net.IP(nil), r.currentOption.value[:net.IPv6len]...)
+			}
+		}
+	}
+	if _, err := r.r.Discard(int(r.currentBlock.length)); err != nil {
+		return err
+	}
+	r.options.NameResolutionCallback(nrb)
+	return nil
+}
+
+// appendNgNameRecord parses the value of an IPv4 or IPv6 name resolution record, an address followed by zero terminated names, and appends it to records.
+func appendNgNameRecord(records []NgNameRecord, value []byte, addrLen int) []NgNameRecord {
+	if len(value) < addrLen {
+		return records
+	}
+	record := NgNameRecord{IP: net.IP(value[:addrLen])}
+	for _, name := range strings.Split(string(value[addrLen:]), "\x00") {
+		if name != "" {
+			record.Names = append(record.Names, name)
+		}
+	}
+	return append(records, record)
+}
+
+// readDecryptionSecrets parses a decryption secrets block and hands it to DecryptionSecretsCallback.
+func (r *NgReader) readDecryptionSecrets() error {
+	if r.options.DecryptionSecretsCallback == nil {
+		_, err := r.r.Discard(int(r.currentBlock.length))
+		return err
+	}
+	if r.currentBlock.length < 12 {
+		return errors.New("Decryption secrets block too short")
+	}
+	if err := r.readBytes(r.buf[:8]); err != nil {
+		return err
+	}
+	r.currentBlock.length -= 8
+	dsb := NgDecryptionSecrets{Type: NgSecretsType(r.getUint32(r.buf[:4]))}
+	length := r.getUint32(r.buf[4:8])
+	padded := length + (4-length&3)&3
+	if padded < length || padded > r.currentBlock.length-4 {
+		return errors.New("Decryption secrets exceed block length")
+	}
+	data, err := r.readBlockBytes(nil, padded)
+	if err != nil {
+		return err
+	}
+	dsb.Data = data[:length]
+	r.currentBlock.length -= padded
+
+OPTIONS:
+	for {
+		if err := r.readOption(); err != nil {
+			return err
+		}
+		switch r.currentOption.code {
+		case ngOptionCodeEndOfOptions:
+			break OPTIONS
+		case ngOptionCodeComment:
+			dsb.Comment = string(r.currentOption.value)
+		}
+	}
+	if _, err := r.r.Discard(int(r.currentBlock.length)); err != nil {
+		return err
+	}
+	r.options.DecryptionSecretsCallback(dsb)
+	return nil
+}
+
+// readCustomBlock parses a custom block and hands it to the handler registered for its PEN. Custom blocks without a handler are handled like unknown blocks.
+func (r *NgReader) readCustomBlock() error {
+	if r.currentBlock.length < 8 {
+		return errors.New("Custom block too short")
+	}
+	if err := r.readBytes(r.buf[:4]); err != nil {
+		return err
+	}
+	r.currentBlock.length -= 4
+	handler := r.options.CustomBlockHandlers[r.getUint32(r.buf[:4])]
+	if handler == nil {
+		return r.readRawBlock(r.buf[:4])
+	}
+	data, err := r.readBlockBytes(nil, r.currentBlock.length-4)
+	if err != nil {
+		return err
+	}
+	block := NgCustomBlock{
+		PEN:       r.getUint32(r.buf[:4]),
+		Data:      data,
+		NoCopy:    r.currentBlock.typ == ngBlockTypeCustomNoCopy,
+		BigEndian: r.bigEndian,
+	}
+	if _, err := r.r.Discard(4); err != nil {
+		return err
+	}
+	handler(block)
+	return nil
+}
+
+// readRawBlock hands the current block to UnknownBlockCallback. prefix holds the already consumed part of the block body.
+func (r *NgReader) readRawBlock(prefix []byte) error {
+	if r.options.UnknownBlockCallback == nil || r.currentBlock.length < 4 {
+		_, err := r.r.Discard(int(r.currentBlock.length))
+		return err
+	}
+	body, err := r.readBlockBytes(prefix, r.currentBlock.length-4)
+	if err != nil {
+		return err
+	}
+	block := NgRawBlock{
+		Type:      uint32(r.currentBlock.typ),
+		Body:      body,
+		BigEndian: r.bigEndian,
+	}
+	if _, err := r.r.Discard(4); err != nil {
+		return err
+	}
+	r.options.UnknownBlockCallback(block)
+	return nil
+}
+
 // readPacketHeader looks for a packet (enhanced, simple, or packet) and parses the header.
 // If an interface descriptor, an interface statistics block, or a section header is encountered, those are handled accordingly.
-// All other block types are skipped. New block types must be added here.
+// All other block types are handed to UnknownBlockCallback or skipped. New block types must be added here.
 func (r *NgReader) readPacketHeader() error {
 RESTART:
 FIND_PACKET:
@@ -485,6 +712,14 @@ FIND_PACKET:
 			if err := r.readInterfaceStatistics(); err != nil {
 				return err
 			}
+		case ngBlockTypeNameResolution:
+			if err := r.readNameResolution(); err != nil {
+				return err
+			}
+		case ngBlockTypeDecryptionSecrets:
+			if err := r.readDecryptionSecrets(); err != nil {
+				return err
+			}
 		case ngBlockTypeSectionHeader:
 			if err := r.readSectionHeader(); err != nil {
 				return err
@@ -502,8 +737,12 @@ FIND_PACKET:
 			r.ci.CaptureLength = int(r.getUint32(r.buf[12:16]))
 			r.ci.Length = int(r.getUint32(r.buf[16:20]))
 			break FIND_PACKET
+		case ngBlockTypeCustom, ngBlockTypeCustomNoCopy:
+			if err := r.readCustomBlock(); err != nil {
+				return err
+			}
 		default:
-			if _, err := r.r.Discard(int(r.currentBlock.length)); err != nil {
+			if err := r.readRawBlock(nil); err != nil {
 				return err
 			}
 		}
@@ -524,28 +763,111 @@ FIND_PACKET:
 	return nil
 }
 
+// readPacketOptions consumes the rest of the current packet block after the packet data. If WantPacketOptions is true, the options of the packet are parsed and stored in r.ancil.
+func (r *NgReader) readPacketOptions() error {
+	r.nancil = 0
+	if r.options.WantMixedLinkType {
+		r.nancil++
+	}
+	if !r.options.WantPacketOptions {
+		_, err := r.r.Discard(int(r.currentBlock.length) - r.ci.CaptureLength)
+		return err
+	}
+
+	if uint32(r.ci.CaptureLength) > r.currentBlock.length {
+		return fmt.Errorf("Capture length %d exceeds the length of the packet block", r.ci.CaptureLength)
+	}
+	r.currentBlock.length -= uint32(r.ci.CaptureLength)
+	options := DefaultNgPacketOptions
+	if r.currentBlock.typ == ngBlockTypeSimplePacket {
+		r.ancil[r.nancil] = options
+		r.nancil++
+		_, err := r.r.Discard(int(r.currentBlock.length))
+		return err
+	}
+	padding := (4 - uint32(r.ci.CaptureLength)&3) & 3
+	if padding > r.currentBlock.length {
+		padding = r.currentBlock.length
+	}
+	if _, err := r.r.Discard(int(padding)); err != nil {
+		return err
+	}
+	r.currentBlock.length -= padding
+
+OPTIONS:
+	for r.currentBlock.length >= 4 {
+		if err := r.readOption(); err != nil {
+			return err
+		}
+		value := r.currentOption.value
+		switch r.currentOption.code {
+		case ngOptionCodeEndOfOptions:
+			break OPTIONS
+		case ngOptionCodeComment:
+			options.Comments = append(options.Comments, string(value))
+		case ngOptionCodePacketFlags:
+			if len(value) >= 4 {
+				options.Flags = NgPacketFlags(r.getUint32(value[:4]))
+			}
+		case ngOptionCodePacketHash:
+			if len(value) >= 1 {
+				options.Hashes = append(options.Hashes, NgPacketHash{
+					Algorithm: NgHashAlgorithm(value[0]),
+					Value:     append([]byte(nil), value[1:]...),
+				})
+			}
+		case ngOptionCodePacketDropCount:
+			if len(value) >= 8 {
+				options.DropCount = r.getUint64(value[:8])
+			}
+		case ngOptionCodePacketID:
+			if len(value) >= 8 {
+				options.PacketID = r.getUint64(value[:8])
+			}
+		case ngOptionCodePacketQueue:
+			if len(value) >= 4 {
+				options.Queue = r.getUint32(value[:4])
+			}
+		case ngOptionCodePacketVerdict:
+			if len(value) >= 1 {
+				options.Verdicts = append(options.Verdicts, NgPacketVerdict{
+					Type:  NgVerdictType(value[0]),
+					Value: append([]byte(nil), value[1:]...),
+				})
+			}
+		}
+	}
+	r.ancil[r.nancil] = options
+	r.nancil++
+	_, err := r.r.Discard(int(r.currentBlock.length))
+	return err
+}
+
 // ReadPacketData returns the next packet available from this data source.
 // If WantMixedLinkType is true, ci.AncillaryData[0] contains the link type.
+// If WantPacketOptions is true, the last element of ci.AncillaryData contains the NgPacketOptions of the packet.
 func (r *NgReader) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
 	if err = r.readPacketHeader(); err != nil {
 		return
 	}
 	ci = r.ci
-	if r.options.WantMixedLinkType {
-		ci.AncillaryData = make([]interface{}, 1)
-		ci.AncillaryData[0] = r.ancil[0]
-	}
 	data = make([]byte, r.ci.CaptureLength)
 	if err = r.readBytes(data); err != nil {
 		return
 	}
-	// handle options somehow - this would be expensive
-	_, err = r.r.Discard(int(r.currentBlock.length) - r.ci.CaptureLength)
+	if err = r.readPacketOptions(); err != nil {
+		return
+	}
+	if r.nancil > 0 {
+		ci.AncillaryData = make([]interface{}, r.nancil)
+		copy(ci.AncillaryData, r.ancil[:r.nancil])
+	}
 	return
 }
 
 // ZeroCopyReadPacketData returns the next packet available from this data source.
 // If WantMixedLinkType is true, ci.AncillaryData[0] contains the link type.
+// If WantPacketOptions is true, the last element of ci.AncillaryData contains the NgPacketOptions of the packet.
 // Warning: Like data, ci.AncillaryData is also reused and overwritten on the next call to ZeroCopyReadPacketData.
 //
 // It is not true zero copy, as data is still copied from the underlying reader. However,
@@ -555,9 +877,6 @@ func (r *NgReader) ZeroCopyReadPacketData() (data []byte, ci gopacket.CaptureInf
 		return
 	}
 	ci = r.ci
-	if r.options.WantMixedLinkType {
-		ci.AncillaryData = r.ancil[:]
-	}
 	if cap(r.packetBuf) < ci.CaptureLength {
 		snaplen := int(r.ifaces[ci.InterfaceIndex].SnapLength)
 		if snaplen < ci.CaptureLength {
@@ -569,8 +888,12 @@ func (r *NgReader) ZeroCopyReadPacketData() (data []byte, ci gopacket.CaptureInf
 	if err = r.readBytes(data); err != nil {
 		return
 	}
-	// handle options somehow - this would be expensive
-	_, err = r.r.Discard(int(r.currentBlock.length) - ci.CaptureLength)
+	if err = r.readPacketOptions(); err != nil {
+		return
+	}
+	if r.nancil > 0 {
+		ci.AncillaryData = r.ancil[:r.nancil]
+	}
 	return
 }
 
@@ -12,9 +12,11 @@ import (
 	"encoding/hex"
 	"io"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"testing"
 	"time"
 
@@ -54,6 +56,7 @@ type ngFileReadTest struct {
 	testContents               io.Reader
 	testType                   string
 	wantMixedLinkType          bool
+	wantPacketOptions          bool
 	errorOnMismatchingLinkType bool
 	skipUnknownVersion         bool
 
@@ -128,6 +131,7 @@ func ngRunFileReadTest(test ngFileReadTest, be string, zerocopy bool, t *testing
 	options := DefaultNgReaderOptions
 	options.ErrorOnMismatchingLinkType = test.errorOnMismatchingLinkType
 	options.WantMixedLinkType = test.wantMixedLinkType
+	options.WantPacketOptions = test.wantPacketOptions
 	options.SkipUnknownVersion = test.skipUnknownVersion
 	if len(test.sections) > 1 {
 		options.SectionEndCallback = testSection
@@ -750,6 +754,62 @@ var tests = []ngFileReadTest{
 			},
 		},
 	},
+	{
+		testName:          "test009",
+		testType:          "/WantPacketOptions",
+		wantPacketOptions: true,
+		linkType:          layers.LinkTypeEthernet,
+		sections: []ngFileReadTestSection{
+			{
+				sectionInfo: NgSectionInfo{
+					Hardware:    "Apple MBP",
+					OS:          "OS-X 10.10.5",
+					Application: "pcap_writer.lua",
+					Comment:     "test009",
+				},
+				ifaces: []NgInterface{
+					{
+						LinkType:   layers.LinkTypeEthernet,
+						SnapLength: 0,
+						Name:       "eth0",
+					},
+				},
+			},
+		},
+		packets: []ngFileReadTestPacket{
+			{
+				data: ngPacketSource[0],
+				ci: gopacket.CaptureInfo{
+					Timestamp:      time.Unix(0, 0x4c39764ca47aa*1000).UTC(),
+					Length:         len(ngPacketSource[0]),
+					CaptureLength:  len(ngPacketSource[0]),
+					InterfaceIndex: 0,
+					AncillaryData: []interface{}{NgPacketOptions{
+						Comments:  []string{"test009-1"},
+						DropCount: 0,
+						PacketID:  NgNoValue64,
+						Queue:     NgNoValue32,
+					}},
+				},
+			},
+			{
+				data: ngPacketSource[1],
+				ci: gopacket.CaptureInfo{
+					Timestamp:      time.Unix(0, 0x4c39764ca47aa*1000+1000*1000).UTC(),
+					Length:         len(ngPacketSource[1]),
+					CaptureLength:  len(ngPacketSource[1]),
+					InterfaceIndex: 0,
+					AncillaryData: []interface{}{NgPacketOptions{
+						Comments:  []string{"test009-2"},
+						Flags:     NewNgPacketFlags(NgPacketDirectionUnknown, NgReceptionTypeUnspecified, 0, NgLinkLayerErrorPreamble|NgLinkLayerErrorInterFrameGap),
+						DropCount: 12345,
+						PacketID:  NgNoValue64,
+						Queue:     NgNoValue32,
+					}},
+				},
+			},
+		},
+	},
 	{
 		testName: "test010",
 		linkType: layers.LinkTypeEthernet,
@@ -1842,6 +1902,182 @@ func TestNgFileReadLEZero(t *testing.T) {
 	}
 }
 
+// ngReadNameResolutions reads all packets from the given test file and returns the name resolution blocks encountered.
+func ngReadNameResolutions(be, name string, t *testing.T) []NgNameResolution {
+	f, err := os.Open(filepath.Join("tests", be, name+".pcapng"))
+	if err != nil {
+		t.Fatal("Couldn't open file:", err)
+	}
+	defer f.Close()
+
+	var nrbs []NgNameResolution
+	options := DefaultNgReaderOptions
+	options.NameResolutionCallback = func(nrb NgNameResolution) {
+		nrbs = append(nrbs, nrb)
+	}
+	r, err := NewNgReader(f, options)
+	if err != nil {
+		t.Fatal("Couldn't read start of file:", err)
+	}
+	for {
+		if _, _, err := r.ReadPacketData(); err == io.EOF {
+			return nrbs
+		} else if err != nil {
+			t.Fatalf("[%s %s] Unexpected error: %s", be, name, err)
+		}
+	}
+}
+
+func TestNgReadNameResolution(t *testing.T) {
+	want := []NgNameResolution{
+		{
+			Records: []NgNameRecord{
+				{IP: net.IP{192, 168, 1, 2}, Names: []string{"example.com"}},
+				{IP: net.IP{192, 168, 3, 4}, Names: []string{"example.net"}},
+				{IP: net.IP{10, 1, 2, 3}, Names: []string{"example.org"}},
+			},
+			Comment: "test016 NRB",
+		},
+		{
+			Records: []NgNameRecord{
+				{IP: net.IP{192, 168, 1, 2}, Names: []string{"foo.example.com"}},
+				{IP: net.IP{192, 168, 3, 4}, Names: []string{"foo.example.net"}},
+				{IP: net.IP{10, 1, 2, 3}, Names: []string{"foo.example.org"}},
+			},
+		},
+		{
+			Records: []NgNameRecord{
+				{IP: net.IP{192, 168, 1, 2}, Names: []string{"qux.example.com"}},
+				{IP: net.IP{192, 168, 1, 3}, Names: []string{"bar.example.com"}},
+				{IP: net.IP{192, 168, 3, 5}, Names: []string{"bar.example.net"}},
+				{IP: net.IP{10, 1, 2, 4}, Names: []string{"bar.example.org"}},
+			},
+			Comment: "test016 NRB",
+		},
+	}
+	for _, be := range []string{"be", "le"} {
+		if got := ngReadNameResolutions(be, "test016", t); !reflect.DeepEqual(got, want) {
+			t.Errorf("[%s] name resolution mismatch:\ngot:\n%#v\nwant:\n%#v\n\n", be, got, want)
+		}
+
+		// test102 starts with a name resolution block before the first interface, holding an IPv6 record and records of unknown types.
+		got := ngReadNameResolutions(be, "test102", t)
+		if len(got) != 3 {
+			t.Fatalf("[%s] got %d name resolution blocks, want 3", be, len(got))
+		}
+		first := NgNameResolution{
+			Records: []NgNameRecord{
+				{IP: net.IP{192, 168, 1, 2}, Names: []string{"a"}},
+				{IP: net.IP{192, 168, 1, 2}, Names: []string{"example.com"}},
+				{IP: net.IP{192, 168, 1, 8}, Names: []string{"example.com"}},
+				{IP: net.ParseIP("fc01:dead::beef"), Names: []string{"example.com"}},
+				{IP: net.IP{10, 1, 2, 3}, Names: []string{"example.org"}},
+				{IP: net.IP{192, 168, 1, 2}, Names: []string{"example.net"}},
+			},
+			Comment: "test102 NRB",
+		}
+		if !reflect.DeepEqual(got[0], first) {
+			t.Errorf("[%s] name resolution mismatch:\ngot:\n%#v\nwant:\n%#v\n\n", be, got[0], first)
+		}
+	}
+}
+
+func TestNgReadHugeBlockLength(t *testing.T) {
+	var start bytes.Buffer
+	w, err := NewNgWriter(&start, layers.LinkTypeEthernet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range [][]byte{
+		// custom block with a handler
+		{0xad, 0x0b, 0x00, 0x00, 0xf0, 0xff, 0xff, 0xff, 0xd9, 0x7e, 0x00, 0x00, 1, 2, 3, 4},
+		// custom block without a handler
+		{0xad, 0x0b, 0x00, 0x00, 0xf0, 0xff, 0xff, 0xff, 0x74, 0x8f, 0x00, 0x00, 1, 2, 3, 4},
+		// unknown block
+		{0x34, 0x12, 0x00, 0x00, 0xf0, 0xff, 0xff, 0xff, 1, 2, 3, 4},
+		// decryption secrets
+		{0x0a, 0x00, 0x00, 0x00, 0xf0, 0xff, 0xff, 0xff, 0x4b, 0x53, 0x4c, 0x54, 0x00, 0xff, 0xff, 0xff, 1, 2, 3, 4},
+	} {
+		options := DefaultNgReaderOptions
+		options.CustomBlockHandlers = map[uint32]func(NgCustomBlock){32473: func(NgCustomBlock) {}}
+		options.UnknownBlockCallback = func(NgRawBlock) {}
+		options.DecryptionSecretsCallback = func(NgDecryptionSecrets) {}
+		r, err := NewNgReader(bytes.NewReader(append(start.Bytes(), block...)), options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if _, _, err := r.ReadPacketData(); err == nil {
+			t.Errorf("%x: no error for a truncated block", block[:8])
+		}
+		runtime.ReadMemStats(&after)
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+			t.Errorf("%x: allocated %d bytes for a truncated block", block[:8], n)
+		}
+	}
+}
+
+func TestNgReadCustomBlocks(t *testing.T) {
+	for _, be := range []string{"be", "le"} {
+		f, err := os.Open(filepath.Join("tests", be, "test018.pcapng"))
+		if err != nil {
+			t.Fatal("Couldn't open file:", err)
+		}
+		defer f.Close()
+
+		var custom []NgCustomBlock
+		var raw []NgRawBlock
+		options := DefaultNgReaderOptions
+		options.CustomBlockHandlers = map[uint32]func(NgCustomBlock){
+			32473: func(block NgCustomBlock) { custom = append(custom, block) },
+		}
+		options.UnknownBlockCallback = func(block NgRawBlock) { raw = append(raw, block) }
+		r, err := NewNgReader(f, options)
+		if err != nil {
+			t.Fatal("Couldn't read start of file:", err)
+		}
+		for {
+			if _, _, err := r.ReadPacketData(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("[%s] Unexpected error: %s", be, err)
+			}
+		}
+
+		if len(custom) != 2 {
+			t.Fatalf("[%s] got %d custom blocks, want 2", be, len(custom))
+		}
+		want := NgCustomBlock{PEN: 32473, Data: []byte("an example Custom Block\x00"), BigEndian: be == "be"}
+		if !reflect.DeepEqual(custom[0], want) {
+			t.Errorf("[%s] custom block mismatch:\ngot:\n%#v\nwant:\n%#v\n\n", be, custom[0], want)
+		}
+		if !custom[1].NoCopy || !bytes.HasPrefix(custom[1].Data, []byte("an example Custom Block not to be copied")) {
+			t.Errorf("[%s] wrong second custom block %#v", be, custom[1])
+		}
+
+		// Blocks of PEN 36724 have no handler.
+		if len(raw) != 2 {
+			t.Fatalf("[%s] got %d raw blocks, want 2", be, len(raw))
+		}
+		pen := []byte{0x74, 0x8f, 0, 0}
+		if be == "be" {
+			pen = []byte{0, 0, 0x8f, 0x74}
+		}
+		wantRaw := NgRawBlock{
+			Type:      0x40000bad,
+			Body:      append(pen, "all your block are belong to us\x00"...),
+			BigEndian: be == "be",
+		}
+		if !reflect.DeepEqual(raw[1], wantRaw) {
+			t.Errorf("[%s] raw block mismatch:\ngot:\n%#v\nwant:\n%#v\n\n", be, raw[1], wantRaw)
+		}
+	}
+}
+
 type endlessNgPacketReader struct {
 	packet []byte
 }
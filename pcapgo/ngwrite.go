@@ -11,6 +11,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"net"
 	"runtime"
 	"time"
 
@@ -352,8 +353,232 @@ func (w *NgWriter) WriteInterfaceStats(intf int, stats NgInterfaceStatistics) er
 	return err
 }
 
+// WriteNameResolution writes the given name resolution records to the file. Addresses that can be represented in 4 bytes are written as IPv4 records. Empty values are not written.
+func (w *NgWriter) WriteNameResolution(nrb NgNameResolution) error {
+	addrs := make([]net.IP, len(nrb.Records))
+	records := uint32(4) // end of records
+	for i, record := range nrb.Records {
+		if addrs[i] = record.IP.To4(); addrs[i] == nil {
+			if addrs[i] = record.IP.To16(); addrs[i] == nil {
+				return fmt.Errorf("Invalid address %v in name resolution record", record.IP)
+			}
+		}
+		length := len(addrs[i])
+		for _, name := range record.Names {
+			length += len(name) + 1
+		}
+		if length > 0xffff {
+			return fmt.Errorf("Name resolution record for %v too long", record.IP)
+		}
+		records += uint32(length) + (4-uint32(length)&3)&3 + 4
+	}
+
+	var scratch [4]ngOption
+	i := 0
+	if nrb.Comment != "" {
+		scratch[i].code = ngOptionCodeComment
+		scratch[i].raw = nrb.Comment
+		i++
+	}
+	if nrb.DNSName != "" {
+		scratch[i].code = ngOptionCodeNameResolutionDNSName
+		scratch[i].raw = nrb.DNSName
+		i++
+	}
+	if ip := nrb.DNSIPv4Address.To4(); ip != nil {
+		scratch[i].code = ngOptionCodeNameResolutionDNSIPv4Address
+		scratch[i].raw = []byte(ip)
+		i++
+	}
+	if ip := nrb.DNSIPv6Address.To16(); ip != nil {
+		scratch[i].code = ngOptionCodeNameResolutionDNSIPv6Address
+		scratch[i].raw = []byte(ip)
+		i++
+	}
+	options := scratch[:i]
+
+	length := prepareNgOptions(options) + records +
+		8 + // header
+		4 // trailer
+
+	binary.LittleEndian.PutUint32(w.buf[:4], uint32(ngBlockTypeNameResolution))
+	binary.LittleEndian.PutUint32(w.buf[4:8], length)
+	if _, err := w.w.Write(w.buf[:8]); err != nil {
+		return err
+	}
+
+	var zero [4]byte
+	for i, record := range nrb.Records {
+		typ := ngNameRecordIPv4
+		if len(addrs[i]) == net.IPv6len {
+			typ = ngNameRecordIPv6
+		}
+		value := append([]byte(nil), addrs[i]...)
+		for _, name := range record.Names {
+			value = append(append(value, name...), 0)
+		}
+		binary.LittleEndian.PutUint16(w.buf[0:2], uint16(typ))
+		binary.LittleEndian.PutUint16(w.buf[2:4], uint16(len(value)))
+		if _, err := w.w.Write(w.buf[:4]); err != nil {
+			return err
+		}
+		if _, err := w.w.Write(value); err != nil {
+			return err
+		}
+		if _, err := w.w.Write(zero[:(4-len(value)&3)&3]); err != nil {
+			return err
+		}
+	}
+	// records must be followed by an end of records record
+	if _, err := w.w.Write(zero[:]); err != nil {
+		return err
+	}
+
+	if err := w.writeOptions(options); err != nil {
+		return err
+	}
+
+	binary.LittleEndian.PutUint32(w.buf[0:4], length)
+	_, err := w.w.Write(w.buf[:4])
+	return err
+}
+
+// WriteDecryptionSecrets writes the given decryption secrets to the file. They should be written before the packets they decrypt. Empty values are not written.
+func (w *NgWriter) WriteDecryptionSecrets(dsb NgDecryptionSecrets) error {
+	var scratch [1]ngOption
+	i := 0
+	if dsb.Comment != "" {
+		scratch[i].code = ngOptionCodeComment
+		scratch[i].raw = dsb.Comment
+		i++
+	}
+	options := scratch[:i]
+
+	padding := (4 - uint32(len(dsb.Data))&3) & 3
+	length := prepareNgOptions(options) + uint32(len(dsb.Data)) + padding +
+		16 + // header
+		4 // trailer
+
+	binary.LittleEndian.PutUint32(w.buf[:4], uint32(ngBlockTypeDecryptionSecrets))
+	binary.LittleEndian.PutUint32(w.buf[4:8], length)
+	binary.LittleEndian.PutUint32(w.buf[8:12], uint32(dsb.Type))
+	binary.LittleEndian.PutUint32(w.buf[12:16], uint32(len(dsb.Data)))
+	if _, err := w.w.Write(w.buf[:16]); err != nil {
+		return err
+	}
+
+	if _, err := w.w.Write(dsb.Data); err != nil {
+		return err
+	}
+	var zero [4]byte
+	if _, err := w.w.Write(zero[:padding]); err != nil {
+		return err
+	}
+
+	if err := w.writeOptions(options); err != nil {
+		return err
+	}
+
+	binary.LittleEndian.PutUint32(w.buf[0:4], length)
+	_, err := w.w.Write(w.buf[:4])
+	return err
+}
+
+// WriteCustomBlock writes the given custom block to the file. Data is padded to a multiple of 4 octets.
+// Since the byte order of custom data can't be converted, blocks read from big endian sections can't be written.
+func (w *NgWriter) WriteCustomBlock(block NgCustomBlock) error {
+	if block.BigEndian {
+		return fmt.Errorf("Can't convert custom block of PEN %d to little endian", block.PEN)
+	}
+	typ := ngBlockTypeCustom
+	if block.NoCopy {
+		typ = ngBlockTypeCustomNoCopy
+	}
+	binary.LittleEndian.PutUint32(w.buf[:4], block.PEN)
+	return w.writeBlock(typ, w.buf[:4], block.Data)
+}
+
+// WriteRawBlock writes the given block unchanged to the file, for example one read with NgReaderOptions.UnknownBlockCallback. Body is padded to a multiple of 4 octets.
+// Since the byte order of unknown blocks can't be converted, blocks read from big endian sections can't be written. Section headers and interface descriptions must be written with the other methods of NgWriter.
+func (w *NgWriter) WriteRawBlock(block NgRawBlock) error {
+	if block.BigEndian {
+		return fmt.Errorf("Can't convert block of type %#x to little endian", block.Type)
+	}
+	switch ngBlockType(block.Type) {
+	case ngBlockTypeSectionHeader, ngBlockTypeInterfaceDescriptor:
+		return fmt.Errorf("Can't write block of type %#x as raw block", block.Type)
+	}
+	return w.writeBlock(ngBlockType(block.Type), nil, block.Body)
+}
+
+// writeBlock writes a block with the given type, whose body consists of header and data. The body is padded to a multiple of 4 octets.
+func (w *NgWriter) writeBlock(typ ngBlockType, header, data []byte) error {
+	body := uint32(len(header) + len(data))
+	padding := (4 - body&3) & 3
+	length := body + padding + 12
+	if int(length) != len(header)+len(data)+int(padding)+12 {
+		return fmt.Errorf("Block of type %#x too long: %d bytes", typ, len(header)+len(data))
+	}
+
+	var scratch [8]byte
+	binary.LittleEndian.PutUint32(scratch[:4], uint32(typ))
+	binary.LittleEndian.PutUint32(scratch[4:8], length)
+	if _, err := w.w.Write(scratch[:8]); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(data); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(scratch[:4], 0)
+	_, err := w.w.Write(scratch[4-padding : 8]) // padding + length
+	return err
+}
+
 // WritePacket writes out packet with the given data and capture info. The given InterfaceIndex must already be added to the file. InterfaceIndex 0 is automatically added by the NewWriter* methods.
 func (w *NgWriter) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
+	return w.writePacket(ci, data, nil)
+}
+
+// WritePacketWithOptions writes out packet with the given data, capture info and options. Empty values are not written, so options should be based on DefaultNgPacketOptions. Otherwise see WritePacket.
+func (w *NgWriter) WritePacketWithOptions(ci gopacket.CaptureInfo, data []byte, options NgPacketOptions) error {
+	var scratch [4]ngOption
+	opts := scratch[:0]
+	for _, comment := range options.Comments {
+		opts = append(opts, ngOption{code: ngOptionCodeComment, raw: comment})
+	}
+	if options.Flags != 0 {
+		opts = append(opts, ngOption{code: ngOptionCodePacketFlags, raw: uint32(options.Flags)})
+	}
+	for _, hash := range options.Hashes {
+		value := append([]byte{byte(hash.Algorithm)}, hash.Value...)
+		opts = append(opts, ngOption{code: ngOptionCodePacketHash, raw: value})
+	}
+	if options.DropCount != NgNoValue64 {
+		opts = append(opts, ngOption{code: ngOptionCodePacketDropCount, raw: options.DropCount})
+	}
+	if options.PacketID != NgNoValue64 {
+		opts = append(opts, ngOption{code: ngOptionCodePacketID, raw: options.PacketID})
+	}
+	if options.Queue != NgNoValue32 {
+		opts = append(opts, ngOption{code: ngOptionCodePacketQueue, raw: options.Queue})
+	}
+	for _, verdict := range options.Verdicts {
+		value := append([]byte{byte(verdict.Type)}, verdict.Value...)
+		opts = append(opts, ngOption{code: ngOptionCodePacketVerdict, raw: value})
+	}
+	for _, opt := range opts {
+		if ngOptionLength(opt) > 0xffff {
+			return fmt.Errorf("packet option %d too long: %d bytes", opt.code, ngOptionLength(opt))
+		}
+	}
+	return w.writePacket(ci, data, opts)
+}
+
+// writePacket writes out an enhanced packet block with the given options.
+func (w *NgWriter) writePacket(ci gopacket.CaptureInfo, data []byte, options []ngOption) error {
 	if ci.InterfaceIndex >= int(w.intf) || ci.InterfaceIndex < 0 {
 		return fmt.Errorf("Can't send statistics for non existent interface %d; have only %d interfaces", ci.InterfaceIndex, w.intf)
 	}
@@ -366,7 +591,7 @@ func (w *NgWriter) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
 
 	length := uint32(len(data)) + 32
 	padding := (4 - length&3) & 3
-	length += padding
+	length += padding + prepareNgOptions(options)
 
 	ts := ci.Timestamp.UnixNano()
 
@@ -386,7 +611,19 @@ func (w *NgWriter) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
 		return err
 	}
 
+	if len(options) > 0 {
+		binary.LittleEndian.PutUint32(w.buf[:4], 0)
+		if _, err := w.w.Write(w.buf[:padding]); err != nil {
+			return err
+		}
+		if err := w.writeOptions(options); err != nil {
+			return err
+		}
+		padding = 0
+	}
+
 	binary.LittleEndian.PutUint32(w.buf[:4], 0)
+	binary.LittleEndian.PutUint32(w.buf[4:8], length)
 	_, err := w.w.Write(w.buf[4-padding : 8]) // padding + length
 	return err
 }
@@ -8,6 +8,8 @@ package pcapgo
 
 import (
 	"bytes"
+	"net"
+	"reflect"
 	"testing"
 	"time"
 
@@ -214,6 +216,199 @@ func TestNgWriteComplex(t *testing.T) {
 	ngRunFileReadTest(test, "", false, t)
 }
 
+func TestNgWriteNameResolutionAndSecrets(t *testing.T) {
+	dsb := NgDecryptionSecrets{
+		Type:    NgSecretsTLSKeyLog,
+		Data:    []byte("CLIENT_RANDOM 0123 4567\n"),
+		Comment: "tls keys",
+	}
+	nrb := NgNameResolution{
+		Records: []NgNameRecord{
+			{IP: net.IP{192, 168, 1, 2}, Names: []string{"example.com", "www.example.com"}},
+			{IP: net.ParseIP("fc01:dead::beef"), Names: []string{"example.net"}},
+		},
+		Comment:        "resolved names",
+		DNSName:        "ns.example.com",
+		DNSIPv4Address: net.IP{192, 168, 1, 1},
+		DNSIPv6Address: net.ParseIP("fc01:dead::1"),
+	}
+
+	buffer := &bytes.Buffer{}
+	w, err := NewNgWriter(buffer, layers.LinkTypeEthernet)
+	if err != nil {
+		t.Fatal("Opening file failed with: ", err)
+	}
+	if err := w.WriteDecryptionSecrets(dsb); err != nil {
+		t.Fatal("Couldn't write decryption secrets", err)
+	}
+	if err := w.WriteNameResolution(nrb); err != nil {
+		t.Fatal("Couldn't write name resolution", err)
+	}
+	ci := gopacket.CaptureInfo{
+		Timestamp:     time.Unix(0, 0).UTC(),
+		Length:        len(ngPacketSource[0]),
+		CaptureLength: len(ngPacketSource[0]),
+	}
+	if err := w.WritePacket(ci, ngPacketSource[0]); err != nil {
+		t.Fatal("Couldn't write packet", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal("Couldn't flush buffer", err)
+	}
+
+	var gotNRB []NgNameResolution
+	var gotDSB []NgDecryptionSecrets
+	options := DefaultNgReaderOptions
+	options.NameResolutionCallback = func(n NgNameResolution) { gotNRB = append(gotNRB, n) }
+	options.DecryptionSecretsCallback = func(d NgDecryptionSecrets) { gotDSB = append(gotDSB, d) }
+	r, err := NewNgReader(bytes.NewReader(buffer.Bytes()), options)
+	if err != nil {
+		t.Fatal("Couldn't read start of file:", err)
+	}
+	data, gotCI, err := r.ReadPacketData()
+	if err != nil {
+		t.Fatal("Couldn't read packet:", err)
+	}
+	if !bytes.Equal(data, ngPacketSource[0]) || !gotCI.Timestamp.Equal(ci.Timestamp) {
+		t.Errorf("packet mismatch: got %v %x", gotCI, data)
+	}
+	if !reflect.DeepEqual(gotDSB, []NgDecryptionSecrets{dsb}) {
+		t.Errorf("decryption secrets mismatch:\ngot:\n%#v\nwant:\n%#v", gotDSB, dsb)
+	}
+	// The reader returns IPv4 addresses in their 4 byte form.
+	if !reflect.DeepEqual(gotNRB, []NgNameResolution{nrb}) {
+		t.Errorf("name resolution mismatch:\ngot:\n%#v\nwant:\n%#v", gotNRB, nrb)
+	}
+
+	nrb.Records[0].IP = net.IP{1, 2, 3}
+	if err := w.WriteNameResolution(nrb); err == nil {
+		t.Error("Writing an invalid address succeeded")
+	}
+}
+
+func TestNgWritePacketOptions(t *testing.T) {
+	options := DefaultNgPacketOptions
+	options.Comments = []string{"triaged", "second look"}
+	options.Flags = NewNgPacketFlags(NgPacketDirectionOutbound, NgReceptionTypeMulticast, 4, NgLinkLayerErrorCRC)
+	options.Hashes = []NgPacketHash{{Algorithm: NgHashCRC32, Value: []byte{1, 2, 3, 4}}}
+	options.DropCount = 3
+	options.PacketID = 0xdeadbeef
+	options.Queue = 7
+	options.Verdicts = []NgPacketVerdict{{Type: NgVerdictLinuxXDP, Value: []byte{2, 0, 0, 0, 0, 0, 0, 0}}}
+
+	if f := options.Flags; f.Direction() != NgPacketDirectionOutbound || f.ReceptionType() != NgReceptionTypeMulticast ||
+		f.FCSLength() != 4 || f.LinkLayerErrors() != NgLinkLayerErrorCRC {
+		t.Fatalf("Flags %#x don't hold the packed values", uint32(f))
+	}
+
+	buffer := &bytes.Buffer{}
+	w, err := NewNgWriter(buffer, layers.LinkTypeEthernet)
+	if err != nil {
+		t.Fatal("Opening file failed with: ", err)
+	}
+	var cis []gopacket.CaptureInfo
+	for i, data := range [][]byte{ngPacketSource[4][:97], ngPacketSource[4][:98]} {
+		ci := gopacket.CaptureInfo{
+			Timestamp:     time.Unix(int64(i), 0).UTC(),
+			Length:        len(ngPacketSource[4]),
+			CaptureLength: len(data),
+		}
+		cis = append(cis, ci)
+		if i == 0 {
+			err = w.WritePacketWithOptions(ci, data, options)
+		} else {
+			err = w.WritePacket(ci, data)
+		}
+		if err != nil {
+			t.Fatal("Couldn't write packet", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal("Couldn't flush buffer", err)
+	}
+
+	interf := DefaultNgInterface
+	interf.LinkType = layers.LinkTypeEthernet
+	cis[0].AncillaryData = []interface{}{options}
+	cis[1].AncillaryData = []interface{}{DefaultNgPacketOptions}
+	test := ngFileReadTest{
+		testContents:      bytes.NewReader(buffer.Bytes()),
+		wantPacketOptions: true,
+		linkType:          layers.LinkTypeEthernet,
+		sections: []ngFileReadTestSection{
+			{
+				sectionInfo: DefaultNgWriterOptions.SectionInfo,
+				ifaces:      []NgInterface{interf},
+			},
+		},
+		packets: []ngFileReadTestPacket{
+			{data: ngPacketSource[4][:97], ci: cis[0]},
+			{data: ngPacketSource[4][:98], ci: cis[1]},
+		},
+	}
+	ngRunFileReadTest(test, "", false, t)
+}
+
+func TestNgWriteCustomBlocks(t *testing.T) {
+	custom := NgCustomBlock{PEN: 32473, Data: []byte("vendor data"), NoCopy: true}
+	raw := NgRawBlock{Type: 0x1234, Body: []byte{1, 2, 3, 4, 5, 6, 7, 8}}
+
+	buffer := &bytes.Buffer{}
+	w, err := NewNgWriter(buffer, layers.LinkTypeEthernet)
+	if err != nil {
+		t.Fatal("Opening file failed with: ", err)
+	}
+	if err := w.WriteCustomBlock(custom); err != nil {
+		t.Fatal("Couldn't write custom block", err)
+	}
+	if err := w.WriteRawBlock(raw); err != nil {
+		t.Fatal("Couldn't write raw block", err)
+	}
+	ci := gopacket.CaptureInfo{
+		Timestamp:     time.Unix(0, 0).UTC(),
+		Length:        len(ngPacketSource[0]),
+		CaptureLength: len(ngPacketSource[0]),
+	}
+	if err := w.WritePacket(ci, ngPacketSource[0]); err != nil {
+		t.Fatal("Couldn't write packet", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal("Couldn't flush buffer", err)
+	}
+	for _, block := range []NgRawBlock{{Type: 0x1234, BigEndian: true}, {Type: uint32(ngBlockTypeSectionHeader)}} {
+		if err := w.WriteRawBlock(block); err == nil {
+			t.Errorf("Writing raw block %#v succeeded", block)
+		}
+	}
+	if err := w.WriteCustomBlock(NgCustomBlock{PEN: 32473, BigEndian: true}); err == nil {
+		t.Error("Writing big endian custom block succeeded")
+	}
+
+	var gotCustom []NgCustomBlock
+	var gotRaw []NgRawBlock
+	options := DefaultNgReaderOptions
+	options.CustomBlockHandlers = map[uint32]func(NgCustomBlock){
+		32473: func(block NgCustomBlock) { gotCustom = append(gotCustom, block) },
+	}
+	options.UnknownBlockCallback = func(block NgRawBlock) { gotRaw = append(gotRaw, block) }
+	r, err := NewNgReader(bytes.NewReader(buffer.Bytes()), options)
+	if err != nil {
+		t.Fatal("Couldn't read start of file:", err)
+	}
+	if data, _, err := r.ReadPacketData(); err != nil || !bytes.Equal(data, ngPacketSource[0]) {
+		t.Fatalf("Couldn't read packet: %v", err)
+	}
+
+	// Custom data is padded.
+	custom.Data = append(custom.Data, 0)
+	if !reflect.DeepEqual(gotCustom, []NgCustomBlock{custom}) {
+		t.Errorf("custom block mismatch:\ngot:\n%#v\nwant:\n%#v", gotCustom, custom)
+	}
+	if !reflect.DeepEqual(gotRaw, []NgRawBlock{raw}) {
+		t.Errorf("raw block mismatch:\ngot:\n%#v\nwant:\n%#v", gotRaw, raw)
+	}
+}
+
 type ngDevNull struct{}
 
 func (w *ngDevNull) Write(p []byte) (n int, err error) {
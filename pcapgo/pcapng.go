@@ -9,6 +9,7 @@ package pcapgo
 import (
 	"errors"
 	"math"
+	"net"
 	"time"
 
 	"github.com/google/gopacket"
@@ -35,11 +36,23 @@ const (
 	ngBlockTypeInterfaceDescriptor ngBlockType = 1          // Interface description block
 	ngBlockTypePacket              ngBlockType = 2          // Packet block (deprecated)
 	ngBlockTypeSimplePacket        ngBlockType = 3          // Simple packet block
+	ngBlockTypeNameResolution      ngBlockType = 4          // Name resolution block
 	ngBlockTypeInterfaceStatistics ngBlockType = 5          // Interface statistics block
 	ngBlockTypeEnhancedPacket      ngBlockType = 6          // Enhanced packet block
+	ngBlockTypeDecryptionSecrets   ngBlockType = 0x0A       // Decryption secrets block
+	ngBlockTypeCustom              ngBlockType = 0x00000BAD // Custom block that may be copied to new files
+	ngBlockTypeCustomNoCopy        ngBlockType = 0x40000BAD // Custom block that must not be copied to new files
 	ngBlockTypeSectionHeader       ngBlockType = 0x0A0D0D0A // Section header block (same in both endians)
 )
 
+type ngNameRecordType uint16
+
+const (
+	ngNameRecordEnd  ngNameRecordType = iota // end of records
+	ngNameRecordIPv4                         // IPv4 address and names
+	ngNameRecordIPv6                         // IPv6 address and names
+)
+
 type ngOptionCode uint16
 
 const (
@@ -76,6 +89,21 @@ const (
 	ngOptionCodeInterfaceStatisticsDelivered                                 // Packets delivered to user
 )
 
+const (
+	ngOptionCodeNameResolutionDNSName        ngOptionCode = iota + 2 // name of the DNS server
+	ngOptionCodeNameResolutionDNSIPv4Address                         // IPv4 address of the DNS server
+	ngOptionCodeNameResolutionDNSIPv6Address                         // IPv6 address of the DNS server
+)
+
+const (
+	ngOptionCodePacketFlags     ngOptionCode = iota + 2 // direction, reception type, FCS length and link-layer errors
+	ngOptionCodePacketHash                              // hash of the packet
+	ngOptionCodePacketDropCount                         // packets lost between this packet and the preceding one
+	ngOptionCodePacketID                                // unique identifier of the packet
+	ngOptionCodePacketQueue                             // interface queue the packet was received on
+	ngOptionCodePacketVerdict                           // verdict of the packet
+)
+
 // ngOption is a pcapng option
 type ngOption struct {
 	code   ngOptionCode
@@ -120,6 +148,9 @@ func (r NgResolution) ToTimestampResolution() (ret gopacket.TimestampResolution)
 // NgNoValue64 is a placeholder for an empty numeric 64 bit value.
 const NgNoValue64 = math.MaxUint64
 
+// NgNoValue32 is a placeholder for an empty numeric 32 bit value.
+const NgNoValue32 = math.MaxUint32
+
 // NgInterfaceStatistics hold the statistic for an interface at a single point in time. These values are already supposed to be accumulated. Most pcapng files contain this information at the end of the file/section.
 type NgInterfaceStatistics struct {
 	// LastUpdate is the last time the statistics were updated.
@@ -185,3 +216,215 @@ type NgSectionInfo struct {
 	// Comment can be an arbitrary comment. This value might be empty if this option is missing.
 	Comment string
 }
+
+// NgNameRecord maps an address to the names it resolves to.
+type NgNameRecord struct {
+	// IP is the IPv4 or IPv6 address.
+	IP net.IP
+	// Names are the host names of the address.
+	Names []string
+}
+
+// NgNameResolution holds the contents of a pcapng name resolution block.
+type NgNameResolution struct {
+	// Records are the name resolution records of the block. Records of unknown types are skipped when reading.
+	Records []NgNameRecord
+	// Comment can be an arbitrary comment. This value might be empty if this option is missing.
+	Comment string
+	// DNSName is the name of the DNS server used for the resolution. This value might be empty if this option is missing.
+	DNSName string
+	// DNSIPv4Address is the IPv4 address of the DNS server. This value might be nil if this option is missing.
+	DNSIPv4Address net.IP
+	// DNSIPv6Address is the IPv6 address of the DNS server. This value might be nil if this option is missing.
+	DNSIPv6Address net.IP
+}
+
+// NgSecretsType is the type of the secrets in a pcapng decryption secrets block.
+type NgSecretsType uint32
+
+const (
+	// NgSecretsTLSKeyLog are TLS secrets in the NSS key log format.
+	NgSecretsTLSKeyLog NgSecretsType = 0x544c534b
+	// NgSecretsWireGuardKeyLog are WireGuard keys in the format of Wireshark's wg.keylog_file.
+	NgSecretsWireGuardKeyLog NgSecretsType = 0x57474b4c
+	// NgSecretsZigBeeNWKKey are ZigBee network keys.
+	NgSecretsZigBeeNWKKey NgSecretsType = 0x5a4e574b
+	// NgSecretsZigBeeAPSKey are ZigBee application support keys.
+	NgSecretsZigBeeAPSKey NgSecretsType = 0x5a415053
+	// NgSecretsSSHKeyLog are SSH secrets in the format of Wireshark's ssh.keylog_file.
+	NgSecretsSSHKeyLog NgSecretsType = 0x5353484b
+)
+
+// NgDecryptionSecrets holds the contents of a pcapng decryption secrets block.
+type NgDecryptionSecrets struct {
+	// Type is the format of Data.
+	Type NgSecretsType
+	// Data are the secrets, like the lines of a TLS key log file.
+	Data []byte
+	// Comment can be an arbitrary comment. This value might be empty if this option is missing.
+	Comment string
+}
+
+// NgPacketDirection is the direction of a packet, as stored in NgPacketFlags.
+type NgPacketDirection uint8
+
+const (
+	// NgPacketDirectionUnknown is used if the direction is not available.
+	NgPacketDirectionUnknown NgPacketDirection = iota
+	// NgPacketDirectionInbound is used for received packets.
+	NgPacketDirectionInbound
+	// NgPacketDirectionOutbound is used for sent packets.
+	NgPacketDirectionOutbound
+)
+
+// NgReceptionType is the reception type of a packet, as stored in NgPacketFlags.
+type NgReceptionType uint8
+
+const (
+	// NgReceptionTypeUnspecified is used if the reception type is not available.
+	NgReceptionTypeUnspecified NgReceptionType = iota
+	// NgReceptionTypeUnicast is used for packets sent to the capturing host.
+	NgReceptionTypeUnicast
+	// NgReceptionTypeMulticast is used for multicast packets.
+	NgReceptionTypeMulticast
+	// NgReceptionTypeBroadcast is used for broadcast packets.
+	NgReceptionTypeBroadcast
+	// NgReceptionTypePromiscuous is used for packets received only because the interface is in promiscuous mode.
+	NgReceptionTypePromiscuous
+)
+
+// NgLinkLayerErrors is a bit mask of the link-layer errors of a packet, as stored in the upper 16 bits of NgPacketFlags.
+type NgLinkLayerErrors uint16
+
+// Link-layer errors, from the bits 24 to 31 of NgPacketFlags.
+const (
+	NgLinkLayerErrorCRC                 NgLinkLayerErrors = 1 << (iota + 8) // CRC error
+	NgLinkLayerErrorPacketTooLong                                           // packet too long
+	NgLinkLayerErrorPacketTooShort                                          // packet too short
+	NgLinkLayerErrorInterFrameGap                                           // wrong inter-frame gap
+	NgLinkLayerErrorUnalignedFrame                                          // unaligned frame
+	NgLinkLayerErrorStartFrameDelimiter                                     // start frame delimiter error
+	NgLinkLayerErrorPreamble                                                // preamble error
+	NgLinkLayerErrorSymbol                                                  // symbol error
+)
+
+// NgPacketFlags holds the link-layer information of a packet (the epb_flags option).
+type NgPacketFlags uint32
+
+// NewNgPacketFlags packs the given values into NgPacketFlags. fcsLength is the length of the frame check sequence in octets and must be less than 16.
+func NewNgPacketFlags(direction NgPacketDirection, reception NgReceptionType, fcsLength int, linkErrors NgLinkLayerErrors) NgPacketFlags {
+	return NgPacketFlags(uint32(direction)&0x3 | uint32(reception)&0x7<<2 | uint32(fcsLength)&0xf<<5 | uint32(linkErrors)<<16)
+}
+
+// Direction returns the direction of the packet.
+func (f NgPacketFlags) Direction() NgPacketDirection {
+	return NgPacketDirection(f & 0x3)
+}
+
+// ReceptionType returns the reception type of the packet.
+func (f NgPacketFlags) ReceptionType() NgReceptionType {
+	return NgReceptionType(f >> 2 & 0x7)
+}
+
+// FCSLength returns the length of the frame check sequence of the packet in octets, or 0 if it is not available.
+func (f NgPacketFlags) FCSLength() int {
+	return int(f >> 5 & 0xf)
+}
+
+// LinkLayerErrors returns the link-layer errors of the packet.
+func (f NgPacketFlags) LinkLayerErrors() NgLinkLayerErrors {
+	return NgLinkLayerErrors(f >> 16)
+}
+
+// NgHashAlgorithm is the algorithm of a packet hash.
+type NgHashAlgorithm uint8
+
+const (
+	// NgHashTwosComplement is the 2's complement of the packet, as sent by some capture hardware.
+	NgHashTwosComplement NgHashAlgorithm = iota
+	// NgHashXOR is the XOR of the packet.
+	NgHashXOR
+	// NgHashCRC32 is the CRC32 of the packet.
+	NgHashCRC32
+	// NgHashMD5 is the MD5 digest of the packet.
+	NgHashMD5
+	// NgHashSHA1 is the SHA-1 digest of the packet.
+	NgHashSHA1
+	// NgHashToeplitz is the Toeplitz hash of the packet, as computed by network cards for receive side scaling.
+	NgHashToeplitz
+)
+
+// NgPacketHash is a hash of a packet (the epb_hash option).
+type NgPacketHash struct {
+	// Algorithm is the algorithm used to compute Value.
+	Algorithm NgHashAlgorithm
+	// Value is the hash of the packet.
+	Value []byte
+}
+
+// NgVerdictType is the source of a packet verdict.
+type NgVerdictType uint8
+
+const (
+	// NgVerdictHardware is a verdict of the capture hardware, in a format specific to it.
+	NgVerdictHardware NgVerdictType = iota
+	// NgVerdictLinuxTC is the return code of a Linux traffic control eBPF program.
+	NgVerdictLinuxTC
+	// NgVerdictLinuxXDP is the return code of a Linux XDP eBPF program.
+	NgVerdictLinuxXDP
+)
+
+// NgPacketVerdict is the verdict of a packet (the epb_verdict option), like the return code of the eBPF program that handled it.
+type NgPacketVerdict struct {
+	// Type is the source of the verdict.
+	Type NgVerdictType
+	// Value is the verdict, whose format depends on Type.
+	Value []byte
+}
+
+// NgPacketOptions holds the options of a pcapng enhanced packet block.
+type NgPacketOptions struct {
+	// Comments are arbitrary comments. This value might be nil if this option is missing.
+	Comments []string
+	// Flags holds the link-layer information of the packet. This value is 0 if this option is missing.
+	Flags NgPacketFlags
+	// Hashes are hashes of the packet. This value might be nil if this option is missing.
+	Hashes []NgPacketHash
+	// DropCount is the number of packets lost between this packet and the preceding one. This value might be NgNoValue64 if this option is missing.
+	DropCount uint64
+	// PacketID uniquely identifies the packet, even if it was captured on several interfaces. This value might be NgNoValue64 if this option is missing.
+	PacketID uint64
+	// Queue is the interface queue the packet was received on. This value might be NgNoValue32 if this option is missing.
+	Queue uint32
+	// Verdicts are the verdicts of the packet. This value might be nil if this option is missing.
+	Verdicts []NgPacketVerdict
+}
+
+// DefaultNgPacketOptions contains packet options without any values set. Use it as a starting point for NgWriter.WritePacketWithOptions.
+var DefaultNgPacketOptions = NgPacketOptions{
+	DropCount: NgNoValue64,
+	PacketID:  NgNoValue64,
+	Queue:     NgNoValue32,
+}
+
+// NgCustomBlock holds the contents of a pcapng custom block.
+type NgCustomBlock struct {
+	// PEN is the IANA private enterprise number of the organization that defined the block.
+	PEN uint32
+	// Data is the content of the block after the PEN, including custom options and padding. It is not converted to a different byte order.
+	Data []byte
+	// NoCopy is true if the block depends on other blocks of the file and must not be copied to a new file by tools that don't understand it.
+	NoCopy bool
+	// BigEndian is true if the block was read from a big endian section. Data is in the byte order of the section.
+	BigEndian bool
+}
+
+// NgRawBlock is a pcapng block which the reader doesn't know.
+type NgRawBlock struct {
+	// Type is the block type.
+	Type uint32
+	// Body is the content of the block without the block type and the block lengths.
+	Body []byte
+	// BigEndian is true if the block was read from a big endian section. Body is in the byte order of the section.
+	BigEndian bool
+}
@@ -9,6 +9,9 @@ import (
 	"bytes"
 	"testing"
 	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 )
 
 // test header read
@@ -251,3 +254,67 @@ func TestPacketZeroCopy(t *testing.T) {
 		t.Error("different buffers returned by subsequent ZeroCopyReadPacketData calls")
 	}
 }
+
+func TestLinuxSLL2Pcap(t *testing.T) {
+	sll := []byte{
+		0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, // protocol, reserved, interface
+		0x00, 0x01, 0x04, 0x06, 0x00, 0x0c, 0x29, 0x3a, // ARPHRD, packet type, address
+		0x8c, 0x5e, 0x00, 0x00,
+		0x45, 0x00, 0x00, 0x14, 0x00, 0x00, 0x00, 0x00, // IPv4
+		0x40, 0x3b, 0x00, 0x00, 0xc0, 0xa8, 0x01, 0x02,
+		0xc0, 0xa8, 0x01, 0x01,
+	}
+	test := []byte{
+		0xd4, 0xc3, 0xb2, 0xa1, 0x02, 0x00, 0x04, 0x00, // magic, maj, min
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // tz, sigfigs
+		0xff, 0xff, 0x00, 0x00, 0x14, 0x01, 0x00, 0x00, // snaplen, linkType
+		0x5A, 0xCC, 0x1A, 0x54, 0x01, 0x00, 0x00, 0x00, // sec, usec
+		0x28, 0x00, 0x00, 0x00, 0x28, 0x00, 0x00, 0x00, // cap len, full len
+	}
+	test = append(test, sll...)
+
+	r, err := NewReader(bytes.NewBuffer(test))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.LinkType() != layers.LinkTypeLinuxSLL2 {
+		t.Fatalf("link type %v, want %v", r.LinkType(), layers.LinkTypeLinuxSLL2)
+	}
+	p, err := gopacket.NewPacketSource(r, r.LinkType()).NextPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ErrorLayer() != nil {
+		t.Fatal("Failed to decode packet:", p.ErrorLayer().Error())
+	}
+	if l, ok := p.LinkLayer().(*layers.LinuxSLL2); !ok || l.InterfaceIndex != 2 || p.NetworkLayer() == nil {
+		t.Errorf("wrong layers %v", p.Layers())
+	}
+
+	// The same packet in a pcapng file.
+	buffer := &bytes.Buffer{}
+	w, err := NewNgWriter(buffer, layers.LinkTypeLinuxSLL2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket(gopacket.CaptureInfo{Timestamp: time.Unix(0, 0), CaptureLength: len(sll), Length: len(sll)}, sll); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	ng, err := NewNgReader(buffer, DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ng.LinkType() != layers.LinkTypeLinuxSLL2 {
+		t.Fatalf("pcapng link type %v, want %v", ng.LinkType(), layers.LinkTypeLinuxSLL2)
+	}
+	p, err = gopacket.NewPacketSource(ng, ng.LinkType()).NextPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l, ok := p.LinkLayer().(*layers.LinuxSLL2); !ok || l.InterfaceIndex != 2 || p.NetworkLayer() == nil {
+		t.Errorf("wrong pcapng layers %v", p.Layers())
+	}
+}
@@ -0,0 +1,411 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package pcapgo
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// RotatingWriterOptions holds options for a RotatingWriter. Files are rotated as soon as one of the limits is reached. Without limits, all packets are
+// written to a single file.
+type RotatingWriterOptions struct {
+	// FileSize is the size in bytes after which a new file is started, like tcpdump -C. A file is rotated before the packet that would make it exceed
+	// FileSize, unless the file is empty. 0 means no limit.
+	FileSize int64
+	// Interval is the time after which a new file is started, like tcpdump -G. It is measured with the timestamps of the packets. 0 means no limit.
+	Interval time.Duration
+	// PacketCount is the number of packets after which a new file is started. 0 means no limit.
+	PacketCount int
+	// MaxFiles is the number of files to keep, like tcpdump -W. If it is reached, the oldest file is removed before a new one is started, making the
+	// files a ring buffer. 0 means no limit.
+	MaxFiles int
+	// Compress enables compressing closed files with gzip. The compressed file gets the name of the file with ".gz" appended, and the uncompressed
+	// file is removed. Files are compressed in the background, one at a time, so that writing packets isn't delayed. A file is rotated only once
+	// the previous one is compressed, though, and Close waits for the last one.
+	Compress bool
+	// PostRotate gets called with the name of every file after it was closed and compressed. It is called before old files are removed. With
+	// Compress, it is called by the first call to WritePacket, Rotate or Close made after the compression is done.
+	PostRotate func(name string)
+	// Nanoseconds enables writing pcap files with nanosecond timestamps, like NewWriterNanos. It has no effect on pcapng files.
+	Nanoseconds bool
+}
+
+// RotatingWriter writes packets to a series of pcap or pcapng files. The name of each file is built from a pattern, in which strftime-style
+// conversions are replaced with the timestamp of the first packet in the file. Supported conversions are %Y, %y, %m, %d, %H, %M, %S, %j, %b, %a,
+// %s (Unix time) and %% (a single %). If a name was already used by the writer, "." and a sequence number are appended to it.
+//
+// Files are created when their first packet is written. Close must be called to finish the last file.
+type RotatingWriter struct {
+	pattern string
+	options RotatingWriterOptions
+
+	// pcap
+	snaplen  uint32
+	linkType layers.LinkType
+	// pcapng
+	ng        bool
+	ifaces    []NgInterface
+	ngOptions NgWriterOptions
+
+	file   *os.File
+	bw     *bufio.Writer
+	cw     *rotatingCountingWriter
+	pw     *Writer
+	ngw    *NgWriter
+	name   string
+	start  time.Time
+	count  int
+	names  map[string]int
+	closed []string
+
+	compressing *rotatingCompression
+}
+
+// rotatingCompression is the compression of a closed file running in the background.
+type rotatingCompression struct {
+	name string
+	done chan error
+}
+
+// rotatingCountingWriter counts the bytes written to w.
+type rotatingCountingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *rotatingCountingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewRotatingWriter returns a writer for a series of pcap files with the given snap length and link type, whose names are built from pattern.
+func NewRotatingWriter(pattern string, snaplen uint32, linkType layers.LinkType, options RotatingWriterOptions) (*RotatingWriter, error) {
+	if pattern == "" {
+		return nil, errors.New("Empty file name pattern")
+	}
+	return &RotatingWriter{
+		pattern:  pattern,
+		options:  options,
+		snaplen:  snaplen,
+		linkType: linkType,
+		names:    make(map[string]int),
+	}, nil
+}
+
+// NewRotatingNgWriter returns a writer for a series of pcapng files, whose names are built from pattern. Each file starts with a section header with
+// the given options, followed by the given interface and all interfaces added with AddInterface.
+func NewRotatingNgWriter(pattern string, intf NgInterface, ngOptions NgWriterOptions, options RotatingWriterOptions) (*RotatingWriter, error) {
+	if pattern == "" {
+		return nil, errors.New("Empty file name pattern")
+	}
+	return &RotatingWriter{
+		pattern:   pattern,
+		options:   options,
+		ng:        true,
+		ifaces:    []NgInterface{intf},
+		ngOptions: ngOptions,
+		names:     make(map[string]int),
+	}, nil
+}
+
+// AddInterface adds the given interface to the current pcapng file and to all following ones, and returns its id. It fails for pcap files.
+func (r *RotatingWriter) AddInterface(intf NgInterface) (id int, err error) {
+	if !r.ng {
+		return 0, errors.New("Interfaces can only be added to pcapng files")
+	}
+	if r.ngw != nil {
+		if id, err = r.ngw.AddInterface(intf); err != nil {
+			return 0, err
+		}
+	}
+	r.ifaces = append(r.ifaces, intf)
+	return len(r.ifaces) - 1, nil
+}
+
+// Name returns the name of the current file, or an empty string if no file is open.
+func (r *RotatingWriter) Name() string {
+	return r.name
+}
+
+// size returns the number of bytes written to the current file, including those still buffered by the pcapng writer.
+func (r *RotatingWriter) size() int64 {
+	if r.ngw != nil {
+		return r.cw.n + int64(r.ngw.w.Buffered())
+	}
+	return r.cw.n
+}
+
+// WritePacket writes the given packet to the current file, after starting a new file if one of the limits is reached.
+func (r *RotatingWriter) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
+	if err := r.finishCompression(false); err != nil {
+		return err
+	}
+	ts := ci.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	if r.file != nil && r.count > 0 {
+		var packetSize int64
+		if r.ng {
+			packetSize = int64(32 + len(data) + (4-len(data)&3)&3)
+		} else {
+			packetSize = int64(16 + len(data))
+		}
+		if (r.options.FileSize > 0 && r.size()+packetSize > r.options.FileSize) ||
+			(r.options.Interval > 0 && ts.Sub(r.start) >= r.options.Interval) ||
+			(r.options.PacketCount > 0 && r.count >= r.options.PacketCount) {
+			if err := r.Rotate(); err != nil {
+				return err
+			}
+		}
+	}
+	if r.file == nil {
+		if err := r.open(ts); err != nil {
+			return err
+		}
+	}
+	var err error
+	if r.ng {
+		err = r.ngw.WritePacket(ci, data)
+	} else {
+		err = r.pw.WritePacket(ci, data)
+	}
+	if err != nil {
+		return err
+	}
+	r.count++
+	return nil
+}
+
+// open starts a new file for packets starting at the given time.
+func (r *RotatingWriter) open(start time.Time) error {
+	if r.options.MaxFiles > 0 {
+		for len(r.closed)+r.pending() >= r.options.MaxFiles {
+			if len(r.closed) == 0 {
+				if err := r.finishCompression(true); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := os.Remove(r.closed[0]); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			r.closed = r.closed[1:]
+		}
+	}
+
+	name := rotatingFileName(r.pattern, start)
+	if n, ok := r.names[name]; ok {
+		r.names[name] = n + 1
+		name += "." + strconv.Itoa(n+1)
+	} else {
+		r.names[name] = 0
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	r.file = f
+	r.bw = bufio.NewWriter(f)
+	r.cw = &rotatingCountingWriter{w: r.bw}
+	r.name = name
+	r.start = start
+	r.count = 0
+
+	if r.ng {
+		r.ngw, err = NewNgWriterInterface(r.cw, r.ifaces[0], r.ngOptions)
+		for _, intf := range r.ifaces[1:] {
+			if err != nil {
+				break
+			}
+			_, err = r.ngw.AddInterface(intf)
+		}
+	} else {
+		if r.options.Nanoseconds {
+			r.pw = NewWriterNanos(r.cw)
+		} else {
+			r.pw = NewWriter(r.cw)
+		}
+		err = r.pw.WriteFileHeader(r.snaplen, r.linkType)
+	}
+	if err != nil {
+		f.Close()
+		r.file = nil
+		r.name = ""
+		return err
+	}
+	return nil
+}
+
+// Rotate finishes the current file. The next packet is written to a new file.
+func (r *RotatingWriter) Rotate() error {
+	if r.file == nil {
+		return r.finishCompression(false)
+	}
+	f, name := r.file, r.name
+	r.file = nil
+	r.name = ""
+
+	var err error
+	if r.ngw != nil {
+		err = r.ngw.Flush()
+		r.ngw = nil
+	}
+	r.pw = nil
+	if err == nil {
+		err = r.bw.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if r.options.Compress {
+		err := r.finishCompression(true)
+		c := &rotatingCompression{name: name, done: make(chan error, 1)}
+		go func() {
+			c.done <- rotatingCompress(c.name)
+		}()
+		r.compressing = c
+		return err
+	}
+	r.closed = append(r.closed, name)
+	if r.options.PostRotate != nil {
+		r.options.PostRotate(name)
+	}
+	return nil
+}
+
+// pending returns the number of closed files still being compressed.
+func (r *RotatingWriter) pending() int {
+	if r.compressing != nil {
+		return 1
+	}
+	return 0
+}
+
+// finishCompression handles the end of the compression of the last closed file, waiting for it if wait is true. If the compression failed, the
+// uncompressed file is kept.
+func (r *RotatingWriter) finishCompression(wait bool) error {
+	c := r.compressing
+	if c == nil {
+		return nil
+	}
+	var err error
+	if wait {
+		err = <-c.done
+	} else {
+		select {
+		case err = <-c.done:
+		default:
+			return nil
+		}
+	}
+	r.compressing = nil
+	if err != nil {
+		r.closed = append(r.closed, c.name)
+		return err
+	}
+	name := c.name + ".gz"
+	r.closed = append(r.closed, name)
+	if r.options.PostRotate != nil {
+		r.options.PostRotate(name)
+	}
+	return nil
+}
+
+// Close finishes the current file, and waits for its compression.
+func (r *RotatingWriter) Close() error {
+	err := r.Rotate()
+	if cerr := r.finishCompression(true); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// rotatingCompress replaces the file with the given name with a gzip compressed copy, whose name has ".gz" appended.
+func rotatingCompress(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(name + ".gz")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	in.Close()
+	return os.Remove(name)
+}
+
+// rotatingFileName replaces the strftime-style conversions in pattern with the given time.
+func rotatingFileName(pattern string, t time.Time) string {
+	var b bytes.Buffer
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i+1 == len(pattern) {
+			b.WriteByte(pattern[i])
+			continue
+		}
+		i++
+		switch pattern[i] {
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case 'y':
+			fmt.Fprintf(&b, "%02d", t.Year()%100)
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'b':
+			b.WriteString(t.Month().String()[:3])
+		case 'a':
+			b.WriteString(t.Weekday().String()[:3])
+		case 's':
+			b.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(pattern[i])
+		}
+	}
+	return b.String()
+}
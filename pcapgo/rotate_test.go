@@ -0,0 +1,244 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package pcapgo
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestRotatingFileName(t *testing.T) {
+	ts := time.Date(2018, 2, 20, 12, 3, 4, 0, time.UTC)
+	for pattern, want := range map[string]string{
+		"cap-%Y%m%d-%H%M%S.pcap": "cap-20180220-120304.pcap",
+		"%y-%j-%b-%a":            "18-051-Feb-Tue",
+		"%s%%":                   "1519128184%",
+		"%q%":                    "%q%",
+	} {
+		if got := rotatingFileName(pattern, ts); got != want {
+			t.Errorf("%q expanded to %q, want %q", pattern, got, want)
+		}
+	}
+}
+
+// rotateTestPackets returns n packets one second apart.
+func rotateTestPackets(n int) (data [][]byte, ci []gopacket.CaptureInfo) {
+	for i := 0; i < n; i++ {
+		d := ngPacketSource[i%len(ngPacketSource)]
+		data = append(data, d)
+		ci = append(ci, gopacket.CaptureInfo{
+			Timestamp:     time.Date(2018, 2, 20, 12, 0, i, 0, time.UTC),
+			CaptureLength: len(d),
+			Length:        len(d),
+		})
+	}
+	return
+}
+
+func TestRotatingWriterPcap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcapgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var rotated []string
+	w, err := NewRotatingWriter(filepath.Join(dir, "cap-%H%M%S.pcap"), 65536, layers.LinkTypeEthernet, RotatingWriterOptions{
+		PacketCount: 2,
+		MaxFiles:    2,
+		PostRotate:  func(name string) { rotated = append(rotated, name) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, ci := rotateTestPackets(7)
+	for i := range data {
+		if err := w.WritePacket(ci[i], data[i]); err != nil {
+			t.Fatal("Couldn't write packet:", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var want []string
+	for _, name := range []string{"cap-120000.pcap", "cap-120002.pcap", "cap-120004.pcap", "cap-120006.pcap"} {
+		want = append(want, filepath.Join(dir, name))
+	}
+	if !reflect.DeepEqual(rotated, want) {
+		t.Errorf("Rotated files %v, want %v", rotated, want)
+	}
+	// The ring buffer keeps the last two files.
+	for i, name := range want {
+		_, err := os.Stat(name)
+		if exists := err == nil; exists != (i >= 2) {
+			t.Errorf("File %s exists: %v", name, exists)
+		}
+	}
+	f, err := os.Open(want[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotData, gotCI := indexReadAll(r, t)
+	if !reflect.DeepEqual(gotData, data[4:6]) || !reflect.DeepEqual(gotCI, ci[4:6]) {
+		t.Errorf("Packets mismatch:\ngot:\n%v\nwant:\n%v", gotCI, ci[4:6])
+	}
+}
+
+func TestRotatingWriterNg(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcapgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	intf := DefaultNgInterface
+	intf.LinkType = layers.LinkTypeEthernet
+	second := NgInterface{Name: "null0", LinkType: layers.LinkTypeNull, TimestampResolution: 9}
+	var rotated []string
+	w, err := NewRotatingNgWriter(filepath.Join(dir, "cap.pcapng"), intf, DefaultNgWriterOptions, RotatingWriterOptions{
+		// room for the headers and a single large packet
+		FileSize:   500,
+		Interval:   3 * time.Second,
+		Compress:   true,
+		PostRotate: func(name string) { rotated = append(rotated, name) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := w.AddInterface(second); err != nil || id != 1 {
+		t.Fatalf("Adding interface returned %d, %v", id, err)
+	}
+	data, ci := rotateTestPackets(6)
+	// small packets fill files by interval
+	for i := range data {
+		data[i] = data[i][:20]
+		ci[i].CaptureLength = 20
+	}
+	// a large one doesn't fit into a file with others
+	data[4] = ngPacketSource[0]
+	ci[4].CaptureLength = len(data[4])
+	ci[4].Length = len(data[4])
+	ci[5].InterfaceIndex = 1
+	for i := range data {
+		if err := w.WritePacket(ci[i], data[i]); err != nil {
+			t.Fatal("Couldn't write packet:", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(dir, "cap.pcapng")
+	want := []string{name + ".gz", name + ".1.gz", name + ".2.gz", name + ".3.gz"}
+	if !reflect.DeepEqual(rotated, want) {
+		t.Fatalf("Rotated files %v, want %v", rotated, want)
+	}
+	for i, packets := range [][]int{{0, 1, 2}, {3}, {4}, {5}} {
+		f, err := os.Open(want[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewNgReader(zr, NgReaderOptions{WantMixedLinkType: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range packets {
+			d, c, err := r.ReadPacketData()
+			if err != nil {
+				t.Fatalf("[%s] Couldn't read packet %d: %v", want[i], p, err)
+			}
+			c.AncillaryData = nil
+			if !reflect.DeepEqual(d, data[p]) || !reflect.DeepEqual(c, ci[p]) {
+				t.Errorf("[%s] Packet %d mismatch:\ngot:\n%v\nwant:\n%v", want[i], p, c, ci[p])
+			}
+		}
+		if _, _, err := r.ReadPacketData(); err != io.EOF {
+			t.Errorf("[%s] Got %v instead of the end of the file", want[i], err)
+		}
+		// Every file has both interfaces.
+		if intf, err := r.Interface(1); err != nil || intf.Name != "null0" {
+			t.Errorf("[%s] Second interface is %v, %v", want[i], intf, err)
+		}
+		if _, err := os.Stat(want[i][:len(want[i])-3]); !os.IsNotExist(err) {
+			t.Errorf("Uncompressed file %s wasn't removed", want[i])
+		}
+	}
+
+	if _, err := w.AddInterface(second); err != nil {
+		t.Error(err)
+	}
+	pw, err := NewRotatingWriter(name, 65536, layers.LinkTypeEthernet, RotatingWriterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pw.AddInterface(second); err == nil {
+		t.Error("Adding an interface to a pcap file succeeded")
+	}
+}
+
+func TestRotatingWriterCompressError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcapgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "cap.pcap")
+	// the compressed copy of the first file can't be created
+	if err := os.Mkdir(name+".gz", 0755); err != nil {
+		t.Fatal(err)
+	}
+	var rotated []string
+	w, err := NewRotatingWriter(name, 65536, layers.LinkTypeEthernet, RotatingWriterOptions{
+		PacketCount: 1,
+		Compress:    true,
+		PostRotate:  func(name string) { rotated = append(rotated, name) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, ci := rotateTestPackets(2)
+	for i := range data {
+		if err := w.WritePacket(ci[i], data[i]); err != nil {
+			t.Fatal("Couldn't write packet:", err)
+		}
+	}
+	if err := w.Close(); err == nil {
+		t.Error("Compressing into a directory succeeded")
+	}
+
+	// the first file is kept uncompressed, and still counts for MaxFiles
+	if want := []string{name, name + ".1.gz"}; !reflect.DeepEqual(w.closed, want) {
+		t.Errorf("Closed files %v, want %v", w.closed, want)
+	}
+	if want := []string{name + ".1.gz"}; !reflect.DeepEqual(rotated, want) {
+		t.Errorf("Rotated files %v, want %v", rotated, want)
+	}
+	if _, err := os.Stat(name); err != nil {
+		t.Error(err)
+	}
+}
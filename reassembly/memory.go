@@ -11,6 +11,7 @@ import (
 	"log"
 	"sync"
 	"time"
+	"unsafe"
 
 	"github.com/google/gopacket/layers"
 )
@@ -20,20 +21,24 @@ var memLog = flag.Bool("assembly_memuse_log", defaultDebug, "If true, the github
 /*
  * pageCache
  */
+
+// pageMemory is the memory used by a single page, in bytes.
+const pageMemory = int(unsafe.Sizeof(page{}))
+
 // pageCache is a concurrency-unsafe store of page objects we use to avoid
-// memory allocation as much as we can.
+// memory allocation as much as we can.  Unused pages are kept in a free list
+// for reuse, until they are released to the garbage collector by shrink or
+// trim.
 type pageCache struct {
-	pagePool     *sync.Pool
+	free         []*page
 	used         int
+	minFree      int // low-water mark of len(free) since the last shrink
+	released     int64
 	pageRequests int64
 }
 
 func newPageCache() *pageCache {
-	pc := &pageCache{
-		pagePool: &sync.Pool{
-			New: func() interface{} { return new(page) },
-		}}
-	return pc
+	return &pageCache{}
 }
 
 // next returns a clean, ready-to-use page object.
@@ -41,10 +46,19 @@ func (c *pageCache) next(ts time.Time) (p *page) {
 	if *memLog {
 		c.pageRequests++
 		if c.pageRequests&0xFFFF == 0 {
-			log.Println("PageCache:", c.pageRequests, "requested,", c.used, "used,")
+			log.Println("PageCache:", c.pageRequests, "requested,", c.used, "used,", len(c.free), "free")
 		}
 	}
-	p = c.pagePool.Get().(*page)
+	if n := len(c.free); n > 0 {
+		p, c.free[n-1] = c.free[n-1], nil
+		c.free = c.free[:n-1]
+		if len(c.free) < c.minFree {
+			c.minFree = len(c.free)
+		}
+	} else {
+		p = new(page)
+		c.minFree = 0
+	}
 	p.seen = ts
 	p.bytes = p.buf[:0]
 	c.used++
@@ -63,7 +77,69 @@ func (c *pageCache) replace(p *page) {
 	}
 	p.prev = nil
 	p.next = nil
-	c.pagePool.Put(p)
+	p.ac = nil
+	c.free = append(c.free, p)
+}
+
+// trim releases free pages so that at most max of them are kept.
+func (c *pageCache) trim(max int) {
+	if max < 0 {
+		max = 0
+	}
+	n := len(c.free) - max
+	if n <= 0 {
+		return
+	}
+	for i := max; i < len(c.free); i++ {
+		c.free[i] = nil
+	}
+	c.free = c.free[:max]
+	// Let the garbage collector reclaim the backing array as well
+	if cap(c.free) > 2*max+64 {
+		c.free = append([]*page(nil), c.free...)
+	}
+	if c.minFree > max {
+		c.minFree = max
+	}
+	c.released += int64(n)
+	if *memLog {
+		log.Println("PageCache: released", n, "pages,", c.used, "used,", len(c.free), "free")
+	}
+}
+
+// shrink releases the free pages which weren't needed since the previous
+// call to shrink.
+func (c *pageCache) shrink() {
+	c.trim(len(c.free) - c.minFree)
+	c.minFree = len(c.free)
+}
+
+// MemoryStats provides figures about the memory used by an Assembler to
+// buffer out-of-order data.
+type MemoryStats struct {
+	// PagesUsed is the number of pages holding buffered data.
+	PagesUsed int
+	// PagesFree is the number of unused pages kept for reuse.
+	PagesFree int
+	// PagesAllocated is the number of pages currently allocated, used or
+	// free.
+	PagesAllocated int
+	// BytesAllocated is the memory held by the allocated pages.
+	BytesAllocated int
+	// PagesReleased is the total number of pages released to the garbage
+	// collector.
+	PagesReleased int64
+}
+
+func (c *pageCache) stats() MemoryStats {
+	allocated := c.used + len(c.free)
+	return MemoryStats{
+		PagesUsed:      c.used,
+		PagesFree:      len(c.free),
+		PagesAllocated: allocated,
+		BytesAllocated: allocated * pageMemory,
+		PagesReleased:  c.released,
+	}
 }
 
 /*
@@ -0,0 +1,281 @@
+// Copyright 2012 Google, Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package reassembly
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// DefaultShardedAssemblerOptions provides default options for a sharded
+// assembler, used when the corresponding ShardedAssemblerOptions fields are
+// zero.
+var DefaultShardedAssemblerOptions = ShardedAssemblerOptions{
+	Shards:           runtime.NumCPU(),
+	QueueLength:      1024,
+	AssemblerOptions: DefaultAssemblerOptions,
+}
+
+// ShardedAssemblerOptions controls the behavior of a ShardedAssembler.
+type ShardedAssemblerOptions struct {
+	// Shards is the number of worker goroutines, each with its own
+	// Assembler and StreamPool.  If <= 0, runtime.NumCPU() is used.
+	Shards int
+	// QueueLength is the number of packets which can be queued for each
+	// shard before AssembleWithContext blocks.  If <= 0, 1024 is used.
+	QueueLength int
+	// AssemblerOptions are the options of the Assembler of each shard.
+	AssemblerOptions
+}
+
+// ShardedAssembler reassembles TCP streams with several Assemblers running
+// concurrently, each one in its own goroutine with its own StreamPool.
+//
+// Packets are dispatched to shards with a hash of their network and transport
+// flows, which is the same for both directions of a connection, so each
+// connection is always handled by the same shard.  The only locking is done
+// by the queues of the shards, and StreamPool locks are never contended.
+//
+// The StreamFactory is shared by all shards, so its New method may be called
+// concurrently.  Streams themselves are only ever called from the goroutine of
+// their shard.
+//
+// Packets are assembled asynchronously.  The TCP layer passed to
+// AssembleWithContext is copied along with its contents, payload and
+// options, so the packet buffer can be reused, like with
+// DecodingLayerParser or ZeroCopyReadPacketData.  The AssemblerContext is
+// not copied: IT MUST NOT BE REUSED OR MODIFIED until the packet is
+// assembled, which is only known after a Flush* or Stats call returns.
+type ShardedAssembler struct {
+	shards []*shard
+	wg     sync.WaitGroup
+}
+
+type shard struct {
+	assembler *Assembler
+	pool      *StreamPool
+	queue     chan shardRequest
+	packets   int64
+}
+
+// shardRequest is either a packet to assemble or a function to run in the
+// shard goroutine.
+type shardRequest struct {
+	netFlow gopacket.Flow
+	tcp     layers.TCP
+	ac      AssemblerContext
+	run     func(*shard)
+}
+
+// NewShardedAssembler creates a ShardedAssembler and starts its shards.
+// Streams will be created as necessary using the passed-in StreamFactory.
+func NewShardedAssembler(factory StreamFactory, opts ShardedAssemblerOptions) *ShardedAssembler {
+	if opts.Shards <= 0 {
+		opts.Shards = DefaultShardedAssemblerOptions.Shards
+	}
+	if opts.QueueLength <= 0 {
+		opts.QueueLength = DefaultShardedAssemblerOptions.QueueLength
+	}
+	sa := &ShardedAssembler{shards: make([]*shard, opts.Shards)}
+	for i := range sa.shards {
+		pool := NewStreamPool(factory)
+		a := NewAssembler(pool)
+		a.AssemblerOptions = opts.AssemblerOptions
+		s := &shard{
+			assembler: a,
+			pool:      pool,
+			queue:     make(chan shardRequest, opts.QueueLength),
+		}
+		sa.shards[i] = s
+		sa.wg.Add(1)
+		go s.loop(&sa.wg)
+	}
+	return sa
+}
+
+func (s *shard) loop(wg *sync.WaitGroup) {
+	defer wg.Done()
+	for req := range s.queue {
+		if req.run != nil {
+			req.run(s)
+			continue
+		}
+		s.packets++
+		s.assembler.AssembleWithContext(req.netFlow, &req.tcp, req.ac)
+	}
+}
+
+// Shards returns the number of shards.
+func (sa *ShardedAssembler) Shards() int {
+	return len(sa.shards)
+}
+
+// shardFor returns the shard handling the connection of a packet.
+func (sa *ShardedAssembler) shardFor(netFlow gopacket.Flow, t *layers.TCP) *shard {
+	// Both FastHash are symmetric, but their low bits are poorly mixed, so
+	// mix the higher bits in before using a modulo.
+	h := netFlow.FastHash()*31 + t.TransportFlow().FastHash()
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	return sa.shards[h%uint64(len(sa.shards))]
+}
+
+// Assemble calls AssembleWithContext with the current timestamp, useful for
+// packets being read directly off the wire.
+func (sa *ShardedAssembler) Assemble(netFlow gopacket.Flow, t *layers.TCP) {
+	ctx := assemblerSimpleContext(gopacket.CaptureInfo{Timestamp: time.Now()})
+	sa.AssembleWithContext(netFlow, t, &ctx)
+}
+
+// AssembleWithContext queues the given TCP packet to the shard handling its
+// connection, which will reassemble it like Assembler.AssembleWithContext.
+// It blocks if the queue of the shard is full.
+//
+// It must not be called concurrently with itself, as this would lose the
+// packet ordering, but the Flush* and Stats methods can be called from other
+// goroutines.
+func (sa *ShardedAssembler) AssembleWithContext(netFlow gopacket.Flow, t *layers.TCP, ac AssemblerContext) {
+	sa.shardFor(netFlow, t).queue <- shardRequest{netFlow: netFlow, tcp: copyTCP(t), ac: ac}
+}
+
+// copyTCP returns a copy of t which doesn't share memory with the packet
+// buffer.
+func copyTCP(t *layers.TCP) layers.TCP {
+	c := *t
+	buf := make([]byte, 0, len(t.Contents)+len(t.Payload))
+	buf = append(buf, t.Contents...)
+	buf = append(buf, t.Payload...)
+	c.Contents = buf[:len(t.Contents):len(t.Contents)]
+	c.Payload = buf[len(t.Contents):]
+	if t.Options != nil {
+		c.Options = make([]layers.TCPOption, len(t.Options))
+		for i, o := range t.Options {
+			o.OptionData = append([]byte(nil), o.OptionData...)
+			c.Options[i] = o
+		}
+	}
+	c.Padding = append([]byte(nil), t.Padding...)
+	return c
+}
+
+// runAll runs f in the goroutine of each shard, once all the packets
+// previously queued have been assembled, and waits for all of them.
+func (sa *ShardedAssembler) runAll(f func(i int, s *shard)) {
+	var wg sync.WaitGroup
+	wg.Add(len(sa.shards))
+	for i, s := range sa.shards {
+		i := i
+		s.queue <- shardRequest{run: func(s *shard) {
+			f(i, s)
+			wg.Done()
+		}}
+	}
+	wg.Wait()
+}
+
+// FlushWithOptions calls Assembler.FlushWithOptions on every shard, after
+// the packets already queued have been assembled, and returns the total
+// number of connections flushed and closed.
+func (sa *ShardedAssembler) FlushWithOptions(opt FlushOptions) (flushed, closed int) {
+	var mu sync.Mutex
+	sa.runAll(func(i int, s *shard) {
+		f, c := s.assembler.FlushWithOptions(opt)
+		mu.Lock()
+		flushed += f
+		closed += c
+		mu.Unlock()
+	})
+	return
+}
+
+// FlushCloseOlderThan flushes and closes streams older than given time, on
+// every shard.
+func (sa *ShardedAssembler) FlushCloseOlderThan(t time.Time) (flushed, closed int) {
+	return sa.FlushWithOptions(FlushOptions{T: t, TC: t})
+}
+
+// FlushAll flushes all remaining data into all remaining connections of
+// every shard and closes those connections.  It returns the total number of
+// connections flushed/closed by the call.
+func (sa *ShardedAssembler) FlushAll() (closed int) {
+	var mu sync.Mutex
+	sa.runAll(func(i int, s *shard) {
+		c := s.assembler.FlushAll()
+		mu.Lock()
+		closed += c
+		mu.Unlock()
+	})
+	return
+}
+
+// Close waits for the queued packets to be assembled and stops the shards.
+// It doesn't flush connections: call FlushAll first to get all the
+// remaining data.  The ShardedAssembler must not be used afterwards.
+func (sa *ShardedAssembler) Close() {
+	for _, s := range sa.shards {
+		close(s.queue)
+	}
+	sa.wg.Wait()
+}
+
+// ShardStats provides some figures about a shard of a ShardedAssembler.
+type ShardStats struct {
+	// Packets is the number of packets assembled by the shard.
+	Packets int64
+	// Queued is the number of packets waiting in the queue of the shard.
+	Queued int
+	// Connections is the number of connections in the StreamPool of the
+	// shard.
+	Connections int
+	// PagesUsed is the number of pages buffering out-of-order data.
+	PagesUsed int
+	// PagesFree is the number of unused pages kept for reuse.
+	PagesFree int
+}
+
+// ShardedAssemblerStats provides figures about all the shards of a
+// ShardedAssembler.
+type ShardedAssemblerStats struct {
+	// Total sums the figures of all shards.
+	Total ShardStats
+	// Shards holds the figures of each shard.
+	Shards []ShardStats
+}
+
+// Stats returns figures about the shards.  Queued is sampled when Stats is
+// called, the other figures are gathered once the packets already queued
+// have been assembled.
+func (sa *ShardedAssembler) Stats() ShardedAssemblerStats {
+	stats := ShardedAssemblerStats{Shards: make([]ShardStats, len(sa.shards))}
+	for i, s := range sa.shards {
+		stats.Shards[i].Queued = len(s.queue)
+	}
+	sa.runAll(func(i int, s *shard) {
+		s.pool.mu.RLock()
+		conns := len(s.pool.conns)
+		s.pool.mu.RUnlock()
+		mem := s.assembler.MemoryStats()
+		st := &stats.Shards[i]
+		st.Packets = s.packets
+		st.Connections = conns
+		st.PagesUsed = mem.PagesUsed
+		st.PagesFree = mem.PagesFree
+	})
+	for _, s := range stats.Shards {
+		stats.Total.Packets += s.Packets
+		stats.Total.Queued += s.Queued
+		stats.Total.Connections += s.Connections
+		stats.Total.PagesUsed += s.PagesUsed
+		stats.Total.PagesFree += s.PagesFree
+	}
+	return stats
+}
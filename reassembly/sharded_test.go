@@ -0,0 +1,196 @@
+// Copyright 2012 Google, Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package reassembly
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+/* For sharded tests: collects the bytes of each stream */
+type testShardedFactory struct {
+	mu      sync.Mutex
+	streams map[key]*testShardedStream
+}
+
+type testShardedStream struct {
+	data     [2][]byte
+	complete bool
+}
+
+func (f *testShardedFactory) New(a, b gopacket.Flow, tcp *layers.TCP, ac AssemblerContext) Stream {
+	s := &testShardedStream{}
+	f.mu.Lock()
+	f.streams[key{a, b}] = s
+	f.mu.Unlock()
+	return s
+}
+func (s *testShardedStream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir TCPFlowDirection, seq Sequence, start *bool, ac AssemblerContext) bool {
+	return true
+}
+func (s *testShardedStream) ReassembledSG(sg ScatterGather, ac AssemblerContext) {
+	dir, _, _, _ := sg.Info()
+	l, _ := sg.Lengths()
+	i := 0
+	if dir == TCPDirServerToClient {
+		i = 1
+	}
+	s.data[i] = append(s.data[i], sg.Fetch(l)...)
+}
+func (s *testShardedStream) ReassemblyComplete(ac AssemblerContext) bool {
+	s.complete = true
+	return true
+}
+
+func TestShardedAssembler(t *testing.T) {
+	factory := &testShardedFactory{streams: map[key]*testShardedStream{}}
+	sa := NewShardedAssembler(factory, ShardedAssemblerOptions{Shards: 4, QueueLength: 8})
+	defer sa.Close()
+
+	const conns = 64
+	start := time.Unix(1500000000, 0)
+	ctx := func(d time.Duration) AssemblerContext {
+		ac := assemblerSimpleContext(gopacket.CaptureInfo{Timestamp: start.Add(d)})
+		return &ac
+	}
+	flows := make([]gopacket.Flow, conns)
+	for i := range flows {
+		flows[i], _ = gopacket.FlowFromEndpoints(
+			layers.NewIPEndpoint(net.IP{10, 0, byte(i >> 8), byte(i)}),
+			layers.NewIPEndpoint(net.IP{10, 1, 0, 1}))
+	}
+	send := func(i int, reverse bool, tcp layers.TCP, d time.Duration) {
+		tcp.SrcPort, tcp.DstPort = layers.TCPPort(10000+i), 80
+		f := flows[i]
+		if reverse {
+			tcp.SrcPort, tcp.DstPort = tcp.DstPort, tcp.SrcPort
+			f = f.Reverse()
+		}
+		tcp.SetInternalPortsForTesting()
+		sa.AssembleWithContext(f, &tcp, ctx(d))
+	}
+
+	for i := 0; i < conns; i++ {
+		send(i, false, layers.TCP{SYN: true, Seq: 100}, 0)
+		send(i, true, layers.TCP{SYN: true, ACK: true, Seq: 500, Ack: 101}, 0)
+		send(i, false, layers.TCP{ACK: true, Seq: 101, BaseLayer: layers.BaseLayer{Payload: []byte("GET / ")}}, time.Second)
+		send(i, true, layers.TCP{ACK: true, Seq: 501, BaseLayer: layers.BaseLayer{Payload: []byte("200")}}, time.Second)
+		// Out of order, waiting for a missing segment
+		send(i, false, layers.TCP{ACK: true, Seq: 110, BaseLayer: layers.BaseLayer{Payload: []byte("xyz")}}, time.Second)
+	}
+
+	stats := sa.Stats()
+	if len(stats.Shards) != 4 || stats.Total.Packets != 5*conns || stats.Total.Connections != conns {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if stats.Total.PagesUsed != conns {
+		t.Errorf("got %d pages used, want %d", stats.Total.PagesUsed, conns)
+	}
+	used := 0
+	for _, s := range stats.Shards {
+		if s.Connections > 0 {
+			used++
+		}
+	}
+	if used < 2 {
+		t.Errorf("connections not spread across shards: %+v", stats.Shards)
+	}
+
+	if flushed, _ := sa.FlushWithOptions(FlushOptions{T: start.Add(2 * time.Second)}); flushed != conns {
+		t.Errorf("flushed %d connections, want %d", flushed, conns)
+	}
+	if closed := sa.FlushAll(); closed != conns {
+		t.Errorf("closed %d connections, want %d", closed, conns)
+	}
+
+	factory.mu.Lock()
+	defer factory.mu.Unlock()
+	if len(factory.streams) != conns {
+		t.Fatalf("got %d streams, want %d", len(factory.streams), conns)
+	}
+	for k, s := range factory.streams {
+		if !bytes.Equal(s.data[0], []byte("GET / xyz")) || !bytes.Equal(s.data[1], []byte("200")) || !s.complete {
+			t.Errorf("%v: got %q, %q, complete: %v", k, s.data[0], s.data[1], s.complete)
+		}
+	}
+}
+
+/* For the sharded queue test: a stream blocking its shard */
+type testBlockingFactory struct {
+	entered chan struct{}
+	release chan struct{}
+	data    []byte
+}
+
+func (f *testBlockingFactory) New(a, b gopacket.Flow, tcp *layers.TCP, ac AssemblerContext) Stream {
+	return f
+}
+func (f *testBlockingFactory) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir TCPFlowDirection, seq Sequence, start *bool, ac AssemblerContext) bool {
+	return true
+}
+func (f *testBlockingFactory) ReassembledSG(sg ScatterGather, ac AssemblerContext) {
+	l, _ := sg.Lengths()
+	if l == 0 {
+		return
+	}
+	if f.data == nil {
+		f.entered <- struct{}{}
+		<-f.release
+	}
+	f.data = append(f.data, sg.Fetch(l)...)
+}
+func (f *testBlockingFactory) ReassemblyComplete(ac AssemblerContext) bool {
+	return true
+}
+
+func TestShardedAssemblerQueue(t *testing.T) {
+	factory := &testBlockingFactory{entered: make(chan struct{}), release: make(chan struct{})}
+	sa := NewShardedAssembler(factory, ShardedAssemblerOptions{Shards: 1, QueueLength: 8})
+	defer sa.Close()
+
+	flow, _ := gopacket.FlowFromEndpoints(layers.NewIPEndpoint(net.IP{10, 0, 0, 1}), layers.NewIPEndpoint(net.IP{10, 1, 0, 1}))
+	// A single buffer is reused for all packets, like with a
+	// DecodingLayerParser.
+	buf := make([]byte, 20+3)
+	send := func(seq uint32, flags byte, payload string) {
+		copy(buf, []byte{0x27, 0x10, 0x00, 0x50, byte(seq >> 24), byte(seq >> 16), byte(seq >> 8), byte(seq), 0, 0, 0, 0, 0x50, flags, 0xff, 0xff})
+		n := copy(buf[20:], payload)
+		var tcp layers.TCP
+		if err := tcp.DecodeFromBytes(buf[:20+n], gopacket.NilDecodeFeedback); err != nil {
+			t.Fatal(err)
+		}
+		sa.Assemble(flow, &tcp)
+	}
+	send(100, 0x02, "")
+	send(101, 0x10, "abc")
+	<-factory.entered
+	// The shard is blocked, these wait in its queue
+	send(104, 0x10, "def")
+	send(107, 0x10, "ghi")
+	send(110, 0x10, "jkl")
+
+	done := make(chan ShardedAssemblerStats)
+	go func() { done <- sa.Stats() }()
+	// Stats has queued its request after sampling the queue length
+	for len(sa.shards[0].queue) != 4 {
+		time.Sleep(time.Millisecond)
+	}
+	close(factory.release)
+	stats := <-done
+	if stats.Total.Queued != 3 || stats.Total.Packets != 5 {
+		t.Errorf("got %d packets queued and %d assembled", stats.Total.Queued, stats.Total.Packets)
+	}
+	if string(factory.data) != "abcdefghijkl" {
+		t.Errorf("got %q", factory.data)
+	}
+}
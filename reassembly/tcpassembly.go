@@ -484,12 +484,32 @@ func (c *connection) String() string {
 // modified before a NewAssembler call they'll affect the resulting Assembler.
 //
 // Note that the default options can result in ever-increasing memory usage
-// unless one of the Flush* methods is called on a regular basis.
+// unless one of the Flush* methods is called on a regular basis.  The pages
+// used during a traffic spike are kept for reuse too, unless MaxFreePages or
+// PageCachePolicy are set.
 var DefaultAssemblerOptions = AssemblerOptions{
 	MaxBufferedPagesPerConnection: 0, // unlimited
 	MaxBufferedPagesTotal:         0, // unlimited
+	MaxBufferedBytesTotal:         0, // unlimited
+	MaxFreePages:                  0, // unlimited
+	PageCachePolicy:               PageCacheNeverShrink,
 }
 
+// PageCachePolicy tells an Assembler when to release the unused pages it
+// keeps for reuse.
+type PageCachePolicy int
+
+const (
+	// PageCacheNeverShrink keeps all the pages ever allocated, only limited
+	// by MaxFreePages and MaxBufferedBytesTotal.
+	PageCacheNeverShrink PageCachePolicy = iota
+	// PageCacheShrinkOnFlush releases, on each call to one of the Flush*
+	// methods, the unused pages which weren't needed since the previous
+	// call.  Calling them on a regular basis makes the page cache follow
+	// the traffic, while avoiding allocations under a stable load.
+	PageCacheShrinkOnFlush
+)
+
 // AssemblerOptions controls the behavior of each assembler.  Modify the
 // options of each assembler you create to change their behavior.
 type AssemblerOptions struct {
@@ -503,6 +523,19 @@ type AssemblerOptions struct {
 	// particular connection, the smallest sequence number will be flushed, along
 	// with any contiguous data.  If <= 0, this is ignored.
 	MaxBufferedPagesPerConnection int
+	// MaxBufferedBytesTotal is an upper limit on the memory, in bytes, held
+	// by the pages of the assembler, used or not.  Unused pages are released
+	// as soon as this limit is exceeded, and once the pages in use alone
+	// reach it, the assembler degrades to flushing every connection it gets
+	// a packet for.  If <= 0, this is ignored.
+	MaxBufferedBytesTotal int
+	// MaxFreePages is an upper limit on the number of unused pages kept for
+	// reuse.  Pages beyond this limit are released to the garbage
+	// collector.  If <= 0, this is ignored, and unused pages are only
+	// released by the Flush* methods, following PageCachePolicy.
+	MaxFreePages int
+	// PageCachePolicy tells when unused pages are released.
+	PageCachePolicy PageCachePolicy
 }
 
 // Assembler handles reassembling TCP streams.  It is not safe for
@@ -536,6 +569,8 @@ type AssemblerOptions struct {
 // then we recommend you use a seperate StreamPool per Assembler, thus
 // avoiding all lock contention.  Only when different Assemblers could receive
 // packets for the same Stream should a StreamPool be shared between them.
+// ShardedAssembler does just that, dispatching packets to Assemblers running
+// in their own goroutines.
 //
 // Avoids Memory Copying
 //
@@ -560,10 +595,13 @@ type AssemblerOptions struct {
 // is done there, then very little allocation is done ever, mostly to handle
 // large increases in bandwidth or numbers of connections.
 //
-// TODO:  The page caches used by an Assembler will grow to the size necessary
-// to handle a workload, and currently will never shrink.  This means that
-// traffic spikes can result in large memory usage which isn't garbage
-// collected when typical traffic levels return.
+// The page caches used by an Assembler will grow to the size necessary to
+// handle a workload, and by default will never shrink.  Set MaxFreePages to
+// release the unused pages beyond it as soon as they are returned to the
+// cache, or set PageCachePolicy to PageCacheShrinkOnFlush to release the
+// unused pages when the Flush* methods are called, so that memory used during
+// traffic spikes is garbage collected when typical traffic levels return.
+// MemoryStats reports the pages currently held.
 type Assembler struct {
 	AssemblerOptions
 	ret      []byteContainer
@@ -594,10 +632,43 @@ func NewAssembler(pool *StreamPool) *Assembler {
 // Dump returns a short string describing the page usage of the Assembler
 func (a *Assembler) Dump() string {
 	s := ""
-	s += fmt.Sprintf("pageCache: used: %d:", a.pc.used)
+	s += fmt.Sprintf("pageCache: used: %d, free: %d:", a.pc.used, len(a.pc.free))
 	return s
 }
 
+// MemoryStats returns figures about the pages used by the Assembler to
+// buffer out-of-order data.
+func (a *Assembler) MemoryStats() MemoryStats {
+	return a.pc.stats()
+}
+
+// trimPageCache releases the unused pages exceeding MaxFreePages and
+// MaxBufferedBytesTotal.
+func (a *Assembler) trimPageCache() {
+	if a.MaxFreePages > 0 {
+		a.pc.trim(a.MaxFreePages)
+	}
+	if a.MaxBufferedBytesTotal > 0 {
+		a.pc.trim(a.MaxBufferedBytesTotal/pageMemory - a.pc.used)
+	}
+}
+
+// overBudget tells whether the pages in use reached one of the total limits
+// of the options.
+func (a *Assembler) overBudget() bool {
+	a.trimPageCache()
+	return (a.MaxBufferedBytesTotal > 0 && a.pc.used*pageMemory >= a.MaxBufferedBytesTotal) ||
+		(a.MaxBufferedPagesTotal > 0 && a.pc.used >= a.MaxBufferedPagesTotal)
+}
+
+// releasePages applies the PageCachePolicy after a flush.
+func (a *Assembler) releasePages() {
+	if a.PageCachePolicy == PageCacheShrinkOnFlush {
+		a.pc.shrink()
+	}
+	a.trimPageCache()
+}
+
 // AssemblerContext provides method to get metadata
 type AssemblerContext interface {
 	GetCaptureInfo() gopacket.CaptureInfo
@@ -731,6 +802,7 @@ func (a *Assembler) AssembleWithContext(netFlow gopacket.Flow, t *layers.TCP, ac
 			half.nextSeq = half.nextSeq.Add(1)
 		}
 	}
+	a.trimPageCache()
 	if *debugLog {
 		log.Printf("%v nextSeq:%d", key, half.nextSeq)
 	}
@@ -964,7 +1036,7 @@ func (a *Assembler) handleBytes(bytes []byte, seq Sequence, half *halfconnection
 	if action.queue {
 		a.checkOverlap(half, true, ac)
 		if (a.MaxBufferedPagesPerConnection > 0 && half.pages >= a.MaxBufferedPagesPerConnection) ||
-			(a.MaxBufferedPagesTotal > 0 && a.pc.used >= a.MaxBufferedPagesTotal) {
+			a.overBudget() {
 			if *debugLog {
 				log.Printf("hit max buffer size: %+v, %v, %v", a.AssemblerOptions, half.pages, a.pc.used)
 			}
@@ -1070,15 +1142,12 @@ func (a *Assembler) cleanSG(half *halfconnection, ac AssemblerContext) {
 	half.saved = nil
 	var saved *page
 	for _, r := range a.cacheSG.all[ndx:] {
-		preConvertLen := r.length()
 		first, last, nb := r.convertToPages(a.pc, skip, ac)
 
-		// Update skip count as we move from one container to the next.
-		if delta := preConvertLen - r.length(); delta > skip {
-			skip = 0
-		} else {
-			skip -= delta
-		}
+		// Only the first container kept is partially consumed.  A live
+		// packet isn't shortened by convertToPages, so its length can't
+		// tell how much was skipped.
+		skip = 0
 
 		if half.saved == nil {
 			half.saved = first
@@ -1281,6 +1350,7 @@ func (a *Assembler) FlushWithOptions(opt FlushOptions) (flushed, closed int) {
 			a.connPool.remove(conn)
 		}
 	}
+	a.releasePages()
 	return flushes, closes
 }
 
@@ -1328,6 +1398,7 @@ func (a *Assembler) FlushAll() (closed int) {
 		}
 		conn.mu.Unlock()
 	}
+	a.releasePages()
 	return
 }
 
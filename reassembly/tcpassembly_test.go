@@ -1201,6 +1201,53 @@ func TestKeepWithFlush(t *testing.T) {
 	})
 }
 
+func TestKeepNotBoundaryLiveBeforeSaved(t *testing.T) {
+	// The live packet is partially kept, along with the out-of-order packet
+	// which follows it.  Only the live packet must be skipped into, the
+	// saved page must be kept whole.
+	testKeep(t, []testKeepSequence{
+		{
+			tcp: layers.TCP{
+				SrcPort:   1,
+				DstPort:   2,
+				SYN:       true,
+				Seq:       1000,
+				BaseLayer: layers.BaseLayer{Payload: []byte{1, 2, 3}},
+			},
+			keep: 3,
+			want: []byte{1, 2, 3},
+		},
+		{
+			tcp: layers.TCP{
+				SrcPort:   1,
+				DstPort:   2,
+				Seq:       1007,
+				BaseLayer: layers.BaseLayer{Payload: []byte{7, 8, 9}},
+			},
+			want: []byte{},
+		},
+		{
+			tcp: layers.TCP{
+				SrcPort:   1,
+				DstPort:   2,
+				Seq:       1004,
+				BaseLayer: layers.BaseLayer{Payload: []byte{4, 5, 6}},
+			},
+			keep: 1,
+			want: []byte{4, 5, 6, 7, 8, 9},
+		},
+		{
+			tcp: layers.TCP{
+				SrcPort:   1,
+				DstPort:   2,
+				Seq:       1010,
+				BaseLayer: layers.BaseLayer{Payload: []byte{0}},
+			},
+			want: []byte{5, 6, 7, 8, 9, 0},
+		},
+	})
+}
+
 func TestKeepWithOutOfOrderPacketAndManualFlush(t *testing.T) {
 	makePayload := func(length int) []byte {
 		data := make([]byte, length)
@@ -1239,6 +1286,192 @@ func TestKeepWithOutOfOrderPacketAndManualFlush(t *testing.T) {
 	})
 }
 
+/*
+ * Keep: framed protocols
+ */
+// testFramedFactory decodes the messages of a protocol sending a sequence of
+// self-delimited messages over TCP, keeping incomplete messages for the next
+// call.  decode returns the length of the message at the beginning of data,
+// or incomplete if data ends before the end of the message.
+type testFramedFactory struct {
+	decode     func(data []byte) (int, error)
+	incomplete error
+	err        error
+}
+
+func (tff *testFramedFactory) New(a, b gopacket.Flow, tcp *layers.TCP, ac AssemblerContext) Stream {
+	return tff
+}
+func (tff *testFramedFactory) ReassembledSG(sg ScatterGather, ac AssemblerContext) {
+	l, _ := sg.Lengths()
+	data := sg.Fetch(l)
+	for off := 0; off < len(data); {
+		n, err := tff.decode(data[off:])
+		if err == tff.incomplete {
+			sg.KeepFrom(off)
+			return
+		} else if err != nil {
+			tff.err = err
+			return
+		}
+		off += n
+	}
+}
+func (tff *testFramedFactory) ReassemblyComplete(ac AssemblerContext) bool {
+	return true
+}
+func (tff *testFramedFactory) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir TCPFlowDirection, seq Sequence, start *bool, ac AssemblerContext) bool {
+	return true
+}
+
+// testFramed sends stream from port in segments of segLen bytes, the segment
+// late arriving after the next one, and checks that all the messages were
+// decoded without error.
+func testFramed(t *testing.T, fact *testFramedFactory, port layers.TCPPort, stream []byte, segLen, late int) {
+	a := NewAssembler(NewStreamPool(fact))
+	var segs []layers.TCP
+	for off := 0; off < len(stream); off += segLen {
+		end := off + segLen
+		if end > len(stream) {
+			end = len(stream)
+		}
+		segs = append(segs, layers.TCP{SrcPort: port, DstPort: 40000, Seq: 1001 + uint32(off),
+			BaseLayer: layers.BaseLayer{Payload: stream[off:end]}})
+	}
+	segs[late], segs[late+1] = segs[late+1], segs[late]
+	syn := layers.TCP{SrcPort: port, DstPort: 40000, SYN: true, Seq: 1000}
+	segs = append([]layers.TCP{syn}, segs...)
+	for i := range segs {
+		segs[i].SetInternalPortsForTesting()
+		a.Assemble(netFlow, &segs[i])
+	}
+	a.FlushAll()
+
+	if fact.err != nil {
+		t.Fatal(fact.err)
+	}
+}
+
+func TestKeepDNSTCP(t *testing.T) {
+	var stream []byte
+	for id := uint16(1); id <= 3; id++ {
+		dns := &layers.DNS{ID: id, QR: true}
+		for i := 0; i < 4; i++ {
+			dns.Answers = append(dns.Answers, layers.DNSResourceRecord{
+				Name: []byte("host.example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN,
+				IP: net.IP{192, 0, 2, byte(i)},
+			})
+		}
+		buf := gopacket.NewSerializeBuffer()
+		if err := dns.SerializeToTCP(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, buf.Bytes()...)
+	}
+
+	var ids []uint16
+	fact := &testFramedFactory{
+		decode: func(data []byte) (int, error) {
+			var dns layers.DNS
+			n, err := dns.DecodeFromTCPBytes(data, gopacket.NilDecodeFeedback)
+			if err == nil {
+				ids = append(ids, dns.ID)
+			}
+			return n, err
+		},
+		incomplete: layers.ErrDNSTCPIncomplete,
+	}
+	// Segments of 50 bytes, the third one arriving late
+	testFramed(t, fact, 53, stream, 50, 2)
+	if !reflect.DeepEqual(ids, []uint16{1, 2, 3}) {
+		t.Errorf("decoded messages %v, want [1 2 3]", ids)
+	}
+}
+
+func TestKeepIEC104(t *testing.T) {
+	var stream []byte
+	for i := 0; i < 4; i++ {
+		apdu := &layers.IEC104{
+			Format: layers.IEC104FormatI, SendSequence: uint16(i),
+			TypeID: layers.IEC104MMeNc1, Cause: layers.IEC104CauseSpontaneous, CommonAddress: 1,
+			Objects: []layers.IEC104InformationObject{
+				{Address: 100, Elements: []byte{0, 0, 0x80, 0x3f, 0}},
+				{Address: 200, Elements: []byte{0, 0, 0, 0x40, 0}},
+			},
+		}
+		if i == 2 {
+			apdu.TypeID = layers.IEC104MSpNa1
+			apdu.Objects = []layers.IEC104InformationObject{{Address: 300, Elements: []byte{1}}}
+		}
+		buf := gopacket.NewSerializeBuffer()
+		if err := apdu.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, buf.Bytes()...)
+	}
+
+	var types []layers.IEC104TypeID
+	fact := &testFramedFactory{
+		decode: func(data []byte) (int, error) {
+			var apdu layers.IEC104
+			if err := apdu.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+				return 0, err
+			}
+			types = append(types, apdu.TypeID)
+			return len(apdu.Contents), nil
+		},
+		incomplete: layers.ErrIEC104Incomplete,
+	}
+	// Segments of 10 bytes, the second one arriving late
+	testFramed(t, fact, 2404, stream, 10, 1)
+	want := []layers.IEC104TypeID{layers.IEC104MMeNc1, layers.IEC104MMeNc1, layers.IEC104MSpNa1, layers.IEC104MMeNc1}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("decoded APDUs of types %v, want %v", types, want)
+	}
+}
+
+func TestKeepDNP3(t *testing.T) {
+	var stream []byte
+	for i := 0; i < 3; i++ {
+		frame := &layers.DNP3{
+			Direction: true, Primary: true, LinkFunction: layers.DNP3LinkUnconfirmedUserData,
+			Destination: 1, Source: 0x400,
+			TransportFinal: true, TransportFirst: true, TransportSequence: uint8(i),
+			Application: &layers.DNP3Application{
+				First: true, Final: true, Sequence: uint8(i), Function: layers.DNP3Read,
+				Objects: []layers.DNP3ObjectHeader{
+					{Group: 60, Variation: 2, Qualifier: 0x06},
+					{Group: 60, Variation: 3, Qualifier: 0x06},
+					{Group: 60, Variation: 1, Qualifier: 0x06},
+				},
+			},
+		}
+		buf := gopacket.NewSerializeBuffer()
+		if err := frame.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, buf.Bytes()...)
+	}
+
+	var seqs []uint8
+	fact := &testFramedFactory{
+		decode: func(data []byte) (int, error) {
+			var frame layers.DNP3
+			if err := frame.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+				return 0, err
+			}
+			seqs = append(seqs, frame.TransportSequence)
+			return len(frame.Contents), nil
+		},
+		incomplete: layers.ErrDNP3Incomplete,
+	}
+	// Segments of 8 bytes, the second one arriving late
+	testFramed(t, fact, 20000, stream, 8, 1)
+	if !reflect.DeepEqual(seqs, []uint8{0, 1, 2}) {
+		t.Errorf("decoded frames %v, want [0 1 2]", seqs)
+	}
+}
+
 /*
  * FSM tests
  */
@@ -1754,6 +1987,84 @@ func TestMemoryShrink(t *testing.T) {
 	}
 }
 
+// queueOutOfOrder queues n out-of-order packets on a new connection, each
+// one needing a page.
+func queueOutOfOrder(a *Assembler, port layers.TCPPort, n int) {
+	tcp := layers.TCP{
+		SrcPort: port,
+		DstPort: 2,
+		SYN:     true,
+		Seq:     999,
+	}
+	tcp.SetInternalPortsForTesting()
+	a.Assemble(netFlow, &tcp)
+	tcp.SYN = false
+	tcp.Seq = 1010
+	tcp.Payload = []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 0}
+	for i := 0; i < n; i++ {
+		a.Assemble(netFlow, &tcp)
+		tcp.Seq += 20
+	}
+}
+
+func TestPageCacheShrinkOnFlush(t *testing.T) {
+	a := NewAssembler(NewStreamPool(&testFactoryBench{}))
+	a.PageCachePolicy = PageCacheShrinkOnFlush
+	queueOutOfOrder(a, 1, 100)
+	if s := a.MemoryStats(); s.PagesUsed != 100 || s.PagesFree != 0 || s.PagesAllocated != 100 || s.BytesAllocated != 100*pageMemory {
+		t.Fatalf("unexpected stats after queuing: %+v", s)
+	}
+	a.FlushAll()
+	// The pages were needed since the last flush, they are kept
+	if s := a.MemoryStats(); s.PagesUsed != 0 || s.PagesFree != 100 {
+		t.Fatalf("unexpected stats after the first flush: %+v", s)
+	}
+	queueOutOfOrder(a, 2, 10)
+	a.FlushAll()
+	// Only 10 pages were needed since the last flush
+	if s := a.MemoryStats(); s.PagesUsed != 0 || s.PagesFree != 10 || s.PagesReleased != 90 {
+		t.Fatalf("unexpected stats after the second flush: %+v", s)
+	}
+	a.FlushAll()
+	if s := a.MemoryStats(); s.PagesAllocated != 0 || s.PagesReleased != 100 {
+		t.Fatalf("unexpected stats after the third flush: %+v", s)
+	}
+
+	// By default, the pages are kept
+	a = NewAssembler(NewStreamPool(&testFactoryBench{}))
+	queueOutOfOrder(a, 1, 100)
+	a.FlushAll()
+	a.FlushAll()
+	if s := a.MemoryStats(); s.PagesFree != 100 || s.PagesReleased != 0 {
+		t.Fatalf("unexpected stats without shrinking: %+v", s)
+	}
+}
+
+func TestMaxFreePages(t *testing.T) {
+	a := NewAssembler(NewStreamPool(&testFactoryBench{}))
+	a.MaxFreePages = 30
+	queueOutOfOrder(a, 1, 100)
+	a.FlushAll()
+	if s := a.MemoryStats(); s.PagesFree != 30 || s.PagesReleased != 70 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
+
+func TestMaxBufferedBytesTotal(t *testing.T) {
+	a := NewAssembler(NewStreamPool(&testFactoryBench{}))
+	a.MaxBufferedBytesTotal = 50 * pageMemory
+	for port := layers.TCPPort(1); port <= 4; port++ {
+		queueOutOfOrder(a, port, 40)
+		if s := a.MemoryStats(); s.BytesAllocated > a.MaxBufferedBytesTotal {
+			t.Fatalf("budget exceeded: %+v", s)
+		}
+	}
+	a.FlushAll()
+	if s := a.MemoryStats(); s.PagesUsed != 0 || s.BytesAllocated > a.MaxBufferedBytesTotal {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
+
 /*
  * Benchmark tests
  */
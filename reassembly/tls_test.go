@@ -0,0 +1,106 @@
+// Copyright 2018 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package reassembly
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+/* For TLS tests: collects the bytes sent by the client */
+type testTLSStream struct {
+	client []byte
+}
+
+func (s *testTLSStream) New(a, b gopacket.Flow, tcp *layers.TCP, ac AssemblerContext) Stream {
+	return s
+}
+func (s *testTLSStream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir TCPFlowDirection, seq Sequence, start *bool, ac AssemblerContext) bool {
+	return true
+}
+func (s *testTLSStream) ReassembledSG(sg ScatterGather, ac AssemblerContext) {
+	dir, _, _, _ := sg.Info()
+	l, _ := sg.Lengths()
+	if dir == TCPDirClientToServer {
+		s.client = append(s.client, sg.Fetch(l)...)
+	}
+}
+func (s *testTLSStream) ReassemblyComplete(ac AssemblerContext) bool {
+	return true
+}
+
+// testTLSClientHello is a TLS 1.3 Client Hello sent by crypto/tls with SNI,
+// ALPN and a X25519 key share.
+var testTLSClientHello = []byte{
+	0x16, 0x03, 0x01, 0x01, 0x2c, 0x01, 0x00, 0x01, 0x28, 0x03, 0x03, 0xdc, 0xce, 0x17, 0xc5, 0x10,
+	0x35, 0x18, 0x27, 0x50, 0x2c, 0xac, 0x0e, 0x11, 0x3b, 0x6c, 0x64, 0x45, 0x36, 0xb8, 0x07, 0x2f,
+	0xee, 0x16, 0x90, 0x19, 0x90, 0x55, 0xd1, 0xe7, 0x10, 0x9e, 0xdb, 0x20, 0x4a, 0x2b, 0x7d, 0x94,
+	0x77, 0xbb, 0xfe, 0xd1, 0xf9, 0xae, 0x3e, 0x9e, 0x1a, 0x71, 0xb9, 0xaf, 0xfc, 0x7c, 0x16, 0xdf,
+	0x67, 0xcf, 0x30, 0x2f, 0x5f, 0x0d, 0x7a, 0xc5, 0x81, 0x47, 0x13, 0xa6, 0x00, 0x1a, 0xc0, 0x2b,
+	0xc0, 0x2f, 0xc0, 0x2c, 0xc0, 0x30, 0xcc, 0xa9, 0xcc, 0xa8, 0xc0, 0x09, 0xc0, 0x13, 0xc0, 0x0a,
+	0xc0, 0x14, 0x13, 0x01, 0x13, 0x02, 0x13, 0x03, 0x01, 0x00, 0x00, 0xc5, 0x00, 0x00, 0x00, 0x10,
+	0x00, 0x0e, 0x00, 0x00, 0x0b, 0x65, 0x78, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x2e, 0x63, 0x6f, 0x6d,
+	0x00, 0x0b, 0x00, 0x02, 0x01, 0x00, 0xff, 0x01, 0x00, 0x01, 0x00, 0x00, 0x17, 0x00, 0x00, 0x00,
+	0x12, 0x00, 0x00, 0x00, 0x05, 0x00, 0x05, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0a, 0x00, 0x04,
+	0x00, 0x02, 0x00, 0x1d, 0x00, 0x0d, 0x00, 0x20, 0x00, 0x1e, 0x09, 0x04, 0x09, 0x05, 0x09, 0x06,
+	0x08, 0x04, 0x04, 0x03, 0x08, 0x07, 0x08, 0x05, 0x08, 0x06, 0x04, 0x01, 0x05, 0x01, 0x06, 0x01,
+	0x05, 0x03, 0x06, 0x03, 0x02, 0x01, 0x02, 0x03, 0x00, 0x32, 0x00, 0x20, 0x00, 0x1e, 0x09, 0x04,
+	0x09, 0x05, 0x09, 0x06, 0x08, 0x04, 0x04, 0x03, 0x08, 0x07, 0x08, 0x05, 0x08, 0x06, 0x04, 0x01,
+	0x05, 0x01, 0x06, 0x01, 0x05, 0x03, 0x06, 0x03, 0x02, 0x01, 0x02, 0x03, 0x00, 0x10, 0x00, 0x0e,
+	0x00, 0x0c, 0x02, 0x68, 0x32, 0x08, 0x68, 0x74, 0x74, 0x70, 0x2f, 0x31, 0x2e, 0x31, 0x00, 0x2b,
+	0x00, 0x05, 0x04, 0x03, 0x04, 0x03, 0x03, 0x00, 0x33, 0x00, 0x26, 0x00, 0x24, 0x00, 0x1d, 0x00,
+	0x20, 0x57, 0x83, 0x48, 0x97, 0x0d, 0x4f, 0x2a, 0xde, 0x70, 0x9b, 0x14, 0x09, 0x43, 0x7f, 0xde,
+	0x1c, 0x97, 0x58, 0xe4, 0x41, 0x1b, 0x3b, 0x94, 0x68, 0x6e, 0x46, 0x3a, 0x71, 0xa1, 0x6c, 0x64,
+	0x0f,
+}
+
+func TestTLSFingerprintSplitClientHello(t *testing.T) {
+	stream := &testTLSStream{}
+	a := NewAssembler(NewStreamPool(stream))
+	flow, _ := gopacket.FlowFromEndpoints(
+		layers.NewIPEndpoint(net.IP{10, 0, 0, 1}),
+		layers.NewIPEndpoint(net.IP{10, 0, 0, 2}))
+	start := time.Unix(1500000000, 0)
+	send := func(reverse bool, tcp layers.TCP) {
+		tcp.SrcPort, tcp.DstPort = 40000, 443
+		f := flow
+		if reverse {
+			tcp.SrcPort, tcp.DstPort = tcp.DstPort, tcp.SrcPort
+			f = f.Reverse()
+		}
+		tcp.SetInternalPortsForTesting()
+		ac := assemblerSimpleContext(gopacket.CaptureInfo{Timestamp: start})
+		a.AssembleWithContext(f, &tcp, &ac)
+	}
+
+	// The Client Hello is split in the middle of the cipher suites, and its
+	// second segment arrives first.
+	first, second := testTLSClientHello[:80], testTLSClientHello[80:]
+	send(false, layers.TCP{SYN: true, Seq: 100})
+	send(true, layers.TCP{SYN: true, ACK: true, Seq: 500, Ack: 101})
+	send(false, layers.TCP{ACK: true, Seq: 101 + 80, Ack: 501, BaseLayer: layers.BaseLayer{Payload: second}})
+	send(false, layers.TCP{ACK: true, Seq: 101, Ack: 501, BaseLayer: layers.BaseLayer{Payload: first}})
+	a.FlushAll()
+
+	var tls layers.TLS
+	if err := tls.DecodeFromBytes(first, gopacket.NilDecodeFeedback); err == nil {
+		t.Error("First segment alone decoded")
+	}
+	if err := tls.DecodeFromBytes(stream.client, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatalf("Failed to decode reassembled Client Hello: %v", err)
+	}
+	if want := "6a32724b58a400f4cfe7e1a93a55e41e"; tls.JA3() != want {
+		t.Errorf("JA3 got %q, want %q", tls.JA3(), want)
+	}
+	if want := "t13d1312h2_f57a46bbacb6_a089bac06eae"; tls.JA4() != want {
+		t.Errorf("JA4 got %q, want %q", tls.JA4(), want)
+	}
+}